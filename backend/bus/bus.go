@@ -1,26 +1,56 @@
 package bus
 
-// Simple in-memory bus for notifications. Handlers can publish notifications
-// (persisted) to the bus; websocket listener forwards them to connected clients.
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
 
-type NotificationMessage struct {
-	RecipientID int64
-	Payload     []byte
-}
+// Envelope kinds carried between server instances.
+const (
+	// KindUser delivers Payload to every connection of UserID.
+	KindUser = "user"
+	// KindBroadcast delivers Payload to every connection.
+	KindBroadcast = "broadcast"
+	// KindPresence reports that UserID's first connection on Origin opened
+	// (Online) or its last one closed.
+	KindPresence = "presence"
+	// KindPresenceSync periodically lists every user connected to Origin, so
+	// instances that joined late or missed a transition converge.
+	KindPresenceSync = "presence_sync"
+)
 
-var NotificationChan chan NotificationMessage
+// Envelope is one realtime fan-out message. Payloads are pre-encoded
+// websocket frames; sequencing happens before publishing, so every instance
+// delivers the same bytes.
+type Envelope struct {
+	Kind    string          `json:"kind"`
+	Origin  string          `json:"origin"`
+	UserID  string          `json:"user_id,omitempty"`
+	UserIDs []string        `json:"user_ids,omitempty"`
+	Online  bool            `json:"online,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
 
-func init() {
-	NotificationChan = make(chan NotificationMessage, 256)
+// Bus fans envelopes out to every server instance. Publishers deliver to
+// their own connections directly, so subscribers skip envelopes whose Origin
+// is their own instance.
+type Bus interface {
+	// Publish sends env to all subscribers, in publish order per publisher.
+	Publish(env Envelope) error
+	// Subscribe registers fn to be called for every published envelope.
+	Subscribe(fn func(Envelope))
+	Close() error
 }
 
-// PublishNotification attempts to enqueue a payload for a recipient. If the
-// channel is full the notification is dropped to avoid blocking request
-// handlers.
-func PublishNotification(recipientID int64, payload []byte) {
-	select {
-	case NotificationChan <- NotificationMessage{RecipientID: recipientID, Payload: payload}:
-	default:
-		// drop if busy
+// New returns the bus selected by url: "" or "memory://" for the in-process
+// bus (a single instance), "redis://host:port[/db]" for Redis pub/sub.
+func New(url string) (Bus, error) {
+	switch {
+	case url == "" || url == "memory://":
+		return NewMemory(), nil
+	case strings.HasPrefix(url, "redis://"), strings.HasPrefix(url, "rediss://"):
+		return NewRedis(url, DefaultChannel)
 	}
+	return nil, fmt.Errorf("unsupported bus url %q", url)
 }
@@ -0,0 +1,147 @@
+package bus
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector records the envelopes a subscriber receives.
+type collector struct {
+	mu   sync.Mutex
+	envs []Envelope
+	got  chan struct{}
+}
+
+func newCollector() *collector {
+	return &collector{got: make(chan struct{}, 1)}
+}
+
+func (c *collector) receive(env Envelope) {
+	c.mu.Lock()
+	c.envs = append(c.envs, env)
+	c.mu.Unlock()
+	select {
+	case c.got <- struct{}{}:
+	default:
+	}
+}
+
+// wait returns the envelopes received once there are n of them.
+func (c *collector) wait(t *testing.T, n int) []Envelope {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		c.mu.Lock()
+		envs := append([]Envelope(nil), c.envs...)
+		c.mu.Unlock()
+		if len(envs) >= n {
+			return envs
+		}
+		select {
+		case <-c.got:
+		case <-timeout:
+			t.Fatalf("received %d envelopes, want %d", len(envs), n)
+		}
+	}
+}
+
+// testFanOut publishes from a and checks that every subscriber of a and b
+// gets the envelopes intact and in publish order.
+func testFanOut(t *testing.T, a, b Bus) {
+	subs := []*collector{newCollector(), newCollector(), newCollector()}
+	a.Subscribe(subs[0].receive)
+	b.Subscribe(subs[1].receive)
+	b.Subscribe(subs[2].receive)
+
+	const n = 50
+	for i := range n {
+		env := Envelope{Kind: KindUser, Origin: "a", UserID: strconv.Itoa(i), Payload: json.RawMessage(fmt.Sprintf(`{"seq":%d}`, i))}
+		if err := a.Publish(env); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for s, sub := range subs {
+		envs := sub.wait(t, n)
+		for i, env := range envs {
+			if env.Kind != KindUser || env.Origin != "a" || env.UserID != strconv.Itoa(i) || string(env.Payload) != fmt.Sprintf(`{"seq":%d}`, i) {
+				t.Fatalf("subscriber %d: envelope %d = %+v", s, i, env)
+			}
+		}
+	}
+}
+
+func TestMemoryFanOut(t *testing.T) {
+	m := NewMemory()
+	testFanOut(t, m, m)
+}
+
+// TestRedisFanOut runs against the Redis server at TEST_REDIS_URL, e.g.
+// redis://localhost:6379/15, and is skipped without one.
+func TestRedisFanOut(t *testing.T) {
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("TEST_REDIS_URL not set")
+	}
+	channel := fmt.Sprintf("social-network:test:%d", time.Now().UnixNano())
+	a, err := NewRedis(url, channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := NewRedis(url, channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	testFanOut(t, a, b)
+}
+
+func TestNew(t *testing.T) {
+	for _, url := range []string{"", "memory://"} {
+		if b, err := New(url); err != nil {
+			t.Errorf("New(%q): %v", url, err)
+		} else if _, ok := b.(*Memory); !ok {
+			t.Errorf("New(%q) = %T, want *Memory", url, b)
+		}
+	}
+	if _, err := New("nats://localhost:4222"); err == nil {
+		t.Error("New accepted an unsupported scheme")
+	}
+	if _, err := New("redis://127.0.0.1:1/0"); err == nil {
+		t.Error("New connected to a closed port")
+	}
+}
+
+func TestNotificationQueueDropsOldest(t *testing.T) {
+	dropped := notificationsDropped.Value()
+	for i := range pendingLimit + 2 {
+		PublishNotification(int64(i), nil)
+	}
+	pendingMu.Lock()
+	queued, first := len(pending), pending[0].RecipientID
+	pendingMu.Unlock()
+	if queued != pendingLimit || first != 2 {
+		t.Fatalf("%d queued starting at %d, want %d starting at 2", queued, first, pendingLimit)
+	}
+	if got := notificationsDropped.Value() - dropped; got != 2 {
+		t.Errorf("%d drops counted, want 2", got)
+	}
+
+	// the pump delivers what is left in publish order
+	StartPump()
+	for want := int64(2); want < pendingLimit+2; want++ {
+		select {
+		case m := <-NotificationChan:
+			if m.RecipientID != want {
+				t.Fatalf("got recipient %d, want %d", m.RecipientID, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("recipient %d was never delivered", want)
+		}
+	}
+}
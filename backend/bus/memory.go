@@ -0,0 +1,35 @@
+package bus
+
+import "sync"
+
+// Memory is an in-process Bus. It is what a single instance uses; since all
+// connections live in the same process, it only matters for subscribers that
+// are not the publisher.
+type Memory struct {
+	mu   sync.RWMutex
+	subs []func(Envelope)
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Publish calls every subscriber synchronously, which keeps publish order.
+func (m *Memory) Publish(env Envelope) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, fn := range m.subs {
+		fn(env)
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(fn func(Envelope)) {
+	m.mu.Lock()
+	m.subs = append(m.subs, fn)
+	m.mu.Unlock()
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
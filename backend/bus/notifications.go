@@ -0,0 +1,96 @@
+package bus
+
+import (
+	"expvar"
+	"sync"
+)
+
+// In-process outbox for realtime payloads produced by the handlers package.
+// The forwarder in main hands them to the websocket hub, which sequences them
+// and fans them out to every instance through the Bus.
+
+type NotificationMessage struct {
+	RecipientID int64
+	Payload     []byte
+	// Snapshot payloads are state (e.g. an unread count) and are delivered
+	// without a sequence number, so they are never replayed.
+	Snapshot bool
+	// Alert payloads are user-facing notifications (see handlers.Notify);
+	// they are sent as Web Push when the recipient is offline.
+	Alert bool
+}
+
+var NotificationChan = make(chan NotificationMessage, 256)
+
+// pendingLimit bounds the messages buffered for a listener that fell behind.
+// When it is reached the oldest message is dropped, and counted in
+// bus_notifications_dropped, so publishing never blocks a request handler.
+const pendingLimit = 10000
+
+// pending buffers published messages until the pump hands them to
+// NotificationChan.
+var (
+	pendingMu sync.Mutex
+	pending   []NotificationMessage
+	wake      = make(chan struct{}, 1)
+	pumpOnce  sync.Once
+
+	notificationsDropped = expvar.NewInt("bus_notifications_dropped")
+)
+
+// StartPump starts moving published messages to NotificationChan. main calls
+// it before reading the channel; until then messages wait in pending.
+func StartPump() {
+	pumpOnce.Do(func() { go pump() })
+}
+
+// PublishNotification enqueues a payload for a recipient. It never blocks.
+func PublishNotification(recipientID int64, payload []byte) {
+	enqueue(NotificationMessage{RecipientID: recipientID, Payload: payload})
+}
+
+// PublishAlert enqueues a notification for a recipient; see
+// NotificationMessage.Alert.
+func PublishAlert(recipientID int64, payload []byte) {
+	enqueue(NotificationMessage{RecipientID: recipientID, Payload: payload, Alert: true})
+}
+
+// PublishSnapshot enqueues a state snapshot for a recipient; see
+// NotificationMessage.Snapshot.
+func PublishSnapshot(recipientID int64, payload []byte) {
+	enqueue(NotificationMessage{RecipientID: recipientID, Payload: payload, Snapshot: true})
+}
+
+func enqueue(m NotificationMessage) {
+	pendingMu.Lock()
+	if len(pending) >= pendingLimit {
+		pending = pending[1:]
+		notificationsDropped.Add(1)
+	}
+	pending = append(pending, m)
+	pendingMu.Unlock()
+	select {
+	case wake <- struct{}{}:
+	default:
+		// a wake-up is already pending
+	}
+}
+
+// pump moves buffered messages to NotificationChan in publish order, waiting
+// for the listener when the channel is full.
+func pump() {
+	for range wake {
+		for {
+			pendingMu.Lock()
+			batch := pending
+			pending = nil
+			pendingMu.Unlock()
+			if len(batch) == 0 {
+				break
+			}
+			for _, m := range batch {
+				NotificationChan <- m
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultChannel is the Redis pub/sub channel shared by all instances.
+const DefaultChannel = "social-network:realtime"
+
+// publishTimeout bounds a single PUBLISH so a slow Redis cannot stall the
+// websocket handlers.
+const publishTimeout = 2 * time.Second
+
+// Redis is a Bus backed by Redis pub/sub. Redis keeps no history: an
+// instance only receives envelopes published while it is subscribed, which
+// is fine because missed events are replayed from the database log.
+type Redis struct {
+	client  *redis.Client
+	pubsub  *redis.PubSub
+	channel string
+
+	mu   sync.RWMutex
+	subs []func(Envelope)
+}
+
+// NewRedis connects to url (redis://host:port/db) and subscribes to channel.
+func NewRedis(url, channel string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	pubsub := client.Subscribe(ctx, channel)
+	// wait for the subscription to be confirmed before anyone publishes
+	if _, err := pubsub.Receive(ctx); err != nil {
+		client.Close()
+		return nil, err
+	}
+	r := &Redis{client: client, pubsub: pubsub, channel: channel}
+	go r.listen()
+	return r, nil
+}
+
+func (r *Redis) Publish(env Envelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	return r.client.Publish(ctx, r.channel, data).Err()
+}
+
+func (r *Redis) Subscribe(fn func(Envelope)) {
+	r.mu.Lock()
+	r.subs = append(r.subs, fn)
+	r.mu.Unlock()
+}
+
+// listen dispatches incoming envelopes; go-redis reconnects and resubscribes
+// on its own when the connection drops.
+func (r *Redis) listen() {
+	for msg := range r.pubsub.Channel() {
+		var env Envelope
+		if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+			log.Println("bus: bad envelope:", err)
+			continue
+		}
+		r.mu.RLock()
+		for _, fn := range r.subs {
+			fn(env)
+		}
+		r.mu.RUnlock()
+	}
+}
+
+func (r *Redis) Close() error {
+	r.pubsub.Close()
+	return r.client.Close()
+}
@@ -0,0 +1,7 @@
+//go:build !sqlite_fts5
+
+package db
+
+// Message search needs SQLite's FTS5, which go-sqlite3 only compiles in with
+// the sqlite_fts5 build tag. Build, run and test with -tags sqlite_fts5.
+var _ = buildWithTagSqliteFTS5
@@ -0,0 +1,13 @@
+-- Per-participant delivery/read cursors for direct-message conversations.
+-- user_id is the reader, peer_id the other side of the conversation; the
+-- cursors hold the highest messages.id (sent by peer_id) acknowledged/read.
+CREATE TABLE IF NOT EXISTS message_receipts (
+    user_id INTEGER NOT NULL,
+    peer_id INTEGER NOT NULL,
+    last_delivered_id INTEGER NOT NULL DEFAULT 0,
+    last_read_id INTEGER NOT NULL DEFAULT 0,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, peer_id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (peer_id) REFERENCES users (id) ON DELETE CASCADE
+);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS hidden_messages;
+ALTER TABLE group_messages DROP COLUMN deleted_at;
+ALTER TABLE group_messages DROP COLUMN edited_at;
+ALTER TABLE messages DROP COLUMN deleted_at;
+ALTER TABLE messages DROP COLUMN edited_at;
@@ -0,0 +1,15 @@
+-- Edit/delete support for chat messages. deleted_at marks a delete-for-everyone
+-- tombstone (content is cleared); hidden_messages records delete-for-me.
+ALTER TABLE messages ADD COLUMN edited_at DATETIME;
+ALTER TABLE messages ADD COLUMN deleted_at DATETIME;
+ALTER TABLE group_messages ADD COLUMN edited_at DATETIME;
+ALTER TABLE group_messages ADD COLUMN deleted_at DATETIME;
+
+CREATE TABLE IF NOT EXISTS hidden_messages (
+    user_id INTEGER NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('direct', 'group')),
+    message_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, kind, message_id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
@@ -0,0 +1,14 @@
+-- Emoji reactions on chat messages. kind selects the table message_id points
+-- into: 'direct' -> messages, 'group' -> group_messages.
+CREATE TABLE IF NOT EXISTS message_reactions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL CHECK (kind IN ('direct', 'group')),
+    message_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    emoji TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (kind, message_id, user_id, emoji),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_reactions_message ON message_reactions (kind, message_id);
@@ -0,0 +1,18 @@
+-- Files attached to chat messages. Rows are created on upload (message_id NULL)
+-- and linked to a messages/group_messages row (selected by kind) when sent.
+CREATE TABLE IF NOT EXISTS attachments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    uploader_id INTEGER NOT NULL,
+    kind TEXT CHECK (kind IN ('direct', 'group')),
+    message_id INTEGER,
+    file_path TEXT NOT NULL,
+    original_name TEXT,
+    mime_type TEXT NOT NULL,
+    size_bytes INTEGER NOT NULL,
+    width INTEGER,
+    height INTEGER,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (uploader_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_attachments_message ON attachments (kind, message_id);
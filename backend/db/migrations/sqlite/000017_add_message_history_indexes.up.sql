@@ -0,0 +1,3 @@
+-- Support id-cursor pagination of DM and group chat history.
+CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages (sender_id, receiver_id, id);
+CREATE INDEX IF NOT EXISTS idx_group_messages_group ON group_messages (group_id, id);
@@ -0,0 +1,46 @@
+-- Full-text search over chat messages (requires building with -tags sqlite_fts5).
+-- External-content FTS5 tables mirror messages/group_messages and are kept in
+-- sync by triggers.
+CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+    content,
+    content='messages',
+    content_rowid='id',
+    tokenize='unicode61 remove_diacritics 2'
+);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS group_messages_fts USING fts5(
+    content,
+    content='group_messages',
+    content_rowid='id',
+    tokenize='unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+    INSERT INTO messages_fts (rowid, content) VALUES (new.id, new.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+    INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF content ON messages BEGIN
+    INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+    INSERT INTO messages_fts (rowid, content) VALUES (new.id, new.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS group_messages_fts_insert AFTER INSERT ON group_messages BEGIN
+    INSERT INTO group_messages_fts (rowid, content) VALUES (new.id, new.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS group_messages_fts_delete AFTER DELETE ON group_messages BEGIN
+    INSERT INTO group_messages_fts (group_messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS group_messages_fts_update AFTER UPDATE OF content ON group_messages BEGIN
+    INSERT INTO group_messages_fts (group_messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+    INSERT INTO group_messages_fts (rowid, content) VALUES (new.id, new.content);
+END;
+
+-- index existing history
+INSERT INTO messages_fts (messages_fts) VALUES ('rebuild');
+INSERT INTO group_messages_fts (group_messages_fts) VALUES ('rebuild');
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_realtime_events_created_at;
+DROP TABLE IF EXISTS realtime_events;
+DROP TABLE IF EXISTS realtime_event_seq;
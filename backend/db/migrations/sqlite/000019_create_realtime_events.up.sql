@@ -0,0 +1,20 @@
+-- Per-user sequence counter and retention log for realtime (websocket)
+-- events, used to replay what a client missed while disconnected.
+-- realtime_event_seq outlives pruned events so sequences never go backwards.
+CREATE TABLE IF NOT EXISTS realtime_event_seq (
+    user_id INTEGER PRIMARY KEY,
+    last_seq INTEGER NOT NULL DEFAULT 0,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS realtime_events (
+    user_id INTEGER NOT NULL,
+    seq INTEGER NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, seq),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_realtime_events_created_at ON realtime_events (created_at);
@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN last_seen_visibility;
+ALTER TABLE users DROP COLUMN last_seen_at;
+ALTER TABLE users DROP COLUMN presence_status;
@@ -0,0 +1,9 @@
+-- Rich presence. presence_status is the status the user chose; connection
+-- state (offline) and idleness (away) are derived at runtime. last_seen_at is
+-- set when the user's last connection closes and last_seen_visibility limits
+-- who may see it.
+ALTER TABLE users ADD COLUMN presence_status TEXT NOT NULL DEFAULT 'online'
+    CHECK (presence_status IN ('online', 'away', 'dnd', 'invisible'));
+ALTER TABLE users ADD COLUMN last_seen_at DATETIME;
+ALTER TABLE users ADD COLUMN last_seen_visibility TEXT NOT NULL DEFAULT 'everyone'
+    CHECK (last_seen_visibility IN ('everyone', 'followers', 'nobody'));
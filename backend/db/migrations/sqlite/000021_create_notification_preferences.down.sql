@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS group_mutes;
+DROP TABLE IF EXISTS notification_preferences;
@@ -0,0 +1,25 @@
+-- Per-user notification settings. A row overrides the defaults of one
+-- notification type (see handlers/notification_prefs.go) for each channel:
+-- the in-app list, realtime push and the email digest.
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id INTEGER NOT NULL,
+    type TEXT NOT NULL,
+    in_app INTEGER NOT NULL DEFAULT 1,
+    realtime INTEGER NOT NULL DEFAULT 1,
+    email INTEGER NOT NULL DEFAULT 0,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, type),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- Muted groups produce no group activity notifications (chat messages,
+-- events) for the user. muted_until NULL mutes until unmuted.
+CREATE TABLE IF NOT EXISTS group_mutes (
+    user_id INTEGER NOT NULL,
+    group_id INTEGER NOT NULL,
+    muted_until DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, group_id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (group_id) REFERENCES groups (id) ON DELETE CASCADE
+);
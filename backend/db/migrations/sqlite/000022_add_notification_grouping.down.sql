@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS notification_actors;
+DROP INDEX IF EXISTS idx_notifications_recipient_updated;
+DROP INDEX IF EXISTS idx_notifications_unread_group;
+ALTER TABLE notifications DROP COLUMN updated_at;
+ALTER TABLE notifications DROP COLUMN count;
+ALTER TABLE notifications DROP COLUMN group_key;
@@ -0,0 +1,26 @@
+-- Notification aggregation. Notifications with a group_key (e.g.
+-- "group_message:group:5") collapse into the recipient's unread row with the
+-- same key: count is bumped, data holds the latest payload and every actor is
+-- recorded in notification_actors. updated_at orders the list.
+ALTER TABLE notifications ADD COLUMN group_key TEXT;
+ALTER TABLE notifications ADD COLUMN count INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE notifications ADD COLUMN updated_at DATETIME;
+UPDATE notifications SET updated_at = created_at;
+
+-- at most one unread row per key, the target of the aggregating upsert
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notifications_unread_group
+    ON notifications (recipient_id, group_key) WHERE is_read = 0 AND group_key IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_notifications_recipient_updated
+    ON notifications (recipient_id, updated_at);
+
+CREATE TABLE IF NOT EXISTS notification_actors (
+    notification_id INTEGER NOT NULL,
+    actor_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (notification_id, actor_id),
+    FOREIGN KEY (notification_id) REFERENCES notifications (id) ON DELETE CASCADE,
+    FOREIGN KEY (actor_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+INSERT OR IGNORE INTO notification_actors (notification_id, actor_id, created_at)
+SELECT id, actor_id, created_at FROM notifications WHERE actor_id > 0;
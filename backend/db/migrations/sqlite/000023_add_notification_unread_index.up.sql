@@ -0,0 +1,2 @@
+-- Backs the unread count (badge) and the read/unread list filters.
+CREATE INDEX IF NOT EXISTS idx_notifications_recipient_read ON notifications (recipient_id, is_read);
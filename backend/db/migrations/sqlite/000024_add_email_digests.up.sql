@@ -0,0 +1,11 @@
+-- Email digest of unread notifications. Users without a row get the daily
+-- digest. unsubscribe_token authenticates the one-click unsubscribe link in
+-- every digest; last_sent_at bounds what the next digest includes.
+CREATE TABLE IF NOT EXISTS email_digests (
+    user_id INTEGER PRIMARY KEY,
+    frequency TEXT NOT NULL DEFAULT 'daily' CHECK (frequency IN ('off', 'daily', 'weekly')),
+    last_sent_at DATETIME,
+    unsubscribe_token TEXT NOT NULL UNIQUE,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
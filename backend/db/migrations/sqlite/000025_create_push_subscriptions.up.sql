@@ -0,0 +1,27 @@
+-- Web Push subscriptions: browsers that receive notifications while their
+-- user has no websocket connection. expiration_time comes from the
+-- PushSubscription; failure_count counts consecutive failed deliveries.
+CREATE TABLE IF NOT EXISTS push_subscriptions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    endpoint TEXT NOT NULL UNIQUE,
+    p256dh TEXT NOT NULL,
+    auth TEXT NOT NULL,
+    expiration_time DATETIME,
+    user_agent TEXT,
+    failure_count INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    last_used_at DATETIME,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_push_subscriptions_user ON push_subscriptions (user_id);
+
+-- The VAPID key pair, generated on first start unless VAPID_PRIVATE_KEY is
+-- set. Subscriptions are bound to its public key, so it is kept here for
+-- every instance to share.
+CREATE TABLE IF NOT EXISTS vapid_keys (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    private_key TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
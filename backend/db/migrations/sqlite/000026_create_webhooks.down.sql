@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_webhook_deliveries_webhook;
+DROP INDEX IF EXISTS idx_webhook_deliveries_due;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP INDEX IF EXISTS idx_webhooks_group;
+DROP INDEX IF EXISTS idx_webhooks_user;
+DROP TABLE IF EXISTS webhooks;
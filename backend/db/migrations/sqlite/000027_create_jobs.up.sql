@@ -0,0 +1,34 @@
+-- Durable background job queue (see backend/jobs). A worker claims a pending
+-- job whose run_at has come by setting it running with a lease
+-- (locked_until); failed attempts go back to pending with a later run_at.
+CREATE TABLE IF NOT EXISTS jobs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    type TEXT NOT NULL,
+    payload TEXT NOT NULL DEFAULT 'null',
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'succeeded', 'failed', 'cancelled')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    run_at DATETIME NOT NULL,
+    locked_by TEXT,
+    locked_until DATETIME,
+    last_error TEXT,
+    schedule TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    started_at DATETIME,
+    finished_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_due ON jobs (status, run_at);
+CREATE INDEX IF NOT EXISTS idx_jobs_type ON jobs (type, status);
+
+-- Recurring jobs. next_run_at is moved on by whichever instance enqueues the
+-- run, so each run is enqueued once.
+CREATE TABLE IF NOT EXISTS job_schedules (
+    name TEXT PRIMARY KEY,
+    spec TEXT NOT NULL,
+    job_type TEXT NOT NULL,
+    next_run_at DATETIME,
+    last_run_at DATETIME,
+    last_job_id INTEGER,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
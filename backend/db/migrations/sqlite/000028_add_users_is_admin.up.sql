@@ -0,0 +1,2 @@
+-- Admins may inspect and manage the job queue through /api/admin/jobs.
+ALTER TABLE users ADD COLUMN is_admin INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE events DROP COLUMN reminder_sent_at;
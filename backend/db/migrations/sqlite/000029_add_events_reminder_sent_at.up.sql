@@ -0,0 +1,2 @@
+-- Set once the "going" voters were reminded of an upcoming event.
+ALTER TABLE events ADD COLUMN reminder_sent_at DATETIME;
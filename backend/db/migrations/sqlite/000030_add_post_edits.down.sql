@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_post_edits_target;
+DROP TABLE IF EXISTS post_edits;
+ALTER TABLE group_comments DROP COLUMN edited_at;
+ALTER TABLE group_posts DROP COLUMN edited_at;
+ALTER TABLE comments DROP COLUMN edited_at;
+ALTER TABLE posts DROP COLUMN edited_at;
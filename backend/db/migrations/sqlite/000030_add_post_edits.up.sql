@@ -0,0 +1,23 @@
+-- Posts and comments, personal and in groups, can be edited by their
+-- author; edited_at marks the current version as edited.
+ALTER TABLE posts ADD COLUMN edited_at DATETIME;
+ALTER TABLE comments ADD COLUMN edited_at DATETIME;
+ALTER TABLE group_posts ADD COLUMN edited_at DATETIME;
+ALTER TABLE group_comments ADD COLUMN edited_at DATETIME;
+
+-- Edit history: every edit stores the version it replaced. kind names the
+-- table of target_id; privacy and allowed_user_ids only apply to posts.
+CREATE TABLE IF NOT EXISTS post_edits (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL CHECK (kind IN ('post', 'comment', 'group_post', 'group_comment')),
+    target_id INTEGER NOT NULL,
+    editor_id INTEGER NOT NULL,
+    content TEXT,
+    image_url TEXT,
+    privacy TEXT,
+    allowed_user_ids TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (editor_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_edits_target ON post_edits (kind, target_id, id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_comments_post;
+DROP INDEX IF EXISTS idx_posts_privacy;
+DROP INDEX IF EXISTS idx_posts_author;
@@ -0,0 +1,6 @@
+-- Feed queries filter visibility in SQL and page by post id (see
+-- handlers.ListFeedHandler). followers already has a unique index on
+-- (follower_id, followed_id) for the followers-only check.
+CREATE INDEX IF NOT EXISTS idx_posts_author ON posts (author_id, id);
+CREATE INDEX IF NOT EXISTS idx_posts_privacy ON posts (privacy, id);
+CREATE INDEX IF NOT EXISTS idx_comments_post ON comments (post_id, id);
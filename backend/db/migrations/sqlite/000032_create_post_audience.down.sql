@@ -0,0 +1,8 @@
+ALTER TABLE posts ADD COLUMN allowed_user_ids TEXT;
+
+UPDATE posts SET allowed_user_ids = (
+    SELECT group_concat(user_id) FROM (SELECT user_id FROM post_audience a WHERE a.post_id = posts.id ORDER BY user_id)
+) WHERE privacy = 'private';
+
+DROP INDEX IF EXISTS idx_post_audience_user;
+DROP TABLE IF EXISTS post_audience;
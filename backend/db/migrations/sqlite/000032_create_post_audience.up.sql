@@ -0,0 +1,29 @@
+-- The users a private post is shared with, replacing the comma-separated
+-- posts.allowed_user_ids. Only the author's followers can be picked.
+CREATE TABLE IF NOT EXISTS post_audience (
+    post_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (post_id, user_id),
+    FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_audience_user ON post_audience (user_id, post_id);
+
+-- Move the existing audiences over, keeping the ids that name a follower of
+-- the author; anything else in the old list was never a valid choice.
+WITH RECURSIVE split (post_id, author_id, rest, user_id) AS (
+    SELECT id, author_id, REPLACE(allowed_user_ids, ' ', '') || ',', ''
+    FROM posts WHERE privacy = 'private' AND IFNULL(allowed_user_ids, '') != ''
+    UNION ALL
+    SELECT post_id, author_id, substr(rest, instr(rest, ',') + 1), substr(rest, 1, instr(rest, ',') - 1)
+    FROM split WHERE rest != ''
+)
+INSERT OR IGNORE INTO post_audience (post_id, user_id)
+SELECT s.post_id, f.follower_id
+FROM split s
+JOIN followers f ON f.followed_id = s.author_id AND f.follower_id = CAST(s.user_id AS INTEGER)
+WHERE s.user_id != '';
+
+ALTER TABLE posts DROP COLUMN allowed_user_ids;
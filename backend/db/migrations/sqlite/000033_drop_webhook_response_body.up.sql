@@ -0,0 +1,2 @@
+-- Response bodies are no longer logged with webhook deliveries.
+ALTER TABLE webhook_deliveries DROP COLUMN response_body;
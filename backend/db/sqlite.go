@@ -72,6 +72,8 @@ func InitDB() {
 			} else {
 				panic(fmt.Sprintf("Migration failed and version couldn't be parsed: %v", err))
 			}
+		} else if strings.Contains(err.Error(), "no such module: fts5") {
+			panic(fmt.Sprintf("Migration failed: %v (message search needs FTS5; build with -tags sqlite_fts5)", err))
 		} else {
 			panic(fmt.Sprintf("Migration failed: %v", err))
 		}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"social-network/backend/db"
+)
+
+const (
+	// eventRetention is how long realtime events are kept for replay. A client
+	// that resumes from an older sequence is told to resync instead.
+	eventRetention = 24 * time.Hour
+	// replayBatchSize bounds one resume reply so a replay never overflows the
+	// send queue (which would evict the client); the client resumes again
+	// while has_more is set.
+	replayBatchSize = sendQueueSize / 2
+)
+
+// transientEvents are delivered without a sequence number and never logged:
+// a typing indicator from minutes ago is just noise.
+var transientEvents = map[string]bool{
+	"typing":      true,
+	"stop_typing": true,
+}
+
+// eventType returns the "type" of a JSON event payload.
+func eventType(payload []byte) string {
+	var head struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(payload, &head)
+	return head.Type
+}
+
+// appendEvent assigns the next sequence number of userID to payload, stores
+// it in the retention log and returns the payload with "seq" added. Callers
+// serialize appends per user (Hub.seqLocks) so delivery order matches
+// sequence order.
+func appendEvent(userID string, payload []byte) (int64, []byte, error) {
+	uid, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+	var seq int64
+	err = tx.QueryRow(`INSERT INTO realtime_event_seq (user_id, last_seq) VALUES (?, 1)
+		ON CONFLICT(user_id) DO UPDATE SET last_seq = last_seq + 1
+		RETURNING last_seq`, uid).Scan(&seq)
+	if err != nil {
+		return 0, nil, err
+	}
+	stamped := withSeq(payload, seq)
+	if _, err := tx.Exec("INSERT INTO realtime_events (user_id, seq, event_type, payload) VALUES (?, ?, ?, ?)",
+		uid, seq, eventType(payload), string(stamped)); err != nil {
+		return 0, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, nil, err
+	}
+	return seq, stamped, nil
+}
+
+// withSeq adds a top-level "seq" member to a JSON object payload.
+func withSeq(payload []byte, seq int64) []byte {
+	trimmed := bytes.TrimSpace(payload)
+	if len(trimmed) < 2 || trimmed[0] != '{' {
+		return payload
+	}
+	out := []byte(`{"seq":` + strconv.FormatInt(seq, 10))
+	rest := bytes.TrimSpace(trimmed[1:])
+	if rest[0] != '}' {
+		out = append(out, ',')
+	}
+	return append(out, rest...)
+}
+
+// eventSeq returns the "seq" of a sequenced payload, or 0.
+func eventSeq(payload []byte) int64 {
+	var head struct {
+		Seq int64 `json:"seq"`
+	}
+	json.Unmarshal(payload, &head)
+	return head.Seq
+}
+
+// lastEventSeq returns the latest sequence number assigned to userID.
+func lastEventSeq(userID string) int64 {
+	var seq int64
+	db.DB.QueryRow("SELECT last_seq FROM realtime_event_seq WHERE user_id = ?", userID).Scan(&seq)
+	return seq
+}
+
+// eventsSince returns up to limit retained events of userID with
+// after < seq <= upto, oldest first. complete is false when some of
+// those events were already pruned (or the cursor is unknown), i.e. the
+// client missed events that can no longer be replayed.
+func eventsSince(userID string, after, upto int64, limit int) (events [][]byte, hasMore, complete bool, err error) {
+	if after > upto {
+		// the client's cursor is from a log that no longer exists
+		return nil, false, false, nil
+	}
+	if after == upto {
+		return nil, false, true, nil
+	}
+	var oldest sql.NullInt64
+	if err := db.DB.QueryRow("SELECT MIN(seq) FROM realtime_events WHERE user_id = ?", userID).Scan(&oldest); err != nil {
+		return nil, false, false, err
+	}
+	if !oldest.Valid || oldest.Int64 > after+1 {
+		return nil, false, false, nil
+	}
+
+	rows, err := db.DB.Query(`SELECT payload FROM realtime_events
+		WHERE user_id = ? AND seq > ? AND seq <= ?
+		ORDER BY seq ASC LIMIT ?`, userID, after, upto, limit+1)
+	if err != nil {
+		return nil, false, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			continue
+		}
+		events = append(events, []byte(p))
+	}
+	if len(events) > limit {
+		events = events[:limit]
+		hasMore = true
+	}
+	return events, hasMore, true, nil
+}
+
+// pruneEvents drops realtime events older than eventRetention.
+func pruneEvents() error {
+	cutoff := time.Now().UTC().Add(-eventRetention).Format("2006-01-02 15:04:05")
+	_, err := db.DB.Exec("DELETE FROM realtime_events WHERE created_at < ?", cutoff)
+	return err
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+
+	"social-network/backend/db"
+)
+
+// decodeFrames decodes the frames drained from a connection.
+func decodeFrames(t *testing.T, frames []string) []map[string]interface{} {
+	t.Helper()
+	out := make([]map[string]interface{}, len(frames))
+	for i, f := range frames {
+		if err := json.Unmarshal([]byte(f), &out[i]); err != nil {
+			t.Fatalf("frame %q: %v", f, err)
+		}
+	}
+	return out
+}
+
+// seqsOf returns the seq of every frame, 0 for unsequenced ones.
+func seqsOf(frames []map[string]interface{}) []int64 {
+	seqs := make([]int64, len(frames))
+	for i, f := range frames {
+		if seq, ok := f["seq"].(float64); ok {
+			seqs[i] = int64(seq)
+		}
+	}
+	return seqs
+}
+
+func TestSendToUserSequencesEvents(t *testing.T) {
+	openTestHub(t)
+	alice, bob := newTestClient(t, "alice"), newTestClient(t, "bob")
+	hub.Register(alice)
+
+	for _, typ := range []string{"message", "typing", "receipt", "stop_typing", "message"} {
+		hub.SendToUser(alice.ID, []byte(fmt.Sprintf(`{"type":%q}`, typ)))
+	}
+	hub.SendToUser(bob.ID, []byte(`{"type":"message"}`))
+
+	// typing frames are delivered unsequenced and never logged
+	if got := seqsOf(decodeFrames(t, drain(alice))); !slices.Equal(got, []int64{1, 0, 2, 0, 3}) {
+		t.Errorf("alice's seqs = %v, want [1 0 2 0 3]", got)
+	}
+	var logged []string
+	rows, err := db.DB.Query("SELECT event_type FROM realtime_events WHERE user_id = ? ORDER BY seq", alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var typ string
+		rows.Scan(&typ)
+		logged = append(logged, typ)
+	}
+	rows.Close()
+	if !slices.Equal(logged, []string{"message", "receipt", "message"}) {
+		t.Errorf("logged %v, want message, receipt, message", logged)
+	}
+	// sequences are per user, and an offline user's events are kept too
+	if seq := lastEventSeq(bob.ID); seq != 1 {
+		t.Errorf("bob's last seq = %d, want 1", seq)
+	}
+}
+
+func TestResumeReplaysMissedEvents(t *testing.T) {
+	openTestHub(t)
+	alice := newTestClient(t, "alice")
+	alice.Protocol = protocolV1
+	for range 3 {
+		hub.SendToUser(alice.ID, []byte(`{"type":"message"}`))
+	}
+	hub.SendToUser(alice.ID, []byte(`{"type":"typing"}`))
+
+	hub.Register(alice)
+	if alice.baseSeq != 3 {
+		t.Fatalf("base seq = %d, want 3", alice.baseSeq)
+	}
+	hub.SendToUser(alice.ID, []byte(`{"type":"message"}`)) // live, seq 4
+	drain(alice)
+
+	resume := func(lastSeq int64) []map[string]interface{} {
+		t.Helper()
+		alice.dispatch([]byte(fmt.Sprintf(`{"type":"resume","data":{"last_seq":%d}}`, lastSeq)))
+		return decodeFrames(t, drain(alice))
+	}
+	// only what was missed before connecting; seq 4 arrived live
+	frames := resume(1)
+	if len(frames) != 3 || !slices.Equal(seqsOf(frames[:2]), []int64{2, 3}) {
+		t.Fatalf("resume from 1 = %v, want seqs 2 and 3 and the outcome", frames)
+	}
+	if end := frames[2]; end["type"] != "resumed" || end["replayed"] != 2.0 || end["has_more"] != false || end["seq"] != 3.0 {
+		t.Errorf("resume from 1 ended with %v", end)
+	}
+	if frames := resume(3); len(frames) != 1 || frames[0]["type"] != "resumed" || frames[0]["replayed"] != 0.0 {
+		t.Errorf("resume from the base seq = %v, want nothing replayed", frames)
+	}
+	// a cursor from a log that no longer exists
+	if frames := resume(10); len(frames) != 1 || frames[0]["type"] != "resync_required" || frames[0]["seq"] != 3.0 {
+		t.Errorf("resume from a future seq = %v, want resync_required", frames)
+	}
+
+	// once pruned, missed events can only be reloaded over REST
+	db.DB.Exec("UPDATE realtime_events SET created_at = datetime('now', '-2 days') WHERE user_id = ? AND seq <= 2", alice.ID)
+	pruneEvents()
+	if frames := resume(0); len(frames) != 1 || frames[0]["type"] != "resync_required" {
+		t.Errorf("resume past pruned events = %v, want resync_required", frames)
+	}
+	if frames := resume(2); len(frames) != 2 || frames[1]["type"] != "resumed" {
+		t.Errorf("resume from the oldest retained event = %v, want seq 3 replayed", frames)
+	}
+}
+
+func TestResumeInBatches(t *testing.T) {
+	openTestHub(t)
+	alice := newTestClient(t, "alice")
+	alice.Protocol = protocolV1
+	total := replayBatchSize + 2
+	for range total {
+		hub.SendToUser(alice.ID, []byte(`{"type":"message"}`))
+	}
+	hub.Register(alice)
+
+	var last int64
+	for batch := 1; ; batch++ {
+		alice.dispatch([]byte(fmt.Sprintf(`{"type":"resume","data":{"last_seq":%d}}`, last)))
+		frames := decodeFrames(t, drain(alice))
+		end := frames[len(frames)-1]
+		seqs := seqsOf(frames[:len(frames)-1])
+		if len(seqs) == 0 || seqs[0] != last+1 || len(seqs) > replayBatchSize {
+			t.Fatalf("batch %d replayed %d events from %v", batch, len(seqs), seqs[:min(len(seqs), 1)])
+		}
+		last = seqs[len(seqs)-1]
+		if end["has_more"] != (last < int64(total)) {
+			t.Fatalf("batch %d ended at %d with %v", batch, last, end)
+		}
+		if last == int64(total) {
+			break
+		}
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"social-network/backend/db"
+	"social-network/backend/handlers"
+	"social-network/backend/models"
+	"social-network/backend/utils"
+)
+
+// Inbound payloads, one per frame type (see frameHandlers). Field names match
+// the legacy flat frames so both protocol modes decode into the same structs.
+
+type directMessageFrame struct {
+	ReceiverID    string  `json:"receiver_id"`
+	Content       string  `json:"content"`
+	AttachmentIDs []int64 `json:"attachment_ids"`
+}
+
+func (f *directMessageFrame) validate() error {
+	if id, err := strconv.ParseInt(f.ReceiverID, 10, 64); err != nil || id <= 0 {
+		return badRequest("receiver_id must be a user id.")
+	}
+	if strings.TrimSpace(f.Content) == "" && len(f.AttachmentIDs) == 0 {
+		return badRequest("Message content cannot be empty.")
+	}
+	return checkMessageLength(f.Content)
+}
+
+type groupMessageFrame struct {
+	GroupID       int64   `json:"group_id"`
+	Content       string  `json:"content"`
+	AttachmentIDs []int64 `json:"attachment_ids"`
+}
+
+func (f *groupMessageFrame) validate() error {
+	if f.GroupID <= 0 {
+		return badRequest("group_id is required.")
+	}
+	if strings.TrimSpace(f.Content) == "" && len(f.AttachmentIDs) == 0 {
+		return badRequest("Message content cannot be empty.")
+	}
+	return checkMessageLength(f.Content)
+}
+
+// checkMessageLength enforces handlers.MaxMessageLength on inbound content.
+func checkMessageLength(content string) error {
+	if utf8.RuneCountInString(content) > handlers.MaxMessageLength {
+		return badRequest(fmt.Sprintf("Message cannot be longer than %d characters.", handlers.MaxMessageLength))
+	}
+	return nil
+}
+
+// messageRefFrame addresses an existing message (receipts, deletes,
+// reactions). group_id is only meaningful for reactions.
+type messageRefFrame struct {
+	MessageID int64  `json:"message_id"`
+	GroupID   int64  `json:"group_id"`
+	Content   string `json:"content"`
+	Scope     string `json:"scope"`
+	Emoji     string `json:"emoji"`
+}
+
+func (f *messageRefFrame) validate() error {
+	if f.MessageID <= 0 {
+		return badRequest("message_id is required.")
+	}
+	if f.Scope != "" && f.Scope != "me" && f.Scope != "everyone" {
+		return badRequest(`scope must be "me" or "everyone".`)
+	}
+	return checkMessageLength(f.Content)
+}
+
+// typingFrame targets either one DM peer (receiver_id) or a group chat
+// (group_id).
+type typingFrame struct {
+	ReceiverID string `json:"receiver_id"`
+	GroupID    int64  `json:"group_id"`
+}
+
+func (f *typingFrame) validate() error {
+	if (f.ReceiverID == "") == (f.GroupID == 0) {
+		return badRequest("Exactly one of receiver_id or group_id is required.")
+	}
+	if f.GroupID < 0 {
+		return badRequest("Invalid group_id.")
+	}
+	return nil
+}
+
+type resumeFrame struct {
+	LastSeq int64 `json:"last_seq"`
+}
+
+func (f *resumeFrame) validate() error {
+	if f.LastSeq < 0 {
+		return badRequest("last_seq cannot be negative.")
+	}
+	return nil
+}
+
+type statusFrame struct {
+	Status string `json:"status"`
+}
+
+func (f *statusFrame) validate() error {
+	if !validStatus(f.Status) {
+		return badRequest("status must be one of online, away, dnd, invisible.")
+	}
+	return nil
+}
+
+type idleFrame struct {
+	Idle bool `json:"idle"`
+}
+
+func (f *idleFrame) validate() error { return nil }
+
+type emptyFrame struct{}
+
+func (f *emptyFrame) validate() error { return nil }
+
+// previewOf trims message content to 140 characters for notification
+// payloads.
+func previewOf(content string) string {
+	if utf8.RuneCountInString(content) > 140 {
+		return string([]rune(content)[:140])
+	}
+	return content
+}
+
+// handleDirectMessageFrame persists a DM between users that follow each other
+// in either direction and pushes it to both sides.
+func handleDirectMessageFrame(c *Client, data json.RawMessage) (interface{}, error) {
+	var f directMessageFrame
+	if err := decodeFrame(data, &f); err != nil {
+		return nil, err
+	}
+	content := utils.ExpandEmoji(f.Content)
+	senderID := c.userID()
+	receiverID, _ := strconv.ParseInt(f.ReceiverID, 10, 64)
+
+	var relCount int
+	err := db.DB.QueryRow("SELECT COUNT(1) FROM followers WHERE (follower_id=? AND followed_id=?) OR (follower_id=? AND followed_id=?)", senderID, receiverID, receiverID, senderID).Scan(&relCount)
+	if err != nil {
+		return nil, err
+	}
+	if relCount == 0 {
+		return nil, &frameError{Code: codeForbidden, Message: "You are not allowed to message this user."}
+	}
+	if err := handlers.ValidateAttachments(senderID, f.AttachmentIDs); err != nil {
+		return nil, badRequest("Invalid attachments.")
+	}
+	if recentMessages.seen(c.ID, "user:"+f.ReceiverID, f.Content, f.AttachmentIDs) {
+		return nil, errDuplicateMessage
+	}
+
+	result, err := db.DB.Exec("INSERT INTO messages (sender_id, receiver_id, content, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)", senderID, receiverID, content)
+	if err != nil {
+		return nil, err
+	}
+	msgID, _ := result.LastInsertId()
+	recentMessages.record(c.ID, "user:"+f.ReceiverID, f.Content, f.AttachmentIDs)
+	attachments, err := handlers.LinkAttachments("direct", msgID, senderID, f.AttachmentIDs)
+	if err != nil {
+		log.Println("attachment link error:", err)
+	}
+	var createdAt string
+	db.DB.QueryRow("SELECT created_at FROM messages WHERE id = ?", msgID).Scan(&createdAt)
+
+	out := models.Message{
+		ID:          int(msgID),
+		Type:        "message",
+		Content:     content,
+		SenderID:    c.ID,
+		SenderName:  c.Nickname,
+		ReceiverID:  f.ReceiverID,
+		Attachments: attachments,
+	}
+	// DB returns created_at as a string
+	if parsed, err := time.Parse("2006-01-02 15:04:05", createdAt); err == nil {
+		out.CreatedAt = parsed
+	} else if parsedRFC, err2 := time.Parse(time.RFC3339, createdAt); err2 == nil {
+		out.CreatedAt = parsedRFC
+	}
+	encoded, _ := json.Marshal(out)
+
+	if hub.SendToUser(f.ReceiverID, encoded) {
+		// lightweight realtime notification
+		notification := models.Message{Type: "new_message_notification", SenderID: out.SenderID, SenderName: out.SenderName, Content: out.Content}
+		notifPayload, _ := json.Marshal(notification)
+		hub.SendToUser(f.ReceiverID, notifPayload)
+	}
+	// persist & publish structured notification (store preview only)
+	_ = handlers.Notify(receiverID, senderID, "new_message", map[string]interface{}{"message_id": msgID, "conversation_id": receiverID, "preview": previewOf(content), "url": "/chat"})
+
+	// echo back to every connection of the sender
+	hub.SendToUser(c.ID, encoded)
+	return map[string]interface{}{"message_id": msgID, "created_at": out.CreatedAt}, nil
+}
+
+// handleGroupMessageFrame persists a group chat message and pushes it to
+// every member.
+func handleGroupMessageFrame(c *Client, data json.RawMessage) (interface{}, error) {
+	var f groupMessageFrame
+	if err := decodeFrame(data, &f); err != nil {
+		return nil, err
+	}
+	content := utils.ExpandEmoji(f.Content)
+	senderID := c.userID()
+
+	var memberCount int
+	err := db.DB.QueryRow("SELECT COUNT(1) FROM group_members WHERE group_id=? AND user_id=?", f.GroupID, senderID).Scan(&memberCount)
+	if err != nil {
+		return nil, err
+	}
+	if memberCount == 0 {
+		return nil, &frameError{Code: codeForbidden, Message: "You are not a member of this group."}
+	}
+	if err := handlers.ValidateAttachments(senderID, f.AttachmentIDs); err != nil {
+		return nil, badRequest("Invalid attachments.")
+	}
+	if recentMessages.seen(c.ID, "group:"+strconv.FormatInt(f.GroupID, 10), f.Content, f.AttachmentIDs) {
+		return nil, errDuplicateMessage
+	}
+
+	res, err := db.DB.Exec("INSERT INTO group_messages (group_id, sender_id, content, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)", f.GroupID, senderID, content)
+	if err != nil {
+		return nil, err
+	}
+	gmID, _ := res.LastInsertId()
+	recentMessages.record(c.ID, "group:"+strconv.FormatInt(f.GroupID, 10), f.Content, f.AttachmentIDs)
+	attachments, err := handlers.LinkAttachments("group", gmID, senderID, f.AttachmentIDs)
+	if err != nil {
+		log.Println("attachment link error:", err)
+	}
+
+	out := map[string]interface{}{
+		"id":          gmID,
+		"type":        "group_message",
+		"group_id":    f.GroupID,
+		"content":     content,
+		"sender_id":   c.ID,
+		"sender_name": c.Nickname,
+	}
+	if len(attachments) > 0 {
+		out["attachments"] = attachments
+	}
+	encoded, _ := json.Marshal(out)
+
+	rows, err := db.DB.Query("SELECT user_id FROM group_members WHERE group_id=? AND user_id != ?", f.GroupID, senderID)
+	if err != nil {
+		return nil, err
+	}
+	var recipients []int64
+	for rows.Next() {
+		var uid int64
+		if err := rows.Scan(&uid); err == nil {
+			recipients = append(recipients, uid)
+		}
+	}
+	rows.Close()
+
+	// realtime to connected members; the persistent notifications are
+	// written by a background job
+	for _, rid := range recipients {
+		hub.SendToUser(strconv.FormatInt(rid, 10), encoded)
+	}
+	handlers.NotifyMany(recipients, senderID, "group_message", map[string]interface{}{"message_id": gmID, "group_id": f.GroupID, "preview": previewOf(content), "url": fmt.Sprintf("/groups/%d", f.GroupID)})
+	// also echo to every connection of the sender
+	hub.SendToUser(c.ID, encoded)
+	return map[string]interface{}{"message_id": gmID}, nil
+}
+
+// handleReceiptFrame handles delivery ("ack") and read ("read") receipts for
+// DMs; the receipt is pushed to the original sender through the bus.
+func handleReceiptFrame(kind string) frameHandler {
+	return func(c *Client, data json.RawMessage) (interface{}, error) {
+		var f messageRefFrame
+		if err := decodeFrame(data, &f); err != nil {
+			return nil, err
+		}
+		var err error
+		if kind == "ack" {
+			_, err = handlers.AcknowledgeMessage(c.userID(), f.MessageID)
+		} else {
+			_, err = handlers.ReadMessage(c.userID(), f.MessageID)
+		}
+		return nil, err
+	}
+}
+
+// handleEditFrame edits a DM or group message; update events reach every
+// participant through the bus.
+func handleEditFrame(kind string) frameHandler {
+	return func(c *Client, data json.RawMessage) (interface{}, error) {
+		var f messageRefFrame
+		if err := decodeFrame(data, &f); err != nil {
+			return nil, err
+		}
+		content := utils.ExpandEmoji(f.Content)
+		if kind == "group" {
+			return nil, handlers.EditGroupMessage(c.userID(), f.MessageID, content)
+		}
+		return nil, handlers.EditDirectMessage(c.userID(), f.MessageID, content)
+	}
+}
+
+// handleDeleteFrame deletes a DM or group message for everyone (default) or,
+// with scope "me", only for the requester.
+func handleDeleteFrame(kind string) frameHandler {
+	return func(c *Client, data json.RawMessage) (interface{}, error) {
+		var f messageRefFrame
+		if err := decodeFrame(data, &f); err != nil {
+			return nil, err
+		}
+		forEveryone := f.Scope != "me"
+		if kind == "group" {
+			return nil, handlers.DeleteGroupMessage(c.userID(), f.MessageID, forEveryone)
+		}
+		return nil, handlers.DeleteDirectMessage(c.userID(), f.MessageID, forEveryone)
+	}
+}
+
+// handleReactionFrame adds or removes a reaction; group_id selects a group
+// message, otherwise a DM.
+func handleReactionFrame(remove bool) frameHandler {
+	return func(c *Client, data json.RawMessage) (interface{}, error) {
+		var f messageRefFrame
+		if err := decodeFrame(data, &f); err != nil {
+			return nil, err
+		}
+		kind := "direct"
+		if f.GroupID != 0 {
+			kind = "group"
+		}
+		return nil, handlers.SetReaction(kind, c.userID(), f.MessageID, f.Emoji, remove)
+	}
+}
+
+// handleTypingFrame forwards typing / stop_typing to the receiver if online,
+// or to the connected members of a group the sender belongs to. Frames from
+// an invisible sender are dropped.
+func handleTypingFrame(typ string) frameHandler {
+	return func(c *Client, data json.RawMessage) (interface{}, error) {
+		var f typingFrame
+		if err := decodeFrame(data, &f); err != nil {
+			return nil, err
+		}
+		if f.GroupID != 0 {
+			return nil, sendGroupTyping(c, typ, f.GroupID)
+		}
+		if invisible, err := isInvisible(c.ID); err != nil || invisible {
+			return nil, err
+		}
+		if !hub.IsOnline(f.ReceiverID) {
+			return nil, nil
+		}
+		out := models.Message{Type: typ, SenderID: c.ID, ReceiverID: f.ReceiverID}
+		if typ == "typing" {
+			out.SenderName = c.Nickname
+		}
+		payload, _ := json.Marshal(out)
+		hub.SendToUser(f.ReceiverID, payload)
+		return nil, nil
+	}
+}
+
+func sendGroupTyping(c *Client, typ string, groupID int64) error {
+	if !handlers.IsGroupMember(groupID, c.userID()) {
+		return &frameError{Code: codeForbidden, Message: "You are not a member of this group."}
+	}
+	if invisible, err := isInvisible(c.ID); err != nil || invisible {
+		return err
+	}
+	online, err := connectedGroupMembers(groupID)
+	if err != nil {
+		return err
+	}
+	out := map[string]interface{}{"type": typ, "group_id": groupID, "sender_id": c.ID}
+	if typ == "typing" {
+		out["sender_name"] = c.Nickname
+	}
+	payload, _ := json.Marshal(out)
+	for _, id := range online {
+		if id != c.userID() {
+			hub.SendToUser(strconv.FormatInt(id, 10), payload)
+		}
+	}
+	return nil
+}
+
+// handleSetStatusFrame changes the user's chosen presence status.
+func handleSetStatusFrame(c *Client, data json.RawMessage) (interface{}, error) {
+	var f statusFrame
+	if err := decodeFrame(data, &f); err != nil {
+		return nil, err
+	}
+	p, err := updatePresenceSettings(c.ID, f.Status, "")
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"status": p.status}, nil
+}
+
+// handleIdleFrame records whether the user is idle on this connection; a
+// user idle on every connection shows as away.
+func handleIdleFrame(c *Client, data json.RawMessage) (interface{}, error) {
+	var f idleFrame
+	if err := decodeFrame(data, &f); err != nil {
+		return nil, err
+	}
+	hub.SetIdle(c, f.Idle)
+	if hub.UpdateIdle(c.ID) {
+		publishPresence(c.ID)
+	}
+	return nil, nil
+}
+
+func handleUserListFrame(c *Client, data json.RawMessage) (interface{}, error) {
+	var f emptyFrame
+	if err := decodeFrame(data, &f); err != nil {
+		return nil, err
+	}
+	sendUserList(c.ID)
+	return nil, nil
+}
+
+// handleResumeFrame replays the events this connection's user missed after
+// last_seq. The outcome is reported in a "resumed" frame (has_more asks the
+// client to resume again from the last replayed seq) or, when the events were
+// already pruned, a "resync_required" frame telling the client to reload its
+// state over REST and continue from seq.
+func handleResumeFrame(c *Client, data json.RawMessage) (interface{}, error) {
+	var f resumeFrame
+	if err := decodeFrame(data, &f); err != nil {
+		return nil, err
+	}
+	replayed, hasMore, complete, err := hub.Replay(c, f.LastSeq)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]interface{}{"type": "resumed", "seq": c.baseSeq, "replayed": replayed, "has_more": hasMore}
+	if !complete {
+		out = map[string]interface{}{"type": "resync_required", "seq": c.baseSeq}
+	}
+	payload, _ := json.Marshal(out)
+	c.send(payload)
+	return nil, nil
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestPreviewOfKeepsWholeCharacters(t *testing.T) {
+	for _, content := range []string{"short", strings.Repeat("a", 200), "a" + strings.Repeat("é", 200), strings.Repeat("👋", 150)} {
+		got := previewOf(content)
+		if !utf8.ValidString(got) {
+			t.Errorf("previewOf(%.10q...) = %q, not valid UTF-8", content, got)
+		}
+		if want := min(utf8.RuneCountInString(content), 140); utf8.RuneCountInString(got) != want || !strings.HasPrefix(content, got) {
+			t.Errorf("previewOf(%.10q...) has %d characters, want a %d character prefix", content, utf8.RuneCountInString(got), want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package handlers
+
+// Activity is something that happened that users hear about: its recipients
+// are notified and the webhooks subscribed to its event receive it. Handlers
+// report an action once, through Emit, so notifications and webhooks cannot
+// drift apart.
+type Activity struct {
+	// Event is the webhook event (see WebhookEvents), or "" for activity
+	// that only notifies.
+	Event   string
+	ActorID int64
+	// GroupID is the group the activity happened in, 0 outside groups.
+	GroupID int64
+	// Data is the webhook payload.
+	Data map[string]interface{}
+
+	// Recipients are notified with a NotifyType notification carrying
+	// Notification.
+	Recipients   []int64
+	NotifyType   string
+	Notification map[string]interface{}
+}
+
+// Emit notifies a's recipients, inline for one and through a fan-out job
+// for several, and queues a's webhook deliveries.
+func Emit(a Activity) {
+	switch {
+	case len(a.Recipients) == 1:
+		_ = Notify(a.Recipients[0], a.ActorID, a.NotifyType, a.Notification)
+	case len(a.Recipients) > 1:
+		NotifyMany(a.Recipients, a.ActorID, a.NotifyType, a.Notification)
+	}
+	if a.Event != "" {
+		emitWebhookEvent(a.Event, a.ActorID, a.GroupID, a.Data)
+	}
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"social-network/backend/jobs"
+	"social-network/backend/utils"
+)
+
+// The /api/admin/jobs endpoints are mounted behind AdminMiddleware.
+
+// AdminJobsHandler - GET /api/admin/jobs?status=&type=&limit=
+// The most recent jobs, optionally only of one status or type.
+func AdminJobsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	list, err := jobs.List(jobs.Filter{Status: q.Get("status"), Type: q.Get("type"), Limit: limit})
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]interface{}{"jobs": list})
+}
+
+// AdminJobStatsHandler - GET /api/admin/jobs/stats
+// Job counts by type and status, and the schedules with their next run.
+func AdminJobStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := jobs.GetStats()
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	utils.JSON(w, http.StatusOK, stats)
+}
+
+// AdminRetryJobHandler - POST /api/admin/jobs/retry { id }
+// Queues a failed or cancelled job again.
+func AdminRetryJobHandler(w http.ResponseWriter, r *http.Request) {
+	adminJobAction(w, r, jobs.Retry, "queued", "No failed or cancelled job with this id")
+}
+
+// AdminCancelJobHandler - POST /api/admin/jobs/cancel { id }
+// Cancels a pending job.
+func AdminCancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	adminJobAction(w, r, jobs.Cancel, "cancelled", "No pending job with this id")
+}
+
+func adminJobAction(w http.ResponseWriter, r *http.Request, action func(int64) error, status, notFound string) {
+	var payload struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.ID <= 0 {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	err := action(payload.ID)
+	if errors.Is(err, jobs.ErrNotFound) {
+		utils.Error(w, http.StatusNotFound, notFound)
+		return
+	}
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]string{"status": status})
+}
+
+// AdminRunScheduleHandler - POST /api/admin/jobs/run-schedule { name }
+// Runs a scheduled job now, outside its schedule.
+func AdminRunScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.Name == "" {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	id, err := jobs.RunScheduleNow(payload.Name)
+	if errors.Is(err, jobs.ErrNoSchedule) {
+		utils.Error(w, http.StatusNotFound, "Schedule not found")
+		return
+	}
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to queue job")
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]interface{}{"status": "queued", "job_id": id})
+}
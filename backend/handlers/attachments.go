@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	// register decoders used to read image dimensions
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"social-network/backend/db"
+	"social-network/backend/models"
+	"social-network/backend/utils"
+)
+
+// attachmentsDir holds chat uploads. It lives outside backend/uploads so the
+// files are never reachable through the public /uploads/ file server.
+var attachmentsDir = filepath.Join("backend", "attachments")
+
+// maxAttachmentsPerMessage bounds how many uploads one message may carry.
+const maxAttachmentsPerMessage = 10
+
+// allowedAttachmentTypes lists the sniffed content types accepted for chat.
+var allowedAttachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
+var (
+	ErrAttachmentType    = errors.New("unsupported attachment type")
+	ErrInvalidAttachment = errors.New("invalid attachment")
+)
+
+// saveChatAttachment stores an uploaded chat file and records its metadata.
+// The attachment stays private to the uploader until it is linked to a
+// message.
+func saveChatAttachment(uploaderID int64, file multipart.File, header *multipart.FileHeader) (models.Attachment, error) {
+	var att models.Attachment
+
+	// sniff the real content type from the first 512 bytes
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return att, err
+	}
+	head = head[:n]
+	mimeType := http.DetectContentType(head)
+	if i := strings.Index(mimeType, ";"); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	if !allowedAttachmentTypes[mimeType] {
+		return att, ErrAttachmentType
+	}
+
+	if err := os.MkdirAll(attachmentsDir, 0755); err != nil {
+		return att, err
+	}
+	filename := fmt.Sprintf("%d-%d%s", time.Now().UnixNano(), uploaderID, strings.ToLower(filepath.Ext(header.Filename)))
+	savePath := filepath.Join(attachmentsDir, filename)
+	dst, err := os.Create(savePath)
+	if err != nil {
+		return att, err
+	}
+	defer dst.Close()
+	size, err := io.Copy(dst, io.MultiReader(bytes.NewReader(head), file))
+	if err != nil {
+		os.Remove(savePath)
+		return att, err
+	}
+
+	var width, height sql.NullInt64
+	if strings.HasPrefix(mimeType, "image/") {
+		if _, err := dst.Seek(0, io.SeekStart); err == nil {
+			if cfg, _, err := image.DecodeConfig(dst); err == nil {
+				width = sql.NullInt64{Int64: int64(cfg.Width), Valid: true}
+				height = sql.NullInt64{Int64: int64(cfg.Height), Valid: true}
+			}
+		}
+	}
+
+	res, err := db.DB.Exec("INSERT INTO attachments (uploader_id, file_path, original_name, mime_type, size_bytes, width, height) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		uploaderID, savePath, filepath.Base(header.Filename), mimeType, size, width, height)
+	if err != nil {
+		os.Remove(savePath)
+		return att, err
+	}
+	id, _ := res.LastInsertId()
+	att = models.Attachment{
+		ID:       id,
+		URL:      fmt.Sprintf("/api/attachments/%d", id),
+		Name:     filepath.Base(header.Filename),
+		MimeType: mimeType,
+		Size:     size,
+		Width:    int(width.Int64),
+		Height:   int(height.Int64),
+	}
+	return att, nil
+}
+
+// LinkAttachments attaches previously uploaded, not yet linked files owned by
+// uploaderID to a message ("direct" or "group") and returns their
+// descriptors.
+func LinkAttachments(kind string, messageID, uploaderID int64, ids []int64) ([]models.Attachment, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if len(ids) > maxAttachmentsPerMessage {
+		return nil, ErrInvalidAttachment
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := []interface{}{kind, messageID, uploaderID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	res, err := db.DB.Exec(`UPDATE attachments SET kind = ?, message_id = ?
+		WHERE uploader_id = ? AND message_id IS NULL AND id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n != int64(len(ids)) {
+		return nil, ErrInvalidAttachment
+	}
+	attachments, err := loadAttachments(kind, []int64{messageID})
+	if err != nil {
+		return nil, err
+	}
+	return attachments[messageID], nil
+}
+
+// ValidateAttachments checks that every id is an unlinked upload owned by
+// uploaderID, so a message is not persisted with attachments that cannot be
+// linked afterwards.
+func ValidateAttachments(uploaderID int64, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if len(ids) > maxAttachmentsPerMessage {
+		return ErrInvalidAttachment
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := []interface{}{uploaderID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	var cnt int
+	err := db.DB.QueryRow(`SELECT COUNT(1) FROM attachments
+		WHERE uploader_id = ? AND message_id IS NULL AND id IN (`+placeholders+`)`, args...).Scan(&cnt)
+	if err != nil {
+		return err
+	}
+	if cnt != len(ids) {
+		return ErrInvalidAttachment
+	}
+	return nil
+}
+
+// loadAttachments returns attachment descriptors for a batch of messages of
+// one kind, keyed by message id.
+func loadAttachments(kind string, messageIDs []int64) (map[int64][]models.Attachment, error) {
+	out := make(map[int64][]models.Attachment)
+	if len(messageIDs) == 0 {
+		return out, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(messageIDs)), ",")
+	args := []interface{}{kind}
+	for _, id := range messageIDs {
+		args = append(args, id)
+	}
+	rows, err := db.DB.Query(`
+		SELECT id, message_id, IFNULL(original_name, ''), mime_type, size_bytes, IFNULL(width, 0), IFNULL(height, 0)
+		FROM attachments
+		WHERE kind = ? AND message_id IN (`+placeholders+`)
+		ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a models.Attachment
+		var messageID int64
+		if err := rows.Scan(&a.ID, &messageID, &a.Name, &a.MimeType, &a.Size, &a.Width, &a.Height); err != nil {
+			continue
+		}
+		a.URL = fmt.Sprintf("/api/attachments/%d", a.ID)
+		out[messageID] = append(out[messageID], a)
+	}
+	return out, nil
+}
+
+// canAccessAttachment reports whether userID may download an attachment:
+// the uploader always can; otherwise the viewer must be a participant of the
+// DM or a member of the group it was sent to.
+func canAccessAttachment(userID, uploaderID int64, kind sql.NullString, messageID sql.NullInt64) bool {
+	if userID == uploaderID {
+		return true
+	}
+	if !kind.Valid || !messageID.Valid {
+		return false
+	}
+	switch kind.String {
+	case "direct":
+		ref, err := loadDirectMessageRef(messageID.Int64)
+		return err == nil && !ref.Deleted && (ref.SenderID == userID || ref.ReceiverID == userID)
+	case "group":
+		ref, err := loadGroupMessageRef(messageID.Int64)
+		return err == nil && !ref.Deleted && IsGroupMember(ref.GroupID, userID)
+	}
+	return false
+}
+
+// GET /api/attachments/<id> - stream a chat attachment to an authorized user
+func GetAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	userIDStr := utils.GetUserIDFromContext(r)
+	if userIDStr == "" {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/attachments/"), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusBadRequest, "Invalid attachment id")
+		return
+	}
+
+	var uploaderID int64
+	var kind sql.NullString
+	var messageID sql.NullInt64
+	var path, name, mimeType string
+	err = db.DB.QueryRow("SELECT uploader_id, kind, message_id, file_path, IFNULL(original_name, ''), mime_type FROM attachments WHERE id = ?", id).
+		Scan(&uploaderID, &kind, &messageID, &path, &name, &mimeType)
+	if err != nil || !canAccessAttachment(userID, uploaderID, kind, messageID) {
+		// do not reveal whether the attachment exists
+		utils.Error(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		utils.Error(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to read attachment")
+		return
+	}
+
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	disposition := "inline"
+	if !strings.HasPrefix(mimeType, "image/") {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=%q", disposition, name))
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"social-network/backend/db"
+	"social-network/backend/models"
+)
+
+// uploadTestAttachment stores a file with the given content as uploaderID's
+// chat attachment.
+func uploadTestAttachment(t *testing.T, uploaderID int64, name string, write func(*os.File) error) (models.Attachment, error) {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := write(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Seek(0, 0)
+	return saveChatAttachment(uploaderID, f, &multipart.FileHeader{Filename: name})
+}
+
+func TestAttachmentAccess(t *testing.T) {
+	openTestDB(t)
+	dir := attachmentsDir
+	attachmentsDir = t.TempDir()
+	t.Cleanup(func() { attachmentsDir = dir })
+	alice, bob, carol := createTestUser(t, "alice"), createTestUser(t, "bob"), createTestUser(t, "carol")
+
+	upload := func() models.Attachment {
+		t.Helper()
+		att, err := uploadTestAttachment(t, alice, "dot.png", func(f *os.File) error {
+			return png.Encode(f, image.NewRGBA(image.Rect(0, 0, 3, 2)))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return att
+	}
+	fetch := func(userID int64, att models.Attachment) int {
+		t.Helper()
+		return call(t, GetAttachmentHandler, userID, fmt.Sprintf("/api/attachments/%d", att.ID), nil, nil)
+	}
+
+	dm := upload()
+	if dm.MimeType != "image/png" || dm.Width != 3 || dm.Height != 2 || dm.Name != "dot.png" {
+		t.Errorf("uploaded %+v, want a 3x2 image/png named dot.png", dm)
+	}
+	if _, err := uploadTestAttachment(t, alice, "page.png", func(f *os.File) error {
+		_, err := f.WriteString("<html><script>alert(1)</script></html>")
+		return err
+	}); err != ErrAttachmentType {
+		t.Errorf("uploading HTML = %v, want ErrAttachmentType", err)
+	}
+
+	// until it is sent, only the uploader can see or use an attachment
+	if code := fetch(alice, dm); code != http.StatusOK {
+		t.Errorf("uploader fetching an unsent attachment = %d", code)
+	}
+	if code := fetch(bob, dm); code != http.StatusNotFound {
+		t.Errorf("bob fetching an unsent attachment = %d, want 404", code)
+	}
+	if err := ValidateAttachments(bob, []int64{dm.ID}); err != ErrInvalidAttachment {
+		t.Errorf("bob sending alice's attachment = %v, want ErrInvalidAttachment", err)
+	}
+
+	msg := sendTestDM(t, alice, bob, "look")
+	if err := ValidateAttachments(alice, []int64{dm.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if linked, err := LinkAttachments("direct", msg, alice, []int64{dm.ID}); err != nil || len(linked) != 1 || linked[0].ID != dm.ID {
+		t.Fatalf("linking = %+v, %v", linked, err)
+	}
+	if _, err := LinkAttachments("direct", sendTestDM(t, alice, carol, "again"), alice, []int64{dm.ID}); err != ErrInvalidAttachment {
+		t.Errorf("linking a sent attachment again = %v, want ErrInvalidAttachment", err)
+	}
+	for user, want := range map[int64]int{alice: http.StatusOK, bob: http.StatusOK, carol: http.StatusNotFound} {
+		if code := fetch(user, dm); code != want {
+			t.Errorf("user %d fetching a DM attachment = %d, want %d", user, code, want)
+		}
+	}
+
+	// deleting the message for everyone revokes the receiver's access
+	if err := DeleteDirectMessage(alice, msg, true); err != nil {
+		t.Fatal(err)
+	}
+	if code := fetch(bob, dm); code != http.StatusNotFound {
+		t.Errorf("bob fetching a deleted message's attachment = %d, want 404", code)
+	}
+
+	res, err := db.DB.Exec("INSERT INTO groups (owner_id, name) VALUES (?, 'g')", alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, _ := res.LastInsertId()
+	db.DB.Exec("INSERT INTO group_members (group_id, user_id) VALUES (?, ?), (?, ?)", group, alice, group, carol)
+	res, err = db.DB.Exec("INSERT INTO group_messages (group_id, sender_id, content) VALUES (?, ?, 'look')", group, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupMsg, _ := res.LastInsertId()
+	shared := upload()
+	if _, err := LinkAttachments("group", groupMsg, alice, []int64{shared.ID}); err != nil {
+		t.Fatal(err)
+	}
+	for user, want := range map[int64]int{carol: http.StatusOK, bob: http.StatusNotFound} {
+		if code := fetch(user, shared); code != want {
+			t.Errorf("user %d fetching a group attachment = %d, want %d", user, code, want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"net/http"
 	"social-network/backend/db"
@@ -59,48 +60,45 @@ func GetAllUsers(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(users)
 }
 
-// GetMessageHistory - Returns message history with proper pagination
-// "Reload the last 10 messages and when scrolled up to see more messages"
-func GetMessageHistory(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	userID := r.Context().Value(utils.UserIDKey).(string)
-	otherUserID := r.URL.Query().Get("user_id")
-	offsetStr := r.URL.Query().Get("offset")
+// historyDefaultLimit is the page size when ?limit is not given: "Reload the
+// last 10 messages and when scrolled up to see more messages".
+const (
+	historyDefaultLimit = 10
+	historyMaxLimit     = 100
+)
 
-	if otherUserID == "" {
-		http.Error(w, `{"error":"Missing user_id parameter"}`, http.StatusBadRequest)
-		return
-	}
+// conversationFilter restricts messages m to the DM conversation between the
+// viewer and the other user, minus anything the viewer deleted for themself.
+// Arguments: viewer, other, other, viewer, viewer.
+const conversationFilter = `((m.sender_id = ? AND m.receiver_id = ?) OR (m.sender_id = ? AND m.receiver_id = ?))
+	AND m.id NOT IN (SELECT message_id FROM hidden_messages WHERE user_id = ? AND kind = 'direct')`
 
-	offset := 0
-	if offsetStr != "" {
-		if parsed, err := strconv.Atoi(offsetStr); err == nil {
-			offset = parsed
-		}
-	}
-
-	// CRITICAL: Must use DESC order for pagination to work correctly
-	// Frontend will reverse for display
-	rows, err := db.DB.Query(`
-		SELECT m.id, m.sender_id, u.nickname, m.receiver_id, m.content, m.created_at
+// queryConversation loads up to limit messages of a conversation matching an
+// extra id condition, in the given id order.
+func queryConversation(viewerID, otherID int64, idCond string, idArg int64, order string, limit int) ([]models.Message, error) {
+	query := `
+		SELECT m.id, m.sender_id, u.nickname, m.receiver_id, IFNULL(m.content, ''), m.created_at, m.edited_at, m.deleted_at IS NOT NULL
 		FROM messages m
 		JOIN users u ON m.sender_id = u.id
-		WHERE (m.sender_id = ? AND m.receiver_id = ?) 
-			OR (m.sender_id = ? AND m.receiver_id = ?)
-		ORDER BY m.created_at DESC, m.id DESC
-		LIMIT 10 OFFSET ?`,
-		userID, otherUserID, otherUserID, userID, offset)
+		WHERE ` + conversationFilter
+	args := []interface{}{viewerID, otherID, otherID, viewerID, viewerID}
+	if idCond != "" {
+		query += " AND m.id " + idCond + " ?"
+		args = append(args, idArg)
+	}
+	query += " ORDER BY m.id " + order + " LIMIT ?"
+	args = append(args, limit)
 
+	rows, err := db.DB.Query(query, args...)
 	if err != nil {
-		http.Error(w, `{"error":"Database error"}`, http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
 	var messages []models.Message
 	for rows.Next() {
 		var msg models.Message
+		var editedAt sql.NullTime
 		if err := rows.Scan(
 			&msg.ID,
 			&msg.SenderID,
@@ -108,16 +106,158 @@ func GetMessageHistory(w http.ResponseWriter, r *http.Request) {
 			&msg.ReceiverID,
 			&msg.Content,
 			&msg.CreatedAt,
+			&editedAt,
+			&msg.Deleted,
 		); err != nil {
 			continue
 		}
+		if editedAt.Valid {
+			msg.EditedAt = &editedAt.Time
+		}
 		messages = append(messages, msg)
 	}
+	return messages, nil
+}
 
-	// Reverse so frontend gets oldest-first for display
+// conversationHasMessage reports whether a visible message matching the id
+// condition exists in the conversation.
+func conversationHasMessage(viewerID, otherID int64, idCond string, idArg int64) bool {
+	var exists int
+	db.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM messages m WHERE `+conversationFilter+` AND m.id `+idCond+` ?)`,
+		viewerID, otherID, otherID, viewerID, viewerID, idArg).Scan(&exists)
+	return exists == 1
+}
+
+func reverseMessages(messages []models.Message) {
 	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
 		messages[i], messages[j] = messages[j], messages[i]
 	}
+}
+
+// GetMessageHistory - GET /api/messages/history?user_id=<id>
+// Cursor-paginated DM history, always returned oldest-first:
+//   - no cursor: the newest page
+//   - before_id: the page of messages older than before_id
+//   - after_id: the page of messages newer than after_id
+//   - around_id: a page centered on around_id ("jump to message")
+//
+// limit defaults to 10 (max 100). Cursors are message ids, so messages that
+// arrive between requests never shift the pages.
+func GetMessageHistory(w http.ResponseWriter, r *http.Request) {
+	userID := utils.GetUserIDFromContext(r)
+	viewerID, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("user_id") == "" {
+		utils.Error(w, http.StatusBadRequest, "Missing user_id parameter")
+		return
+	}
+	otherID, err := strconv.ParseInt(q.Get("user_id"), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusBadRequest, "Invalid user_id")
+		return
+	}
+
+	limit := historyDefaultLimit
+	if l := q.Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed <= 0 {
+			utils.Error(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = parsed
+	}
+	if limit > historyMaxLimit {
+		limit = historyMaxLimit
+	}
+
+	// exactly one cursor may be supplied
+	var mode string
+	var cursor int64
+	for _, name := range []string{"before_id", "after_id", "around_id"} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		if mode != "" {
+			utils.Error(w, http.StatusBadRequest, "Only one of before_id, after_id, around_id may be given")
+			return
+		}
+		cursor, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || cursor <= 0 {
+			utils.Error(w, http.StatusBadRequest, "Invalid "+name)
+			return
+		}
+		mode = name
+	}
+
+	page := models.MessagePage{Messages: []models.Message{}}
+	var messages []models.Message
+	switch mode {
+	case "after_id":
+		messages, err = queryConversation(viewerID, otherID, ">", cursor, "ASC", limit+1)
+		if err == nil && len(messages) > limit {
+			messages = messages[:limit]
+			page.HasMore = true
+		}
+	case "around_id":
+		if !conversationHasMessage(viewerID, otherID, "=", cursor) {
+			utils.Error(w, http.StatusNotFound, "Message not found")
+			return
+		}
+		// the target plus up to half a page on each side
+		var older, newer []models.Message
+		older, err = queryConversation(viewerID, otherID, "<=", cursor, "DESC", limit/2+1)
+		if err == nil {
+			newer, err = queryConversation(viewerID, otherID, ">", cursor, "ASC", limit-len(older))
+		}
+		reverseMessages(older)
+		messages = append(older, newer...)
+	default:
+		idCond := ""
+		if mode == "before_id" {
+			idCond = "<"
+		}
+		messages, err = queryConversation(viewerID, otherID, idCond, cursor, "DESC", limit+1)
+		if err == nil && len(messages) > limit {
+			messages = messages[:limit]
+			page.HasMore = true
+		}
+		// Reverse so frontend gets oldest-first for display
+		reverseMessages(messages)
+	}
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	if len(messages) > 0 {
+		page.Messages = messages
+		page.HasMoreBefore = conversationHasMessage(viewerID, otherID, "<", int64(messages[0].ID))
+		page.HasMoreAfter = conversationHasMessage(viewerID, otherID, ">", int64(messages[len(messages)-1].ID))
+		if mode == "around_id" {
+			page.HasMore = page.HasMoreBefore || page.HasMoreAfter
+		}
+	}
+
+	// attach aggregated reactions and attachment descriptors in batched queries
+	ids := make([]int64, len(page.Messages))
+	for i := range page.Messages {
+		ids[i] = int64(page.Messages[i].ID)
+	}
+	if reactions, err := loadReactions("direct", ids, viewerID); err == nil {
+		for i := range page.Messages {
+			page.Messages[i].Reactions = reactions[int64(page.Messages[i].ID)]
+		}
+	}
+	if attachments, err := loadAttachments("direct", ids); err == nil {
+		for i := range page.Messages {
+			page.Messages[i].Attachments = attachments[int64(page.Messages[i].ID)]
+		}
+	}
 
-	json.NewEncoder(w).Encode(messages)
+	utils.JSON(w, http.StatusOK, page)
 }
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+
+	"social-network/backend/models"
+)
+
+// historyIDs returns the message ids of one history page, oldest first.
+func historyIDs(t *testing.T, viewerID, otherID int64, query string) ([]int64, models.MessagePage) {
+	t.Helper()
+	var page models.MessagePage
+	target := fmt.Sprintf("/api/messages/history?user_id=%d%s", otherID, query)
+	if code := call(t, GetMessageHistory, viewerID, target, nil, &page); code != http.StatusOK {
+		t.Fatalf("GET %s: %d", target, code)
+	}
+	ids := make([]int64, len(page.Messages))
+	for i, m := range page.Messages {
+		ids[i] = int64(m.ID)
+	}
+	return ids, page
+}
+
+func TestMessageHistoryCursors(t *testing.T) {
+	openTestDB(t)
+	alice, bob, carol := createTestUser(t, "alice"), createTestUser(t, "bob"), createTestUser(t, "carol")
+	var ids []int64
+	for i := range 9 {
+		if i%2 == 0 {
+			ids = append(ids, sendTestDM(t, alice, bob, "ping"))
+		} else {
+			ids = append(ids, sendTestDM(t, bob, alice, "pong"))
+		}
+		sendTestDM(t, carol, alice, "elsewhere")
+	}
+
+	check := func(query string, want []int64, more, before, after bool) {
+		t.Helper()
+		got, page := historyIDs(t, alice, bob, query)
+		if !slices.Equal(got, want) || page.HasMore != more || page.HasMoreBefore != before || page.HasMoreAfter != after {
+			t.Errorf("%s = %v more=%v before=%v after=%v; want %v %v %v %v",
+				query, got, page.HasMore, page.HasMoreBefore, page.HasMoreAfter, want, more, before, after)
+		}
+	}
+	check("", ids[:9], false, false, false)
+	check("&limit=4", ids[5:], true, true, false)
+	check(fmt.Sprintf("&limit=4&before_id=%d", ids[5]), ids[1:5], true, true, true)
+	check(fmt.Sprintf("&limit=4&before_id=%d", ids[1]), ids[:1], false, false, true)
+	check(fmt.Sprintf("&limit=4&after_id=%d", ids[0]), ids[1:5], true, true, true)
+	check(fmt.Sprintf("&limit=4&after_id=%d", ids[4]), ids[5:], false, true, false)
+	check(fmt.Sprintf("&limit=4&around_id=%d", ids[4]), ids[2:6], true, true, true)
+	check(fmt.Sprintf("&limit=4&around_id=%d", ids[8]), ids[6:], true, true, false)
+
+	// hidden messages are skipped without disturbing the pages around them
+	if err := DeleteDirectMessage(alice, ids[4], false); err != nil {
+		t.Fatal(err)
+	}
+	check(fmt.Sprintf("&limit=4&before_id=%d", ids[6]), []int64{ids[1], ids[2], ids[3], ids[5]}, true, true, true)
+	if code := call(t, GetMessageHistory, alice, fmt.Sprintf("/api/messages/history?user_id=%d&around_id=%d", bob, ids[4]), nil, nil); code != http.StatusNotFound {
+		t.Errorf("around a hidden message = %d, want 404", code)
+	}
+	if got, _ := historyIDs(t, bob, alice, fmt.Sprintf("&around_id=%d&limit=1", ids[4])); !slices.Equal(got, ids[4:5]) {
+		t.Errorf("bob around his visible message = %v, want %v", got, ids[4:5])
+	}
+
+	for _, query := range []string{
+		"",
+		"?user_id=x",
+		fmt.Sprintf("?user_id=%d&limit=0", bob),
+		fmt.Sprintf("?user_id=%d&before_id=0", bob),
+		fmt.Sprintf("?user_id=%d&before_id=%d&after_id=%d", bob, ids[5], ids[1]),
+	} {
+		if code := call(t, GetMessageHistory, alice, "/api/messages/history"+query, nil, nil); code != http.StatusBadRequest {
+			t.Errorf("GET /api/messages/history%s = %d, want 400", query, code)
+		}
+	}
+}
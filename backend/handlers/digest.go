@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"social-network/backend/db"
+	"social-network/backend/mailer"
+	"social-network/backend/models"
+	"social-network/backend/utils"
+
+	"github.com/google/uuid"
+)
+
+// Digest frequencies; users without an email_digests row get digestDaily.
+const (
+	digestOff    = "off"
+	digestDaily  = "daily"
+	digestWeekly = "weekly"
+)
+
+var digestPeriods = map[string]time.Duration{
+	digestDaily:  24 * time.Hour,
+	digestWeekly: 7 * 24 * time.Hour,
+}
+
+// maxDigestItems is how many notifications a digest lists; the rest are
+// only counted.
+const maxDigestItems = 20
+
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+//go:embed templates/digest.txt.tmpl templates/digest.html.tmpl
+var digestTemplateFS embed.FS
+
+var (
+	digestText = texttemplate.Must(texttemplate.ParseFS(digestTemplateFS, "templates/digest.txt.tmpl"))
+	digestHTML = htmltemplate.Must(htmltemplate.ParseFS(digestTemplateFS, "templates/digest.html.tmpl"))
+)
+
+// DigestSender emails users a summary of the unread notifications they got
+// since their last digest. Only notification types with the email channel
+// enabled (see NotificationChannelsFor) are included.
+type DigestSender struct {
+	Mailer mailer.Mailer
+	// AppURL is the frontend notification links point to, APIURL this
+	// server, which handles the unsubscribe link.
+	AppURL string
+	APIURL string
+}
+
+type digestItem struct {
+	Summary string
+	URL     string
+	When    string
+}
+
+type digestView struct {
+	Nickname       string
+	Period         string
+	Total          int
+	Items          []digestItem
+	More           int
+	AppURL         string
+	SettingsURL    string
+	UnsubscribeURL string
+}
+
+type digestRecipient struct {
+	id         int64
+	email      string
+	nickname   string
+	frequency  string
+	lastSentAt string
+}
+
+// SendDue sends every digest that is due: the user's period has passed since
+// the last one and they have unread notifications since. Returns how many
+// were sent.
+func (d *DigestSender) SendDue() (int, error) {
+	rows, err := db.DB.Query(`SELECT u.id, u.email, u.nickname, IFNULL(e.frequency, ?), IFNULL(e.last_sent_at, '')
+		FROM users u
+		LEFT JOIN email_digests e ON e.user_id = u.id
+		WHERE IFNULL(e.frequency, ?) != ?
+			AND EXISTS (SELECT 1 FROM notifications n
+				WHERE n.recipient_id = u.id AND n.is_read = 0 AND n.updated_at > IFNULL(e.last_sent_at, ''))`,
+		digestDaily, digestDaily, digestOff)
+	if err != nil {
+		return 0, err
+	}
+	var due []digestRecipient
+	now := time.Now().UTC()
+	for rows.Next() {
+		var r digestRecipient
+		if err := rows.Scan(&r.id, &r.email, &r.nickname, &r.frequency, &r.lastSentAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if last, ok := parseSQLiteTime(r.lastSentAt); ok && now.Sub(last) < digestPeriods[r.frequency] {
+			continue
+		}
+		due = append(due, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, r := range due {
+		ok, err := d.send(r, now)
+		if err != nil {
+			log.Printf("digest for user %d: %v", r.id, err)
+			continue
+		}
+		if ok {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// send compiles and mails r's digest. It reports false when none of the
+// unread notifications are enabled for email.
+func (d *DigestSender) send(r digestRecipient, now time.Time) (bool, error) {
+	rows, err := db.DB.Query(`SELECT id, IFNULL(actor_id, 0), type, IFNULL(data, ''), count, updated_at
+		FROM notifications
+		WHERE recipient_id = ? AND is_read = 0 AND updated_at > ?
+		ORDER BY updated_at DESC, id DESC`, r.id, r.lastSentAt)
+	if err != nil {
+		return false, err
+	}
+	var list []models.Notification
+	emailEnabled := map[string]bool{}
+	for rows.Next() {
+		var n models.Notification
+		var data string
+		if err := rows.Scan(&n.ID, &n.ActorID, &n.Type, &data, &n.Count, &n.UpdatedAt); err != nil {
+			rows.Close()
+			return false, err
+		}
+		enabled, ok := emailEnabled[n.Type]
+		if !ok {
+			enabled = NotificationChannelsFor(r.id, n.Type).Email
+			emailEnabled[n.Type] = enabled
+		}
+		if enabled {
+			n.Data = decodeNotificationData(data)
+			list = append(list, n)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	if len(list) == 0 {
+		return false, nil
+	}
+
+	view := digestView{
+		Nickname:    r.nickname,
+		Period:      r.frequency,
+		Total:       len(list),
+		AppURL:      d.AppURL,
+		SettingsURL: d.AppURL + "/profile/edit",
+	}
+	if len(list) > maxDigestItems {
+		view.More = len(list) - maxDigestItems
+		list = list[:maxDigestItems]
+	}
+	if err := attachNotificationActors(list); err != nil {
+		return false, err
+	}
+	summarizeNotifications(list)
+	for i := range list {
+		link := d.AppURL
+		if path := dataString(&list[i], "url", ""); strings.HasPrefix(path, "/") {
+			link += path
+		}
+		when := list[i].UpdatedAt
+		if t, err := time.Parse(time.RFC3339, when); err == nil {
+			when = t.UTC().Format("Jan 2, 15:04 UTC")
+		}
+		view.Items = append(view.Items, digestItem{Summary: list[i].Summary, URL: link, When: when})
+	}
+
+	token, err := digestToken(r.id)
+	if err != nil {
+		return false, err
+	}
+	view.UnsubscribeURL = d.APIURL + "/api/notifications/unsubscribe?token=" + url.QueryEscape(token)
+
+	var text, html bytes.Buffer
+	if err := digestText.Execute(&text, view); err != nil {
+		return false, err
+	}
+	if err := digestHTML.Execute(&html, view); err != nil {
+		return false, err
+	}
+	subject := fmt.Sprintf("You have %d unread notification", view.Total)
+	if view.Total != 1 {
+		subject += "s"
+	}
+	err = d.Mailer.Send(mailer.Message{
+		To:      r.email,
+		Subject: subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + view.UnsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	// notifications arriving while this digest was compiled go into the next
+	_, err = db.DB.Exec("UPDATE email_digests SET last_sent_at = ? WHERE user_id = ?", now.Format(sqliteTimeFormat), r.id)
+	return true, err
+}
+
+// digestToken returns userID's unsubscribe token, creating their
+// email_digests row (with the default frequency) if needed.
+func digestToken(userID int64) (string, error) {
+	_, err := db.DB.Exec(`INSERT INTO email_digests (user_id, frequency, unsubscribe_token) VALUES (?, ?, ?)
+		ON CONFLICT(user_id) DO NOTHING`, userID, digestDaily, uuid.New().String())
+	if err != nil {
+		return "", err
+	}
+	var token string
+	err = db.DB.QueryRow("SELECT unsubscribe_token FROM email_digests WHERE user_id = ?", userID).Scan(&token)
+	return token, err
+}
+
+// parseSQLiteTime parses a DATETIME column as stored by CURRENT_TIMESTAMP or
+// as returned by the driver.
+func parseSQLiteTime(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339, sqliteTimeFormat} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// DigestSettingsHandler - GET/POST /api/notifications/digest { frequency }
+// frequency is "off", "daily" or "weekly".
+func DigestSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var payload struct {
+			Frequency string `json:"frequency"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			utils.Error(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if _, ok := digestPeriods[payload.Frequency]; !ok && payload.Frequency != digestOff {
+			utils.Error(w, http.StatusBadRequest, "frequency must be off, daily or weekly")
+			return
+		}
+		_, err := db.DB.Exec(`INSERT INTO email_digests (user_id, frequency, unsubscribe_token) VALUES (?, ?, ?)
+			ON CONFLICT(user_id) DO UPDATE SET frequency = excluded.frequency, updated_at = CURRENT_TIMESTAMP`,
+			userID, payload.Frequency, uuid.New().String())
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "Failed to save digest settings")
+			return
+		}
+	} else if r.Method != http.MethodGet {
+		utils.Error(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	frequency := digestDaily
+	var lastSentAt sql.NullString
+	err = db.DB.QueryRow("SELECT frequency, last_sent_at FROM email_digests WHERE user_id = ?", userID).Scan(&frequency, &lastSentAt)
+	if err != nil && err != sql.ErrNoRows {
+		utils.Error(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	var last *string
+	if lastSentAt.Valid {
+		last = &lastSentAt.String
+	}
+	utils.JSON(w, http.StatusOK, map[string]interface{}{"frequency": frequency, "last_sent_at": last})
+}
+
+// UnsubscribeDigestHandler - GET/POST /api/notifications/unsubscribe?token=
+// The link in every digest; turns the digest off without logging in. POST is
+// the RFC 8058 one-click unsubscribe mail clients send.
+func UnsubscribeDigestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		utils.Error(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		utils.Error(w, http.StatusBadRequest, "Missing token")
+		return
+	}
+	res, err := db.DB.Exec("UPDATE email_digests SET frequency = ?, updated_at = CURRENT_TIMESTAMP WHERE unsubscribe_token = ?", digestOff, token)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		utils.Error(w, http.StatusNotFound, "Unknown unsubscribe link")
+		return
+	}
+	if r.Method == http.MethodPost {
+		utils.JSON(w, http.StatusOK, map[string]string{"status": "unsubscribed"})
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, `<!DOCTYPE html><html><body style="font-family: sans-serif;">
+<p>You will no longer receive notification digest emails.</p>
+<p>You can turn them back on in your notification settings.</p>
+</body></html>`)
+}
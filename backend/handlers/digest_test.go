@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"social-network/backend/db"
+	"social-network/backend/mailer"
+)
+
+// recordingMailer keeps the messages it is asked to send.
+type recordingMailer struct {
+	sent []mailer.Message
+}
+
+func (m *recordingMailer) Send(msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *recordingMailer) recipients() []string {
+	var to []string
+	for _, msg := range m.sent {
+		to = append(to, msg.To)
+	}
+	slices.Sort(to)
+	return to
+}
+
+func TestDigestSendDue(t *testing.T) {
+	openTestDB(t)
+	actor := createTestUser(t, "actor")
+	users := map[string]int64{}
+	for _, name := range []string{"fresh", "noemail", "off", "recent", "weekly", "stale"} {
+		users[name] = createTestUser(t, name)
+	}
+	setDigest := func(name, frequency, lastSent string) {
+		t.Helper()
+		if _, err := db.DB.Exec("INSERT INTO email_digests (user_id, frequency, last_sent_at, unsubscribe_token) VALUES (?, ?, datetime('now', ?), ?)",
+			users[name], frequency, lastSent, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setDigest("off", digestOff, "-30 days")
+	setDigest("recent", digestDaily, "-1 hour")
+	setDigest("weekly", digestWeekly, "-2 days")
+	setDigest("stale", digestDaily, "-2 days")
+	for name, id := range users {
+		ntype := "group_invite" // mailed by default
+		if name == "noemail" {
+			ntype = "new_follower"
+		}
+		Notify(id, actor, ntype, map[string]interface{}{"group_id": 1, "url": "/groups/1"})
+	}
+	// read notifications are left out
+	db.DB.Exec("UPDATE notifications SET is_read = 1 WHERE recipient_id = ?", actor)
+
+	m := &recordingMailer{}
+	d := &DigestSender{Mailer: m, AppURL: "https://app.example", APIURL: "https://api.example"}
+	sent, err := d.SendDue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"fresh@example.com", "stale@example.com"}; sent != 2 || !slices.Equal(m.recipients(), want) {
+		t.Fatalf("sent %d to %v, want %v", sent, m.recipients(), want)
+	}
+	msg := m.sent[0]
+	if msg.Subject != "You have 1 unread notification" || !strings.Contains(msg.Text, "https://app.example/groups/1") ||
+		!strings.HasPrefix(msg.Headers["List-Unsubscribe"], "<https://api.example/api/notifications/unsubscribe?token=") {
+		t.Errorf("digest = %+v", msg)
+	}
+
+	// last_sent_at moves, so nothing is due until new notifications arrive
+	// and the period has passed
+	var stamped int
+	db.DB.QueryRow("SELECT COUNT(*) FROM email_digests WHERE last_sent_at > datetime('now', '-1 minute')").Scan(&stamped)
+	if stamped != 2 {
+		t.Errorf("%d digests stamped as sent, want 2", stamped)
+	}
+	var noEmailRow int
+	db.DB.QueryRow("SELECT COUNT(*) FROM email_digests WHERE user_id = ?", users["noemail"]).Scan(&noEmailRow)
+	if noEmailRow != 0 {
+		t.Error("a user with nothing to mail got a digest row")
+	}
+	m.sent = nil
+	Notify(users["fresh"], actor, "group_join_request", map[string]interface{}{"group_id": 1})
+	if sent, err := d.SendDue(); err != nil || sent != 0 {
+		t.Errorf("second run sent %d (%v), want 0 within the period", sent, err)
+	}
+	// a day later: the first digest's notification is not repeated
+	db.DB.Exec("UPDATE email_digests SET last_sent_at = datetime('now', '-25 hours') WHERE user_id = ?", users["fresh"])
+	db.DB.Exec("UPDATE notifications SET updated_at = datetime('now', '-26 hours') WHERE recipient_id = ? AND type = 'group_invite'", users["fresh"])
+	if sent, err := d.SendDue(); err != nil || sent != 1 || m.sent[0].Subject != "You have 1 unread notification" {
+		t.Errorf("after the period sent %d (%v) %+v, want only the new notification", sent, err, m.sent)
+	}
+}
+
+func TestDigestSettingsAndUnsubscribe(t *testing.T) {
+	openTestDB(t)
+	alice := createTestUser(t, "alice")
+	const target = "/api/notifications/digest"
+	var got struct {
+		Frequency  string  `json:"frequency"`
+		LastSentAt *string `json:"last_sent_at"`
+	}
+	if code := call(t, DigestSettingsHandler, alice, target, nil, &got); code != http.StatusOK || got.Frequency != digestDaily || got.LastSentAt != nil {
+		t.Errorf("default settings = %d %+v, want daily, never sent", code, got)
+	}
+	if code := call(t, DigestSettingsHandler, alice, target, map[string]string{"frequency": "hourly"}, nil); code != http.StatusBadRequest {
+		t.Errorf("hourly = %d, want 400", code)
+	}
+	if code := call(t, DigestSettingsHandler, alice, target, map[string]string{"frequency": digestWeekly}, &got); code != http.StatusOK || got.Frequency != digestWeekly {
+		t.Errorf("weekly = %d %+v", code, got)
+	}
+
+	token, err := digestToken(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := digestToken(alice); again != token {
+		t.Error("the unsubscribe token changed")
+	}
+	if code := call(t, UnsubscribeDigestHandler, 0, "/api/notifications/unsubscribe?token=nope", nil, nil); code != http.StatusNotFound {
+		t.Errorf("unknown token = %d, want 404", code)
+	}
+	if code := call(t, UnsubscribeDigestHandler, 0, "/api/notifications/unsubscribe?token="+token, map[string]string{}, nil); code != http.StatusOK {
+		t.Errorf("one-click unsubscribe = %d", code)
+	}
+	call(t, DigestSettingsHandler, alice, target, nil, &got)
+	if got.Frequency != digestOff {
+		t.Errorf("after unsubscribing frequency = %q, want off", got.Frequency)
+	}
+}
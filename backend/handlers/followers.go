@@ -160,6 +160,8 @@ func UnfollowHandler(w http.ResponseWriter, r *http.Request) {
 		utils.Error(w, http.StatusInternalServerError, "Failed")
 		return
 	}
+	// private posts are only shared with followers
+	db.DB.Exec("DELETE FROM post_audience WHERE user_id = ? AND post_id IN (SELECT id FROM posts WHERE author_id = ?)", userID, payload.TargetID)
 	utils.JSON(w, http.StatusOK, map[string]string{"status": "unfollowed"})
 }
 
@@ -6,6 +6,7 @@ import (
 	"strconv"
 
 	"social-network/backend/db"
+	"social-network/backend/models"
 	"social-network/backend/utils"
 )
 
@@ -67,9 +68,9 @@ func ListGroupMessagesHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "invalid before_id", http.StatusBadRequest)
 			return
 		}
-		rows, err = db.DB.Query(`SELECT gm.id, gm.sender_id, gm.content, gm.created_at, u.nickname FROM group_messages gm JOIN users u ON u.id = gm.sender_id WHERE gm.group_id = ? AND gm.id < ? ORDER BY gm.id DESC LIMIT ?`, gid, beforeID, limit)
+		rows, err = db.DB.Query(`SELECT gm.id, gm.sender_id, IFNULL(gm.content, ''), gm.created_at, gm.edited_at, gm.deleted_at IS NOT NULL, u.nickname FROM group_messages gm JOIN users u ON u.id = gm.sender_id WHERE gm.group_id = ? AND gm.id < ? AND gm.id NOT IN (SELECT message_id FROM hidden_messages WHERE user_id = ? AND kind = 'group') ORDER BY gm.id DESC LIMIT ?`, gid, beforeID, uid, limit)
 	} else {
-		rows, err = db.DB.Query(`SELECT gm.id, gm.sender_id, gm.content, gm.created_at, u.nickname FROM group_messages gm JOIN users u ON u.id = gm.sender_id WHERE gm.group_id = ? ORDER BY gm.id DESC LIMIT ?`, gid, limit)
+		rows, err = db.DB.Query(`SELECT gm.id, gm.sender_id, IFNULL(gm.content, ''), gm.created_at, gm.edited_at, gm.deleted_at IS NOT NULL, u.nickname FROM group_messages gm JOIN users u ON u.id = gm.sender_id WHERE gm.group_id = ? AND gm.id NOT IN (SELECT message_id FROM hidden_messages WHERE user_id = ? AND kind = 'group') ORDER BY gm.id DESC LIMIT ?`, gid, uid, limit)
 	}
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
@@ -78,17 +79,21 @@ func ListGroupMessagesHandler(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	type msg struct {
-		ID         int64          `json:"id"`
-		SenderID   int64          `json:"sender_id"`
-		Content    string         `json:"content"`
-		CreatedAt  sql.NullString `json:"created_at"`
-		SenderName string         `json:"sender_name"`
+		ID          int64               `json:"id"`
+		SenderID    int64               `json:"sender_id"`
+		Content     string              `json:"content"`
+		CreatedAt   sql.NullString      `json:"created_at"`
+		EditedAt    *string             `json:"edited_at,omitempty"`
+		Deleted     bool                `json:"deleted"`
+		SenderName  string              `json:"sender_name"`
+		Reactions   []models.Reaction   `json:"reactions,omitempty"`
+		Attachments []models.Attachment `json:"attachments,omitempty"`
 	}
 
 	var out []msg
 	for rows.Next() {
 		var m msg
-		if err := rows.Scan(&m.ID, &m.SenderID, &m.Content, &m.CreatedAt, &m.SenderName); err == nil {
+		if err := rows.Scan(&m.ID, &m.SenderID, &m.Content, &m.CreatedAt, &m.EditedAt, &m.Deleted, &m.SenderName); err == nil {
 			out = append(out, m)
 		}
 	}
@@ -98,5 +103,21 @@ func ListGroupMessagesHandler(w http.ResponseWriter, r *http.Request) {
 		out[i], out[j] = out[j], out[i]
 	}
 
+	// attach aggregated reactions and attachment descriptors in batched queries
+	ids := make([]int64, len(out))
+	for i := range out {
+		ids[i] = out[i].ID
+	}
+	if reactions, err := loadReactions("group", ids, uid); err == nil {
+		for i := range out {
+			out[i].Reactions = reactions[out[i].ID]
+		}
+	}
+	if attachments, err := loadAttachments("group", ids); err == nil {
+		for i := range out {
+			out[i].Attachments = attachments[out[i].ID]
+		}
+	}
+
 	utils.JSON(w, http.StatusOK, out)
 }
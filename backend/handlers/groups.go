@@ -4,10 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
-	"path/filepath"
 	"social-network/backend/db"
 	"social-network/backend/utils"
 	"strconv"
@@ -176,7 +173,12 @@ func RespondInviteHandler(w http.ResponseWriter, r *http.Request) {
 		db.DB.Exec("UPDATE group_invites SET status='accepted' WHERE id=?", payload.InviteID)
 		db.DB.Exec("INSERT OR IGNORE INTO group_members (group_id, user_id) VALUES (?,?)", invite.GroupID, userID)
 		// notify inviter that invite was accepted
-		_ = Notify(invite.InviterID, userID, "group_invite_response", map[string]interface{}{"invite_id": payload.InviteID, "status": "accepted", "group_id": invite.GroupID, "url": fmt.Sprintf("/groups/%d", invite.GroupID)})
+		Emit(Activity{
+			Event: "group_member_joined", ActorID: userID, GroupID: invite.GroupID,
+			Data:       map[string]interface{}{"group_id": invite.GroupID, "user_id": userID, "via": "invite"},
+			Recipients: []int64{invite.InviterID}, NotifyType: "group_invite_response",
+			Notification: map[string]interface{}{"invite_id": payload.InviteID, "status": "accepted", "group_id": invite.GroupID, "url": fmt.Sprintf("/groups/%d", invite.GroupID)},
+		})
 		utils.JSON(w, http.StatusOK, map[string]string{"status": "accepted"})
 		return
 	}
@@ -199,17 +201,6 @@ func CreateGroupPostHandler(w http.ResponseWriter, r *http.Request) {
 	gid, _ := strconv.ParseInt(gidStr, 10, 64)
 	content := r.FormValue("content")
 	imageURL := ""
-	file, fh, err := r.FormFile("image")
-	if err == nil && file != nil {
-		defer file.Close()
-		// save to uploads
-		os.MkdirAll("uploads", 0755)
-		fname := fmt.Sprintf("group_%d_%s", gid, filepath.Base(fh.Filename))
-		dst, _ := os.Create(filepath.Join("uploads", fname))
-		defer dst.Close()
-		io.Copy(dst, file)
-		imageURL = "/uploads/" + fname
-	}
 	// ensure user is a member
 	var cnt int
 	db.DB.QueryRow("SELECT COUNT(1) FROM group_members WHERE group_id=? AND user_id=?", gid, userID).Scan(&cnt)
@@ -217,11 +208,22 @@ func CreateGroupPostHandler(w http.ResponseWriter, r *http.Request) {
 		utils.Error(w, http.StatusForbidden, "Not a member")
 		return
 	}
-	_, err = db.DB.Exec("INSERT INTO group_posts (group_id, author_id, content, image_url) VALUES (?, ?, ?, ?)", gid, userID, content, imageURL)
+	file, fh, err := r.FormFile("image")
+	if err == nil && file != nil {
+		defer file.Close()
+		if imageURL, err = savePostImage(userID, file, fh.Filename); err != nil {
+			utils.Error(w, http.StatusInternalServerError, "Could not save image")
+			return
+		}
+	}
+	res, err := db.DB.Exec("INSERT INTO group_posts (group_id, author_id, content, image_url) VALUES (?, ?, ?, ?)", gid, userID, content, imageURL)
 	if err != nil {
 		utils.Error(w, http.StatusInternalServerError, "Failed to create post")
 		return
 	}
+	postID, _ := res.LastInsertId()
+	Emit(Activity{Event: "group_post_created", ActorID: userID, GroupID: gid,
+		Data: map[string]interface{}{"post_id": postID, "group_id": gid, "author_id": userID, "content": content, "image_url": imageURL}})
 	utils.JSON(w, http.StatusOK, map[string]string{"status": "created"})
 }
 
@@ -233,24 +235,25 @@ func ListGroupPostsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	gid, _ := strconv.ParseInt(gidStr, 10, 64)
-	rows, err := db.DB.Query("SELECT id, group_id, author_id, content, image_url, created_at FROM group_posts WHERE group_id = ? ORDER BY created_at DESC", gid)
+	rows, err := db.DB.Query("SELECT id, group_id, author_id, content, image_url, created_at, edited_at FROM group_posts WHERE group_id = ? ORDER BY created_at DESC", gid)
 	if err != nil {
 		utils.Error(w, http.StatusInternalServerError, "Failed")
 		return
 	}
 	defer rows.Close()
 	type P struct {
-		ID       int64  `json:"id"`
-		GroupID  int64  `json:"group_id"`
-		AuthorID int64  `json:"author_id"`
-		Content  string `json:"content"`
-		Image    string `json:"image_url"`
-		Created  string `json:"created_at"`
+		ID       int64   `json:"id"`
+		GroupID  int64   `json:"group_id"`
+		AuthorID int64   `json:"author_id"`
+		Content  string  `json:"content"`
+		Image    string  `json:"image_url"`
+		Created  string  `json:"created_at"`
+		EditedAt *string `json:"edited_at,omitempty"`
 	}
 	var out []P
 	for rows.Next() {
 		var p P
-		rows.Scan(&p.ID, &p.GroupID, &p.AuthorID, &p.Content, &p.Image, &p.Created)
+		rows.Scan(&p.ID, &p.GroupID, &p.AuthorID, &p.Content, &p.Image, &p.Created, &p.EditedAt)
 		out = append(out, p)
 	}
 	utils.JSON(w, http.StatusOK, out)
@@ -318,25 +321,31 @@ func CreateEventHandler(w http.ResponseWriter, r *http.Request) {
 		utils.Error(w, http.StatusForbidden, "Not a member")
 		return
 	}
-	_, err := db.DB.Exec("INSERT INTO events (group_id, creator_id, title, description, event_time) VALUES (?, ?, ?, ?, ?)", payload.GroupID, userID, payload.Title, payload.Description, payload.EventTime)
+	res, err := db.DB.Exec("INSERT INTO events (group_id, creator_id, title, description, event_time) VALUES (?, ?, ?, ?, ?)", payload.GroupID, userID, payload.Title, payload.Description, payload.EventTime)
 	if err != nil {
 		utils.Error(w, http.StatusInternalServerError, "Failed to create event")
 		return
 	}
+	eventID, _ := res.LastInsertId()
 
 	// notify group members about the new event (persist notifications)
+	var members []int64
 	rows, err := db.DB.Query("SELECT user_id FROM group_members WHERE group_id = ? AND user_id != ?", payload.GroupID, userID)
 	if err == nil {
-		defer rows.Close()
 		for rows.Next() {
 			var mid int64
 			if err := rows.Scan(&mid); err == nil {
-				// create a simple JSON payload with event info
-				data := map[string]interface{}{"group_id": payload.GroupID, "title": payload.Title, "event_time": payload.EventTime, "url": fmt.Sprintf("/groups/%d", payload.GroupID)}
-				_ = Notify(mid, userID, "group_event", data)
+				members = append(members, mid)
 			}
 		}
-	}
+		rows.Close()
+	}
+	Emit(Activity{
+		Event: "group_event_created", ActorID: userID, GroupID: payload.GroupID,
+		Data:       map[string]interface{}{"event_id": eventID, "group_id": payload.GroupID, "creator_id": userID, "title": payload.Title, "description": payload.Description, "event_time": payload.EventTime},
+		Recipients: members, NotifyType: "group_event",
+		Notification: map[string]interface{}{"event_id": eventID, "group_id": payload.GroupID, "title": payload.Title, "event_time": payload.EventTime, "url": fmt.Sprintf("/groups/%d", payload.GroupID)},
+	})
 	utils.JSON(w, http.StatusOK, map[string]string{"status": "created"})
 }
 
@@ -524,7 +533,12 @@ func RespondRequestHandler(w http.ResponseWriter, r *http.Request) {
 	if payload.Action == "accept" {
 		db.DB.Exec("UPDATE group_requests SET status='accepted' WHERE id=?", payload.RequestID)
 		db.DB.Exec("INSERT OR IGNORE INTO group_members (group_id, user_id) VALUES (?,?)", req.GroupID, req.RequesterID)
-		_ = Notify(req.RequesterID, userID, "group_join_response", map[string]interface{}{"request_id": payload.RequestID, "status": "accepted", "group_id": req.GroupID, "url": fmt.Sprintf("/groups/%d", req.GroupID)})
+		Emit(Activity{
+			Event: "group_member_joined", ActorID: userID, GroupID: req.GroupID,
+			Data:       map[string]interface{}{"group_id": req.GroupID, "user_id": req.RequesterID, "via": "request"},
+			Recipients: []int64{req.RequesterID}, NotifyType: "group_join_response",
+			Notification: map[string]interface{}{"request_id": payload.RequestID, "status": "accepted", "group_id": req.GroupID, "url": fmt.Sprintf("/groups/%d", req.GroupID)},
+		})
 		utils.JSON(w, http.StatusOK, map[string]string{"status": "accepted"})
 		return
 	}
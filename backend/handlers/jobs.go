@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"social-network/backend/db"
+	"social-network/backend/jobs"
+)
+
+const (
+	// eventReminderLead is how long before an event its "going" voters are
+	// reminded.
+	eventReminderLead = 24 * time.Hour
+	// uploadGracePeriod is how long an upload may stay unused (picked in
+	// the composer, never posted) before it is deleted.
+	uploadGracePeriod = 24 * time.Hour
+)
+
+// RegisterJobs registers the handlers package's background jobs and their
+// schedules. Call it before jobs.Start.
+func RegisterJobs(digests *DigestSender) error {
+	jobs.Register("cleanup_sessions", simpleJob(CleanupSessions), jobs.Options{})
+	jobs.Register("cleanup_push_subscriptions", simpleJob(CleanupPushSubscriptions), jobs.Options{})
+	jobs.Register("cleanup_webhook_deliveries", simpleJob(CleanupWebhookDeliveries), jobs.Options{})
+	jobs.Register("cleanup_uploads", simpleJob(CleanupUploads), jobs.Options{Timeout: 10 * time.Minute})
+	jobs.Register("event_reminders", simpleJob(SendEventReminders), jobs.Options{})
+	jobs.Register("send_digests", func(ctx context.Context, _ json.RawMessage) error {
+		n, err := digests.SendDue()
+		if n > 0 {
+			log.Printf("Sent %d notification digests", n)
+		}
+		return err
+	}, jobs.Options{Timeout: 10 * time.Minute})
+	jobs.Register("notify_fanout", runNotifyFanout, jobs.Options{Concurrency: 4, Timeout: 5 * time.Minute})
+	// a failed push is counted on its subscription, not retried: the other
+	// subscriptions of the user already got it
+	jobs.Register("send_web_push", runWebPush, jobs.Options{MaxAttempts: 1, Concurrency: pushConcurrency})
+
+	for _, s := range []struct{ name, spec string }{
+		{"cleanup_sessions", "@every 10m"},
+		{"cleanup_push_subscriptions", "@hourly"},
+		{"cleanup_webhook_deliveries", "@daily"},
+		{"cleanup_uploads", "30 3 * * *"},
+		{"event_reminders", "@every 5m"},
+		// each user gets at most one digest per period
+		{"send_digests", "@hourly"},
+	} {
+		if err := jobs.Schedule(s.name, s.spec, s.name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// simpleJob adapts a function without payload to a jobs.Handler.
+func simpleJob(f func() error) jobs.Handler {
+	return func(context.Context, json.RawMessage) error { return f() }
+}
+
+type notifyFanout struct {
+	Recipients []int64                `json:"recipients"`
+	ActorID    int64                  `json:"actor_id"`
+	Type       string                 `json:"type"`
+	Data       map[string]interface{} `json:"data"`
+}
+
+// fanoutBatchSize is how many recipients one notify_fanout job takes.
+const fanoutBatchSize = 200
+
+// NotifyMany notifies every recipient like Notify, from background jobs of
+// up to fanoutBatchSize recipients so the caller does not wait on one round
+// of writes per recipient. Batches that cannot be queued are sent inline.
+func NotifyMany(recipients []int64, actorID int64, ntype string, payload map[string]interface{}) {
+	for batch := range slices.Chunk(recipients, fanoutBatchSize) {
+		if _, err := jobs.Enqueue("notify_fanout", notifyFanout{Recipients: batch, ActorID: actorID, Type: ntype, Data: payload}); err != nil {
+			log.Println("notify fan-out enqueue error:", err)
+			for _, rid := range batch {
+				_ = Notify(rid, actorID, ntype, payload)
+			}
+		}
+	}
+}
+
+// runNotifyFanout notifies the recipients of a notify_fanout job. When the
+// job runs out of time it queues the recipients it did not get to as a new
+// job rather than failing: retrying would notify the others twice.
+func runNotifyFanout(ctx context.Context, payload json.RawMessage) error {
+	var f notifyFanout
+	if err := json.Unmarshal(payload, &f); err != nil {
+		return jobs.Permanent(err)
+	}
+	for i, rid := range f.Recipients {
+		if ctx.Err() != nil {
+			f.Recipients = f.Recipients[i:]
+			if _, err := jobs.Enqueue("notify_fanout", f); err != nil {
+				return jobs.Permanent(fmt.Errorf("%d recipients left: %w", len(f.Recipients), err))
+			}
+			return nil
+		}
+		_ = Notify(rid, f.ActorID, f.Type, f.Data)
+	}
+	return nil
+}
+
+// eventTimeLayouts are the event_time formats clients send; times without a
+// zone are taken as UTC.
+var eventTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02T15:04:05", sqliteTimeFormat, "2006-01-02 15:04"}
+
+func parseEventTime(s string) (time.Time, bool) {
+	for _, layout := range eventTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// SendEventReminders notifies the "going" voters of events starting within
+// eventReminderLead, once per event.
+func SendEventReminders() error {
+	now := time.Now().UTC()
+	// event_time is free-form text; narrow down by its date prefix and
+	// compare parsed times below
+	rows, err := db.DB.Query(`SELECT id, group_id, title, event_time FROM events
+		WHERE reminder_sent_at IS NULL AND event_time >= ? AND event_time < ?`,
+		now.AddDate(0, 0, -1).Format("2006-01-02"), now.Add(eventReminderLead).AddDate(0, 0, 2).Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+	type event struct {
+		id, groupID      int64
+		title, eventTime string
+	}
+	var due []event
+	for rows.Next() {
+		var e event
+		if err := rows.Scan(&e.id, &e.groupID, &e.title, &e.eventTime); err != nil {
+			rows.Close()
+			return err
+		}
+		if at, ok := parseEventTime(e.eventTime); ok && at.After(now) && at.Sub(now) <= eventReminderLead {
+			due = append(due, e)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range due {
+		// claim first: a reminder is better missed than sent twice
+		res, err := db.DB.Exec("UPDATE events SET reminder_sent_at = CURRENT_TIMESTAMP WHERE id = ? AND reminder_sent_at IS NULL", e.id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		voters, err := db.DB.Query("SELECT user_id FROM event_votes WHERE event_id = ? AND vote = 'going'", e.id)
+		if err != nil {
+			return err
+		}
+		var recipients []int64
+		for voters.Next() {
+			var uid int64
+			if voters.Scan(&uid) == nil {
+				recipients = append(recipients, uid)
+			}
+		}
+		voters.Close()
+		data := map[string]interface{}{"event_id": e.id, "group_id": e.groupID, "title": e.title, "event_time": e.eventTime, "url": fmt.Sprintf("/groups/%d", e.groupID)}
+		for _, uid := range recipients {
+			// actor 0: the reminder is sent by nobody, so the creator gets
+			// one too
+			_ = Notify(uid, 0, "event_reminder", data)
+		}
+	}
+	return nil
+}
+
+// CleanupUploads deletes uploads nothing refers to once uploadGracePeriod
+// has passed: chat attachments never linked to a message, and avatar and
+// post images no user, post or comment uses.
+func CleanupUploads() error {
+	cutoff := time.Now().Add(-uploadGracePeriod)
+
+	rows, err := db.DB.Query("SELECT id, file_path FROM attachments WHERE message_id IS NULL AND created_at < ?", cutoff.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return err
+	}
+	type orphan struct {
+		id   int64
+		path string
+	}
+	var orphans []orphan
+	for rows.Next() {
+		var o orphan
+		if rows.Scan(&o.id, &o.path) == nil {
+			orphans = append(orphans, o)
+		}
+	}
+	rows.Close()
+	for _, o := range orphans {
+		// the row goes first, unless a message linked it meanwhile
+		res, err := db.DB.Exec("DELETE FROM attachments WHERE id = ? AND message_id IS NULL", o.id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		if err := os.Remove(o.path); err != nil && !os.IsNotExist(err) {
+			log.Printf("remove attachment %d: %v", o.id, err)
+		}
+	}
+
+	removed := 0
+	for _, dir := range []string{"avatars", "posts"} {
+		entries, err := os.ReadDir(filepath.Join("backend", "uploads", dir))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || info.ModTime().After(cutoff) {
+				continue
+			}
+			used, err := uploadInUse("/uploads/" + dir + "/" + entry.Name())
+			if err != nil {
+				return err
+			}
+			if used {
+				continue
+			}
+			if err := os.Remove(filepath.Join("backend", "uploads", dir, entry.Name())); err == nil {
+				removed++
+			}
+		}
+	}
+	if n := len(orphans) + removed; n > 0 {
+		log.Printf("Removed %d unused uploads", n)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"social-network/backend/db"
+	"social-network/backend/jobs"
+)
+
+var registerJobsOnce sync.Once
+
+// registerTestJobs registers the package's job types once per test binary,
+// as jobs.Register panics on duplicates.
+func registerTestJobs(t *testing.T) {
+	t.Helper()
+	registerJobsOnce.Do(func() {
+		if err := RegisterJobs(&DigestSender{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// countNotifications returns how many notifications of ntype userID has.
+func countNotifications(t *testing.T, userID int64, ntype string) int {
+	t.Helper()
+	var n int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM notifications WHERE recipient_id = ? AND type = ?", userID, ntype).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSendEventReminders(t *testing.T) {
+	openTestDB(t)
+	alice, bob, carol := createTestUser(t, "alice"), createTestUser(t, "bob"), createTestUser(t, "carol")
+	res, _ := db.DB.Exec("INSERT INTO groups (owner_id, name) VALUES (?, 'g')", alice)
+	groupID, _ := res.LastInsertId()
+	now := time.Now().UTC()
+	event := func(at string) int64 {
+		t.Helper()
+		res, err := db.DB.Exec("INSERT INTO events (group_id, creator_id, title, event_time) VALUES (?, ?, 'meetup', ?)", groupID, alice, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		db.DB.Exec("INSERT INTO event_votes (event_id, user_id, vote) VALUES (?, ?, 'going'), (?, ?, 'not_going')", id, bob, id, carol)
+		return id
+	}
+	soon := event(now.Add(2 * time.Hour).Format("2006-01-02T15:04"))
+	later := event(now.Add(3 * 24 * time.Hour).Format(time.RFC3339))
+	past := event(now.Add(-2 * time.Hour).Format(time.RFC3339))
+
+	for range 2 {
+		if err := SendEventReminders(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countNotifications(t, bob, "event_reminder"); n != 1 {
+		t.Errorf("bob got %d reminders, want 1 for the upcoming event", n)
+	}
+	if n := countNotifications(t, carol, "event_reminder"); n != 0 {
+		t.Errorf("carol, not going, got %d reminders", n)
+	}
+	for id, want := range map[int64]bool{soon: true, later: false, past: false} {
+		var sent bool
+		db.DB.QueryRow("SELECT reminder_sent_at IS NOT NULL FROM events WHERE id = ?", id).Scan(&sent)
+		if sent != want {
+			t.Errorf("event %d reminder_sent_at set = %v, want %v", id, sent, want)
+		}
+	}
+}
+
+func TestNotifyManyQueuesFanout(t *testing.T) {
+	openTestDB(t)
+	registerTestJobs(t)
+	alice, bob, carol := createTestUser(t, "alice"), createTestUser(t, "bob"), createTestUser(t, "carol")
+
+	NotifyMany([]int64{bob, carol}, alice, "group_event", map[string]interface{}{"group_id": 1, "title": "meetup"})
+	if n := countNotifications(t, bob, "group_event"); n != 0 {
+		t.Fatalf("bob was notified inline (%d)", n)
+	}
+	var payload string
+	if err := db.DB.QueryRow("SELECT payload FROM jobs WHERE type = 'notify_fanout' AND status = 'pending'").Scan(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := runNotifyFanout(context.Background(), json.RawMessage(payload)); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int64{bob, carol} {
+		if n := countNotifications(t, id, "group_event"); n != 1 {
+			t.Errorf("user %d got %d notifications from the fan-out, want 1", id, n)
+		}
+	}
+	if err := runNotifyFanout(context.Background(), json.RawMessage(`{`)); err == nil {
+		t.Error("a broken payload was retried")
+	}
+}
+
+func TestAdminJobHandlers(t *testing.T) {
+	openTestDB(t)
+	registerTestJobs(t)
+	admin := createTestUser(t, "admin")
+	id, err := jobs.EnqueueAt("cleanup_sessions", nil, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var list struct {
+		Jobs []jobs.Job `json:"jobs"`
+	}
+	if code := call(t, AdminJobsHandler, admin, "/api/admin/jobs?status=pending", nil, &list); code != http.StatusOK || len(list.Jobs) != 1 || list.Jobs[0].ID != id {
+		t.Errorf("pending jobs = %d %+v", code, list.Jobs)
+	}
+	if code := call(t, AdminRetryJobHandler, admin, "/api/admin/jobs/retry", map[string]int64{"id": id}, nil); code != http.StatusNotFound {
+		t.Errorf("retrying a pending job = %d, want 404", code)
+	}
+	if code := call(t, AdminCancelJobHandler, admin, "/api/admin/jobs/cancel", map[string]int64{"id": id}, nil); code != http.StatusOK {
+		t.Errorf("cancel = %d", code)
+	}
+	if code := call(t, AdminRetryJobHandler, admin, "/api/admin/jobs/retry", map[string]int64{"id": id}, nil); code != http.StatusOK {
+		t.Errorf("retrying a cancelled job = %d", code)
+	}
+	if code := call(t, AdminCancelJobHandler, admin, "/api/admin/jobs/cancel", map[string]int64{}, nil); code != http.StatusBadRequest {
+		t.Errorf("cancel without id = %d, want 400", code)
+	}
+
+	var queued struct {
+		JobID int64 `json:"job_id"`
+	}
+	if code := call(t, AdminRunScheduleHandler, admin, "/api/admin/jobs/run-schedule", map[string]string{"name": "event_reminders"}, &queued); code != http.StatusOK || queued.JobID == 0 {
+		t.Errorf("run schedule = %d %+v", code, queued)
+	}
+	if code := call(t, AdminRunScheduleHandler, admin, "/api/admin/jobs/run-schedule", map[string]string{"name": "nope"}, nil); code != http.StatusNotFound {
+		t.Errorf("unknown schedule = %d, want 404", code)
+	}
+	var stats jobs.Stats
+	if code := call(t, AdminJobStatsHandler, admin, "/api/admin/jobs/stats", nil, &stats); code != http.StatusOK || stats.Counts["event_reminders"]["pending"] != 1 {
+		t.Errorf("stats = %d %+v", code, stats.Counts)
+	}
+}
+
+func TestNotifyFanoutRequeuesTailWhenOutOfTime(t *testing.T) {
+	openTestDB(t)
+	registerTestJobs(t)
+	ids := []int64{createTestUser(t, "bob"), createTestUser(t, "carol")}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	payload, _ := json.Marshal(notifyFanout{Recipients: ids, ActorID: createTestUser(t, "alice"), Type: "group_event"})
+	if err := runNotifyFanout(ctx, payload); err != nil {
+		t.Fatalf("runNotifyFanout out of time = %v, want nil", err)
+	}
+
+	var queued string
+	if err := db.DB.QueryRow("SELECT payload FROM jobs WHERE type = 'notify_fanout' AND status = 'pending'").Scan(&queued); err != nil {
+		t.Fatal(err)
+	}
+	var tail notifyFanout
+	json.Unmarshal([]byte(queued), &tail)
+	if len(tail.Recipients) != 2 || tail.Recipients[0] != ids[0] || tail.Recipients[1] != ids[1] || tail.Type != "group_event" {
+		t.Fatalf("queued %s, want the same fan-out for %v", queued, ids)
+	}
+}
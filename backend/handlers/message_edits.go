@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"social-network/backend/bus"
+	"social-network/backend/db"
+	"social-network/backend/utils"
+)
+
+// MessageEditWindow is how long after sending a chat message its sender may
+// still edit it. Deleting is not time limited.
+const MessageEditWindow = 15 * time.Minute
+
+// MaxMessageLength bounds the content of a chat message, in characters.
+const MaxMessageLength = 4000
+
+var (
+	ErrNotMessageSender = errors.New("only the sender can change this message")
+	ErrEditWindowClosed = errors.New("message can no longer be edited")
+	ErrMessageDeleted   = errors.New("message was deleted")
+	ErrEmptyMessage     = errors.New("message content cannot be empty")
+	ErrMessageTooLong   = fmt.Errorf("message cannot be longer than %d characters", MaxMessageLength)
+)
+
+// chatMessageRef is the subset of a messages/group_messages row needed to
+// authorize an edit or delete.
+type chatMessageRef struct {
+	SenderID   int64
+	ReceiverID int64 // direct messages only
+	GroupID    int64 // group messages only
+	Deleted    bool
+	AgeSeconds int64
+}
+
+func loadDirectMessageRef(messageID int64) (chatMessageRef, error) {
+	var ref chatMessageRef
+	err := db.DB.QueryRow(`
+		SELECT sender_id, receiver_id, deleted_at IS NOT NULL,
+			CAST(strftime('%s', 'now') AS INTEGER) - CAST(strftime('%s', created_at) AS INTEGER)
+		FROM messages WHERE id = ?`, messageID).Scan(&ref.SenderID, &ref.ReceiverID, &ref.Deleted, &ref.AgeSeconds)
+	if err == sql.ErrNoRows {
+		return ref, ErrMessageNotFound
+	}
+	return ref, err
+}
+
+func loadGroupMessageRef(messageID int64) (chatMessageRef, error) {
+	var ref chatMessageRef
+	err := db.DB.QueryRow(`
+		SELECT sender_id, group_id, deleted_at IS NOT NULL,
+			CAST(strftime('%s', 'now') AS INTEGER) - CAST(strftime('%s', created_at) AS INTEGER)
+		FROM group_messages WHERE id = ?`, messageID).Scan(&ref.SenderID, &ref.GroupID, &ref.Deleted, &ref.AgeSeconds)
+	if err == sql.ErrNoRows {
+		return ref, ErrMessageNotFound
+	}
+	return ref, err
+}
+
+// checkEditable applies the rules shared by DM and group message edits.
+func checkEditable(ref chatMessageRef, userID int64) error {
+	if ref.SenderID != userID {
+		return ErrNotMessageSender
+	}
+	if ref.Deleted {
+		return ErrMessageDeleted
+	}
+	if time.Duration(ref.AgeSeconds)*time.Second > MessageEditWindow {
+		return ErrEditWindowClosed
+	}
+	return nil
+}
+
+// IsGroupMember reports whether userID belongs to groupID.
+func IsGroupMember(groupID, userID int64) bool {
+	var cnt int
+	db.DB.QueryRow("SELECT COUNT(1) FROM group_members WHERE group_id=? AND user_id=?", groupID, userID).Scan(&cnt)
+	return cnt > 0
+}
+
+// GroupMemberIDs returns every member of groupID.
+func GroupMemberIDs(groupID int64) ([]int64, error) {
+	rows, err := db.DB.Query("SELECT user_id FROM group_members WHERE group_id=?", groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// publishTo pushes the same realtime event to several users.
+func publishTo(recipients []int64, event map[string]interface{}) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	for _, rid := range recipients {
+		bus.PublishNotification(rid, payload)
+	}
+}
+
+// EditDirectMessage replaces the content of a DM sent by userID and pushes a
+// message_updated event to both participants.
+func EditDirectMessage(userID, messageID int64, content string) error {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return ErrEmptyMessage
+	}
+	if utf8.RuneCountInString(content) > MaxMessageLength {
+		return ErrMessageTooLong
+	}
+	ref, err := loadDirectMessageRef(messageID)
+	if err != nil {
+		return err
+	}
+	if err := checkEditable(ref, userID); err != nil {
+		return err
+	}
+	if _, err := db.DB.Exec("UPDATE messages SET content = ?, edited_at = CURRENT_TIMESTAMP WHERE id = ?", content, messageID); err != nil {
+		return err
+	}
+	var editedAt string
+	db.DB.QueryRow("SELECT edited_at FROM messages WHERE id = ?", messageID).Scan(&editedAt)
+	publishTo([]int64{ref.SenderID, ref.ReceiverID}, map[string]interface{}{
+		"type":        "message_updated",
+		"id":          messageID,
+		"sender_id":   strconv.FormatInt(ref.SenderID, 10),
+		"receiver_id": strconv.FormatInt(ref.ReceiverID, 10),
+		"content":     content,
+		"edited_at":   editedAt,
+	})
+	return nil
+}
+
+// DeleteDirectMessage removes a DM. With forEveryone the sender replaces it
+// with a tombstone for both participants; otherwise it is only hidden from
+// userID's own history.
+func DeleteDirectMessage(userID, messageID int64, forEveryone bool) error {
+	ref, err := loadDirectMessageRef(messageID)
+	if err != nil {
+		return err
+	}
+	if userID != ref.SenderID && userID != ref.ReceiverID {
+		return ErrMessageNotFound
+	}
+	event := map[string]interface{}{
+		"type":        "message_deleted",
+		"id":          messageID,
+		"sender_id":   strconv.FormatInt(ref.SenderID, 10),
+		"receiver_id": strconv.FormatInt(ref.ReceiverID, 10),
+	}
+	if !forEveryone {
+		if _, err := db.DB.Exec("INSERT OR IGNORE INTO hidden_messages (user_id, kind, message_id) VALUES (?, 'direct', ?)", userID, messageID); err != nil {
+			return err
+		}
+		event["scope"] = "me"
+		publishTo([]int64{userID}, event)
+		return nil
+	}
+	if ref.SenderID != userID {
+		return ErrNotMessageSender
+	}
+	if _, err := db.DB.Exec("UPDATE messages SET content = '', deleted_at = CURRENT_TIMESTAMP WHERE id = ?", messageID); err != nil {
+		return err
+	}
+	db.DB.Exec("DELETE FROM message_reactions WHERE kind = 'direct' AND message_id = ?", messageID)
+	event["scope"] = "everyone"
+	publishTo([]int64{ref.SenderID, ref.ReceiverID}, event)
+	return nil
+}
+
+// EditGroupMessage replaces the content of a group message sent by userID and
+// pushes a group_message_updated event to every member.
+func EditGroupMessage(userID, messageID int64, content string) error {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return ErrEmptyMessage
+	}
+	if utf8.RuneCountInString(content) > MaxMessageLength {
+		return ErrMessageTooLong
+	}
+	ref, err := loadGroupMessageRef(messageID)
+	if err != nil {
+		return err
+	}
+	if err := checkEditable(ref, userID); err != nil {
+		return err
+	}
+	if _, err := db.DB.Exec("UPDATE group_messages SET content = ?, edited_at = CURRENT_TIMESTAMP WHERE id = ?", content, messageID); err != nil {
+		return err
+	}
+	var editedAt string
+	db.DB.QueryRow("SELECT edited_at FROM group_messages WHERE id = ?", messageID).Scan(&editedAt)
+	members, err := GroupMemberIDs(ref.GroupID)
+	if err != nil {
+		return err
+	}
+	publishTo(members, map[string]interface{}{
+		"type":      "group_message_updated",
+		"id":        messageID,
+		"group_id":  ref.GroupID,
+		"sender_id": strconv.FormatInt(ref.SenderID, 10),
+		"content":   content,
+		"edited_at": editedAt,
+	})
+	return nil
+}
+
+// DeleteGroupMessage removes a group message for everyone (sender only) or
+// hides it from userID's own history.
+func DeleteGroupMessage(userID, messageID int64, forEveryone bool) error {
+	ref, err := loadGroupMessageRef(messageID)
+	if err != nil {
+		return err
+	}
+	if !IsGroupMember(ref.GroupID, userID) {
+		return ErrMessageNotFound
+	}
+	event := map[string]interface{}{
+		"type":      "group_message_deleted",
+		"id":        messageID,
+		"group_id":  ref.GroupID,
+		"sender_id": strconv.FormatInt(ref.SenderID, 10),
+	}
+	if !forEveryone {
+		if _, err := db.DB.Exec("INSERT OR IGNORE INTO hidden_messages (user_id, kind, message_id) VALUES (?, 'group', ?)", userID, messageID); err != nil {
+			return err
+		}
+		event["scope"] = "me"
+		publishTo([]int64{userID}, event)
+		return nil
+	}
+	if ref.SenderID != userID {
+		return ErrNotMessageSender
+	}
+	if _, err := db.DB.Exec("UPDATE group_messages SET content = '', deleted_at = CURRENT_TIMESTAMP WHERE id = ?", messageID); err != nil {
+		return err
+	}
+	db.DB.Exec("DELETE FROM message_reactions WHERE kind = 'group' AND message_id = ?", messageID)
+	members, err := GroupMemberIDs(ref.GroupID)
+	if err != nil {
+		return err
+	}
+	event["scope"] = "everyone"
+	publishTo(members, event)
+	return nil
+}
+
+// MessageErrorStatus maps chat message errors (edit/delete, receipts,
+// reactions, attachments) to HTTP status codes; the websocket layer reuses it
+// to pick error frame codes.
+func MessageErrorStatus(err error) int {
+	switch err {
+	case ErrMessageNotFound:
+		return http.StatusNotFound
+	case ErrNotMessageSender, ErrEditWindowClosed:
+		return http.StatusForbidden
+	case ErrMessageDeleted:
+		return http.StatusConflict
+	case ErrEmptyMessage, ErrMessageTooLong, ErrInvalidReaction, ErrInvalidAttachment, ErrAttachmentType:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func writeMessageError(w http.ResponseWriter, err error) {
+	status := MessageErrorStatus(err)
+	if status == http.StatusInternalServerError {
+		utils.Error(w, status, "Failed to update message")
+		return
+	}
+	utils.Error(w, status, err.Error())
+}
+
+// editDeletePayload is the shared body of the edit/delete endpoints.
+type editDeletePayload struct {
+	MessageID int64  `json:"message_id"`
+	Content   string `json:"content"`
+	Scope     string `json:"scope"` // "everyone" (default) or "me"
+}
+
+func decodeEditDelete(w http.ResponseWriter, r *http.Request) (int64, editDeletePayload, bool) {
+	var payload editDeletePayload
+	userIDStr := utils.GetUserIDFromContext(r)
+	if userIDStr == "" {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return 0, payload, false
+	}
+	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return 0, payload, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.MessageID == 0 {
+		utils.Error(w, http.StatusBadRequest, "Invalid input")
+		return 0, payload, false
+	}
+	return userID, payload, true
+}
+
+// POST /api/messages/edit { message_id, content }
+func EditMessageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, payload, ok := decodeEditDelete(w, r)
+	if !ok {
+		return
+	}
+	if err := EditDirectMessage(userID, payload.MessageID, payload.Content); err != nil {
+		writeMessageError(w, err)
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]string{"status": "edited"})
+}
+
+// POST /api/messages/delete { message_id, scope: everyone|me }
+func DeleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, payload, ok := decodeEditDelete(w, r)
+	if !ok {
+		return
+	}
+	if err := DeleteDirectMessage(userID, payload.MessageID, payload.Scope != "me"); err != nil {
+		writeMessageError(w, err)
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// POST /api/group/messages/edit { message_id, content }
+func EditGroupMessageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, payload, ok := decodeEditDelete(w, r)
+	if !ok {
+		return
+	}
+	if err := EditGroupMessage(userID, payload.MessageID, payload.Content); err != nil {
+		writeMessageError(w, err)
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]string{"status": "edited"})
+}
+
+// POST /api/group/messages/delete { message_id, scope: everyone|me }
+func DeleteGroupMessageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, payload, ok := decodeEditDelete(w, r)
+	if !ok {
+		return
+	}
+	if err := DeleteGroupMessage(userID, payload.MessageID, payload.Scope != "me"); err != nil {
+		writeMessageError(w, err)
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
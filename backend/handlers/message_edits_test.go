@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"social-network/backend/db"
+	"social-network/backend/models"
+)
+
+// dmHistory returns the conversation with otherID as userID sees it.
+func dmHistory(t *testing.T, userID, otherID int64) map[int]models.Message {
+	t.Helper()
+	var page models.MessagePage
+	if code := call(t, GetMessageHistory, userID, fmt.Sprintf("/api/messages/history?user_id=%d", otherID), nil, &page); code != http.StatusOK {
+		t.Fatalf("history: %d", code)
+	}
+	byID := map[int]models.Message{}
+	for _, m := range page.Messages {
+		byID[m.ID] = m
+	}
+	return byID
+}
+
+func TestEditDirectMessage(t *testing.T) {
+	openTestDB(t)
+	alice, bob := createTestUser(t, "alice"), createTestUser(t, "bob")
+	fresh := sendTestDM(t, alice, bob, "helo")
+	old := sendTestDM(t, alice, bob, "old")
+	db.DB.Exec("UPDATE messages SET created_at = datetime('now', '-16 minutes') WHERE id = ?", old)
+
+	if err := EditDirectMessage(bob, fresh, "hijacked"); err != ErrNotMessageSender {
+		t.Errorf("receiver edit = %v, want ErrNotMessageSender", err)
+	}
+	if err := EditDirectMessage(alice, fresh, "  "); err != ErrEmptyMessage {
+		t.Errorf("blank edit = %v, want ErrEmptyMessage", err)
+	}
+	if err := EditDirectMessage(alice, old, "too late"); err != ErrEditWindowClosed {
+		t.Errorf("edit after %s = %v, want ErrEditWindowClosed", MessageEditWindow, err)
+	}
+	if err := EditDirectMessage(alice, fresh+100, "nothing"); err != ErrMessageNotFound {
+		t.Errorf("edit of a missing message = %v, want ErrMessageNotFound", err)
+	}
+	if err := EditDirectMessage(alice, fresh, " hello "); err != nil {
+		t.Fatal(err)
+	}
+	if m := dmHistory(t, bob, alice)[int(fresh)]; m.Content != "hello" || m.EditedAt == nil {
+		t.Errorf("edited message = %+v, want hello with edited_at", m)
+	}
+	if m := dmHistory(t, bob, alice)[int(old)]; m.Content != "old" || m.EditedAt != nil {
+		t.Errorf("rejected edit changed %+v", m)
+	}
+
+	code := call(t, EditMessageHandler, alice, "/api/messages/edit", map[string]interface{}{"message_id": old, "content": "x"}, nil)
+	if code != http.StatusForbidden {
+		t.Errorf("late edit over HTTP = %d, want 403", code)
+	}
+}
+
+func TestDeleteDirectMessage(t *testing.T) {
+	openTestDB(t)
+	alice, bob, carol := createTestUser(t, "alice"), createTestUser(t, "bob"), createTestUser(t, "carol")
+	mine := sendTestDM(t, alice, bob, "for me")
+	everyone := sendTestDM(t, alice, bob, "for everyone")
+	db.DB.Exec("UPDATE messages SET created_at = datetime('now', '-1 day') WHERE id = ?", everyone)
+
+	if err := DeleteDirectMessage(carol, mine, false); err != ErrMessageNotFound {
+		t.Errorf("outsider delete = %v, want ErrMessageNotFound", err)
+	}
+	if err := DeleteDirectMessage(bob, everyone, true); err != ErrNotMessageSender {
+		t.Errorf("receiver delete for everyone = %v, want ErrNotMessageSender", err)
+	}
+
+	// delete for me only hides the message from the one who deleted it
+	if err := DeleteDirectMessage(bob, mine, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dmHistory(t, bob, alice)[int(mine)]; ok {
+		t.Error("bob still sees the message he deleted for himself")
+	}
+	if m := dmHistory(t, alice, bob)[int(mine)]; m.Content != "for me" || m.Deleted {
+		t.Errorf("alice sees %+v after bob deleted it for himself", m)
+	}
+
+	// delete for everyone leaves a tombstone for both, however old it is
+	if code := call(t, DeleteMessageHandler, alice, "/api/messages/delete", map[string]interface{}{"message_id": everyone}, nil); code != http.StatusOK {
+		t.Fatalf("delete for everyone: %d", code)
+	}
+	for _, viewer := range [][2]int64{{alice, bob}, {bob, alice}} {
+		if m := dmHistory(t, viewer[0], viewer[1])[int(everyone)]; m.Content != "" || !m.Deleted {
+			t.Errorf("user %d sees %+v, want a tombstone", viewer[0], m)
+		}
+	}
+	if err := EditDirectMessage(alice, everyone, "back"); err != ErrMessageDeleted {
+		t.Errorf("edit of a deleted message = %v, want ErrMessageDeleted", err)
+	}
+}
+
+func TestEditDeleteGroupMessage(t *testing.T) {
+	openTestDB(t)
+	alice, bob, carol := createTestUser(t, "alice"), createTestUser(t, "bob"), createTestUser(t, "carol")
+	res, err := db.DB.Exec("INSERT INTO groups (owner_id, name) VALUES (?, 'g')", alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, _ := res.LastInsertId()
+	for _, id := range []int64{alice, bob} {
+		db.DB.Exec("INSERT INTO group_members (group_id, user_id) VALUES (?, ?)", group, id)
+	}
+	send := func(senderID int64, age string) int64 {
+		t.Helper()
+		res, err := db.DB.Exec("INSERT INTO group_messages (group_id, sender_id, content, created_at) VALUES (?, ?, 'hi', datetime('now', ?))", group, senderID, age)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		return id
+	}
+	fresh, old := send(alice, "-1 minute"), send(alice, "-16 minutes")
+
+	if err := EditGroupMessage(bob, fresh, "x"); err != ErrNotMessageSender {
+		t.Errorf("member edit = %v, want ErrNotMessageSender", err)
+	}
+	if err := EditGroupMessage(alice, old, "x"); err != ErrEditWindowClosed {
+		t.Errorf("late edit = %v, want ErrEditWindowClosed", err)
+	}
+	if err := EditGroupMessage(alice, fresh, "edited"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteGroupMessage(carol, fresh, false); err != ErrMessageNotFound {
+		t.Errorf("non-member delete = %v, want ErrMessageNotFound", err)
+	}
+	if err := DeleteGroupMessage(bob, fresh, true); err != ErrNotMessageSender {
+		t.Errorf("member delete for everyone = %v, want ErrNotMessageSender", err)
+	}
+	if err := DeleteGroupMessage(bob, fresh, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteGroupMessage(alice, old, true); err != nil {
+		t.Fatal(err)
+	}
+
+	var hidden int
+	db.DB.QueryRow("SELECT COUNT(*) FROM hidden_messages WHERE user_id = ? AND kind = 'group' AND message_id = ?", bob, fresh).Scan(&hidden)
+	var content string
+	var edited, deleted bool
+	db.DB.QueryRow("SELECT content, edited_at IS NOT NULL, deleted_at IS NOT NULL FROM group_messages WHERE id = ?", fresh).Scan(&content, &edited, &deleted)
+	if hidden != 1 || content != "edited" || !edited || deleted {
+		t.Errorf("after bob's delete for himself: hidden %d, %q edited %v deleted %v", hidden, content, edited, deleted)
+	}
+	db.DB.QueryRow("SELECT content, deleted_at IS NOT NULL FROM group_messages WHERE id = ?", old).Scan(&content, &deleted)
+	if content != "" || !deleted {
+		t.Errorf("after delete for everyone: %q deleted %v, want a tombstone", content, deleted)
+	}
+}
@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"social-network/backend/db"
+	"social-network/backend/utils"
+)
+
+// NotificationChannels says where a notification goes: the in-app list
+// (persisted), a realtime push to connected clients and the email digest.
+// The digest is built from the in-app list, so email needs in_app.
+type NotificationChannels struct {
+	InApp    bool `json:"in_app"`
+	Realtime bool `json:"realtime"`
+	Email    bool `json:"email"`
+}
+
+// How notifications of a type are aggregated (see notificationGroupKey).
+const (
+	aggregateNone    = ""
+	aggregateByType  = "type"  // one unread row per type: "Alice and 3 others followed you"
+	aggregateByActor = "actor" // per sender: "4 new messages from Alice"
+	aggregateByGroup = "group" // per group_id: "5 new messages in Group X"
+)
+
+// notificationType describes one type passed to Notify.
+type notificationType struct {
+	Name     string
+	Defaults NotificationChannels
+	// GroupActivity types are silenced by muting their group_id.
+	GroupActivity bool
+	Aggregate     string
+}
+
+// NotificationTypes lists every notification type users can configure, in
+// the order settings are shown.
+var NotificationTypes = []notificationType{
+	{Name: "new_follower", Defaults: NotificationChannels{InApp: true, Realtime: true}, Aggregate: aggregateByType},
+	{Name: "follow_request", Defaults: NotificationChannels{InApp: true, Realtime: true, Email: true}},
+	{Name: "follow_request_accepted", Defaults: NotificationChannels{InApp: true, Realtime: true}, Aggregate: aggregateByType},
+	{Name: "follow_request_declined", Defaults: NotificationChannels{InApp: true, Realtime: true}},
+	{Name: "group_invite", Defaults: NotificationChannels{InApp: true, Realtime: true, Email: true}},
+	{Name: "group_invite_response", Defaults: NotificationChannels{InApp: true, Realtime: true}},
+	{Name: "group_join_request", Defaults: NotificationChannels{InApp: true, Realtime: true, Email: true}, Aggregate: aggregateByGroup},
+	{Name: "group_join_response", Defaults: NotificationChannels{InApp: true, Realtime: true}},
+	{Name: "group_event", Defaults: NotificationChannels{InApp: true, Realtime: true, Email: true}, GroupActivity: true},
+	{Name: "event_reminder", Defaults: NotificationChannels{InApp: true, Realtime: true, Email: true}, GroupActivity: true},
+	{Name: "new_message", Defaults: NotificationChannels{InApp: true, Realtime: true}, Aggregate: aggregateByActor},
+	{Name: "group_message", Defaults: NotificationChannels{InApp: true, Realtime: true}, GroupActivity: true, Aggregate: aggregateByGroup},
+}
+
+func lookupNotificationType(name string) (notificationType, bool) {
+	for _, t := range NotificationTypes {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return notificationType{}, false
+}
+
+// NotificationChannelsFor returns userID's channels for ntype: their saved
+// preference, else the type's defaults. Unknown types use the in-app list and
+// realtime push only.
+func NotificationChannelsFor(userID int64, ntype string) NotificationChannels {
+	ch := NotificationChannels{InApp: true, Realtime: true}
+	if t, ok := lookupNotificationType(ntype); ok {
+		ch = t.Defaults
+	}
+	var inApp, realtime, email bool
+	err := db.DB.QueryRow("SELECT in_app, realtime, email FROM notification_preferences WHERE user_id=? AND type=?", userID, ntype).
+		Scan(&inApp, &realtime, &email)
+	if err == nil {
+		ch = NotificationChannels{InApp: inApp, Realtime: realtime, Email: email}
+	}
+	return ch
+}
+
+// GroupMuted reports whether userID muted groupID and the mute has not
+// expired.
+func GroupMuted(userID, groupID int64) bool {
+	var muted bool
+	db.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM group_mutes WHERE user_id=? AND group_id=?
+		AND (muted_until IS NULL OR muted_until > CURRENT_TIMESTAMP))`, userID, groupID).Scan(&muted)
+	return muted
+}
+
+// groupIDOf extracts the group_id of a Notify payload, if any.
+func groupIDOf(payload map[string]interface{}) (int64, bool) {
+	switch v := payload["group_id"].(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case float64:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// notificationGroupKey returns the key unread notifications of ntype collapse
+// under, or "" when they are not aggregated.
+func notificationGroupKey(ntype string, actorID int64, payload map[string]interface{}) string {
+	t, _ := lookupNotificationType(ntype)
+	switch t.Aggregate {
+	case aggregateByType:
+		return ntype
+	case aggregateByActor:
+		if actorID > 0 {
+			return fmt.Sprintf("%s:actor:%d", ntype, actorID)
+		}
+	case aggregateByGroup:
+		if groupID, ok := groupIDOf(payload); ok {
+			return fmt.Sprintf("%s:group:%d", ntype, groupID)
+		}
+	}
+	return ""
+}
+
+type notificationPreference struct {
+	Type string `json:"type"`
+	NotificationChannels
+}
+
+// NotificationPreferencesHandler - GET/POST /api/notifications/preferences
+// GET returns the caller's channels for every notification type and their
+// muted groups. POST updates some types:
+//
+//	{"preferences": [{"type": "group_message", "realtime": false}, ...]}
+//
+// Omitted channels keep their current value. email needs in_app, since
+// digests are built from the in-app list.
+func NotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var payload struct {
+			Preferences []struct {
+				Type     string `json:"type"`
+				InApp    *bool  `json:"in_app"`
+				Realtime *bool  `json:"realtime"`
+				Email    *bool  `json:"email"`
+			} `json:"preferences"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			utils.Error(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		updates := make([]notificationPreference, 0, len(payload.Preferences))
+		for _, p := range payload.Preferences {
+			if _, ok := lookupNotificationType(p.Type); !ok {
+				utils.Error(w, http.StatusBadRequest, "Unknown notification type: "+p.Type)
+				return
+			}
+			ch := NotificationChannelsFor(userID, p.Type)
+			if p.InApp != nil {
+				ch.InApp = *p.InApp
+			}
+			if p.Realtime != nil {
+				ch.Realtime = *p.Realtime
+			}
+			if p.Email != nil {
+				ch.Email = *p.Email
+			}
+			if ch.Email && !ch.InApp {
+				// digests are built from the in-app list
+				utils.Error(w, http.StatusBadRequest, "Email digests need in_app: "+p.Type)
+				return
+			}
+			updates = append(updates, notificationPreference{Type: p.Type, NotificationChannels: ch})
+		}
+		tx, err := db.DB.Begin()
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		defer tx.Rollback()
+		for _, p := range updates {
+			_, err := tx.Exec(`INSERT INTO notification_preferences (user_id, type, in_app, realtime, email) VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT(user_id, type) DO UPDATE SET in_app=excluded.in_app, realtime=excluded.realtime, email=excluded.email, updated_at=CURRENT_TIMESTAMP`,
+				userID, p.Type, p.InApp, p.Realtime, p.Email)
+			if err != nil {
+				utils.Error(w, http.StatusInternalServerError, "Failed to save preferences")
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			utils.Error(w, http.StatusInternalServerError, "Failed to save preferences")
+			return
+		}
+	} else if r.Method != http.MethodGet {
+		utils.Error(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	prefs := make([]notificationPreference, 0, len(NotificationTypes))
+	for _, t := range NotificationTypes {
+		prefs = append(prefs, notificationPreference{Type: t.Name, NotificationChannels: NotificationChannelsFor(userID, t.Name)})
+	}
+	mutes, err := mutedGroups(userID)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]interface{}{"preferences": prefs, "muted_groups": mutes})
+}
+
+type groupMute struct {
+	GroupID    int64   `json:"group_id"`
+	MutedUntil *string `json:"muted_until"`
+}
+
+func mutedGroups(userID int64) ([]groupMute, error) {
+	rows, err := db.DB.Query(`SELECT group_id, muted_until FROM group_mutes WHERE user_id=?
+		AND (muted_until IS NULL OR muted_until > CURRENT_TIMESTAMP) ORDER BY group_id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []groupMute{}
+	for rows.Next() {
+		var m groupMute
+		var until sql.NullString
+		if err := rows.Scan(&m.GroupID, &until); err != nil {
+			return nil, err
+		}
+		if until.Valid {
+			m.MutedUntil = &until.String
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// MuteGroupHandler - POST /api/group/mute { group_id, duration_minutes? }
+// Silences the group's chat message and event notifications for the caller,
+// for duration_minutes or until unmuted. Members only.
+func MuteGroupHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var payload struct {
+		GroupID         int64 `json:"group_id"`
+		DurationMinutes int64 `json:"duration_minutes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.GroupID <= 0 || payload.DurationMinutes < 0 {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !IsGroupMember(payload.GroupID, userID) {
+		utils.Error(w, http.StatusForbidden, "Not a member")
+		return
+	}
+	var until interface{}
+	if payload.DurationMinutes > 0 {
+		until = time.Now().UTC().Add(time.Duration(payload.DurationMinutes) * time.Minute).Format("2006-01-02 15:04:05")
+	}
+	_, err = db.DB.Exec(`INSERT INTO group_mutes (user_id, group_id, muted_until) VALUES (?, ?, ?)
+		ON CONFLICT(user_id, group_id) DO UPDATE SET muted_until=excluded.muted_until`, userID, payload.GroupID, until)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to mute group")
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]interface{}{"group_id": payload.GroupID, "muted_until": until})
+}
+
+// UnmuteGroupHandler - POST /api/group/unmute { group_id }
+func UnmuteGroupHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var payload struct {
+		GroupID int64 `json:"group_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.GroupID <= 0 {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if _, err := db.DB.Exec("DELETE FROM group_mutes WHERE user_id=? AND group_id=?", userID, payload.GroupID); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to unmute group")
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
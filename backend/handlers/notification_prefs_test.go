@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"social-network/backend/db"
+)
+
+func TestNotificationPreferences(t *testing.T) {
+	openTestDB(t)
+	alice := createTestUser(t, "alice")
+	const target = "/api/notifications/preferences"
+	type pref = map[string]interface{}
+	var got struct {
+		Preferences []notificationPreference `json:"preferences"`
+	}
+	channels := func() map[string]NotificationChannels {
+		t.Helper()
+		byType := map[string]NotificationChannels{}
+		for _, p := range got.Preferences {
+			byType[p.Type] = p.NotificationChannels
+		}
+		return byType
+	}
+
+	if code := call(t, NotificationPreferencesHandler, alice, target, nil, &got); code != http.StatusOK {
+		t.Fatalf("GET = %d", code)
+	}
+	if len(got.Preferences) != len(NotificationTypes) {
+		t.Errorf("%d preferences, want one per type (%d)", len(got.Preferences), len(NotificationTypes))
+	}
+	if ch := channels()["group_invite"]; ch != (NotificationChannels{InApp: true, Realtime: true, Email: true}) {
+		t.Errorf("group_invite defaults = %+v", ch)
+	}
+
+	// omitted channels keep their value
+	body := map[string]interface{}{"preferences": []pref{{"type": "group_message", "realtime": false}, {"type": "group_invite", "email": false}}}
+	if code := call(t, NotificationPreferencesHandler, alice, target, body, &got); code != http.StatusOK {
+		t.Fatalf("POST = %d", code)
+	}
+	byType := channels()
+	if ch := byType["group_message"]; ch != (NotificationChannels{InApp: true}) {
+		t.Errorf("group_message = %+v, want in_app only", ch)
+	}
+	if ch := byType["group_invite"]; ch != (NotificationChannels{InApp: true, Realtime: true}) {
+		t.Errorf("group_invite = %+v, want in_app and realtime", ch)
+	}
+	if ch := NotificationChannelsFor(alice, "group_message"); ch != byType["group_message"] {
+		t.Errorf("stored group_message = %+v", ch)
+	}
+
+	body = map[string]interface{}{"preferences": []pref{{"type": "new_follower", "realtime": false}, {"type": "shout"}}}
+	if code := call(t, NotificationPreferencesHandler, alice, target, body, nil); code != http.StatusBadRequest {
+		t.Errorf("unknown type = %d, want 400", code)
+	}
+	if ch := NotificationChannelsFor(alice, "new_follower"); !ch.Realtime {
+		t.Error("a rejected update was partly saved")
+	}
+}
+
+func TestNotifyUsesChannelsAndMutes(t *testing.T) {
+	openTestDB(t)
+	alice, bob, carol := createTestUser(t, "alice"), createTestUser(t, "bob"), createTestUser(t, "carol")
+	res, err := db.DB.Exec("INSERT INTO groups (owner_id, name) VALUES (?, 'g')", alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, _ := res.LastInsertId()
+	db.DB.Exec("INSERT INTO group_members (group_id, user_id) VALUES (?, ?), (?, ?)", group, alice, group, bob)
+	db.DB.Exec("INSERT INTO notification_preferences (user_id, type, in_app, realtime, email) VALUES (?, 'new_follower', 0, 1, 0)", alice)
+	stored := func(userID int64, ntype string) int {
+		t.Helper()
+		var n int
+		db.DB.QueryRow("SELECT COUNT(*) FROM notifications WHERE recipient_id = ? AND type = ?", userID, ntype).Scan(&n)
+		return n
+	}
+	published()
+
+	Notify(alice, bob, "new_follower", map[string]interface{}{})
+	if stored(alice, "new_follower") != 0 {
+		t.Error("stored a notification with in_app off")
+	}
+	if got := published(); len(got) != 1 {
+		t.Errorf("pushed %v, want the realtime copy", got)
+	}
+	Notify(alice, alice, "new_message", map[string]interface{}{})
+	if stored(alice, "new_message") != 0 {
+		t.Error("notified alice of her own action")
+	}
+
+	mute := func(userID int64, body map[string]interface{}) int {
+		t.Helper()
+		return call(t, MuteGroupHandler, userID, "/api/group/mute", body, nil)
+	}
+	if code := mute(bob, map[string]interface{}{"group_id": group}); code != http.StatusOK {
+		t.Fatalf("mute = %d", code)
+	}
+	if code := mute(carol, map[string]interface{}{"group_id": group}); code != http.StatusForbidden {
+		t.Errorf("non-member mute = %d, want 403", code)
+	}
+	if code := mute(bob, map[string]interface{}{"group_id": group, "duration_minutes": -1}); code != http.StatusBadRequest {
+		t.Errorf("negative duration = %d, want 400", code)
+	}
+	Notify(bob, alice, "group_message", map[string]interface{}{"group_id": group})
+	Notify(bob, alice, "group_invite_response", map[string]interface{}{"group_id": group}) // not group activity
+	if stored(bob, "group_message") != 0 || stored(bob, "group_invite_response") != 1 {
+		t.Error("muting did not drop only group activity")
+	}
+
+	// an expired mute no longer applies
+	db.DB.Exec("UPDATE group_mutes SET muted_until = datetime('now', '-1 minute') WHERE user_id = ?", bob)
+	if GroupMuted(bob, group) {
+		t.Error("an expired mute still applies")
+	}
+	if code := mute(bob, map[string]interface{}{"group_id": group, "duration_minutes": 30}); code != http.StatusOK || !GroupMuted(bob, group) {
+		t.Errorf("timed mute = %d, muted %v", code, GroupMuted(bob, group))
+	}
+	if code := call(t, UnmuteGroupHandler, bob, "/api/group/unmute", map[string]interface{}{"group_id": group}, nil); code != http.StatusOK || GroupMuted(bob, group) {
+		t.Errorf("unmute = %d, still muted %v", code, GroupMuted(bob, group))
+	}
+	Notify(bob, alice, "group_message", map[string]interface{}{"group_id": group})
+	if stored(bob, "group_message") != 1 {
+		t.Error("group messages are still dropped after unmuting")
+	}
+}
+
+func TestNotificationPreferencesEmailNeedsInApp(t *testing.T) {
+	openTestDB(t)
+	alice := createTestUser(t, "alice")
+	const target = "/api/notifications/preferences"
+
+	type pref = map[string]interface{}
+	post := func(p pref) int {
+		return call(t, NotificationPreferencesHandler, alice, target, map[string]interface{}{"preferences": []pref{p}}, nil)
+	}
+	// group_invite mails by default, so in_app cannot be turned off alone
+	if code := post(pref{"type": "group_invite", "in_app": false}); code != http.StatusBadRequest {
+		t.Fatalf("in_app off with email on = %d, want 400", code)
+	}
+	if code := post(pref{"type": "new_follower", "in_app": false, "email": true}); code != http.StatusBadRequest {
+		t.Fatalf("email on with in_app off = %d, want 400", code)
+	}
+	if code := post(pref{"type": "group_invite", "in_app": false, "email": false}); code != http.StatusOK {
+		t.Fatalf("in_app and email off = %d, want 200", code)
+	}
+	if ch := NotificationChannelsFor(alice, "group_invite"); ch.InApp || ch.Email || !ch.Realtime {
+		t.Fatalf("group_invite channels = %+v, want realtime only", ch)
+	}
+	if ch := NotificationChannelsFor(alice, "new_follower"); !ch.InApp || ch.Email {
+		t.Fatalf("new_follower channels = %+v, want unchanged defaults", ch)
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"social-network/backend/db"
+	"social-network/backend/models"
+)
+
+// maxListedActors is how many of an aggregated notification's actors are
+// returned; the rest are only counted.
+const maxListedActors = 3
+
+// attachNotificationActors fills Actors and ActorCount of list, most recent
+// actor first, with a single query.
+func attachNotificationActors(list []models.Notification) error {
+	if len(list) == 0 {
+		return nil
+	}
+	index := make(map[int64]int, len(list))
+	ids := make([]interface{}, len(list))
+	for i := range list {
+		index[list[i].ID] = i
+		ids[i] = list[i].ID
+	}
+	rows, err := db.DB.Query(`SELECT a.notification_id, a.actor_id, u.nickname, IFNULL(u.avatar, ''), a.rn, a.total
+		FROM (
+			SELECT notification_id, actor_id,
+				ROW_NUMBER() OVER (PARTITION BY notification_id ORDER BY created_at DESC, actor_id DESC) AS rn,
+				COUNT(*) OVER (PARTITION BY notification_id) AS total
+			FROM notification_actors
+			WHERE notification_id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)
+		) a
+		JOIN users u ON u.id = a.actor_id
+		WHERE a.rn <= ?
+		ORDER BY a.notification_id, a.rn`, append(ids, maxListedActors)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var notificationID int64
+		var actor models.NotificationActor
+		var rn, total int
+		if err := rows.Scan(&notificationID, &actor.ID, &actor.Nickname, &actor.Avatar, &rn, &total); err != nil {
+			return err
+		}
+		n := &list[index[notificationID]]
+		n.Actors = append(n.Actors, actor)
+		n.ActorCount = total
+	}
+	return rows.Err()
+}
+
+// summarizeNotifications sets a human readable Summary on every notification,
+// e.g. "Alice and 3 others followed you". The email digest lists summaries.
+func summarizeNotifications(list []models.Notification) {
+	groupNames := map[int64]string{}
+	groupName := func(n *models.Notification) string {
+		groupID, ok := groupIDOf(notificationData(n))
+		if !ok {
+			return "a group"
+		}
+		if name, ok := groupNames[groupID]; ok {
+			return name
+		}
+		name := "a group"
+		db.DB.QueryRow("SELECT name FROM groups WHERE id = ?", groupID).Scan(&name)
+		groupNames[groupID] = name
+		return name
+	}
+
+	for i := range list {
+		n := &list[i]
+		actors := actorPhrase(n)
+		switch n.Type {
+		case "new_follower":
+			n.Summary = actors + " followed you"
+		case "follow_request":
+			n.Summary = actors + " asked to follow you"
+		case "follow_request_accepted":
+			n.Summary = actors + " accepted your follow request"
+		case "follow_request_declined":
+			n.Summary = actors + " declined your follow request"
+		case "group_invite":
+			n.Summary = fmt.Sprintf("%s invited you to join %s", actors, groupName(n))
+		case "group_invite_response":
+			n.Summary = fmt.Sprintf("%s %s your invitation to %s", actors, dataString(n, "status", "answered"), groupName(n))
+		case "group_join_response":
+			n.Summary = fmt.Sprintf("Your request to join %s was %s", groupName(n), dataString(n, "status", "answered"))
+		case "group_event":
+			n.Summary = fmt.Sprintf("New event in %s: %s", groupName(n), dataString(n, "title", "untitled"))
+		case "event_reminder":
+			n.Summary = fmt.Sprintf("Reminder: %s in %s starts %s", dataString(n, "title", "an event"), groupName(n), dataString(n, "event_time", "soon"))
+		case "group_join_request":
+			n.Summary = fmt.Sprintf("%s asked to join %s", actors, groupName(n))
+		case "new_message":
+			n.Summary = "New message from " + actors
+			if n.Count > 1 {
+				n.Summary = fmt.Sprintf("%d new messages from %s", n.Count, actors)
+			}
+		case "group_message":
+			n.Summary = "New message in " + groupName(n)
+			if n.Count > 1 {
+				n.Summary = fmt.Sprintf("%d new messages in %s", n.Count, groupName(n))
+			}
+		default:
+			n.Summary = strings.ReplaceAll(n.Type, "_", " ")
+		}
+	}
+}
+
+// notificationData decodes n.Data, which is a JSON object for every type
+// Notify creates.
+func notificationData(n *models.Notification) map[string]interface{} {
+	var data map[string]interface{}
+	json.Unmarshal(n.Data, &data)
+	return data
+}
+
+// dataString returns the string field key of n.Data, or fallback.
+func dataString(n *models.Notification, key, fallback string) string {
+	if s, ok := notificationData(n)[key].(string); ok && s != "" {
+		return s
+	}
+	return fallback
+}
+
+// actorPhrase names a notification's actors: "Alice", "Alice and Bob" or
+// "Alice and 3 others".
+func actorPhrase(n *models.Notification) string {
+	switch {
+	case len(n.Actors) == 0:
+		return "Someone"
+	case n.ActorCount <= 1:
+		return n.Actors[0].Nickname
+	case n.ActorCount == 2:
+		return n.Actors[0].Nickname + " and " + n.Actors[1].Nickname
+	}
+	return fmt.Sprintf("%s and %d others", n.Actors[0].Nickname, n.ActorCount-1)
+}
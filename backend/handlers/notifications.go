@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"social-network/backend/bus"
@@ -9,39 +13,131 @@ import (
 	"social-network/backend/models"
 	"social-network/backend/utils"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // CreateNotification inserts a notification into DB for recipient. actorID may be 0.
 func CreateNotification(recipientID int64, actorID int64, ntype string, data string) error {
-	_, err := db.DB.Exec("INSERT INTO notifications (recipient_id, actor_id, type, data, is_read, created_at) VALUES (?, ?, ?, ?, 0, CURRENT_TIMESTAMP)", recipientID, actorID, ntype, data)
+	_, err := db.DB.Exec("INSERT INTO notifications (recipient_id, actor_id, type, data, is_read, created_at, updated_at) VALUES (?, ?, ?, ?, 0, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)", recipientID, actorID, ntype, data)
 	return err
 }
 
-// Notify builds a consistent JSON payload, persists the notification, and
-// publishes a realtime copy to the in-memory bus so connected websocket
-// clients receive it.
+// storeNotification persists a notification and returns its id and count.
+// With a groupKey it is folded into the recipient's unread notification with
+// the same key, if any: the count goes up and the latest actor and data win.
+func storeNotification(recipientID, actorID int64, ntype, data, groupKey string) (int64, int, error) {
+	var key interface{}
+	if groupKey != "" {
+		key = groupKey
+	}
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	var id int64
+	var count int
+	err = tx.QueryRow(`INSERT INTO notifications (recipient_id, actor_id, type, data, is_read, group_key, count, created_at, updated_at)
+		VALUES (?, ?, ?, ?, 0, ?, 1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (recipient_id, group_key) WHERE is_read = 0 AND group_key IS NOT NULL
+		DO UPDATE SET count = count + 1, actor_id = excluded.actor_id, data = excluded.data, updated_at = CURRENT_TIMESTAMP
+		RETURNING id, count`, recipientID, actorID, ntype, data, key).Scan(&id, &count)
+	if err != nil {
+		return 0, 0, err
+	}
+	if actorID > 0 {
+		_, err = tx.Exec(`INSERT INTO notification_actors (notification_id, actor_id) VALUES (?, ?)
+			ON CONFLICT (notification_id, actor_id) DO UPDATE SET created_at = CURRENT_TIMESTAMP`, id, actorID)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return id, count, tx.Commit()
+}
+
+// Notify builds a consistent JSON payload and delivers the notification on
+// the channels the recipient enabled for ntype (see NotificationChannelsFor):
+// it is persisted for the in-app list and published to the in-memory bus so
+// connected websocket clients receive it. Nobody is notified of their own
+// actions, group activity from muted groups is dropped and do-not-disturb
+// suppresses the realtime push.
 func Notify(recipientID int64, actorID int64, ntype string, payload map[string]interface{}) error {
+	if recipientID == actorID {
+		return nil
+	}
+	if t, ok := lookupNotificationType(ntype); ok && t.GroupActivity {
+		if groupID, ok := groupIDOf(payload); ok && GroupMuted(recipientID, groupID) {
+			return nil
+		}
+	}
+	channels := NotificationChannelsFor(recipientID, ntype)
+
 	// ensure payload is JSON string
 	dataBytes, _ := json.Marshal(payload)
 	dataStr := string(dataBytes)
 
-	if err := CreateNotification(recipientID, actorID, ntype, dataStr); err != nil {
-		log.Println("CreateNotification error:", err)
-		// still try to publish realtime for a best-effort UX
+	var notificationID int64
+	count := 1
+	if channels.InApp {
+		var err error
+		notificationID, count, err = storeNotification(recipientID, actorID, ntype, dataStr, notificationGroupKey(ntype, actorID, payload))
+		if err != nil {
+			log.Println("CreateNotification error:", err)
+			// still try to publish realtime for a best-effort UX
+		} else {
+			pushUnreadCount(recipientID)
+		}
+	}
+
+	// do-not-disturb: the notification is stored above and shows up in the
+	// list, but is not pushed
+	if !channels.Realtime || doNotDisturb(recipientID) {
+		return nil
 	}
 
 	// publish to bus for realtime delivery (best-effort)
 	notif := map[string]interface{}{
-		"type": ntype,
-		"data": payload,
+		"type":  ntype,
+		"data":  payload,
+		"count": count,
+	}
+	if notificationID > 0 {
+		// lets clients replace the aggregated entry instead of adding one
+		notif["notification_id"] = notificationID
+	}
+	if actorID > 0 {
+		notif["actor_id"] = actorID
 	}
 	realtimeBytes, _ := json.Marshal(notif)
-	bus.PublishNotification(recipientID, realtimeBytes)
+	bus.PublishAlert(recipientID, realtimeBytes)
 	log.Printf("Published realtime notification type=%s to recipient=%d", ntype, recipientID)
 	return nil
 }
 
-// GET /api/notifications - list recent notifications for current user
+// doNotDisturb reports whether userID chose the dnd presence status.
+func doNotDisturb(userID int64) bool {
+	var status string
+	db.DB.QueryRow("SELECT presence_status FROM users WHERE id = ?", userID).Scan(&status)
+	return status == "dnd"
+}
+
+const (
+	notificationsDefaultLimit = 20
+	notificationsMaxLimit     = 100
+)
+
+// ListNotificationsHandler - GET /api/notifications
+// A page of the current user's notifications, most recently updated first:
+//   - limit: page size, default 20 (max 100)
+//   - cursor: next_cursor of the previous page
+//   - type: only these types (comma separated)
+//   - read: "true" or "false" for only read or only unread notifications
+//
+// Aggregated notifications come with their count, actors and a summary.
+// data is decoded into an object and the latest actor is hydrated. Cursors
+// are (updated_at, id) positions; a notification that aggregates a new event
+// moves to the top, where realtime clients already have it.
 func ListNotificationsHandler(w http.ResponseWriter, r *http.Request) {
 	userIDStr := utils.GetUserIDFromContext(r)
 	if userIDStr == "" {
@@ -53,25 +149,227 @@ func ListNotificationsHandler(w http.ResponseWriter, r *http.Request) {
 		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
+	q := r.URL.Query()
+
+	limit := notificationsDefaultLimit
+	if l := q.Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed <= 0 {
+			utils.Error(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = parsed
+	}
+	if limit > notificationsMaxLimit {
+		limit = notificationsMaxLimit
+	}
+
+	where := []string{"n.recipient_id = ?"}
+	args := []interface{}{userID}
+	if t := q.Get("type"); t != "" {
+		types := strings.Split(t, ",")
+		where = append(where, "n.type IN (?"+strings.Repeat(", ?", len(types)-1)+")")
+		for _, typ := range types {
+			args = append(args, strings.TrimSpace(typ))
+		}
+	}
+	switch q.Get("read") {
+	case "":
+	case "true", "false":
+		where = append(where, "n.is_read = ?")
+		args = append(args, q.Get("read") == "true")
+	default:
+		utils.Error(w, http.StatusBadRequest, `read must be "true" or "false"`)
+		return
+	}
+	if c := q.Get("cursor"); c != "" {
+		updated, id, ok := decodeNotificationCursor(c)
+		if !ok {
+			utils.Error(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+		where = append(where, "(n.updated_at < ? OR (n.updated_at = ? AND n.id < ?))")
+		args = append(args, updated, updated, id)
+	}
 
-	rows, err := db.DB.Query("SELECT id, recipient_id, actor_id, type, data, is_read, created_at FROM notifications WHERE recipient_id=? ORDER BY created_at DESC LIMIT 50", userID)
+	rows, err := db.DB.Query(`SELECT n.id, n.recipient_id, IFNULL(n.actor_id, 0), n.type, IFNULL(n.data, ''), n.is_read, n.created_at, n.count, n.updated_at,
+			u.nickname, IFNULL(u.avatar, '')
+		FROM notifications n
+		LEFT JOIN users u ON u.id = n.actor_id
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY n.updated_at DESC, n.id DESC LIMIT ?`, append(args, limit+1)...)
 	if err != nil {
 		utils.Error(w, http.StatusInternalServerError, "Failed to query notifications")
 		return
 	}
 	defer rows.Close()
 
-	var out []models.Notification
+	page := models.NotificationPage{Notifications: []models.Notification{}}
 	for rows.Next() {
 		var n models.Notification
-		var isRead int
-		if err := rows.Scan(&n.ID, &n.RecipientID, &n.ActorID, &n.Type, &n.Data, &isRead, &n.CreatedAt); err != nil {
+		var data string
+		var isRead bool
+		var nickname sql.NullString
+		var avatar string
+		if err := rows.Scan(&n.ID, &n.RecipientID, &n.ActorID, &n.Type, &data, &isRead, &n.CreatedAt, &n.Count, &n.UpdatedAt, &nickname, &avatar); err != nil {
 			continue
 		}
-		n.IsRead = isRead == 1
-		out = append(out, n)
+		n.IsRead = isRead
+		n.Data = decodeNotificationData(data)
+		if nickname.Valid {
+			n.Actor = &models.NotificationActor{ID: n.ActorID, Nickname: nickname.String, Avatar: avatar}
+		}
+		page.Notifications = append(page.Notifications, n)
+	}
+	rows.Close()
+	if len(page.Notifications) > limit {
+		page.Notifications = page.Notifications[:limit]
+		page.HasMore = true
+		page.NextCursor = encodeNotificationCursor(page.Notifications[limit-1])
+	}
+	if err := attachNotificationActors(page.Notifications); err != nil {
+		log.Println("notification actors error:", err)
+	}
+	summarizeNotifications(page.Notifications)
+	page.UnreadCount = unreadNotificationCount(userID)
+	utils.JSON(w, http.StatusOK, page)
+}
+
+// decodeNotificationData returns the stored data as JSON; data that is not
+// valid JSON is returned as a string.
+func decodeNotificationData(data string) json.RawMessage {
+	if data == "" {
+		return nil
+	}
+	if json.Valid([]byte(data)) {
+		return json.RawMessage(data)
+	}
+	encoded, _ := json.Marshal(data)
+	return encoded
+}
+
+// encodeNotificationCursor returns the opaque position after n.
+func encodeNotificationCursor(n models.Notification) string {
+	updated := n.UpdatedAt
+	if t, err := time.Parse(time.RFC3339, updated); err == nil {
+		// compare in the format SQLite stores CURRENT_TIMESTAMP in
+		updated = t.UTC().Format("2006-01-02 15:04:05")
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s|%d", updated, n.ID)))
+}
+
+func decodeNotificationCursor(cursor string) (string, int64, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", 0, false
+	}
+	updated, idStr, found := strings.Cut(string(raw), "|")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if !found || err != nil {
+		return "", 0, false
+	}
+	return updated, id, true
+}
+
+// unreadNotificationCount returns how many unread notifications userID has
+// (an aggregated notification counts once).
+func unreadNotificationCount(userID int64) int {
+	var count int
+	db.DB.QueryRow("SELECT COUNT(*) FROM notifications WHERE recipient_id=? AND is_read=0", userID).Scan(&count)
+	return count
+}
+
+// pushUnreadCount sends userID's connections their current unread count, as
+// an unsequenced snapshot: {"type": "unread_count", "count": N}.
+func pushUnreadCount(userID int64) {
+	payload, _ := json.Marshal(map[string]interface{}{"type": "unread_count", "count": unreadNotificationCount(userID)})
+	bus.PublishSnapshot(userID, payload)
+}
+
+// UnreadCountHandler - GET /api/notifications/unread-count
+func UnreadCountHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]int{"unread_count": unreadNotificationCount(userID)})
+}
+
+// deleteNotifications removes userID's notifications matching cond (a
+// condition on the notifications table) with their actors, and returns how
+// many were removed.
+func deleteNotifications(userID int64, cond string, args ...interface{}) (int64, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	args = append([]interface{}{userID}, args...)
+	if _, err := tx.Exec("DELETE FROM notification_actors WHERE notification_id IN (SELECT id FROM notifications WHERE recipient_id=? AND "+cond+")", args...); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec("DELETE FROM notifications WHERE recipient_id=? AND "+cond, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return n, tx.Commit()
+}
+
+// DeleteNotificationHandler - POST /api/notifications/delete { id }
+func DeleteNotificationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var payload struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.ID <= 0 {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	deleted, err := deleteNotifications(userID, "id=?", payload.ID)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to delete notification")
+		return
+	}
+	if deleted == 0 {
+		utils.Error(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+	pushUnreadCount(userID)
+	utils.JSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ClearNotificationsHandler - POST /api/notifications/clear { read_only? }
+// Deletes all of the user's notifications, or only the read ones.
+func ClearNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var payload struct {
+		ReadOnly bool `json:"read_only"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && err != io.EOF {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	cond := "1=1"
+	if payload.ReadOnly {
+		cond = "is_read=1"
+	}
+	deleted, err := deleteNotifications(userID, cond)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to clear notifications")
+		return
 	}
-	utils.JSON(w, http.StatusOK, out)
+	pushUnreadCount(userID)
+	utils.JSON(w, http.StatusOK, map[string]int64{"deleted": deleted})
 }
 
 // POST /api/notifications/mark-read - mark notifications read (accepts optional id)
@@ -107,5 +405,6 @@ func MarkNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	pushUnreadCount(userID)
 	utils.JSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
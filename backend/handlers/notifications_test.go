@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"social-network/backend/bus"
+	"social-network/backend/db"
+	"social-network/backend/models"
+)
+
+// published returns the recipients of the realtime notifications published
+// within a short wait, leaving out snapshots.
+func published() []int64 {
+	var ids []int64
+	timeout := time.After(200 * time.Millisecond)
+	for {
+		select {
+		case m := <-bus.NotificationChan:
+			if !m.Snapshot {
+				ids = append(ids, m.RecipientID)
+			}
+		case <-timeout:
+			return ids
+		}
+	}
+}
+
+func TestNotifyRespectsDoNotDisturb(t *testing.T) {
+	openTestDB(t)
+	alice, bob := createTestUser(t, "alice"), createTestUser(t, "bob")
+	db.DB.Exec("UPDATE users SET presence_status = 'dnd' WHERE id = ?", alice)
+	published()
+
+	for _, id := range []int64{alice, bob} {
+		if err := Notify(id, 0, "new_follower", map[string]interface{}{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := published(); len(got) != 1 || got[0] != bob {
+		t.Errorf("pushed to %v, want only bob", got)
+	}
+	// the notification is still stored for the dnd user's list
+	var stored int
+	db.DB.QueryRow("SELECT COUNT(*) FROM notifications WHERE recipient_id = ?", alice).Scan(&stored)
+	if stored != 1 {
+		t.Errorf("%d notifications stored for alice, want 1", stored)
+	}
+}
+
+func TestNotifyAggregatesUnreadNotifications(t *testing.T) {
+	openTestDB(t)
+	alice, bob, carol := createTestUser(t, "alice"), createTestUser(t, "bob"), createTestUser(t, "carol")
+	notify := func(actorID int64, ntype string) {
+		t.Helper()
+		if err := Notify(alice, actorID, ntype, map[string]interface{}{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := func() map[string][]models.Notification {
+		t.Helper()
+		var page models.NotificationPage
+		if code := call(t, ListNotificationsHandler, alice, "/api/notifications", nil, &page); code != http.StatusOK {
+			t.Fatalf("list: %d", code)
+		}
+		byType := map[string][]models.Notification{}
+		for _, n := range page.Notifications {
+			byType[n.Type] = append(byType[n.Type], n)
+		}
+		return byType
+	}
+
+	notify(bob, "new_follower")
+	notify(carol, "new_follower")
+	notify(bob, "new_follower")
+	notify(bob, "new_message")
+	notify(carol, "new_message")
+	notify(bob, "new_message")
+	notify(bob, "follow_request")
+	notify(carol, "follow_request")
+	notify(alice, "new_follower") // own actions are dropped
+
+	got := list()
+	// per type: one row for all actors, bob being the latest
+	if f := got["new_follower"]; len(f) != 1 || f[0].Count != 3 || f[0].ActorCount != 2 || f[0].ActorID != bob {
+		t.Errorf("new_follower = %+v, want one row of 3 from 2 actors, latest bob", f)
+	}
+	// both followed within the same second, so either may be listed first
+	if f := got["new_follower"]; len(f) == 1 && f[0].Summary != "bob and carol followed you" && f[0].Summary != "carol and bob followed you" {
+		t.Errorf("new_follower summary = %q, want both actors named", f[0].Summary)
+	}
+	// per actor
+	counts := map[int64]int{}
+	for _, n := range got["new_message"] {
+		counts[n.ActorID] += n.Count
+	}
+	if m := got["new_message"]; len(m) != 2 || counts[bob] != 2 || counts[carol] != 1 {
+		t.Errorf("new_message = %+v, want bob's 2 and carol's 1", m)
+	}
+	if r := got["follow_request"]; len(r) != 2 || r[0].Count != 1 || r[1].Count != 1 {
+		t.Errorf("follow_request = %+v, want 2 separate rows", r)
+	}
+
+	// once read, a notification no longer takes new events
+	readID := got["new_follower"][0].ID
+	if code := call(t, MarkNotificationsReadHandler, alice, "/api/notifications/mark-read", map[string]int64{"id": readID}, nil); code != http.StatusOK {
+		t.Fatalf("mark read: %d", code)
+	}
+	notify(carol, "new_follower")
+	f := list()["new_follower"]
+	if len(f) != 2 || f[0].ID == readID || f[0].Count != 1 || f[0].IsRead || f[1].ID != readID || f[1].Count != 3 {
+		t.Errorf("new_follower after read = %+v, want a new unread row above the read one", f)
+	}
+
+	var rows int
+	db.DB.QueryRow("SELECT COUNT(*) FROM notifications WHERE recipient_id = ?", alice).Scan(&rows)
+	if rows != 6 {
+		t.Errorf("%d notification rows, want 6", rows)
+	}
+}
+
+func TestListNotificationsFiltersAndPages(t *testing.T) {
+	openTestDB(t)
+	alice, bob := createTestUser(t, "alice"), createTestUser(t, "bob")
+	// distinct update times so the order does not depend on ids alone
+	for i, ntype := range []string{"new_follower", "group_invite", "follow_request", "group_invite", "follow_request_accepted"} {
+		db.DB.Exec(`INSERT INTO notifications (recipient_id, actor_id, type, data, is_read, created_at, updated_at)
+			VALUES (?, ?, ?, '{"n":1}', ?, datetime('now', ?), datetime('now', ?))`,
+			alice, bob, ntype, i%2 == 1, fmt.Sprintf("-%d minutes", 10-i), fmt.Sprintf("-%d minutes", 10-i))
+	}
+	db.DB.Exec("INSERT INTO notifications (recipient_id, actor_id, type, is_read) VALUES (?, ?, 'new_follower', 0)", bob, alice)
+	list := func(query string) (int, models.NotificationPage) {
+		t.Helper()
+		var page models.NotificationPage
+		code := call(t, ListNotificationsHandler, alice, "/api/notifications"+query, nil, &page)
+		return code, page
+	}
+	types := func(page models.NotificationPage) []string {
+		var out []string
+		for _, n := range page.Notifications {
+			out = append(out, n.Type)
+		}
+		return out
+	}
+
+	// pages follow each other without gaps or repeats
+	var got []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		code, page := list("?limit=2&cursor=" + cursor)
+		if code != http.StatusOK || pages > 3 {
+			t.Fatalf("page %d = %d", pages, code)
+		}
+		if page.UnreadCount != 3 {
+			t.Errorf("unread_count = %d, want 3", page.UnreadCount)
+		}
+		got = append(got, types(page)...)
+		if !page.HasMore {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	want := []string{"follow_request_accepted", "group_invite", "follow_request", "group_invite", "new_follower"}
+	if !slices.Equal(got, want) {
+		t.Errorf("paged = %v, want %v", got, want)
+	}
+
+	if _, page := list("?type=group_invite,new_follower&read=false"); !slices.Equal(types(page), []string{"new_follower"}) {
+		t.Errorf("unread of two types = %v, want [new_follower]", types(page))
+	}
+	if _, page := list("?read=true"); !slices.Equal(types(page), []string{"group_invite", "group_invite"}) {
+		t.Errorf("read = %v", types(page))
+	}
+	if _, page := list("?limit=1"); len(page.Notifications) != 1 || string(page.Notifications[0].Data) != `{"n":1}` ||
+		page.Notifications[0].Actor == nil || page.Notifications[0].Actor.Nickname != "bob" {
+		t.Errorf("first notification = %+v, want decoded data and bob as actor", page.Notifications)
+	}
+	for _, query := range []string{"?limit=0", "?limit=x", "?read=maybe", "?cursor=bm9wZQ"} {
+		if code, _ := list(query); code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want 400", query, code)
+		}
+	}
+}
+
+func TestUnreadCountAndDelete(t *testing.T) {
+	openTestDB(t)
+	alice, bob, carol := createTestUser(t, "alice"), createTestUser(t, "bob"), createTestUser(t, "carol")
+	Notify(alice, bob, "follow_request", map[string]interface{}{})
+	Notify(alice, carol, "follow_request", map[string]interface{}{})
+	Notify(alice, bob, "new_message", map[string]interface{}{})
+	unread := func() int {
+		t.Helper()
+		var got struct {
+			UnreadCount int `json:"unread_count"`
+		}
+		if code := call(t, UnreadCountHandler, alice, "/api/notifications/unread-count", nil, &got); code != http.StatusOK {
+			t.Fatalf("unread-count = %d", code)
+		}
+		return got.UnreadCount
+	}
+	if n := unread(); n != 3 {
+		t.Fatalf("unread = %d, want 3", n)
+	}
+
+	var ids []int64
+	rows, _ := db.DB.Query("SELECT id FROM notifications WHERE recipient_id = ? ORDER BY id", alice)
+	for rows.Next() {
+		var id int64
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if code := call(t, DeleteNotificationHandler, alice, "/api/notifications/delete", map[string]int64{"id": ids[0]}, nil); code != http.StatusOK {
+		t.Fatalf("delete = %d", code)
+	}
+	// every change pushes the new count as a snapshot
+	for timeout := time.After(time.Second); ; {
+		select {
+		case m := <-bus.NotificationChan:
+			if !m.Snapshot || m.RecipientID != alice || string(m.Payload) != `{"count":2,"type":"unread_count"}` {
+				continue
+			}
+		case <-timeout:
+			t.Error("deleting pushed no unread count of 2")
+		}
+		break
+	}
+	if code := call(t, DeleteNotificationHandler, bob, "/api/notifications/delete", map[string]int64{"id": ids[1]}, nil); code != http.StatusNotFound {
+		t.Errorf("deleting someone else's notification = %d, want 404", code)
+	}
+
+	call(t, MarkNotificationsReadHandler, alice, "/api/notifications/mark-read", map[string]int64{"id": ids[1]}, nil)
+	if n := unread(); n != 1 {
+		t.Errorf("unread after reading one = %d, want 1", n)
+	}
+	var cleared struct {
+		Deleted int64 `json:"deleted"`
+	}
+	if code := call(t, ClearNotificationsHandler, alice, "/api/notifications/clear", map[string]bool{"read_only": true}, &cleared); code != http.StatusOK || cleared.Deleted != 1 {
+		t.Errorf("clearing read = %d, deleted %d, want 1", code, cleared.Deleted)
+	}
+	if code := call(t, ClearNotificationsHandler, alice, "/api/notifications/clear", map[string]bool{}, &cleared); code != http.StatusOK || cleared.Deleted != 1 {
+		t.Errorf("clearing all = %d, deleted %d, want 1", code, cleared.Deleted)
+	}
+	var actors int
+	db.DB.QueryRow("SELECT COUNT(*) FROM notification_actors").Scan(&actors)
+	if n := unread(); n != 0 || actors != 0 {
+		t.Errorf("after clearing: unread %d, %d actor rows, want none", n, actors)
+	}
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"social-network/backend/db"
+	"social-network/backend/utils"
+)
+
+// A private post is seen by its author and its audience: followers of the
+// author picked when posting, kept in post_audience. Unfollowing the author
+// takes a user out of the audiences of their posts.
+
+var errNotPrivate = errors.New("audience on a post that is not private")
+
+// notFollowersError lists picked users who do not follow the author.
+type notFollowersError []int64
+
+func (e notFollowersError) Error() string {
+	ids := make([]string, len(e))
+	for i, id := range e {
+		ids[i] = strconv.FormatInt(id, 10)
+	}
+	return "not followers: " + strings.Join(ids, ", ")
+}
+
+// checkAudience returns ids sorted and without duplicates or the author,
+// or a notFollowersError if any of them does not follow authorID.
+func checkAudience(authorID int64, ids []int64) ([]int64, error) {
+	audience := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if id != authorID {
+			audience = append(audience, id)
+		}
+	}
+	slices.Sort(audience)
+	audience = slices.Compact(audience)
+	if len(audience) == 0 {
+		return audience, nil
+	}
+
+	args := []interface{}{authorID}
+	for _, id := range audience {
+		args = append(args, id)
+	}
+	rows, err := db.DB.Query("SELECT follower_id FROM followers WHERE followed_id = ? AND follower_id IN (?"+strings.Repeat(", ?", len(audience)-1)+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	followers := map[int64]bool{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		followers[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var missing notFollowersError
+	for _, id := range audience {
+		if !followers[id] {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, missing
+	}
+	return audience, nil
+}
+
+// audienceError answers a request whose audience checkAudience rejected.
+func audienceError(w http.ResponseWriter, err error) {
+	var missing notFollowersError
+	if errors.As(err, &missing) {
+		utils.JSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":    fmt.Sprintf("Only your followers can be in the audience (%d selected users are not)", len(missing)),
+			"user_ids": []int64(missing),
+		})
+		return
+	}
+	utils.Error(w, http.StatusInternalServerError, "Database error")
+}
+
+// joinAudience and splitAudience convert an audience to and from the form
+// postVersion and post_edits keep it in.
+func joinAudience(ids []int64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+func splitAudience(s string) []int64 {
+	var ids []int64
+	for _, part := range strings.Split(s, ",") {
+		if id, err := strconv.ParseInt(part, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func loadAudience(tx *sql.Tx, postID int64) ([]int64, error) {
+	rows, err := tx.Query("SELECT user_id FROM post_audience WHERE post_id = ? ORDER BY user_id", postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// setAudience replaces the audience of postID.
+func setAudience(tx *sql.Tx, postID int64, ids []int64) error {
+	if _, err := tx.Exec("DELETE FROM post_audience WHERE post_id = ?", postID); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, err := tx.Exec("INSERT INTO post_audience (post_id, user_id) VALUES (?, ?)", postID, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PostAudienceHandler - GET/POST /api/posts/audience
+// GET ?id=<post id> returns the privacy and audience of one of the caller's
+// posts. POST { id, audience: [user ids] } replaces the audience of a
+// private post, keeping the change in its edit history, and returns the
+// new one.
+func PostAudienceHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var id int64
+	if r.Method == http.MethodPost {
+		var payload struct {
+			ID       int64   `json:"id"`
+			Audience []int64 `json:"audience"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.ID <= 0 {
+			utils.Error(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		audience, err := checkAudience(userID, payload.Audience)
+		if err != nil {
+			audienceError(w, err)
+			return
+		}
+		allowed := joinAudience(audience)
+		if _, _, err := editPost("post", payload.ID, userID, postVersion{Allowed: &allowed}); err != nil {
+			finishEdit(w, postVersion{}, postVersion{}, false, err)
+			return
+		}
+		id = payload.ID
+	} else if r.Method == http.MethodGet {
+		id, _ = strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	} else {
+		utils.Error(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var authorID int64
+	var privacy string
+	if err := db.DB.QueryRow("SELECT author_id, privacy FROM posts WHERE id = ?", id).Scan(&authorID, &privacy); err != nil {
+		utils.Error(w, http.StatusNotFound, "Post not found")
+		return
+	}
+	if authorID != userID {
+		utils.Error(w, http.StatusForbidden, "Only the author can see the audience")
+		return
+	}
+
+	rows, err := db.DB.Query(`SELECT u.id, u.nickname, IFNULL(u.avatar, '') FROM post_audience a
+		JOIN users u ON u.id = a.user_id
+		WHERE a.post_id = ? ORDER BY u.nickname`, id)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer rows.Close()
+	type member struct {
+		ID       int64  `json:"id"`
+		Nickname string `json:"nickname"`
+		Avatar   string `json:"avatar"`
+	}
+	audience := []member{}
+	for rows.Next() {
+		var m member
+		if err := rows.Scan(&m.ID, &m.Nickname, &m.Avatar); err != nil {
+			utils.Error(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		m.Avatar = normalizeURL(m.Avatar)
+		audience = append(audience, m)
+	}
+	utils.JSON(w, http.StatusOK, map[string]interface{}{"id": id, "privacy": privacy, "audience": audience})
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+type audienceView struct {
+	Privacy  string `json:"privacy"`
+	Audience []struct {
+		ID int64 `json:"id"`
+	} `json:"audience"`
+	UserIDs []int64 `json:"user_ids"`
+}
+
+func (v audienceView) ids() []int64 {
+	ids := make([]int64, len(v.Audience))
+	for i, m := range v.Audience {
+		ids[i] = m.ID
+	}
+	return ids
+}
+
+func TestPostAudience(t *testing.T) {
+	openTestDB(t)
+	alice, bob, carol, dave := createTestUser(t, "alice"), createTestUser(t, "bob"), createTestUser(t, "carol"), createTestUser(t, "dave")
+	follow(t, bob, alice)
+	follow(t, carol, alice)
+
+	// only followers can be picked, and only for private posts
+	var rejected audienceView
+	body := map[string]interface{}{"content": "hi", "privacy": "private", "audience": []int64{bob, dave}}
+	if code := call(t, CreatePostHandler, alice, "/api/posts/create", body, &rejected); code != http.StatusBadRequest || !slices.Equal(rejected.UserIDs, []int64{dave}) {
+		t.Errorf("audience with a non-follower = %d %+v, want 400 naming dave", code, rejected)
+	}
+	body = map[string]interface{}{"content": "hi", "privacy": "public", "audience": []int64{bob}}
+	if code := call(t, CreatePostHandler, alice, "/api/posts/create", body, nil); code != http.StatusBadRequest {
+		t.Errorf("public post with an audience = %d, want 400", code)
+	}
+	post := createTestPost(t, alice, "private", bob, bob, alice)
+	audienceURL := "/api/posts/audience?id=" + strconv.FormatInt(post, 10)
+
+	var v audienceView
+	if code := call(t, PostAudienceHandler, alice, audienceURL, nil, &v); code != http.StatusOK || v.Privacy != "private" || !slices.Equal(v.ids(), []int64{bob}) {
+		t.Errorf("audience = %d %+v, want bob only", code, v)
+	}
+	if code := call(t, PostAudienceHandler, bob, audienceURL, nil, nil); code != http.StatusForbidden {
+		t.Errorf("audience seen by someone else = %d, want 403", code)
+	}
+	if code := call(t, PostAudienceHandler, alice, "/api/posts/audience", map[string]interface{}{"id": post, "audience": []int64{bob, carol}}, &v); code != http.StatusOK || !slices.Equal(v.ids(), []int64{bob, carol}) {
+		t.Errorf("replacing the audience = %d %+v", code, v)
+	}
+	if got, _ := feedIDs(t, carol, ""); !slices.Equal(got, []int64{post}) {
+		t.Errorf("carol, now in the audience, sees %v", got)
+	}
+	if code := call(t, PostAudienceHandler, alice, "/api/posts/audience", map[string]interface{}{"id": post, "audience": []int64{dave}}, nil); code != http.StatusBadRequest {
+		t.Errorf("adding a non-follower = %d, want 400", code)
+	}
+
+	// unfollowing leaves the audience
+	call(t, UnfollowHandler, carol, "/api/unfollow", map[string]int64{"target_id": alice}, nil)
+	if call(t, PostAudienceHandler, alice, audienceURL, nil, &v); !slices.Equal(v.ids(), []int64{bob}) {
+		t.Errorf("audience after carol unfollowed = %v", v.ids())
+	}
+
+	// an audience only applies while the post is private
+	public := createTestPost(t, alice, "public")
+	if code := call(t, PostAudienceHandler, alice, "/api/posts/audience", map[string]interface{}{"id": public, "audience": []int64{bob}}, nil); code != http.StatusBadRequest {
+		t.Errorf("audience on a public post = %d, want 400", code)
+	}
+	call(t, UpdatePostHandler, alice, "/api/posts/update", map[string]interface{}{"id": post, "privacy": "followers"}, nil)
+	if call(t, PostAudienceHandler, alice, audienceURL, nil, &v); v.Privacy != "followers" || len(v.Audience) != 0 {
+		t.Errorf("after going followers-only the post has %+v", v)
+	}
+
+	if code := call(t, DeletePostHandler, alice, "/api/posts/delete", map[string]int64{"id": post}, nil); code != http.StatusOK {
+		t.Fatalf("delete = %d", code)
+	}
+	if n := countRows(t, "SELECT COUNT(*) FROM post_audience"); n != 0 {
+		t.Errorf("%d audience rows left", n)
+	}
+}
@@ -0,0 +1,556 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"social-network/backend/db"
+	"social-network/backend/utils"
+)
+
+// Posts and comments, personal and in groups, are edited and deleted by
+// their author; comments may also be deleted by the author of the post they
+// are on. Every edit keeps the replaced version in post_edits, images
+// included; images that neither a post or comment nor its history shows any
+// more are removed from backend/uploads.
+
+// editKind describes the table behind a post_edits kind.
+type editKind struct {
+	table    string
+	author   string // author column
+	image    bool   // has image_url
+	privacy  bool   // has privacy and an audience in post_audience
+	parent   string // for comments, the posts table and
+	parentFK string // the column pointing into it
+}
+
+var editKinds = map[string]editKind{
+	"post":          {table: "posts", author: "author_id", image: true, privacy: true},
+	"comment":       {table: "comments", author: "user_id", image: true, parent: "posts", parentFK: "post_id"},
+	"group_post":    {table: "group_posts", author: "author_id", image: true},
+	"group_comment": {table: "group_comments", author: "user_id", parent: "group_posts", parentFK: "post_id"},
+}
+
+var errNotAuthor = errors.New("not the author")
+
+// postVersion is the editable part of a post or comment; nil fields are
+// left unchanged by editPost, or do not apply to the kind. Allowed is a
+// private post's audience, as its ids in order joined by commas.
+type postVersion struct {
+	Content  *string `json:"content"`
+	ImageURL *string `json:"image_url,omitempty"`
+	Privacy  *string `json:"privacy,omitempty"`
+	Allowed  *string `json:"allowed_user_ids,omitempty"`
+}
+
+func (k editKind) columns() string {
+	cols := "content"
+	if k.image {
+		cols += ", image_url"
+	}
+	if k.privacy {
+		cols += ", privacy"
+	}
+	return cols
+}
+
+func (k editKind) fields(v *postVersion) []interface{} {
+	fields := []interface{}{&v.Content}
+	if k.image {
+		fields = append(fields, &v.ImageURL)
+	}
+	if k.privacy {
+		fields = append(fields, &v.Privacy)
+	}
+	return fields
+}
+
+// editPost applies the non-nil fields of change to the row id of kind, if
+// editorID wrote it, and records the version it replaces. It returns that
+// version and whether anything changed.
+func editPost(kind string, id, editorID int64, change postVersion) (postVersion, bool, error) {
+	k := editKinds[kind]
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return postVersion{}, false, err
+	}
+	defer tx.Rollback()
+
+	var prev postVersion
+	var authorID int64
+	err = tx.QueryRow("SELECT "+k.author+", "+k.columns()+" FROM "+k.table+" WHERE id = ?", id).
+		Scan(append([]interface{}{&authorID}, k.fields(&prev)...)...)
+	if err != nil {
+		return prev, false, err
+	}
+	if authorID != editorID {
+		return prev, false, errNotAuthor
+	}
+	if k.privacy {
+		audience, err := loadAudience(tx, id)
+		if err != nil {
+			return prev, false, err
+		}
+		allowed := joinAudience(audience)
+		prev.Allowed = &allowed
+	}
+
+	next := prev
+	changed := false
+	apply := func(dst **string, src *string) {
+		if src != nil && (*dst == nil || **dst != *src) {
+			*dst, changed = src, true
+		}
+	}
+	apply(&next.Content, change.Content)
+	if k.image {
+		apply(&next.ImageURL, change.ImageURL)
+	}
+	if k.privacy {
+		apply(&next.Privacy, change.Privacy)
+		apply(&next.Allowed, change.Allowed)
+	}
+	if !changed {
+		return prev, false, nil
+	}
+	if k.privacy && *next.Privacy != "private" && *next.Allowed != "" {
+		return prev, false, errNotPrivate
+	}
+
+	_, err = tx.Exec("INSERT INTO post_edits (kind, target_id, editor_id, content, image_url, privacy, allowed_user_ids) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		kind, id, editorID, prev.Content, prev.ImageURL, prev.Privacy, prev.Allowed)
+	if err != nil {
+		return prev, false, err
+	}
+	set := strings.ReplaceAll(k.columns(), ",", " = ?,") + " = ?"
+	_, err = tx.Exec("UPDATE "+k.table+" SET "+set+", edited_at = CURRENT_TIMESTAMP WHERE id = ?",
+		append(k.fields(&next), id)...)
+	if err != nil {
+		return prev, false, err
+	}
+	if k.privacy && *next.Allowed != *prev.Allowed {
+		if err := setAudience(tx, id, splitAudience(*next.Allowed)); err != nil {
+			return prev, false, err
+		}
+	}
+	return prev, true, tx.Commit()
+}
+
+// finishEdit answers an edit request and removes an image the edit replaced.
+func finishEdit(w http.ResponseWriter, prev, change postVersion, changed bool, err error) {
+	switch {
+	case err == sql.ErrNoRows:
+		utils.Error(w, http.StatusNotFound, "Not found")
+		return
+	case err == errNotAuthor:
+		utils.Error(w, http.StatusForbidden, "Only the author can edit this")
+		return
+	case err == errNotPrivate:
+		utils.Error(w, http.StatusBadRequest, "Only private posts have an audience")
+		return
+	case err != nil:
+		utils.Error(w, http.StatusInternalServerError, "Failed to save changes")
+		return
+	}
+	if !changed {
+		utils.JSON(w, http.StatusOK, map[string]string{"status": "unchanged"})
+		return
+	}
+	if change.ImageURL != nil && prev.ImageURL != nil && *prev.ImageURL != *change.ImageURL {
+		removeUpload(*prev.ImageURL)
+	}
+	utils.JSON(w, http.StatusOK, map[string]string{"status": "updated"})
+}
+
+// UpdatePostHandler - POST /api/posts/update { id, content?, image_url?, privacy?, audience? }
+// Omitted fields keep their value; image_url "" removes the image. audience
+// (follower ids) replaces a private post's audience; it is cleared when the
+// post stops being private.
+func UpdatePostHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var payload struct {
+		ID       int64   `json:"id"`
+		Content  *string `json:"content"`
+		ImageURL *string `json:"image_url"`
+		Privacy  *string `json:"privacy"`
+		Audience []int64 `json:"audience"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.ID <= 0 {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if payload.Content != nil && strings.TrimSpace(*payload.Content) == "" {
+		utils.Error(w, http.StatusBadRequest, "Post content cannot be empty")
+		return
+	}
+	change := postVersion{Content: payload.Content, Privacy: payload.Privacy}
+	if payload.Audience != nil {
+		audience, err := checkAudience(userID, payload.Audience)
+		if err != nil {
+			audienceError(w, err)
+			return
+		}
+		allowed := joinAudience(audience)
+		change.Allowed = &allowed
+	}
+	if payload.Privacy != nil {
+		switch *payload.Privacy {
+		case "public", "followers":
+			if change.Allowed == nil {
+				none := ""
+				change.Allowed = &none
+			}
+		case "private":
+		default:
+			utils.Error(w, http.StatusBadRequest, "Invalid privacy")
+			return
+		}
+	}
+	if payload.ImageURL != nil {
+		image := normalizeURL(*payload.ImageURL)
+		change.ImageURL = &image
+	}
+	prev, changed, err := editPost("post", payload.ID, userID, change)
+	finishEdit(w, prev, change, changed, err)
+}
+
+// UpdateCommentHandler - POST /api/posts/comment/update { id, content?, image_url? }
+func UpdateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	updateCommentHandler(w, r, "comment")
+}
+
+// UpdateGroupCommentHandler - POST /api/group/comment/update { id, content }
+func UpdateGroupCommentHandler(w http.ResponseWriter, r *http.Request) {
+	updateCommentHandler(w, r, "group_comment")
+}
+
+func updateCommentHandler(w http.ResponseWriter, r *http.Request, kind string) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var payload struct {
+		ID       int64   `json:"id"`
+		Content  *string `json:"content"`
+		ImageURL *string `json:"image_url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.ID <= 0 {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if payload.Content != nil && strings.TrimSpace(*payload.Content) == "" {
+		utils.Error(w, http.StatusBadRequest, "Comment cannot be empty")
+		return
+	}
+	change := postVersion{Content: payload.Content}
+	if payload.ImageURL != nil && editKinds[kind].image {
+		image := normalizeURL(*payload.ImageURL)
+		change.ImageURL = &image
+	}
+	prev, changed, err := editPost(kind, payload.ID, userID, change)
+	finishEdit(w, prev, change, changed, err)
+}
+
+// UpdateGroupPostHandler - POST /api/group/post/update multipart/form with
+// id, and content, image (a new file) or remove_image=1 to change.
+func UpdateGroupPostHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		utils.Error(w, http.StatusBadRequest, "Could not parse multipart form")
+		return
+	}
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if id <= 0 {
+		utils.Error(w, http.StatusBadRequest, "Missing id")
+		return
+	}
+	var change postVersion
+	if values, ok := r.MultipartForm.Value["content"]; ok && len(values) > 0 {
+		change.Content = &values[0]
+	}
+	// check authorship before storing a new image
+	var authorID int64
+	if err := db.DB.QueryRow("SELECT author_id FROM group_posts WHERE id = ?", id).Scan(&authorID); err != nil {
+		utils.Error(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if authorID != userID {
+		utils.Error(w, http.StatusForbidden, "Only the author can edit this")
+		return
+	}
+	if r.FormValue("remove_image") == "1" {
+		none := ""
+		change.ImageURL = &none
+	}
+	file, fh, err := r.FormFile("image")
+	if err == nil {
+		defer file.Close()
+		image, err := savePostImage(userID, file, fh.Filename)
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "Could not save image")
+			return
+		}
+		change.ImageURL = &image
+	}
+	prev, changed, err := editPost("group_post", id, userID, change)
+	if (err != nil || !changed) && file != nil {
+		removeUpload(*change.ImageURL)
+	}
+	finishEdit(w, prev, change, changed, err)
+}
+
+// DeletePostHandler - POST /api/posts/delete { id }
+// Deletes the caller's post with its comments and edit history.
+func DeletePostHandler(w http.ResponseWriter, r *http.Request) {
+	deletePostHandler(w, r, "post", "comment")
+}
+
+// DeleteGroupPostHandler - POST /api/group/post/delete { id }
+func DeleteGroupPostHandler(w http.ResponseWriter, r *http.Request) {
+	deletePostHandler(w, r, "group_post", "group_comment")
+}
+
+func deletePostHandler(w http.ResponseWriter, r *http.Request, kind, commentKind string) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var payload struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.ID <= 0 {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	k, ck := editKinds[kind], editKinds[commentKind]
+
+	var authorID int64
+	var image sql.NullString
+	if err := db.DB.QueryRow("SELECT author_id, image_url FROM "+k.table+" WHERE id = ?", payload.ID).Scan(&authorID, &image); err != nil {
+		utils.Error(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if authorID != userID {
+		utils.Error(w, http.StatusForbidden, "Only the author can delete this")
+		return
+	}
+	images := []string{image.String}
+	if ck.image {
+		images = append(images, imageURLs("SELECT image_url FROM "+ck.table+" WHERE post_id = ?", payload.ID)...)
+	}
+	images = append(images, imageURLs(`SELECT image_url FROM post_edits
+		WHERE kind = ? AND target_id = ? OR kind = ? AND target_id IN (SELECT id FROM `+ck.table+` WHERE post_id = ?)`,
+		kind, payload.ID, commentKind, payload.ID)...)
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer tx.Rollback()
+	type stmt struct {
+		query string
+		args  []interface{}
+	}
+	stmts := []stmt{
+		{"DELETE FROM post_edits WHERE kind = ? AND target_id IN (SELECT id FROM " + ck.table + " WHERE post_id = ?)", []interface{}{commentKind, payload.ID}},
+		{"DELETE FROM post_edits WHERE kind = ? AND target_id = ?", []interface{}{kind, payload.ID}},
+		{"DELETE FROM " + ck.table + " WHERE post_id = ?", []interface{}{payload.ID}},
+		{"DELETE FROM " + k.table + " WHERE id = ?", []interface{}{payload.ID}},
+	}
+	if k.privacy {
+		stmts = append(stmts, stmt{"DELETE FROM post_audience WHERE post_id = ?", []interface{}{payload.ID}})
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt.query, stmt.args...); err != nil {
+			utils.Error(w, http.StatusInternalServerError, "Failed to delete post")
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to delete post")
+		return
+	}
+	for _, url := range images {
+		removeUpload(url)
+	}
+	utils.JSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// DeleteCommentHandler - POST /api/posts/comment/delete { id }
+// The comment's author or the post's author may delete it.
+func DeleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	deleteCommentHandler(w, r, "comment")
+}
+
+// DeleteGroupCommentHandler - POST /api/group/comment/delete { id }
+func DeleteGroupCommentHandler(w http.ResponseWriter, r *http.Request) {
+	deleteCommentHandler(w, r, "group_comment")
+}
+
+func deleteCommentHandler(w http.ResponseWriter, r *http.Request, kind string) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var payload struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.ID <= 0 {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	k := editKinds[kind]
+	image := "''"
+	if k.image {
+		image = "IFNULL(c.image_url, '')"
+	}
+	var commenterID, postAuthorID int64
+	var imageURL string
+	err = db.DB.QueryRow("SELECT c.user_id, IFNULL(p.author_id, 0), "+image+" FROM "+k.table+" c LEFT JOIN "+k.parent+" p ON p.id = c."+k.parentFK+" WHERE c.id = ?",
+		payload.ID).Scan(&commenterID, &postAuthorID, &imageURL)
+	if err != nil {
+		utils.Error(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if userID != commenterID && userID != postAuthorID {
+		utils.Error(w, http.StatusForbidden, "Only the comment's or the post's author can delete this")
+		return
+	}
+	images := append(imageURLs("SELECT image_url FROM post_edits WHERE kind = ? AND target_id = ?", kind, payload.ID), imageURL)
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM post_edits WHERE kind = ? AND target_id = ?", kind, payload.ID); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to delete comment")
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM "+k.table+" WHERE id = ?", payload.ID); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to delete comment")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to delete comment")
+		return
+	}
+	for _, url := range images {
+		removeUpload(url)
+	}
+	utils.JSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// imageURLs returns the non-empty image URLs a query selects.
+func imageURLs(query string, args ...interface{}) []string {
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var urls []string
+	for rows.Next() {
+		var url sql.NullString
+		if rows.Scan(&url) == nil && url.String != "" {
+			urls = append(urls, url.String)
+		}
+	}
+	return urls
+}
+
+// canSeeEdits reports whether viewerID may see the post or comment id of
+// kind, and so its history.
+func canSeeEdits(kind string, id, viewerID int64) bool {
+	var query string
+	switch kind {
+	case "post":
+		query = "SELECT EXISTS(SELECT 1 FROM posts p WHERE p.id = ? AND " + postVisibleSQL + ")"
+	case "comment":
+		query = "SELECT EXISTS(SELECT 1 FROM comments c JOIN posts p ON p.id = c.post_id WHERE c.id = ? AND " + postVisibleSQL + ")"
+	case "group_post":
+		query = "SELECT EXISTS(SELECT 1 FROM group_posts p JOIN group_members m ON m.group_id = p.group_id WHERE p.id = ? AND m.user_id = ?)"
+	case "group_comment":
+		query = `SELECT EXISTS(SELECT 1 FROM group_comments c JOIN group_posts p ON p.id = c.post_id
+			JOIN group_members m ON m.group_id = p.group_id WHERE c.id = ? AND m.user_id = ?)`
+	}
+	args := []interface{}{id, viewerID}
+	if kind == "post" || kind == "comment" {
+		args = append(args, viewerID, viewerID)
+	}
+	var ok bool
+	db.DB.QueryRow(query, args...).Scan(&ok)
+	return ok
+}
+
+type postEdit struct {
+	postVersion
+	EditorID   int64  `json:"editor_id"`
+	ReplacedAt string `json:"replaced_at"`
+}
+
+// PostHistoryHandler - GET /api/posts/history?kind=<post|comment|group_post|group_comment>&id=<id>
+// The earlier versions of a post or comment, newest first; replaced_at is
+// when the edit that replaced the version was made. Only the author of a
+// post sees the privacy and audience of its earlier versions.
+func PostHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	kind := r.URL.Query().Get("kind")
+	if kind == "" {
+		kind = "post"
+	}
+	id, _ := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if _, ok := editKinds[kind]; !ok || id <= 0 {
+		utils.Error(w, http.StatusBadRequest, "Invalid kind or id")
+		return
+	}
+	if !canSeeEdits(kind, id, userID) {
+		utils.Error(w, http.StatusNotFound, "Not found")
+		return
+	}
+	k := editKinds[kind]
+	var authorID int64
+	if k.privacy {
+		db.DB.QueryRow("SELECT "+k.author+" FROM "+k.table+" WHERE id = ?", id).Scan(&authorID)
+	}
+	rows, err := db.DB.Query(`SELECT editor_id, content, image_url, privacy, allowed_user_ids, created_at FROM post_edits
+		WHERE kind = ? AND target_id = ? ORDER BY id DESC`, kind, id)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer rows.Close()
+	edits := []postEdit{}
+	for rows.Next() {
+		var e postEdit
+		if err := rows.Scan(&e.EditorID, &e.Content, &e.ImageURL, &e.Privacy, &e.Allowed, &e.ReplacedAt); err != nil {
+			utils.Error(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if authorID != userID {
+			e.Privacy, e.Allowed = nil, nil
+		}
+		edits = append(edits, e)
+	}
+	utils.JSON(w, http.StatusOK, map[string]interface{}{"kind": kind, "id": id, "edits": edits})
+}
@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"social-network/backend/db"
+	"social-network/backend/utils"
+)
+
+type postHistory struct {
+	Edits []struct {
+		EditorID int64   `json:"editor_id"`
+		Content  *string `json:"content"`
+		ImageURL *string `json:"image_url"`
+		Privacy  *string `json:"privacy"`
+	} `json:"edits"`
+}
+
+// insertTestRow adds a row and returns its id.
+func insertTestRow(t *testing.T, query string, args ...interface{}) int64 {
+	t.Helper()
+	res, err := db.DB.Exec(query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
+
+func countRows(t *testing.T, query string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := db.DB.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestEditPost(t *testing.T) {
+	openTestDB(t)
+	alice, bob, carol := createTestUser(t, "alice"), createTestUser(t, "bob"), createTestUser(t, "carol")
+	if code := call(t, CreatePostHandler, alice, "/api/posts/create", map[string]string{"content": "hi"}, nil); code != http.StatusCreated {
+		t.Fatalf("create = %d", code)
+	}
+
+	for _, tc := range []struct {
+		userID int64
+		body   map[string]interface{}
+		want   int
+	}{
+		{bob, map[string]interface{}{"id": 1, "content": "mine now"}, http.StatusForbidden},
+		{alice, map[string]interface{}{"id": 99, "content": "x"}, http.StatusNotFound},
+		{alice, map[string]interface{}{"id": 1, "content": "  "}, http.StatusBadRequest},
+		{alice, map[string]interface{}{"id": 1, "privacy": "secret"}, http.StatusBadRequest},
+		{alice, map[string]interface{}{"content": "x"}, http.StatusBadRequest},
+	} {
+		if code := call(t, UpdatePostHandler, tc.userID, "/api/posts/update", tc.body, nil); code != tc.want {
+			t.Errorf("update %v as %d = %d, want %d", tc.body, tc.userID, code, tc.want)
+		}
+	}
+
+	var status map[string]string
+	call(t, UpdatePostHandler, alice, "/api/posts/update", map[string]interface{}{"id": 1, "content": "hi again"}, &status)
+	if status["status"] != "updated" {
+		t.Errorf("editing the content = %v", status)
+	}
+	call(t, UpdatePostHandler, alice, "/api/posts/update", map[string]interface{}{"id": 1, "content": "hi again"}, &status)
+	if status["status"] != "unchanged" {
+		t.Errorf("saving the same content = %v, want unchanged", status)
+	}
+	follow(t, bob, alice)
+	call(t, UpdatePostHandler, alice, "/api/posts/update", map[string]interface{}{"id": 1, "privacy": "private", "audience": []int64{bob}}, nil)
+	if n := countRows(t, "SELECT COUNT(*) FROM posts WHERE id = 1 AND edited_at IS NOT NULL AND privacy = 'private'"); n != 1 {
+		t.Error("the post is not marked edited and private")
+	}
+
+	var h postHistory
+	if code := call(t, PostHistoryHandler, alice, "/api/posts/history?kind=post&id=1", nil, &h); code != http.StatusOK || len(h.Edits) != 2 {
+		t.Fatalf("history = %d %+v, want two earlier versions", code, h)
+	}
+	if e := h.Edits[0]; *e.Content != "hi again" || *e.Privacy != "public" || e.EditorID != alice {
+		t.Errorf("newest earlier version = %+v, want public \"hi again\"", e)
+	}
+	if e := h.Edits[1]; *e.Content != "hi" {
+		t.Errorf("oldest version = %+v, want \"hi\"", e)
+	}
+	if code := call(t, PostHistoryHandler, carol, "/api/posts/history?kind=post&id=1", nil, nil); code != http.StatusNotFound {
+		t.Errorf("history of a post carol cannot see = %d, want 404", code)
+	}
+	if code := call(t, PostHistoryHandler, alice, "/api/posts/history?kind=poll&id=1", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("unknown kind = %d, want 400", code)
+	}
+}
+
+// updateGroupPost posts a multipart group post edit as userID.
+func updateGroupPost(t *testing.T, userID int64, fields map[string]string) int {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/api/group/post/update", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r = r.WithContext(context.WithValue(r.Context(), utils.UserIDKey, strconv.FormatInt(userID, 10)))
+	w := httptest.NewRecorder()
+	UpdateGroupPostHandler(w, r)
+	return w.Code
+}
+
+func TestEditCommentsAndGroupPosts(t *testing.T) {
+	openTestDB(t)
+	alice, bob, carol := createTestUser(t, "alice"), createTestUser(t, "bob"), createTestUser(t, "carol")
+	post := insertTestRow(t, "INSERT INTO posts (author_id, content) VALUES (?, 'post')", alice)
+	comment := insertTestRow(t, "INSERT INTO comments (post_id, user_id, content) VALUES (?, ?, 'nice')", post, bob)
+
+	if code := call(t, UpdateCommentHandler, alice, "/api/posts/comment/update", map[string]interface{}{"id": comment, "content": "rude"}, nil); code != http.StatusForbidden {
+		t.Errorf("editing someone else's comment = %d, want 403", code)
+	}
+	if code := call(t, UpdateCommentHandler, bob, "/api/posts/comment/update", map[string]interface{}{"id": comment, "content": "very nice"}, nil); code != http.StatusOK {
+		t.Errorf("editing own comment = %d", code)
+	}
+	var h postHistory
+	if call(t, PostHistoryHandler, carol, "/api/posts/history?kind=comment&id="+strconv.FormatInt(comment, 10), nil, &h); len(h.Edits) != 1 || *h.Edits[0].Content != "nice" {
+		t.Errorf("comment history on a public post = %+v", h)
+	}
+
+	group := insertTestRow(t, "INSERT INTO groups (owner_id, name) VALUES (?, 'g')", alice)
+	db.DB.Exec("INSERT INTO group_members (group_id, user_id) VALUES (?, ?), (?, ?)", group, alice, group, bob)
+	groupPost := insertTestRow(t, "INSERT INTO group_posts (group_id, author_id, content) VALUES (?, ?, 'hello group')", group, alice)
+	groupComment := insertTestRow(t, "INSERT INTO group_comments (post_id, user_id, content) VALUES (?, ?, 'hey')", groupPost, bob)
+
+	id := strconv.FormatInt(groupPost, 10)
+	if code := updateGroupPost(t, bob, map[string]string{"id": id, "content": "hijacked"}); code != http.StatusForbidden {
+		t.Errorf("editing someone else's group post = %d, want 403", code)
+	}
+	if code := updateGroupPost(t, alice, map[string]string{"id": id, "content": "hello everyone"}); code != http.StatusOK {
+		t.Errorf("editing own group post = %d", code)
+	}
+	if code := call(t, UpdateGroupCommentHandler, bob, "/api/group/comment/update", map[string]interface{}{"id": groupComment, "content": "hey all"}, nil); code != http.StatusOK {
+		t.Errorf("editing own group comment = %d", code)
+	}
+	for kind, target := range map[string]int64{"group_post": groupPost, "group_comment": groupComment} {
+		url := "/api/posts/history?kind=" + kind + "&id=" + strconv.FormatInt(target, 10)
+		if code := call(t, PostHistoryHandler, bob, url, nil, &h); code != http.StatusOK || len(h.Edits) != 1 {
+			t.Errorf("%s history for a member = %d %+v", kind, code, h)
+		}
+		if code := call(t, PostHistoryHandler, carol, url, nil, nil); code != http.StatusNotFound {
+			t.Errorf("%s history for a non-member = %d, want 404", kind, code)
+		}
+	}
+}
+
+func TestDeletePostsAndComments(t *testing.T) {
+	openTestDB(t)
+	alice, bob, carol := createTestUser(t, "alice"), createTestUser(t, "bob"), createTestUser(t, "carol")
+	dir := t.TempDir()
+	t.Chdir(dir)
+	uploads := filepath.Join(dir, "backend", "uploads", "posts")
+	os.MkdirAll(uploads, 0o755)
+	for _, name := range []string{"post.jpg", "comment.jpg"} {
+		os.WriteFile(filepath.Join(uploads, name), []byte("jpg"), 0o644)
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(uploads, name))
+		return err == nil
+	}
+
+	post := insertTestRow(t, "INSERT INTO posts (author_id, content, image_url) VALUES (?, 'post', '/uploads/posts/post.jpg')", alice)
+	kept := insertTestRow(t, "INSERT INTO comments (post_id, user_id, content, image_url) VALUES (?, ?, 'pic', '/uploads/posts/comment.jpg')", post, bob)
+	first := insertTestRow(t, "INSERT INTO comments (post_id, user_id, content) VALUES (?, ?, 'one')", post, bob)
+	second := insertTestRow(t, "INSERT INTO comments (post_id, user_id, content) VALUES (?, ?, 'two')", post, bob)
+	call(t, UpdateCommentHandler, bob, "/api/posts/comment/update", map[string]interface{}{"id": kept, "content": "picture"}, nil)
+
+	// comments go by their author or the post's author
+	if code := call(t, DeleteCommentHandler, carol, "/api/posts/comment/delete", map[string]int64{"id": first}, nil); code != http.StatusForbidden {
+		t.Errorf("a stranger deleting a comment = %d, want 403", code)
+	}
+	if code := call(t, DeleteCommentHandler, bob, "/api/posts/comment/delete", map[string]int64{"id": first}, nil); code != http.StatusOK {
+		t.Errorf("deleting own comment = %d", code)
+	}
+	if code := call(t, DeleteCommentHandler, alice, "/api/posts/comment/delete", map[string]int64{"id": second}, nil); code != http.StatusOK {
+		t.Errorf("the post's author deleting a comment = %d", code)
+	}
+	if code := call(t, DeleteCommentHandler, alice, "/api/posts/comment/delete", map[string]int64{"id": second}, nil); code != http.StatusNotFound {
+		t.Errorf("deleting a deleted comment = %d, want 404", code)
+	}
+
+	// posts only by their author, with their comments, history and images
+	if code := call(t, DeletePostHandler, bob, "/api/posts/delete", map[string]int64{"id": post}, nil); code != http.StatusForbidden {
+		t.Errorf("deleting someone else's post = %d, want 403", code)
+	}
+	if code := call(t, DeletePostHandler, alice, "/api/posts/delete", map[string]int64{"id": post}, nil); code != http.StatusOK {
+		t.Fatalf("deleting own post = %d", code)
+	}
+	if n := countRows(t, "SELECT COUNT(*) FROM comments WHERE post_id = ?", post); n != 0 {
+		t.Errorf("%d comments left on a deleted post", n)
+	}
+	if n := countRows(t, "SELECT COUNT(*) FROM post_edits"); n != 0 {
+		t.Errorf("%d edits left of a deleted post", n)
+	}
+	if exists("post.jpg") || exists("comment.jpg") {
+		t.Error("images of a deleted post and its comments were kept")
+	}
+
+	group := insertTestRow(t, "INSERT INTO groups (owner_id, name) VALUES (?, 'g')", alice)
+	groupPost := insertTestRow(t, "INSERT INTO group_posts (group_id, author_id, content) VALUES (?, ?, 'hello')", group, alice)
+	insertTestRow(t, "INSERT INTO group_comments (post_id, user_id, content) VALUES (?, ?, 'hey')", groupPost, bob)
+	if code := call(t, DeleteGroupPostHandler, alice, "/api/group/post/delete", map[string]int64{"id": groupPost}, nil); code != http.StatusOK {
+		t.Fatalf("deleting own group post = %d", code)
+	}
+	if n := countRows(t, "SELECT COUNT(*) FROM group_comments WHERE post_id = ?", groupPost); n != 0 {
+		t.Errorf("%d comments left on a deleted group post", n)
+	}
+}
+
+func TestPostHistoryShowsAudienceOnlyToAuthor(t *testing.T) {
+	openTestDB(t)
+	author, bob, carol := createTestUser(t, "author"), createTestUser(t, "bob"), createTestUser(t, "carol")
+	follow(t, bob, author)
+	follow(t, carol, author)
+
+	body := map[string]interface{}{"content": "hi", "privacy": "private", "audience": []int64{bob, carol}}
+	if code := call(t, CreatePostHandler, author, "/api/posts/create", body, nil); code != http.StatusCreated {
+		t.Fatalf("create: %d", code)
+	}
+	body = map[string]interface{}{"id": 1, "content": "hi again", "audience": []int64{bob}}
+	if code := call(t, UpdatePostHandler, author, "/api/posts/update", body, nil); code != http.StatusOK {
+		t.Fatalf("update: %d", code)
+	}
+
+	type history struct {
+		Edits []struct {
+			Content *string `json:"content"`
+			Privacy *string `json:"privacy"`
+			Allowed *string `json:"allowed_user_ids"`
+		} `json:"edits"`
+	}
+	var h history
+	if code := call(t, PostHistoryHandler, author, "/api/posts/history?kind=post&id=1", nil, &h); code != http.StatusOK || len(h.Edits) != 1 {
+		t.Fatalf("author history: %d, %+v", code, h)
+	}
+	e := h.Edits[0]
+	wantAllowed := strconv.FormatInt(bob, 10) + "," + strconv.FormatInt(carol, 10)
+	if e.Privacy == nil || *e.Privacy != "private" || e.Allowed == nil || *e.Allowed != wantAllowed {
+		t.Errorf("author sees privacy %v and audience %v, want private and %s", e.Privacy, e.Allowed, wantAllowed)
+	}
+
+	h = history{}
+	if code := call(t, PostHistoryHandler, bob, "/api/posts/history?kind=post&id=1", nil, &h); code != http.StatusOK || len(h.Edits) != 1 {
+		t.Fatalf("audience member history: %d, %+v", code, h)
+	}
+	e = h.Edits[0]
+	if e.Content == nil || *e.Content != "hi" {
+		t.Errorf("audience member sees content %v, want the earlier version", e.Content)
+	}
+	if e.Privacy != nil || e.Allowed != nil {
+		t.Errorf("audience member sees privacy %v and audience %v, want neither", e.Privacy, e.Allowed)
+	}
+
+	if code := call(t, PostHistoryHandler, carol, "/api/posts/history?kind=post&id=1", nil, &h); code != http.StatusNotFound {
+		t.Errorf("history for a user no longer in the audience: %d, want 404", code)
+	}
+}
+
+func TestEditedPostKeepsImagesOfItsHistory(t *testing.T) {
+	openTestDB(t)
+	author := createTestUser(t, "author")
+	dir := t.TempDir()
+	t.Chdir(dir)
+	posts := filepath.Join(dir, "backend", "uploads", "posts")
+	os.MkdirAll(posts, 0o755)
+	old := time.Now().Add(-2 * uploadGracePeriod)
+	for _, name := range []string{"old.jpg", "new.jpg"} {
+		os.WriteFile(filepath.Join(posts, name), []byte("jpg"), 0o644)
+		os.Chtimes(filepath.Join(posts, name), old, old)
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(posts, name))
+		return err == nil
+	}
+
+	post := insertTestRow(t, "INSERT INTO posts (author_id, content, image_url) VALUES (?, 'hi', '/uploads/posts/old.jpg')", author)
+	body := map[string]interface{}{"id": post, "image_url": "/uploads/posts/new.jpg"}
+	if code := call(t, UpdatePostHandler, author, "/api/posts/update", body, nil); code != http.StatusOK {
+		t.Fatalf("update: %d", code)
+	}
+	if !exists("old.jpg") {
+		t.Fatal("replaced image was deleted while the post's history shows it")
+	}
+	if err := CleanupUploads(); err != nil {
+		t.Fatal(err)
+	}
+	if !exists("old.jpg") || !exists("new.jpg") {
+		t.Fatal("upload cleanup deleted an image the post or its history shows")
+	}
+
+	if code := call(t, DeletePostHandler, author, "/api/posts/delete", map[string]int64{"id": post}, nil); code != http.StatusOK {
+		t.Fatalf("delete: %d", code)
+	}
+	if exists("old.jpg") || exists("new.jpg") {
+		t.Fatal("images of a deleted post and its history were kept")
+	}
+}
@@ -3,7 +3,9 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"math"
 	"net/http"
+	"slices"
 	"social-network/backend/db"
 	"social-network/backend/utils"
 	"strconv"
@@ -27,7 +29,9 @@ func normalizeURL(path string) string {
 	return path
 }
 
-// CreatePostHandler handles creating a new post from a JSON payload
+// CreatePostHandler handles creating a new post from a JSON payload:
+// { content, image_url?, privacy?, audience? }, where audience lists the
+// followers a private post is shared with
 func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 	uid := utils.GetUserIDFromContext(r)
 	if uid == "" {
@@ -37,10 +41,10 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 	userID, _ := strconv.ParseInt(uid, 10, 64)
 
 	var payload struct {
-		Content  string `json:"content"`
-		ImageURL string `json:"image_url"`
-		Privacy  string `json:"privacy"`
-		Allowed  string `json:"allowed"` // comma-separated ids for private
+		Content  string  `json:"content"`
+		ImageURL string  `json:"image_url"`
+		Privacy  string  `json:"privacy"`
+		Audience []int64 `json:"audience"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
@@ -56,20 +60,90 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 	if payload.Privacy == "" {
 		payload.Privacy = "public"
 	}
+	var audience []int64
+	switch payload.Privacy {
+	case "public", "followers":
+		if len(payload.Audience) > 0 {
+			utils.Error(w, http.StatusBadRequest, "Only private posts have an audience")
+			return
+		}
+	case "private":
+		var err error
+		if audience, err = checkAudience(userID, payload.Audience); err != nil {
+			audienceError(w, err)
+			return
+		}
+	default:
+		utils.Error(w, http.StatusBadRequest, "Invalid privacy")
+		return
+	}
 
 	imagePath := normalizeURL(payload.ImageURL)
 
-	_, err := db.DB.Exec("INSERT INTO posts (author_id, content, image_url, privacy, allowed_user_ids) VALUES (?, ?, ?, ?, ?)", userID, payload.Content, imagePath, payload.Privacy, payload.Allowed)
+	tx, err := db.DB.Begin()
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to create post")
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("INSERT INTO posts (author_id, content, image_url, privacy) VALUES (?, ?, ?, ?)", userID, payload.Content, imagePath, payload.Privacy)
 	if err != nil {
 		utils.Error(w, http.StatusInternalServerError, "Failed to create post")
 		return
 	}
+	postID, _ := res.LastInsertId()
+	if err := setAudience(tx, postID, audience); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to create post")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to create post")
+		return
+	}
+	Emit(Activity{Event: "post_created", ActorID: userID,
+		Data: map[string]interface{}{"post_id": postID, "author_id": userID, "content": payload.Content, "image_url": imagePath, "privacy": payload.Privacy}})
 	utils.JSON(w, http.StatusCreated, map[string]string{"status": "created"})
 }
 
-// ListFeedHandler returns posts visible to the requester
+const (
+	feedDefaultLimit = 20
+	feedMaxLimit     = 100
+	// commentPreviews is how many of its latest comments come with each
+	// post in the feed; the rest are at /api/posts/comments.
+	commentPreviews = 3
+)
+
+// postVisibleSQL is true for posts p the viewer (bound three times) may see:
+// their own, public ones, followers-only ones of people they follow, and
+// private ones with them in the audience. Anonymous viewers (0) see public posts only.
+const postVisibleSQL = `(p.author_id = ? OR p.privacy = 'public'
+	OR (p.privacy = 'followers' AND EXISTS (SELECT 1 FROM followers f WHERE f.follower_id = ? AND f.followed_id = p.author_id))
+	OR (p.privacy = 'private' AND EXISTS (SELECT 1 FROM post_audience a WHERE a.post_id = p.id AND a.user_id = ?)))`
+
+type feedPost struct {
+	ID             int64        `json:"id"`
+	AuthorID       int64        `json:"author_id"`
+	AuthorNickname string       `json:"author_nickname"`
+	Content        string       `json:"content"`
+	ImageURL       string       `json:"image_url"`
+	Privacy        string       `json:"privacy"`
+	Created        string       `json:"created_at"`
+	EditedAt       *string      `json:"edited_at,omitempty"`
+	Comments       []commentDTO `json:"comments"`
+	CommentCount   int          `json:"comment_count"`
+}
+
+// ListFeedHandler - GET /api/posts
+// A page of the posts the requester may see, newest first:
+//   - user_id: only this author's posts
+//   - limit: page size, default 20 (max 100)
+//   - before: only posts older than this post id (the next page)
+//   - after: only posts newer than this post id (polling for new ones)
+//
+// Returns {posts, has_more}; has_more tells whether more posts lie beyond
+// the page in the direction asked. Each post comes with its comment_count
+// and its latest comments (up to 3).
 func ListFeedHandler(w http.ResponseWriter, r *http.Request) {
-	// optional ?user_id to list a user's posts
 	viewer := utils.GetUserIDFromContext(r)
 	if viewer == "" {
 		viewer = utils.GetUserIDFromSession(w, r)
@@ -78,96 +152,82 @@ func ListFeedHandler(w http.ResponseWriter, r *http.Request) {
 	if viewer != "" {
 		viewerID, _ = strconv.ParseInt(viewer, 10, 64)
 	}
+	q := r.URL.Query()
 
-	qUser := r.URL.Query().Get("user_id")
-	var rows *sql.Rows
-	var err error
-	if qUser != "" {
-		// list posts by a specific user, but apply privacy
-		tid, _ := strconv.ParseInt(qUser, 10, 64)
-		rows, err = db.DB.Query(`
-			SELECT p.id, p.author_id, p.content, p.image_url, p.privacy, p.allowed_user_ids, p.created_at, u.nickname 
-			FROM posts p JOIN users u ON p.author_id = u.id 
-			WHERE p.author_id = ? 
-			ORDER BY p.created_at DESC`, tid)
-	} else {
-		// feed: show public posts + posts from followed users + own private posts where allowed
-		rows, err = db.DB.Query(`
-			SELECT p.id, p.author_id, p.content, p.image_url, p.privacy, p.allowed_user_ids, p.created_at, u.nickname 
-			FROM posts p JOIN users u ON p.author_id = u.id 
-			ORDER BY p.created_at DESC`)
+	limit := feedDefaultLimit
+	if l := q.Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed <= 0 {
+			utils.Error(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(parsed, feedMaxLimit)
+	}
+
+	where := []string{postVisibleSQL}
+	args := []interface{}{viewerID, viewerID, viewerID}
+	for _, param := range []struct{ name, cond string }{
+		{"user_id", "p.author_id = ?"},
+		{"before", "p.id < ?"},
+		{"after", "p.id > ?"},
+	} {
+		v := q.Get(param.name)
+		if v == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			utils.Error(w, http.StatusBadRequest, "Invalid "+param.name)
+			return
+		}
+		where = append(where, param.cond)
+		args = append(args, id)
 	}
+	// pages after a post run oldest first from it, and are reversed below
+	order := "DESC"
+	if q.Get("after") != "" && q.Get("before") == "" {
+		order = "ASC"
+	}
+
+	rows, err := db.DB.Query(`SELECT p.id, p.author_id, p.content, p.image_url, p.privacy, p.created_at, p.edited_at, u.nickname
+		FROM posts p JOIN users u ON p.author_id = u.id
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY p.id `+order+` LIMIT ?`, append(args, limit+1)...)
 	if err != nil {
 		utils.Error(w, http.StatusInternalServerError, "Failed to load posts")
 		return
 	}
 	defer rows.Close()
 
-	type P struct {
-		ID             int64        `json:"id"`
-		AuthorID       int64        `json:"author_id"`
-		AuthorNickname string       `json:"author_nickname"`
-		Content        string       `json:"content"`
-		ImageURL       string       `json:"image_url"`
-		Privacy        string       `json:"privacy"`
-		Allowed        string       `json:"allowed_user_ids"`
-		Created        string       `json:"created_at"`
-		Comments       []commentDTO `json:"comments"`
-		CommentCount   int          `json:"comment_count"`
-	}
-	var out []P
+	out := []feedPost{}
 	for rows.Next() {
-		var p P
-		var allowed sql.NullString
-		if err := rows.Scan(&p.ID, &p.AuthorID, &p.Content, &p.ImageURL, &p.Privacy, &allowed, &p.Created, &p.AuthorNickname); err != nil {
-			continue
-		}
-		p.Allowed = allowed.String
-		p.ImageURL = normalizeURL(p.ImageURL)
-		// privacy enforcement: minimalistic
-		visible := false
-		if p.Privacy == "public" {
-			visible = true
-		} else if p.Privacy == "followers" {
-			if viewerID > 0 {
-				var cnt int
-				db.DB.QueryRow("SELECT COUNT(1) FROM followers WHERE follower_id=? AND followed_id=?", viewerID, p.AuthorID).Scan(&cnt)
-				if cnt > 0 || viewerID == p.AuthorID {
-					visible = true
-				}
-			}
-		} else if p.Privacy == "private" {
-			if viewerID == p.AuthorID {
-				visible = true
-			} else if p.Allowed != "" {
-				parts := strings.Split(p.Allowed, ",")
-				for _, s := range parts {
-					if s == "" {
-						continue
-					}
-					if vid, _ := strconv.ParseInt(strings.TrimSpace(s), 10, 64); vid == viewerID {
-						visible = true
-						break
-					}
-				}
-			}
-		}
-		if visible {
-			out = append(out, p)
+		var p feedPost
+		var content, image sql.NullString
+		if err := rows.Scan(&p.ID, &p.AuthorID, &content, &image, &p.Privacy, &p.Created, &p.EditedAt, &p.AuthorNickname); err != nil {
+			utils.Error(w, http.StatusInternalServerError, "Failed to load posts")
+			return
 		}
+		p.Content = content.String
+		p.ImageURL = normalizeURL(image.String)
+		out = append(out, p)
+	}
+	if err := rows.Err(); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to load posts")
+		return
+	}
+	hasMore := len(out) > limit
+	if hasMore {
+		out = out[:limit]
+	}
+	if order == "ASC" {
+		slices.Reverse(out)
 	}
 
-	if len(out) > 0 {
-		for i := range out {
-			comments, err := loadComments(out[i].ID)
-			if err != nil {
-				continue
-			}
-			out[i].Comments = comments
-			out[i].CommentCount = len(comments)
-		}
+	if err := attachCommentPreviews(out); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to load comments")
+		return
 	}
-	utils.JSON(w, http.StatusOK, out)
+	utils.JSON(w, http.StatusOK, map[string]interface{}{"posts": out, "has_more": hasMore})
 }
 
 // AddCommentHandler adds a comment to a post (respecting post visibility implicitly by assuming front-end only shows allowed posts)
@@ -197,36 +257,117 @@ func AddCommentHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 type commentDTO struct {
-	ID        int64  `json:"id"`
-	PostID    int64  `json:"post_id"`
-	UserID    int64  `json:"user_id"`
-	Nickname  string `json:"nickname"`
-	Content   string `json:"content"`
-	ImageURL  string `json:"image_url,omitempty"`
-	CreatedAt string `json:"created_at"`
+	ID        int64   `json:"id"`
+	PostID    int64   `json:"post_id"`
+	UserID    int64   `json:"user_id"`
+	Nickname  string  `json:"nickname"`
+	Content   string  `json:"content"`
+	ImageURL  string  `json:"image_url,omitempty"`
+	CreatedAt string  `json:"created_at"`
+	EditedAt  *string `json:"edited_at,omitempty"`
 }
 
-func loadComments(postID int64) ([]commentDTO, error) {
-	rows, err := db.DB.Query(`
-		SELECT c.id, c.post_id, c.user_id, c.content, c.image_url, c.created_at, IFNULL(u.nickname, '')
-		FROM comments c
+// commentColumns are scanned by scanComment.
+const commentColumns = "c.id, c.post_id, c.user_id, c.content, c.image_url, c.created_at, c.edited_at, IFNULL(u.nickname, '')"
+
+func scanComment(rows *sql.Rows) (commentDTO, error) {
+	var c commentDTO
+	var image sql.NullString
+	err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Content, &image, &c.CreatedAt, &c.EditedAt, &c.Nickname)
+	c.ImageURL = normalizeURL(image.String)
+	return c, err
+}
+
+// attachCommentPreviews sets the comment count and the latest
+// commentPreviews comments, oldest first, of every post in one query.
+func attachCommentPreviews(posts []feedPost) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	index := make(map[int64]*feedPost, len(posts))
+	args := make([]interface{}, 0, len(posts)+1)
+	for i := range posts {
+		posts[i].Comments = []commentDTO{}
+		index[posts[i].ID] = &posts[i]
+		args = append(args, posts[i].ID)
+	}
+	rows, err := db.DB.Query(`SELECT `+commentColumns+`, total FROM (
+			SELECT c.*, ROW_NUMBER() OVER (PARTITION BY c.post_id ORDER BY c.id DESC) AS n,
+				COUNT(*) OVER (PARTITION BY c.post_id) AS total
+			FROM comments c WHERE c.post_id IN (?`+strings.Repeat(", ?", len(posts)-1)+`)
+		) c
 		LEFT JOIN users u ON c.user_id = u.id
-		WHERE c.post_id = ?
-		ORDER BY c.created_at ASC`, postID)
+		WHERE c.n <= ?
+		ORDER BY c.post_id, c.id`, append(args, commentPreviews)...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
-
-	var comments []commentDTO
 	for rows.Next() {
 		var c commentDTO
 		var image sql.NullString
-		if err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Content, &image, &c.CreatedAt, &c.Nickname); err != nil {
-			continue
+		var total int
+		if err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Content, &image, &c.CreatedAt, &c.EditedAt, &c.Nickname, &total); err != nil {
+			return err
 		}
 		c.ImageURL = normalizeURL(image.String)
+		p := index[c.PostID]
+		p.Comments = append(p.Comments, c)
+		p.CommentCount = total
+	}
+	return rows.Err()
+}
+
+// ListCommentsHandler - GET /api/posts/comments?post_id=<id>&before=<comment id>&limit=
+// A post's comments, oldest first; with before, the ones older than that
+// comment (to expand the previews in the feed). Returns {comments, has_more}.
+func ListCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	viewer := utils.GetUserIDFromContext(r)
+	if viewer == "" {
+		viewer = utils.GetUserIDFromSession(w, r)
+	}
+	viewerID, _ := strconv.ParseInt(viewer, 10, 64)
+	q := r.URL.Query()
+	postID, _ := strconv.ParseInt(q.Get("post_id"), 10, 64)
+	var visible bool
+	db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM posts p WHERE p.id = ? AND "+postVisibleSQL+")", postID, viewerID, viewerID, viewerID).Scan(&visible)
+	if !visible {
+		utils.Error(w, http.StatusNotFound, "Post not found")
+		return
+	}
+	limit := feedMaxLimit
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 {
+		limit = min(v, feedMaxLimit)
+	}
+	before, _ := strconv.ParseInt(q.Get("before"), 10, 64)
+	if before <= 0 {
+		before = math.MaxInt64
+	}
+
+	// the newest page before the cursor, shown oldest first
+	rows, err := db.DB.Query(`SELECT `+commentColumns+`
+		FROM comments c
+		LEFT JOIN users u ON c.user_id = u.id
+		WHERE c.post_id = ? AND c.id < ?
+		ORDER BY c.id DESC LIMIT ?`, postID, before, limit+1)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to load comments")
+		return
+	}
+	defer rows.Close()
+	comments := []commentDTO{}
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "Failed to load comments")
+			return
+		}
 		comments = append(comments, c)
 	}
-	return comments, nil
+	hasMore := len(comments) > limit
+	if hasMore {
+		comments = comments[:limit]
+	}
+	slices.Reverse(comments)
+	utils.JSON(w, http.StatusOK, map[string]interface{}{"comments": comments, "has_more": hasMore})
 }
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+
+	"social-network/backend/db"
+)
+
+type feedPage struct {
+	Posts   []feedPost `json:"posts"`
+	HasMore bool       `json:"has_more"`
+}
+
+// feedIDs returns the ids of a feed page as the viewer sees it.
+func feedIDs(t *testing.T, viewerID int64, query string) ([]int64, bool) {
+	t.Helper()
+	var page feedPage
+	if code := call(t, ListFeedHandler, viewerID, "/api/posts"+query, nil, &page); code != http.StatusOK {
+		t.Fatalf("GET /api/posts%s as %d: %d", query, viewerID, code)
+	}
+	ids := make([]int64, len(page.Posts))
+	for i, p := range page.Posts {
+		ids[i] = p.ID
+	}
+	return ids, page.HasMore
+}
+
+// createTestPost adds a post through CreatePostHandler and returns its id.
+func createTestPost(t *testing.T, authorID int64, privacy string, audience ...int64) int64 {
+	t.Helper()
+	body := map[string]interface{}{"content": privacy + " post", "privacy": privacy, "audience": audience}
+	if code := call(t, CreatePostHandler, authorID, "/api/posts/create", body, nil); code != http.StatusCreated {
+		t.Fatalf("create %s post: %d", privacy, code)
+	}
+	var id int64
+	db.DB.QueryRow("SELECT MAX(id) FROM posts WHERE author_id = ?", authorID).Scan(&id)
+	return id
+}
+
+func TestFeedVisibility(t *testing.T) {
+	openTestDB(t)
+	alice, bob, carol, dave := createTestUser(t, "alice"), createTestUser(t, "bob"), createTestUser(t, "carol"), createTestUser(t, "dave")
+	follow(t, bob, alice)
+	follow(t, dave, alice)
+
+	public := createTestPost(t, alice, "public")
+	followers := createTestPost(t, alice, "followers")
+	private := createTestPost(t, alice, "private", bob)
+	carols := createTestPost(t, carol, "followers")
+
+	for _, tc := range []struct {
+		name   string
+		viewer int64
+		want   []int64
+	}{
+		{"author", alice, []int64{private, followers, public}},
+		{"follower in the audience", bob, []int64{private, followers, public}},
+		{"follower outside the audience", dave, []int64{followers, public}},
+		{"stranger", carol, []int64{carols, public}},
+		{"anonymous", 0, []int64{public}},
+	} {
+		if got, _ := feedIDs(t, tc.viewer, ""); !slices.Equal(got, tc.want) {
+			t.Errorf("%s sees %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	if got, _ := feedIDs(t, bob, fmt.Sprintf("?user_id=%d", carol)); len(got) != 0 {
+		t.Errorf("bob sees %v of carol's followers-only posts", got)
+	}
+
+	// unfollowing takes bob out of the audience too
+	if code := call(t, UnfollowHandler, bob, "/api/unfollow", map[string]int64{"target_id": alice}, nil); code != http.StatusOK {
+		t.Fatalf("unfollow: %d", code)
+	}
+	if got, _ := feedIDs(t, bob, ""); !slices.Equal(got, []int64{public}) {
+		t.Errorf("after unfollowing bob sees %v, want %v", got, []int64{public})
+	}
+}
+
+func TestFeedCursors(t *testing.T) {
+	openTestDB(t)
+	alice := createTestUser(t, "alice")
+	var ids []int64
+	for range 5 {
+		ids = append(ids, createTestPost(t, alice, "public"))
+	}
+	slices.Reverse(ids) // newest first, like the feed
+
+	got, more := feedIDs(t, alice, "?limit=2")
+	if !slices.Equal(got, ids[:2]) || !more {
+		t.Fatalf("first page = %v %v, want %v true", got, more, ids[:2])
+	}
+	got, more = feedIDs(t, alice, fmt.Sprintf("?limit=2&before=%d", got[1]))
+	if !slices.Equal(got, ids[2:4]) || !more {
+		t.Fatalf("second page = %v %v, want %v true", got, more, ids[2:4])
+	}
+	got, more = feedIDs(t, alice, fmt.Sprintf("?limit=2&before=%d", got[1]))
+	if !slices.Equal(got, ids[4:]) || more {
+		t.Fatalf("last page = %v %v, want %v false", got, more, ids[4:])
+	}
+
+	// after returns the posts right after the cursor, still newest first
+	got, more = feedIDs(t, alice, fmt.Sprintf("?limit=2&after=%d", ids[4]))
+	if !slices.Equal(got, ids[2:4]) || !more {
+		t.Fatalf("after oldest = %v %v, want %v true", got, more, ids[2:4])
+	}
+	got, more = feedIDs(t, alice, fmt.Sprintf("?after=%d", ids[0]))
+	if len(got) != 0 || more {
+		t.Fatalf("after newest = %v %v, want nothing", got, more)
+	}
+	got, _ = feedIDs(t, alice, fmt.Sprintf("?after=%d&before=%d", ids[4], ids[0]))
+	if !slices.Equal(got, ids[1:4]) {
+		t.Fatalf("between oldest and newest = %v, want %v", got, ids[1:4])
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=x", "?before=0", "?after=-1", "?user_id=x"} {
+		if code := call(t, ListFeedHandler, alice, "/api/posts"+query, nil, nil); code != http.StatusBadRequest {
+			t.Errorf("GET /api/posts%s = %d, want 400", query, code)
+		}
+	}
+}
+
+func TestFeedCommentPreviews(t *testing.T) {
+	openTestDB(t)
+	alice, bob, carol := createTestUser(t, "alice"), createTestUser(t, "bob"), createTestUser(t, "carol")
+	follow(t, bob, alice)
+	busy := createTestPost(t, alice, "public")
+	quiet := createTestPost(t, alice, "public")
+	hidden := createTestPost(t, alice, "private", bob)
+	var comments []int64
+	for i := range 5 {
+		body := map[string]interface{}{"post_id": busy, "content": fmt.Sprintf("comment %d", i)}
+		if code := call(t, AddCommentHandler, bob, "/api/posts/comment", body, nil); code != http.StatusOK {
+			t.Fatalf("comment: %d", code)
+		}
+		var id int64
+		db.DB.QueryRow("SELECT MAX(id) FROM comments").Scan(&id)
+		comments = append(comments, id)
+	}
+
+	var page feedPage
+	call(t, ListFeedHandler, carol, "/api/posts", nil, &page)
+	for _, p := range page.Posts {
+		var ids []int64
+		for _, c := range p.Comments {
+			ids = append(ids, c.ID)
+		}
+		switch p.ID {
+		case busy:
+			// the latest comments, oldest first
+			if p.CommentCount != 5 || !slices.Equal(ids, comments[2:]) || p.Comments[0].Nickname != "bob" {
+				t.Errorf("busy post has %d comments with previews %v, want 5 and %v", p.CommentCount, ids, comments[2:])
+			}
+		case quiet:
+			if p.CommentCount != 0 || p.Comments == nil || len(p.Comments) != 0 {
+				t.Errorf("quiet post has %d comments and previews %v", p.CommentCount, p.Comments)
+			}
+		}
+	}
+
+	var list struct {
+		Comments []commentDTO `json:"comments"`
+		HasMore  bool         `json:"has_more"`
+	}
+	url := fmt.Sprintf("/api/posts/comments?post_id=%d&limit=2&before=%d", busy, comments[2])
+	if code := call(t, ListCommentsHandler, carol, url, nil, &list); code != http.StatusOK || len(list.Comments) != 2 ||
+		list.Comments[0].ID != comments[0] || list.Comments[1].ID != comments[1] || list.HasMore {
+		t.Errorf("comments before the previews = %d %+v", code, list)
+	}
+	url = fmt.Sprintf("/api/posts/comments?post_id=%d&limit=2", busy)
+	if call(t, ListCommentsHandler, carol, url, nil, &list); len(list.Comments) != 2 || list.Comments[1].ID != comments[4] || !list.HasMore {
+		t.Errorf("latest comments = %+v", list)
+	}
+	url = fmt.Sprintf("/api/posts/comments?post_id=%d", hidden)
+	if code := call(t, ListCommentsHandler, carol, url, nil, nil); code != http.StatusNotFound {
+		t.Errorf("comments of a post carol cannot see = %d, want 404", code)
+	}
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"social-network/backend/db"
+	"social-network/backend/models"
+	"social-network/backend/utils"
+)
+
+// maxReactionRunes bounds the length of a reaction's emoji sequence; the
+// longest in use (e.g. a couple with two skin tones) have about 10.
+const maxReactionRunes = 16
+
+var ErrInvalidReaction = errors.New("invalid reaction")
+
+// normalizeReaction expands shortcodes and checks that a reaction is a
+// single emoji.
+func normalizeReaction(emoji string) (string, error) {
+	emoji = strings.TrimSpace(utils.ExpandEmoji(emoji))
+	if utf8.RuneCountInString(emoji) > maxReactionRunes || !utils.IsEmojiSequence(emoji) {
+		return "", ErrInvalidReaction
+	}
+	return emoji, nil
+}
+
+// reactionAudience checks that userID may react to the message and returns
+// the users that should receive the realtime update plus the group id (0 for
+// direct messages).
+func reactionAudience(kind string, userID, messageID int64) ([]int64, int64, error) {
+	if kind == "group" {
+		ref, err := loadGroupMessageRef(messageID)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !IsGroupMember(ref.GroupID, userID) {
+			return nil, 0, ErrMessageNotFound
+		}
+		if ref.Deleted {
+			return nil, 0, ErrMessageDeleted
+		}
+		members, err := GroupMemberIDs(ref.GroupID)
+		return members, ref.GroupID, err
+	}
+	ref, err := loadDirectMessageRef(messageID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if userID != ref.SenderID && userID != ref.ReceiverID {
+		return nil, 0, ErrMessageNotFound
+	}
+	if ref.Deleted {
+		return nil, 0, ErrMessageDeleted
+	}
+	return []int64{ref.SenderID, ref.ReceiverID}, 0, nil
+}
+
+// loadReactions aggregates reactions for a batch of messages of one kind,
+// keyed by message id. Emoji are ordered by their first use.
+func loadReactions(kind string, messageIDs []int64, viewerID int64) (map[int64][]models.Reaction, error) {
+	out := make(map[int64][]models.Reaction)
+	if len(messageIDs) == 0 {
+		return out, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(messageIDs)), ",")
+	args := []interface{}{kind}
+	for _, id := range messageIDs {
+		args = append(args, id)
+	}
+	rows, err := db.DB.Query(`
+		SELECT r.message_id, r.emoji, r.user_id, IFNULL(u.nickname, '')
+		FROM message_reactions r
+		LEFT JOIN users u ON u.id = r.user_id
+		WHERE r.kind = ? AND r.message_id IN (`+placeholders+`)
+		ORDER BY r.message_id, r.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID, uid int64
+		var emoji, nickname string
+		if err := rows.Scan(&messageID, &emoji, &uid, &nickname); err != nil {
+			continue
+		}
+		list := out[messageID]
+		idx := -1
+		for i := range list {
+			if list[i].Emoji == emoji {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			list = append(list, models.Reaction{Emoji: emoji})
+			idx = len(list) - 1
+		}
+		list[idx].Count++
+		list[idx].UserIDs = append(list[idx].UserIDs, uid)
+		list[idx].Nicknames = append(list[idx].Nicknames, nickname)
+		if uid == viewerID {
+			list[idx].ReactedByMe = true
+		}
+		out[messageID] = list
+	}
+	return out, nil
+}
+
+// SetReaction adds (or removes) userID's emoji reaction on a DM ("direct") or
+// group message ("group") and pushes the new aggregate to every participant.
+func SetReaction(kind string, userID, messageID int64, emoji string, remove bool) error {
+	emoji, err := normalizeReaction(emoji)
+	if err != nil {
+		return err
+	}
+	audience, groupID, err := reactionAudience(kind, userID, messageID)
+	if err != nil {
+		return err
+	}
+	if remove {
+		_, err = db.DB.Exec("DELETE FROM message_reactions WHERE kind=? AND message_id=? AND user_id=? AND emoji=?", kind, messageID, userID, emoji)
+	} else {
+		_, err = db.DB.Exec("INSERT OR IGNORE INTO message_reactions (kind, message_id, user_id, emoji) VALUES (?, ?, ?, ?)", kind, messageID, userID, emoji)
+	}
+	if err != nil {
+		return err
+	}
+
+	reactions, err := loadReactions(kind, []int64{messageID}, 0)
+	if err != nil {
+		return err
+	}
+	action := "add"
+	if remove {
+		action = "remove"
+	}
+	event := map[string]interface{}{
+		"type":       "reaction_updated",
+		"kind":       kind,
+		"message_id": messageID,
+		"user_id":    userID,
+		"emoji":      emoji,
+		"action":     action,
+		"reactions":  reactions[messageID],
+	}
+	if groupID != 0 {
+		event["group_id"] = groupID
+	}
+	publishTo(audience, event)
+	return nil
+}
+
+// handleReaction is the shared body of the DM and group react endpoints.
+func handleReaction(w http.ResponseWriter, r *http.Request, kind string) {
+	userIDStr := utils.GetUserIDFromContext(r)
+	if userIDStr == "" {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var payload struct {
+		MessageID int64  `json:"message_id"`
+		Emoji     string `json:"emoji"`
+		Action    string `json:"action"` // add (default) or remove
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.MessageID == 0 {
+		utils.Error(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+	if err := SetReaction(kind, userID, payload.MessageID, payload.Emoji, payload.Action == "remove"); err != nil {
+		writeMessageError(w, err)
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// POST /api/messages/react { message_id, emoji, action: add|remove }
+func ReactMessageHandler(w http.ResponseWriter, r *http.Request) {
+	handleReaction(w, r, "direct")
+}
+
+// POST /api/group/messages/react { message_id, emoji, action: add|remove }
+func ReactGroupMessageHandler(w http.ResponseWriter, r *http.Request) {
+	handleReaction(w, r, "group")
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"social-network/backend/models"
+)
+
+func TestReactionToggle(t *testing.T) {
+	openTestDB(t)
+	alice, bob, carol := createTestUser(t, "alice"), createTestUser(t, "bob"), createTestUser(t, "carol")
+	msg := sendTestDM(t, alice, bob, "hi")
+
+	reactions := func(viewerID int64) []models.Reaction {
+		t.Helper()
+		got, err := loadReactions("direct", []int64{msg}, viewerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got[msg]
+	}
+	react := func(userID int64, emoji, action string) int {
+		t.Helper()
+		body := map[string]interface{}{"message_id": msg, "emoji": emoji, "action": action}
+		return call(t, ReactMessageHandler, userID, "/api/messages/react", body, nil)
+	}
+
+	for _, step := range []struct {
+		user          int64
+		emoji, action string
+	}{
+		{bob, "👍", "add"},
+		{bob, "👍", "add"}, // adding twice is a no-op
+		{alice, ":thumbs_up:", ""},
+		{alice, "😂", "add"},
+	} {
+		if code := react(step.user, step.emoji, step.action); code != http.StatusOK {
+			t.Fatalf("%d reacting %s: %d", step.user, step.emoji, code)
+		}
+	}
+	want := []models.Reaction{
+		{Emoji: "👍", Count: 2, UserIDs: []int64{bob, alice}, Nicknames: []string{"bob", "alice"}, ReactedByMe: true},
+		{Emoji: "😂", Count: 1, UserIDs: []int64{alice}, Nicknames: []string{"alice"}},
+	}
+	if got := reactions(bob); !reflect.DeepEqual(got, want) {
+		t.Errorf("reactions = %+v, want %+v", got, want)
+	}
+
+	if code := react(bob, "👍", "remove"); code != http.StatusOK {
+		t.Fatalf("remove: %d", code)
+	}
+	if code := react(bob, "😂", "remove"); code != http.StatusOK {
+		t.Fatalf("removing a reaction never added: %d", code)
+	}
+	want = []models.Reaction{
+		{Emoji: "👍", Count: 1, UserIDs: []int64{alice}, Nicknames: []string{"alice"}},
+		want[1],
+	}
+	if got := reactions(bob); !reflect.DeepEqual(got, want) {
+		t.Errorf("after bob's removal reactions = %+v, want %+v", got, want)
+	}
+
+	for _, emoji := range []string{"", "  ", "👍 👍", "123456789"} {
+		if code := react(bob, emoji, "add"); code != http.StatusBadRequest {
+			t.Errorf("reacting %q = %d, want 400", emoji, code)
+		}
+	}
+	if code := react(carol, "👍", "add"); code != http.StatusNotFound {
+		t.Errorf("outsider reacting = %d, want 404", code)
+	}
+	if err := DeleteDirectMessage(alice, msg, true); err != nil {
+		t.Fatal(err)
+	}
+	if code := react(bob, "👍", "add"); code != http.StatusConflict {
+		t.Errorf("reacting to a deleted message = %d, want 409", code)
+	}
+}
+
+func TestNormalizeReaction(t *testing.T) {
+	for _, emoji := range []string{
+		"👍", "❤️", "☺", "👍🏽", "👩‍💻", "👨‍👩‍👧‍👦", "🏳️‍🌈", "👩🏻‍❤️‍💋‍👨🏼",
+		"🇫🇷", "1️⃣", "#⃣", "🏴\U000e0067\U000e0062\U000e0073\U000e0063\U000e0074\U000e007f", ":smile:",
+	} {
+		if _, err := normalizeReaction(emoji); err != nil {
+			t.Errorf("%q was rejected", emoji)
+		}
+	}
+	for _, emoji := range []string{
+		"", "a", "ok", "1", "👍👍", "👍 👍", "👍a", "🏽", "\u200d👍", "👍\u200d", "🇫", "🇫🇷🇩", "\ufe0f",
+		"🏴\U000e0067\U000e0062", "<b>", "\u202e👍",
+	} {
+		if _, err := normalizeReaction(emoji); err == nil {
+			t.Errorf("%q was accepted", emoji)
+		}
+	}
+}
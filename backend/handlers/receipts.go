@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"social-network/backend/bus"
+	"social-network/backend/db"
+	"social-network/backend/models"
+	"social-network/backend/utils"
+)
+
+// ErrMessageNotFound is returned when a receipt references a message that
+// was not sent to the acknowledging user.
+var ErrMessageNotFound = errors.New("message not found")
+
+// loadReceipt returns userID's cursor for the conversation with peerID (zero
+// values when nothing has been acknowledged yet).
+func loadReceipt(userID, peerID int64) (models.Receipt, error) {
+	rc := models.Receipt{UserID: userID, PeerID: peerID}
+	var updated sql.NullString
+	err := db.DB.QueryRow("SELECT last_delivered_id, last_read_id, updated_at FROM message_receipts WHERE user_id=? AND peer_id=?", userID, peerID).
+		Scan(&rc.LastDeliveredID, &rc.LastReadID, &updated)
+	if err != nil && err != sql.ErrNoRows {
+		return rc, err
+	}
+	rc.UpdatedAt = updated.String
+	return rc, nil
+}
+
+// clampToConversation returns the newest message id sent by peerID to userID
+// that is <= upToID (or the newest overall when upToID is 0).
+func clampToConversation(userID, peerID, upToID int64) (int64, error) {
+	var id int64
+	var err error
+	if upToID > 0 {
+		err = db.DB.QueryRow("SELECT IFNULL(MAX(id), 0) FROM messages WHERE sender_id=? AND receiver_id=? AND id <= ?", peerID, userID, upToID).Scan(&id)
+	} else {
+		err = db.DB.QueryRow("SELECT IFNULL(MAX(id), 0) FROM messages WHERE sender_id=? AND receiver_id=?", peerID, userID).Scan(&id)
+	}
+	return id, err
+}
+
+// advanceReceipt moves userID's cursors forward (never backwards) and, when
+// something changed, pushes a "receipt" frame to peerID so the sender's UI can
+// update its ticks. read implies delivered.
+func advanceReceipt(userID, peerID, upToID int64, read bool) (models.Receipt, error) {
+	upTo, err := clampToConversation(userID, peerID, upToID)
+	if err != nil {
+		return models.Receipt{}, err
+	}
+	prev, err := loadReceipt(userID, peerID)
+	if err != nil {
+		return prev, err
+	}
+	if upTo <= prev.LastDeliveredID && (!read || upTo <= prev.LastReadID) {
+		return prev, nil
+	}
+
+	readID := int64(0)
+	if read {
+		readID = upTo
+	}
+	_, err = db.DB.Exec(`
+		INSERT INTO message_receipts (user_id, peer_id, last_delivered_id, last_read_id, updated_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id, peer_id) DO UPDATE SET
+			last_delivered_id = MAX(last_delivered_id, excluded.last_delivered_id),
+			last_read_id = MAX(last_read_id, excluded.last_read_id),
+			updated_at = CURRENT_TIMESTAMP`,
+		userID, peerID, upTo, readID)
+	if err != nil {
+		return prev, err
+	}
+
+	rc, err := loadReceipt(userID, peerID)
+	if err != nil {
+		return rc, err
+	}
+	rc.Type = "receipt"
+	if payload, err := json.Marshal(rc); err == nil {
+		bus.PublishNotification(peerID, payload)
+	}
+	return rc, nil
+}
+
+// messageSender resolves the sender of a DM addressed to userID.
+func messageSender(userID, messageID int64) (int64, error) {
+	var senderID int64
+	err := db.DB.QueryRow("SELECT sender_id FROM messages WHERE id=? AND receiver_id=?", messageID, userID).Scan(&senderID)
+	if err == sql.ErrNoRows {
+		return 0, ErrMessageNotFound
+	}
+	return senderID, err
+}
+
+// AcknowledgeMessage records that userID's client has received messageID
+// (and everything before it in the same conversation).
+func AcknowledgeMessage(userID, messageID int64) (models.Receipt, error) {
+	peerID, err := messageSender(userID, messageID)
+	if err != nil {
+		return models.Receipt{}, err
+	}
+	return advanceReceipt(userID, peerID, messageID, false)
+}
+
+// ReadMessage records that userID has read messageID (and everything before
+// it in the same conversation).
+func ReadMessage(userID, messageID int64) (models.Receipt, error) {
+	peerID, err := messageSender(userID, messageID)
+	if err != nil {
+		return models.Receipt{}, err
+	}
+	return advanceReceipt(userID, peerID, messageID, true)
+}
+
+// POST /api/messages/read { user_id, message_id? } - mark a conversation read
+// up to message_id (or up to the newest message when omitted)
+func MarkConversationReadHandler(w http.ResponseWriter, r *http.Request) {
+	userIDStr := utils.GetUserIDFromContext(r)
+	if userIDStr == "" {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var payload struct {
+		UserID    int64 `json:"user_id"`
+		MessageID int64 `json:"message_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.UserID == 0 {
+		utils.Error(w, http.StatusBadRequest, "Invalid input")
+		return
+	}
+	rc, err := advanceReceipt(userID, payload.UserID, payload.MessageID, true)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to mark read")
+		return
+	}
+	utils.JSON(w, http.StatusOK, rc)
+}
+
+// GET /api/messages/receipts?user_id=<id> - both participants' cursors for a
+// conversation: "mine" (what I have read) and "peer" (what they have read of
+// my messages)
+func GetReceiptsHandler(w http.ResponseWriter, r *http.Request) {
+	userIDStr := utils.GetUserIDFromContext(r)
+	if userIDStr == "" {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	peerID, err := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusBadRequest, "Invalid user_id")
+		return
+	}
+	mine, err := loadReceipt(userID, peerID)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to load receipts")
+		return
+	}
+	peer, err := loadReceipt(peerID, userID)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to load receipts")
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]models.Receipt{"mine": mine, "peer": peer})
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"social-network/backend/db"
+	"social-network/backend/models"
+)
+
+// sendTestDM stores a direct message and returns its id.
+func sendTestDM(t *testing.T, senderID, receiverID int64, content string) int64 {
+	t.Helper()
+	res, err := db.DB.Exec("INSERT INTO messages (sender_id, receiver_id, content) VALUES (?, ?, ?)", senderID, receiverID, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
+
+func TestReceiptsOnlyMoveForward(t *testing.T) {
+	openTestDB(t)
+	alice, bob, carol := createTestUser(t, "alice"), createTestUser(t, "bob"), createTestUser(t, "carol")
+	m1 := sendTestDM(t, alice, bob, "one")
+	m2 := sendTestDM(t, alice, bob, "two")
+	m3 := sendTestDM(t, alice, bob, "three")
+	other := sendTestDM(t, carol, bob, "elsewhere")
+
+	check := func(rc models.Receipt, err error, delivered, read int64) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rc.LastDeliveredID != delivered || rc.LastReadID != read {
+			t.Fatalf("receipt = delivered %d, read %d; want %d, %d", rc.LastDeliveredID, rc.LastReadID, delivered, read)
+		}
+	}
+	rc, err := AcknowledgeMessage(bob, m2)
+	check(rc, err, m2, 0)
+	// reading an earlier message does not take delivery back
+	rc, err = ReadMessage(bob, m1)
+	check(rc, err, m2, m1)
+	rc, err = AcknowledgeMessage(bob, m1)
+	check(rc, err, m2, m1)
+	// read implies delivered
+	rc, err = ReadMessage(bob, m3)
+	check(rc, err, m3, m3)
+	rc, err = ReadMessage(bob, m2)
+	check(rc, err, m3, m3)
+
+	// only the receiver acknowledges a message
+	if _, err := ReadMessage(alice, m3); err != ErrMessageNotFound {
+		t.Fatalf("sender reading their own message: err = %v, want ErrMessageNotFound", err)
+	}
+
+	// ids from another conversation are clamped to this one
+	if code := call(t, MarkConversationReadHandler, carol, "/api/messages/read", map[string]int64{"user_id": bob, "message_id": m3}, nil); code != http.StatusOK {
+		t.Fatalf("mark read: %d", code)
+	}
+	if rc, _ := loadReceipt(carol, bob); rc.LastReadID != 0 {
+		t.Fatalf("carol read up to %d of bob's messages, she has none", rc.LastReadID)
+	}
+	if code := call(t, MarkConversationReadHandler, bob, "/api/messages/read", map[string]int64{"user_id": carol}, &rc); code != http.StatusOK || rc.LastReadID != other {
+		t.Fatalf("mark read without message_id = %d, %+v; want read up to %d", code, rc, other)
+	}
+
+	var receipts map[string]models.Receipt
+	if code := call(t, GetReceiptsHandler, alice, fmt.Sprintf("/api/messages/receipts?user_id=%d", bob), nil, &receipts); code != http.StatusOK {
+		t.Fatalf("receipts: %d", code)
+	}
+	if receipts["peer"].LastReadID != m3 || receipts["mine"].LastReadID != 0 {
+		t.Fatalf("receipts = %+v, want bob's read cursor at %d", receipts, m3)
+	}
+}
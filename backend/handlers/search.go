@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"social-network/backend/db"
+	"social-network/backend/models"
+	"social-network/backend/utils"
+)
+
+const (
+	searchDefaultLimit = 20
+	searchMaxLimit     = 100
+	// snippet() wraps matches in these control characters; the rest of the
+	// snippet is HTML-escaped before they are swapped for <mark> tags.
+	snippetOpen  = "\x02"
+	snippetClose = "\x03"
+)
+
+// ftsQuery turns free user input into a safe FTS5 query: every term is quoted
+// (so operators and punctuation are taken literally) and prefix-matched.
+func ftsQuery(input string) string {
+	var terms []string
+	for _, t := range strings.Fields(input) {
+		t = strings.ReplaceAll(t, `"`, "")
+		if t == "" {
+			continue
+		}
+		terms = append(terms, `"`+t+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// highlightSnippet escapes a snippet for HTML and marks the matched terms.
+func highlightSnippet(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, snippetOpen, "<mark>")
+	return strings.ReplaceAll(s, snippetClose, "</mark>")
+}
+
+// parseSearchTime accepts a date (YYYY-MM-DD) or an RFC3339 timestamp and
+// returns it in the format SQLite stores CURRENT_TIMESTAMP values in. For
+// date-only upper bounds the whole day is included.
+func parseSearchTime(v string, endOfDay bool) (string, bool) {
+	const stored = "2006-01-02 15:04:05"
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		if endOfDay {
+			t = t.Add(24*time.Hour - time.Second)
+		}
+		return t.Format(stored), true
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC().Format(stored), true
+	}
+	return "", false
+}
+
+// GET /api/search/messages?q=<text>[&sender_id=&group_id=&from=&to=&scope=direct|group&limit=]
+// Searches the requester's DMs and the chats of groups they belong to, newest
+// first, returning highlighted snippets. Deleted and hidden messages are never
+// matched.
+func SearchMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	userIDStr := utils.GetUserIDFromContext(r)
+	if userIDStr == "" {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	q := r.URL.Query()
+	match := ftsQuery(q.Get("q"))
+	if match == "" {
+		utils.Error(w, http.StatusBadRequest, "Missing q parameter")
+		return
+	}
+
+	limit := searchDefaultLimit
+	if l := q.Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed <= 0 {
+			utils.Error(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = parsed
+	}
+	if limit > searchMaxLimit {
+		limit = searchMaxLimit
+	}
+
+	var senderID, groupID int64
+	if v := q.Get("sender_id"); v != "" {
+		if senderID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			utils.Error(w, http.StatusBadRequest, "Invalid sender_id")
+			return
+		}
+	}
+	if v := q.Get("group_id"); v != "" {
+		if groupID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			utils.Error(w, http.StatusBadRequest, "Invalid group_id")
+			return
+		}
+		if !IsGroupMember(groupID, userID) {
+			utils.Error(w, http.StatusForbidden, "Not a member")
+			return
+		}
+	}
+	var from, to string
+	if v := q.Get("from"); v != "" {
+		var ok bool
+		if from, ok = parseSearchTime(v, false); !ok {
+			utils.Error(w, http.StatusBadRequest, "Invalid from date")
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		var ok bool
+		if to, ok = parseSearchTime(v, true); !ok {
+			utils.Error(w, http.StatusBadRequest, "Invalid to date")
+			return
+		}
+	}
+
+	scope := q.Get("scope")
+	if groupID != 0 {
+		scope = "group"
+	}
+	if scope != "" && scope != "direct" && scope != "group" {
+		utils.Error(w, http.StatusBadRequest, "Invalid scope")
+		return
+	}
+
+	// filters shared by both queries, applied to alias m
+	var extra string
+	var extraArgs []interface{}
+	if senderID != 0 {
+		extra += " AND m.sender_id = ?"
+		extraArgs = append(extraArgs, senderID)
+	}
+	if from != "" {
+		extra += " AND m.created_at >= ?"
+		extraArgs = append(extraArgs, from)
+	}
+	if to != "" {
+		extra += " AND m.created_at <= ?"
+		extraArgs = append(extraArgs, to)
+	}
+
+	results := []models.SearchResult{}
+	if scope == "" || scope == "direct" {
+		args := []interface{}{snippetOpen, snippetClose, match, userID, userID, userID}
+		args = append(args, extraArgs...)
+		args = append(args, limit)
+		rows, err := db.DB.Query(`
+			SELECT m.id, m.sender_id, IFNULL(u.nickname, ''), m.receiver_id,
+				snippet(messages_fts, 0, ?, ?, '…', 12), m.created_at
+			FROM messages_fts
+			JOIN messages m ON m.id = messages_fts.rowid
+			LEFT JOIN users u ON u.id = m.sender_id
+			WHERE messages_fts MATCH ?
+				AND (m.sender_id = ? OR m.receiver_id = ?)
+				AND m.deleted_at IS NULL
+				AND m.id NOT IN (SELECT message_id FROM hidden_messages WHERE user_id = ? AND kind = 'direct')`+extra+`
+			ORDER BY m.id DESC
+			LIMIT ?`, args...)
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "Search failed")
+			return
+		}
+		for rows.Next() {
+			res := models.SearchResult{Kind: "direct"}
+			var receiverID int64
+			if err := rows.Scan(&res.MessageID, &res.SenderID, &res.SenderName, &receiverID, &res.Snippet, &res.CreatedAt); err != nil {
+				continue
+			}
+			res.PeerID = receiverID
+			if receiverID == userID {
+				res.PeerID = res.SenderID
+			}
+			res.Snippet = highlightSnippet(res.Snippet)
+			results = append(results, res)
+		}
+		rows.Close()
+	}
+
+	if scope == "" || scope == "group" {
+		groupFilter := "m.group_id IN (SELECT group_id FROM group_members WHERE user_id = ?)"
+		groupArg := userID
+		if groupID != 0 {
+			groupFilter = "m.group_id = ?"
+			groupArg = groupID
+		}
+		args := []interface{}{snippetOpen, snippetClose, match, groupArg, userID}
+		args = append(args, extraArgs...)
+		args = append(args, limit)
+		rows, err := db.DB.Query(`
+			SELECT m.id, m.group_id, IFNULL(g.name, ''), m.sender_id, IFNULL(u.nickname, ''),
+				snippet(group_messages_fts, 0, ?, ?, '…', 12), m.created_at
+			FROM group_messages_fts
+			JOIN group_messages m ON m.id = group_messages_fts.rowid
+			LEFT JOIN groups g ON g.id = m.group_id
+			LEFT JOIN users u ON u.id = m.sender_id
+			WHERE group_messages_fts MATCH ?
+				AND `+groupFilter+`
+				AND m.deleted_at IS NULL
+				AND m.id NOT IN (SELECT message_id FROM hidden_messages WHERE user_id = ? AND kind = 'group')`+extra+`
+			ORDER BY m.id DESC
+			LIMIT ?`, args...)
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "Search failed")
+			return
+		}
+		for rows.Next() {
+			res := models.SearchResult{Kind: "group"}
+			if err := rows.Scan(&res.MessageID, &res.GroupID, &res.GroupName, &res.SenderID, &res.SenderName, &res.Snippet, &res.CreatedAt); err != nil {
+				continue
+			}
+			res.Snippet = highlightSnippet(res.Snippet)
+			results = append(results, res)
+		}
+		rows.Close()
+	}
+
+	// merge both sources newest first
+	sort.SliceStable(results, func(i, j int) bool { return results[i].CreatedAt.After(results[j].CreatedAt) })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	utils.JSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"social-network/backend/db"
+	"social-network/backend/models"
+)
+
+func TestSearchMessages(t *testing.T) {
+	openTestDB(t)
+	alice, bob, carol := createTestUser(t, "alice"), createTestUser(t, "bob"), createTestUser(t, "carol")
+	res, err := db.DB.Exec("INSERT INTO groups (owner_id, name) VALUES (?, 'hikers')", bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, _ := res.LastInsertId()
+	db.DB.Exec("INSERT INTO group_members (group_id, user_id) VALUES (?, ?), (?, ?)", group, alice, group, bob)
+	sendGroup := func(senderID int64, content string) int64 {
+		t.Helper()
+		res, err := db.DB.Exec("INSERT INTO group_messages (group_id, sender_id, content) VALUES (?, ?, ?)", group, senderID, content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		return id
+	}
+
+	dm := sendTestDM(t, alice, bob, "Lunch at the <b>café</b> tomorrow?")
+	reply := sendTestDM(t, bob, alice, "lunchtime works")
+	hidden := sendTestDM(t, bob, alice, "lunch hidden")
+	deleted := sendTestDM(t, alice, bob, "lunch deleted")
+	sendTestDM(t, carol, bob, "lunch without alice")
+	trip := sendGroup(bob, "Lunch on the summit")
+	sendGroup(alice, "NOT lunch OR dinner")
+	if err := DeleteDirectMessage(alice, hidden, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteDirectMessage(alice, deleted, true); err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(query string) []models.SearchResult {
+		t.Helper()
+		var results []models.SearchResult
+		if code := call(t, SearchMessagesHandler, alice, "/api/search/messages?"+query, nil, &results); code != http.StatusOK {
+			t.Fatalf("search %s: %d", query, code)
+		}
+		return results
+	}
+	ids := func(query string) []int64 {
+		t.Helper()
+		var out []int64
+		for _, r := range search(query) {
+			out = append(out, r.MessageID)
+		}
+		slices.Sort(out)
+		return out
+	}
+
+	// the FTS5 indexes are kept in sync by their triggers
+	var indexed, stored int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM messages_fts WHERE messages_fts MATCH 'lunch*'").Scan(&indexed); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.QueryRow("SELECT COUNT(*) FROM messages WHERE content LIKE '%lunch%'").Scan(&stored)
+	if indexed == 0 || indexed != stored {
+		t.Fatalf("messages_fts has %d lunch messages, want %d", indexed, stored)
+	}
+
+	// prefix matching; hidden, deleted and other people's messages never match
+	if got, want := ids("q=lunch&scope=direct"), []int64{dm, reply}; !slices.Equal(got, want) {
+		t.Errorf("direct lunch = %v, want %v", got, want)
+	}
+	if got, want := ids("q=lunch&scope=group"), []int64{trip, trip + 1}; !slices.Equal(got, want) {
+		t.Errorf("group lunch = %v, want %v", got, want)
+	}
+	// the sender filter applies to DMs and group chats alike
+	if got := search(fmt.Sprintf("q=lunch&sender_id=%d", bob)); len(got) != 2 || got[0].Kind == got[1].Kind {
+		t.Errorf("lunch from bob = %+v, want his DM and his group message", got)
+	}
+	// diacritics are folded and FTS operators are taken literally
+	if got := ids("q=cafe"); !slices.Equal(got, []int64{dm}) {
+		t.Errorf("cafe = %v, want %v", got, []int64{dm})
+	}
+	if got := ids("q=" + url.QueryEscape(`NOT lunch "OR`)); !slices.Equal(got, []int64{trip + 1}) {
+		t.Errorf("literal operators = %v, want %v", got, []int64{trip + 1})
+	}
+
+	results := search("q=caf")
+	if len(results) != 1 || results[0].Kind != "direct" || results[0].PeerID != bob || results[0].SenderName != "alice" ||
+		results[0].Snippet != "Lunch at the &lt;b&gt;<mark>café</mark>&lt;/b&gt; tomorrow?" {
+		t.Errorf("caf = %+v, want alice's DM to bob with an escaped, highlighted snippet", results)
+	}
+
+	// edits are reindexed
+	if err := EditDirectMessage(alice, dm, "dinner instead"); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids("q=cafe"); len(got) != 0 {
+		t.Errorf("cafe after the edit = %v, want nothing", got)
+	}
+	if got := ids("q=dinner&scope=direct"); !slices.Equal(got, []int64{dm}) {
+		t.Errorf("dinner after the edit = %v, want %v", got, []int64{dm})
+	}
+
+	if code := call(t, SearchMessagesHandler, carol, fmt.Sprintf("/api/search/messages?q=lunch&group_id=%d", group), nil, nil); code != http.StatusForbidden {
+		t.Errorf("non-member searching the group = %d, want 403", code)
+	}
+	for _, query := range []string{"", "q=%22%22", "q=a&limit=0", "q=a&from=yesterday", "q=a&scope=all", "q=a&sender_id=x"} {
+		if code := call(t, SearchMessagesHandler, alice, "/api/search/messages?"+query, nil, nil); code != http.StatusBadRequest {
+			t.Errorf("search %q = %d, want 400", query, code)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"social-network/backend/bus"
+	"social-network/backend/db"
+	"social-network/backend/utils"
+)
+
+// openTestDB points db.DB at a fresh, migrated database for the test. It
+// runs from the repository root, where InitDB finds the migrations. As in
+// main, published notifications are pumped to bus.NotificationChan.
+func openTestDB(t *testing.T) {
+	t.Helper()
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+	t.Chdir("../..")
+	db.InitDB()
+	t.Cleanup(func() { db.DB.Close() })
+	bus.StartPump()
+}
+
+// createTestUser adds a user with the given nickname and returns its id.
+func createTestUser(t *testing.T, nickname string) int64 {
+	t.Helper()
+	res, err := db.DB.Exec("INSERT INTO users (email, password, first_name, last_name, nickname) VALUES (?, 'x', ?, 'L', ?)",
+		nickname+"@example.com", nickname, nickname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
+
+func follow(t *testing.T, followerID, followedID int64) {
+	t.Helper()
+	if _, err := db.DB.Exec("INSERT INTO followers (follower_id, followed_id) VALUES (?, ?)", followerID, followedID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// call runs handler as userID (0 for anonymous) with body JSON-encoded (GET
+// when nil) and decodes the response into out.
+func call(t *testing.T, handler http.HandlerFunc, userID int64, target string, body, out interface{}) int {
+	t.Helper()
+	method, reader := http.MethodGet, &bytes.Buffer{}
+	if body != nil {
+		method = http.MethodPost
+		json.NewEncoder(reader).Encode(body)
+	}
+	r := httptest.NewRequest(method, target, reader)
+	if userID != 0 {
+		r = r.WithContext(context.WithValue(r.Context(), utils.UserIDKey, strconv.FormatInt(userID, 10)))
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, target, w.Body.String(), err)
+		}
+	}
+	return w.Code
+}
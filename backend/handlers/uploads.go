@@ -3,10 +3,13 @@ package handlers
 import (
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"social-network/backend/db"
 	"social-network/backend/utils"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -35,12 +38,29 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 	defer file.Close()
 
 	// Check the file type
-	uploadType := r.FormValue("type") // "avatar" or "post"
-	if uploadType != "avatar" && uploadType != "post" {
+	uploadType := r.FormValue("type") // "avatar", "post" or "chat"
+	if uploadType != "avatar" && uploadType != "post" && uploadType != "chat" {
 		utils.Error(w, http.StatusBadRequest, "Invalid upload type specified")
 		return
 	}
 
+	// Chat attachments are stored privately and served through
+	// /api/attachments/<id> with participant checks.
+	if uploadType == "chat" {
+		uploaderID, _ := strconv.ParseInt(requestingUserIDStr, 10, 64)
+		att, err := saveChatAttachment(uploaderID, file, handler)
+		if err == ErrAttachmentType {
+			utils.Error(w, http.StatusBadRequest, "Unsupported file type")
+			return
+		}
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "Could not save file")
+			return
+		}
+		utils.JSON(w, http.StatusOK, att)
+		return
+	}
+
 	// Create a unique filename to avoid collisions
 	ext := filepath.Ext(handler.Filename)
 	filename := fmt.Sprintf("%d-%s%s", time.Now().UnixNano(), requestingUserIDStr, ext)
@@ -83,3 +103,51 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 		"url": relPath,
 	})
 }
+
+// savePostImage stores an image uploaded with a group post form under
+// backend/uploads/posts and returns its /uploads/ URL.
+func savePostImage(userID int64, file io.Reader, filename string) (string, error) {
+	dir := filepath.Join("backend", "uploads", "posts")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%d-%d%s", time.Now().UnixNano(), userID, strings.ToLower(filepath.Ext(filename)))
+	dst, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, file); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return "/uploads/posts/" + name, nil
+}
+
+// uploadInUse reports whether an avatar, post or comment, or an earlier
+// version of one kept in post_edits, still refers to the /uploads/ URL url.
+func uploadInUse(url string) (bool, error) {
+	var used bool
+	err := db.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE instr(avatar, ?) > 0)
+		OR EXISTS(SELECT 1 FROM posts WHERE instr(image_url, ?) > 0)
+		OR EXISTS(SELECT 1 FROM comments WHERE instr(image_url, ?) > 0)
+		OR EXISTS(SELECT 1 FROM group_posts WHERE instr(image_url, ?) > 0)
+		OR EXISTS(SELECT 1 FROM post_edits WHERE instr(image_url, ?) > 0)`, url, url, url, url, url).Scan(&used)
+	return used, err
+}
+
+// removeUpload deletes the file behind an /uploads/ URL once nothing refers
+// to it any more, e.g. after the post or comment showing it was deleted.
+// Other URLs are left alone.
+func removeUpload(url string) {
+	rel, ok := strings.CutPrefix(normalizeURL(url), "/uploads/")
+	if !ok || rel == "" || strings.Contains(rel, "..") {
+		return
+	}
+	if used, err := uploadInUse("/uploads/" + rel); err != nil || used {
+		return
+	}
+	if err := os.Remove(filepath.Join("backend", "uploads", filepath.FromSlash(rel))); err != nil && !os.IsNotExist(err) {
+		log.Printf("remove upload %s: %v", url, err)
+	}
+}
@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"social-network/backend/db"
+
+	"github.com/google/uuid"
+)
+
+// Webhook deliveries are queued in webhook_deliveries by emitWebhookEvent
+// and posted by RunWebhookDeliveries. A failed attempt (network error or
+// non-2xx answer) is retried after each webhookBackoff step, then the
+// delivery is marked failed.
+
+var webhookBackoff = []time.Duration{30 * time.Second, 2 * time.Minute, 10 * time.Minute, time.Hour, 6 * time.Hour}
+
+const (
+	// webhookLease is how long an attempt may take before another worker
+	// (on this or another instance) may pick the delivery up again.
+	webhookLease = time.Minute
+	// webhookBatchSize bounds the deliveries attempted concurrently.
+	webhookBatchSize = 8
+	// maxWebhookResponse is how much of a response body is read (and
+	// discarded) so the connection can be reused.
+	maxWebhookResponse = 1024
+	// webhookRetention is how long the delivery log is kept.
+	webhookRetention = 30 * 24 * time.Hour
+)
+
+// WebhookAllowPrivate lets hooks reach loopback and private addresses, for
+// trying integrations on a development machine (WEBHOOK_ALLOW_PRIVATE).
+var WebhookAllowPrivate bool
+
+var errWebhookAddress = errors.New("webhook address is not public")
+
+// blockedWebhookAddr reports whether hooks may not be sent to addr:
+// loopback, private, link-local, unspecified and multicast addresses, which
+// would let users reach this server's own network.
+func blockedWebhookAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() ||
+		webhookCGNAT.Contains(addr)
+}
+
+// webhookCGNAT is the shared address space of carrier-grade NAT, also used
+// inside cloud networks.
+var webhookCGNAT = netip.MustParsePrefix("100.64.0.0/10")
+
+// webhookDialControl checks the address a connection is made to, after
+// DNS resolution, so a hostname cannot be pointed at a blocked address
+// once the hook is saved.
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+	if WebhookAllowPrivate {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil || blockedWebhookAddr(addrPort.Addr()) {
+		return errWebhookAddress
+	}
+	return nil
+}
+
+var (
+	webhookClient = &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			// direct connections only, so the dialer sees the hook's address
+			Proxy:               nil,
+			DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: webhookDialControl}).DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConnsPerHost: 2,
+		},
+		// a redirect is answered like any other non-2xx status
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	// webhookWake makes the worker look for due deliveries right away.
+	webhookWake = make(chan struct{}, 1)
+)
+
+// webhookEnvelope is the JSON body of every delivery.
+type webhookEnvelope struct {
+	ID        string                 `json:"id"`
+	Event     string                 `json:"event"`
+	CreatedAt string                 `json:"created_at"`
+	ActorID   int64                  `json:"actor_id,omitempty"`
+	GroupID   int64                  `json:"group_id,omitempty"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// emitWebhookEvent queues event for every active hook subscribed to it:
+// hooks on groupID, and the user hooks of its members; for events outside
+// a group (groupID 0) the user hooks of actorID. Handlers reach it through
+// Emit.
+func emitWebhookEvent(event string, actorID, groupID int64, data map[string]interface{}) {
+	rows, err := db.DB.Query(`SELECT id FROM webhooks
+		WHERE active = 1 AND (',' || events || ',') LIKE ('%,' || ? || ',%')
+			AND ((group_id IS NOT NULL AND group_id = ?)
+				OR (group_id IS NULL AND ((? = 0 AND user_id = ?)
+					OR user_id IN (SELECT user_id FROM group_members WHERE group_id = ?))))`,
+		event, groupID, groupID, actorID, groupID)
+	if err != nil {
+		log.Println("webhook lookup error:", err)
+		return
+	}
+	var hookIDs []int64
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			hookIDs = append(hookIDs, id)
+		}
+	}
+	rows.Close()
+	if len(hookIDs) == 0 {
+		return
+	}
+
+	body, _ := json.Marshal(webhookEnvelope{
+		ID:        uuid.New().String(),
+		Event:     event,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		ActorID:   actorID,
+		GroupID:   groupID,
+		Data:      data,
+	})
+	for _, hookID := range hookIDs {
+		if _, err := queueWebhookDelivery(hookID, event, body, len(webhookBackoff)+1, true); err != nil {
+			log.Printf("queue webhook %d delivery: %v", hookID, err)
+		}
+	}
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// queueWebhookDelivery logs a delivery of body to hookID. Unless queued, the
+// worker leaves it alone and the caller attempts it.
+func queueWebhookDelivery(hookID int64, event string, body []byte, maxAttempts int, queued bool) (int64, error) {
+	var next interface{}
+	if queued {
+		next = time.Now().UTC().Format(sqliteTimeFormat)
+	}
+	res, err := db.DB.Exec(`INSERT INTO webhook_deliveries (webhook_id, delivery_id, event, payload, max_attempts, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, hookID, uuid.New().String(), event, string(body), maxAttempts, next)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// RunWebhookDeliveries attempts due deliveries until the process exits.
+func RunWebhookDeliveries() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		if deliverDueWebhooks() == webhookBatchSize {
+			continue // a full batch: more may be due
+		}
+		select {
+		case <-ticker.C:
+		case <-webhookWake:
+		}
+	}
+}
+
+// deliverDueWebhooks attempts up to webhookBatchSize due deliveries
+// concurrently and returns how many it claimed.
+func deliverDueWebhooks() int {
+	now := time.Now().UTC().Format(sqliteTimeFormat)
+	rows, err := db.DB.Query(`SELECT id FROM webhook_deliveries WHERE status = 'pending' AND next_attempt_at <= ?
+		ORDER BY next_attempt_at, id LIMIT ?`, now, webhookBatchSize)
+	if err != nil {
+		log.Println("webhook queue error:", err)
+		return 0
+	}
+	var due []int64
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			due = append(due, id)
+		}
+	}
+	rows.Close()
+
+	var wg sync.WaitGroup
+	claimed := 0
+	lease := time.Now().UTC().Add(webhookLease).Format(sqliteTimeFormat)
+	for _, id := range due {
+		// claim by pushing next_attempt_at past the lease, so no other
+		// worker attempts it concurrently
+		res, err := db.DB.Exec(`UPDATE webhook_deliveries SET next_attempt_at = ?
+			WHERE id = ? AND status = 'pending' AND next_attempt_at <= ?`, lease, id, now)
+		if err != nil {
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		claimed++
+		wg.Add(1)
+		go func(id int64) {
+			defer wg.Done()
+			attemptWebhookDelivery(id)
+		}(id)
+	}
+	wg.Wait()
+	return claimed
+}
+
+// attemptWebhookDelivery posts a claimed delivery once and records the
+// outcome: succeeded, retried later, or failed after max_attempts.
+func attemptWebhookDelivery(id int64) {
+	var d struct {
+		deliveryID, event, payload string
+		attempts, maxAttempts      int
+		url, secret                sql.NullString
+		active                     sql.NullBool
+	}
+	err := db.DB.QueryRow(`SELECT d.delivery_id, d.event, d.payload, d.attempts, d.max_attempts, w.url, w.secret, w.active
+		FROM webhook_deliveries d LEFT JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.id = ?`, id).Scan(&d.deliveryID, &d.event, &d.payload, &d.attempts, &d.maxAttempts, &d.url, &d.secret, &d.active)
+	if err != nil {
+		log.Printf("webhook delivery %d: %v", id, err)
+		return
+	}
+	if !d.url.Valid || !d.active.Bool {
+		db.DB.Exec("UPDATE webhook_deliveries SET status = 'failed', next_attempt_at = NULL, error = ? WHERE id = ?", "webhook deleted or disabled", id)
+		return
+	}
+
+	status, err := postWebhook(d.url.String, d.secret.String, d.event, d.deliveryID, []byte(d.payload))
+	attempts := d.attempts + 1
+	now := time.Now().UTC()
+	var errMsg interface{}
+	var responseStatus interface{}
+	if status > 0 {
+		responseStatus = status
+	}
+	switch {
+	case err != nil:
+		errMsg = err.Error()
+	case status < 200 || status > 299:
+		errMsg = fmt.Sprintf("unexpected status %d", status)
+	}
+
+	state, next := "succeeded", interface{}(nil)
+	if errMsg != nil {
+		state = "failed"
+		if attempts < d.maxAttempts {
+			state = "pending"
+			next = now.Add(webhookBackoff[min(attempts-1, len(webhookBackoff)-1)]).Format(sqliteTimeFormat)
+		}
+	}
+	_, err = db.DB.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?, last_attempt_at = ?,
+		response_status = ?, error = ? WHERE id = ?`,
+		state, attempts, next, now.Format(sqliteTimeFormat), responseStatus, errMsg, id)
+	if err != nil {
+		log.Printf("webhook delivery %d: %v", id, err)
+	}
+}
+
+// postWebhook sends one signed request. The X-Webhook-Signature header is
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>" with the secret>";
+// receivers should recompute it and reject old timestamps. Only the status
+// of the answer is kept: the body could be anything the URL serves.
+func postWebhook(url, secret, event, deliveryID string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "social-network-webhooks/1")
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set("X-Webhook-Delivery", deliveryID)
+	req.Header.Set("X-Webhook-Signature", "t="+timestamp+",v1="+signWebhook(secret, timestamp, body))
+	resp, err := webhookClient.Do(req)
+	if errors.Is(err, errWebhookAddress) {
+		return 0, errWebhookAddress
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxWebhookResponse))
+	return resp.StatusCode, nil
+}
+
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CleanupWebhookDeliveries deletes finished deliveries older than
+// webhookRetention.
+func CleanupWebhookDeliveries() error {
+	cutoff := time.Now().UTC().Add(-webhookRetention).Format(sqliteTimeFormat)
+	_, err := db.DB.Exec("DELETE FROM webhook_deliveries WHERE status != 'pending' AND created_at < ?", cutoff)
+	return err
+}
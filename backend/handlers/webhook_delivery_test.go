@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"social-network/backend/db"
+)
+
+// webhookReceiver is a test endpoint that answers with the queued statuses
+// (200 once they run out) and records what it got.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.requests = append(rcv.requests, r)
+	rcv.bodies = append(rcv.bodies, body)
+	status := http.StatusOK
+	if len(rcv.statuses) > 0 {
+		status, rcv.statuses = rcv.statuses[0], rcv.statuses[1:]
+	}
+	w.WriteHeader(status)
+	io.WriteString(w, "got it")
+}
+
+// allowPrivateWebhooks lets the test's hooks reach httptest servers on
+// loopback addresses.
+func allowPrivateWebhooks(t *testing.T) {
+	WebhookAllowPrivate = true
+	t.Cleanup(func() { WebhookAllowPrivate = false })
+}
+
+// insertTestWebhook adds an active hook owned by userID, on groupID when
+// it is not 0.
+func insertTestWebhook(t *testing.T, userID, groupID int64, url string, events ...string) int64 {
+	t.Helper()
+	var group interface{}
+	if groupID != 0 {
+		group = groupID
+	}
+	res, err := db.DB.Exec("INSERT INTO webhooks (user_id, group_id, url, secret, events) VALUES (?, ?, ?, 'whsec_test', ?)",
+		userID, group, url, strings.Join(events, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
+
+// queuedHooks returns the hooks with a delivery of event, in id order.
+func queuedHooks(t *testing.T, event string) []int64 {
+	t.Helper()
+	rows, err := db.DB.Query("SELECT webhook_id FROM webhook_deliveries WHERE event = ? ORDER BY webhook_id", event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestWebhookSignature(t *testing.T) {
+	allowPrivateWebhooks(t)
+	rcv := &webhookReceiver{statuses: []int{http.StatusAccepted}}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	body := []byte(`{"event":"post_created"}`)
+	status, err := postWebhook(srv.URL, "whsec_test", "post_created", "d-1", body)
+	if err != nil || status != http.StatusAccepted {
+		t.Fatalf("postWebhook = %d %v", status, err)
+	}
+	r := rcv.requests[0]
+	if r.Header.Get("X-Webhook-Event") != "post_created" || r.Header.Get("X-Webhook-Delivery") != "d-1" {
+		t.Errorf("headers = %v", r.Header)
+	}
+	// the receiver can recompute the signature from the timestamp and body
+	sig := r.Header.Get("X-Webhook-Signature")
+	ts, mac, ok := strings.Cut(strings.TrimPrefix(sig, "t="), ",v1=")
+	if !ok {
+		t.Fatalf("X-Webhook-Signature = %q", sig)
+	}
+	if unix, _ := strconv.ParseInt(ts, 10, 64); time.Since(time.Unix(unix, 0)) > time.Minute {
+		t.Errorf("signature timestamp %s is not now", ts)
+	}
+	if mac != signWebhook("whsec_test", ts, rcv.bodies[0]) || string(rcv.bodies[0]) != string(body) {
+		t.Errorf("signature %q does not match the body", sig)
+	}
+	if mac == signWebhook("whsec_other", ts, body) {
+		t.Error("the signature does not depend on the secret")
+	}
+}
+
+func TestWebhookDeliveryRetries(t *testing.T) {
+	openTestDB(t)
+	allowPrivateWebhooks(t)
+	rcv := &webhookReceiver{statuses: []int{http.StatusInternalServerError}}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	alice := createTestUser(t, "alice")
+	hookID := insertTestWebhook(t, alice, 0, srv.URL, "post_created")
+
+	emitWebhookEvent("post_created", alice, 0, map[string]interface{}{"post_id": 1})
+	var id int64
+	db.DB.QueryRow("SELECT id FROM webhook_deliveries WHERE webhook_id = ?", hookID).Scan(&id)
+
+	// a 500 is retried after the first backoff step
+	if n := deliverDueWebhooks(); n != 1 {
+		t.Fatalf("claimed %d deliveries, want 1", n)
+	}
+	d, _ := loadWebhookDelivery(id)
+	if d.Status != "pending" || d.Attempts != 1 || d.MaxAttempts != len(webhookBackoff)+1 || d.ResponseStatus == nil || *d.ResponseStatus != 500 || d.Error == nil {
+		t.Fatalf("after a 500 the delivery is %+v", d)
+	}
+	next, _ := time.Parse(time.RFC3339, *d.NextAttemptAt)
+	if wait := time.Until(next); wait < webhookBackoff[0]-5*time.Second || wait > webhookBackoff[0] {
+		t.Errorf("next attempt in %s, want %s", wait, webhookBackoff[0])
+	}
+	if n := deliverDueWebhooks(); n != 0 {
+		t.Errorf("claimed %d deliveries before the retry was due", n)
+	}
+
+	db.DB.Exec("UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id = ?", time.Now().UTC().Add(-time.Second).Format(sqliteTimeFormat), id)
+	deliverDueWebhooks()
+	if d, _ = loadWebhookDelivery(id); d.Status != "succeeded" || d.Attempts != 2 || d.NextAttemptAt != nil || d.Error != nil {
+		t.Errorf("after a 200 the delivery is %+v", d)
+	}
+	if len(rcv.requests) != 2 || rcv.requests[0].Header.Get("X-Webhook-Delivery") != rcv.requests[1].Header.Get("X-Webhook-Delivery") {
+		t.Error("the retry did not reuse the delivery id")
+	}
+
+	// the last allowed attempt fails for good
+	rcv.statuses = []int{http.StatusBadGateway}
+	emitWebhookEvent("post_created", alice, 0, map[string]interface{}{"post_id": 2})
+	db.DB.QueryRow("SELECT id FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC", hookID).Scan(&id)
+	db.DB.Exec("UPDATE webhook_deliveries SET attempts = max_attempts - 1 WHERE id = ?", id)
+	deliverDueWebhooks()
+	if d, _ = loadWebhookDelivery(id); d.Status != "failed" || d.Attempts != d.MaxAttempts || d.NextAttemptAt != nil {
+		t.Errorf("after the last attempt the delivery is %+v", d)
+	}
+
+	// a disabled hook fails its pending deliveries without posting
+	emitWebhookEvent("post_created", alice, 0, map[string]interface{}{"post_id": 3})
+	db.DB.Exec("UPDATE webhooks SET active = 0 WHERE id = ?", hookID)
+	posted := len(rcv.requests)
+	deliverDueWebhooks()
+	db.DB.QueryRow("SELECT id FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC", hookID).Scan(&id)
+	if d, _ = loadWebhookDelivery(id); d.Status != "failed" || len(rcv.requests) != posted {
+		t.Errorf("delivery to a disabled hook = %+v after %d requests", d, len(rcv.requests)-posted)
+	}
+}
+
+func TestEmitWebhookEventTargets(t *testing.T) {
+	openTestDB(t)
+	alice, bob, carol := createTestUser(t, "alice"), createTestUser(t, "bob"), createTestUser(t, "carol")
+	res, _ := db.DB.Exec("INSERT INTO groups (owner_id, name) VALUES (?, 'g')", alice)
+	groupID, _ := res.LastInsertId()
+	db.DB.Exec("INSERT INTO group_members (group_id, user_id) VALUES (?, ?), (?, ?)", groupID, alice, groupID, bob)
+
+	const url = "http://hooks.example.com/"
+	aliceHook := insertTestWebhook(t, alice, 0, url, "post_created", "group_post_created")
+	bobHook := insertTestWebhook(t, bob, 0, url, "group_post_created")
+	carolHook := insertTestWebhook(t, carol, 0, url, "post_created", "group_post_created")
+	groupHook := insertTestWebhook(t, alice, groupID, url, "group_post_created")
+	insertTestWebhook(t, alice, groupID, url, "group_event_created") // another event
+	disabled := insertTestWebhook(t, bob, 0, url, "post_created", "group_post_created")
+	db.DB.Exec("UPDATE webhooks SET active = 0 WHERE id = ?", disabled)
+
+	// a post outside groups goes to the author's hooks only
+	emitWebhookEvent("post_created", alice, 0, map[string]interface{}{"post_id": 1})
+	if got := queuedHooks(t, "post_created"); !slices.Equal(got, []int64{aliceHook}) {
+		t.Errorf("post_created queued for %v, want [%d]", got, aliceHook)
+	}
+	// group activity goes to the group's hooks and its members' hooks
+	emitWebhookEvent("group_post_created", bob, groupID, map[string]interface{}{"post_id": 2})
+	if got := queuedHooks(t, "group_post_created"); !slices.Equal(got, []int64{aliceHook, bobHook, groupHook}) {
+		t.Errorf("group_post_created queued for %v, want [%d %d %d] and not carol's %d", got, aliceHook, bobHook, groupHook, carolHook)
+	}
+
+	var payload webhookEnvelope
+	var body string
+	db.DB.QueryRow("SELECT payload FROM webhook_deliveries WHERE event = 'group_post_created' LIMIT 1").Scan(&body)
+	json.Unmarshal([]byte(body), &payload)
+	if payload.ID == "" || payload.ActorID != bob || payload.GroupID != groupID || payload.Data["post_id"] != 2.0 {
+		t.Errorf("payload = %+v", payload)
+	}
+}
+
+func TestEmitActivity(t *testing.T) {
+	openTestDB(t)
+	alice, bob := createTestUser(t, "alice"), createTestUser(t, "bob")
+	res, _ := db.DB.Exec("INSERT INTO groups (owner_id, name) VALUES (?, 'g')", alice)
+	groupID, _ := res.LastInsertId()
+	db.DB.Exec("INSERT INTO group_members (group_id, user_id) VALUES (?, ?), (?, ?)", groupID, alice, groupID, bob)
+	hook := insertTestWebhook(t, alice, groupID, "http://hooks.example.com/", "group_member_joined")
+
+	// one action both notifies and reaches the hooks
+	Emit(Activity{
+		Event: "group_member_joined", ActorID: bob, GroupID: groupID,
+		Data:       map[string]interface{}{"group_id": groupID, "user_id": bob},
+		Recipients: []int64{alice}, NotifyType: "group_invite_response",
+		Notification: map[string]interface{}{"status": "accepted", "group_id": groupID},
+	})
+	if n := countNotifications(t, alice, "group_invite_response"); n != 1 {
+		t.Errorf("alice got %d notifications, want 1", n)
+	}
+	if got := queuedHooks(t, "group_member_joined"); !slices.Equal(got, []int64{hook}) {
+		t.Errorf("group_member_joined queued for %v, want [%d]", got, hook)
+	}
+
+	// activity without an event only notifies
+	Emit(Activity{ActorID: bob, Recipients: []int64{alice}, NotifyType: "group_invite_response", Notification: map[string]interface{}{"status": "declined"}})
+	var deliveries int
+	db.DB.QueryRow("SELECT COUNT(*) FROM webhook_deliveries").Scan(&deliveries)
+	if deliveries != 1 {
+		t.Errorf("%d deliveries queued, want 1", deliveries)
+	}
+}
+
+func TestWebhookHandlers(t *testing.T) {
+	openTestDB(t)
+	allowPrivateWebhooks(t)
+	rcv := &webhookReceiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	alice, bob := createTestUser(t, "alice"), createTestUser(t, "bob")
+	res, _ := db.DB.Exec("INSERT INTO groups (owner_id, name) VALUES (?, 'g')", alice)
+	groupID, _ := res.LastInsertId()
+
+	var h webhook
+	create := map[string]interface{}{"url": srv.URL, "events": []string{"group_post_created", "post_created", "post_created"}}
+	if code := call(t, CreateWebhookHandler, alice, "/api/webhooks/create", create, &h); code != http.StatusCreated {
+		t.Fatalf("create = %d", code)
+	}
+	if !strings.HasPrefix(h.Secret, "whsec_") || !slices.Equal(h.Events, []string{"post_created", "group_post_created"}) || !h.Active {
+		t.Errorf("created hook = %+v", h)
+	}
+	for _, tc := range []struct {
+		userID int64
+		body   map[string]interface{}
+		want   int
+	}{
+		{alice, map[string]interface{}{"url": "ftp://example.com", "events": []string{"post_created"}}, http.StatusBadRequest},
+		{alice, map[string]interface{}{"url": srv.URL, "events": []string{}}, http.StatusBadRequest},
+		{alice, map[string]interface{}{"url": srv.URL, "events": []string{"post_deleted"}}, http.StatusBadRequest},
+		{bob, map[string]interface{}{"url": srv.URL, "events": []string{"group_post_created"}, "group_id": groupID}, http.StatusForbidden},
+		{alice, map[string]interface{}{"url": srv.URL, "events": []string{"group_post_created"}, "group_id": groupID}, http.StatusCreated},
+	} {
+		if code := call(t, CreateWebhookHandler, tc.userID, "/api/webhooks/create", tc.body, nil); code != tc.want {
+			t.Errorf("create %v as %d = %d, want %d", tc.body, tc.userID, code, tc.want)
+		}
+	}
+
+	var list struct {
+		Webhooks []webhook `json:"webhooks"`
+	}
+	call(t, ListWebhooksHandler, alice, "/api/webhooks", nil, &list)
+	if len(list.Webhooks) != 2 || list.Webhooks[0].Secret != "" || list.Webhooks[1].GroupID == nil {
+		t.Errorf("alice's hooks = %+v", list.Webhooks)
+	}
+
+	// the test endpoint posts a ping right away, signed with the secret
+	var d webhookDelivery
+	if code := call(t, TestWebhookHandler, alice, "/api/webhooks/test", map[string]int64{"id": h.ID}, &d); code != http.StatusOK || d.Event != "ping" || d.Status != "succeeded" {
+		t.Fatalf("test = %d %+v", code, d)
+	}
+	ts, mac, _ := strings.Cut(strings.TrimPrefix(rcv.requests[0].Header.Get("X-Webhook-Signature"), "t="), ",v1=")
+	if mac != signWebhook(h.Secret, ts, rcv.bodies[0]) {
+		t.Error("the ping is not signed with the hook's secret")
+	}
+	var log []webhookDelivery
+	if code := call(t, WebhookDeliveriesHandler, alice, "/api/webhooks/deliveries?webhook_id="+strconv.FormatInt(h.ID, 10), nil, &log); code != http.StatusOK || len(log) != 1 || log[0].ID != d.ID {
+		t.Errorf("delivery log = %d %+v", code, log)
+	}
+	if code := call(t, TestWebhookHandler, bob, "/api/webhooks/test", map[string]int64{"id": h.ID}, nil); code != http.StatusNotFound {
+		t.Errorf("testing another user's hook = %d, want 404", code)
+	}
+
+	var updated webhook
+	update := map[string]interface{}{"id": h.ID, "active": false, "events": []string{"post_created"}}
+	if code := call(t, UpdateWebhookHandler, alice, "/api/webhooks/update", update, &updated); code != http.StatusOK || updated.Active || !slices.Equal(updated.Events, []string{"post_created"}) {
+		t.Errorf("update = %d %+v", code, updated)
+	}
+	if code := call(t, DeleteWebhookHandler, bob, "/api/webhooks/delete", map[string]int64{"id": h.ID}, nil); code != http.StatusNotFound {
+		t.Errorf("deleting another user's hook = %d, want 404", code)
+	}
+	if code := call(t, DeleteWebhookHandler, alice, "/api/webhooks/delete", map[string]int64{"id": h.ID}, nil); code != http.StatusOK {
+		t.Errorf("delete = %d", code)
+	}
+	var left int
+	db.DB.QueryRow("SELECT COUNT(*) FROM webhook_deliveries WHERE webhook_id = ?", h.ID).Scan(&left)
+	if left != 0 {
+		t.Errorf("%d deliveries left after deleting the hook", left)
+	}
+}
+
+func TestValidWebhookURL(t *testing.T) {
+	for raw, want := range map[string]bool{
+		"https://hooks.example.com/in":            true,
+		"http://203.0.113.7:8080/x":               true,
+		"ftp://example.com/":                      false,
+		"/relative":                               false,
+		"http://localhost:8080/":                  false,
+		"http://api.localhost/":                   false,
+		"http://127.0.0.1/":                       false,
+		"http://10.1.2.3/":                        false,
+		"http://192.168.0.10/":                    false,
+		"http://169.254.169.254/latest/":          false,
+		"http://0.0.0.0/":                         false,
+		"http://[::1]/":                           false,
+		"http://[fe80::1]/":                       false,
+		"http://[::ffff:127.0.0.1]/":              false,
+		"http://100.100.100.200/latest/meta-data": false,
+	} {
+		if got := validWebhookURL(raw); got != want {
+			t.Errorf("validWebhookURL(%q) = %v, want %v", raw, got, want)
+		}
+	}
+}
+
+func TestBlockedWebhookAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"8.8.8.8":     false,
+		"2001:db8::1": false,
+		"127.0.0.2":   true,
+		"172.16.5.4":  true,
+		"fd00::1":     true,
+		"224.0.0.1":   true,
+		"::":          true,
+	} {
+		if got := blockedWebhookAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("blockedWebhookAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestPostWebhookRefusesLocalAddresses(t *testing.T) {
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/target", http.StatusFound)
+			return
+		}
+		w.Write([]byte("secret"))
+	}))
+	defer srv.Close()
+
+	// the dialer refuses the address itself, whatever the saved URL passed
+	if _, err := postWebhook(srv.URL, "s", "ping", "d", []byte("{}")); !errors.Is(err, errWebhookAddress) {
+		t.Fatalf("postWebhook to %s: err = %v, want errWebhookAddress", srv.URL, err)
+	}
+	if hits != 0 {
+		t.Fatalf("server was reached %d times", hits)
+	}
+
+	allowPrivateWebhooks(t)
+	status, err := postWebhook(srv.URL+"/redirect", "s", "ping", "d", []byte("{}"))
+	if err != nil || status != http.StatusFound {
+		t.Fatalf("postWebhook with a redirect = %d, %v; want 302 and no error", status, err)
+	}
+	if hits != 1 {
+		t.Fatalf("redirect was followed: %d requests", hits)
+	}
+}
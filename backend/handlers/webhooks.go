@@ -0,0 +1,409 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"social-network/backend/db"
+	"social-network/backend/utils"
+
+	"github.com/google/uuid"
+)
+
+// WebhookEvents are the event types hooks can subscribe to. "ping" is only
+// sent by the test endpoint.
+var WebhookEvents = []string{
+	"post_created",        // a post by the hook's user
+	"group_post_created",  // a post in the group
+	"group_event_created", // an event scheduled in the group
+	"group_member_joined", // an accepted invite or join request
+}
+
+// maxWebhooksPerUser bounds the hooks a user registers, including those
+// on groups they own.
+const maxWebhooksPerUser = 20
+
+type webhook struct {
+	ID        int64    `json:"id"`
+	GroupID   *int64   `json:"group_id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Active    bool     `json:"active"`
+	CreatedAt string   `json:"created_at"`
+	// Secret is only returned when the hook is created.
+	Secret string `json:"secret,omitempty"`
+}
+
+// validWebhookURL accepts absolute http(s) URLs, except those naming a
+// local host or a blocked address outright; hostnames are checked again
+// when each delivery connects.
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return false
+	}
+	if WebhookAllowPrivate {
+		return true
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && blockedWebhookAddr(addr) {
+		return false
+	}
+	return true
+}
+
+// normalizeWebhookEvents validates events and returns them deduplicated in
+// WebhookEvents order.
+func normalizeWebhookEvents(events []string) ([]string, bool) {
+	want := map[string]bool{}
+	for _, e := range events {
+		want[e] = true
+	}
+	out := []string{}
+	for _, e := range WebhookEvents {
+		if want[e] {
+			out = append(out, e)
+			delete(want, e)
+		}
+	}
+	return out, len(want) == 0 && len(out) > 0
+}
+
+func loadWebhook(userID, id int64) (webhook, error) {
+	var h webhook
+	var groupID sql.NullInt64
+	var events string
+	err := db.DB.QueryRow("SELECT id, group_id, url, events, active, created_at FROM webhooks WHERE id = ? AND user_id = ?", id, userID).
+		Scan(&h.ID, &groupID, &h.URL, &events, &h.Active, &h.CreatedAt)
+	if groupID.Valid {
+		h.GroupID = &groupID.Int64
+	}
+	h.Events = strings.Split(events, ",")
+	return h, err
+}
+
+// ListWebhooksHandler - GET /api/webhooks
+// The caller's hooks, including those on groups they own.
+func ListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	rows, err := db.DB.Query("SELECT id FROM webhooks WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	out := []webhook{}
+	for _, id := range ids {
+		if h, err := loadWebhook(userID, id); err == nil {
+			out = append(out, h)
+		}
+	}
+	utils.JSON(w, http.StatusOK, map[string]interface{}{"webhooks": out, "events": WebhookEvents})
+}
+
+// CreateWebhookHandler - POST /api/webhooks/create { url, events, group_id? }
+// Without group_id the hook gets the caller's posts and the activity of
+// groups they are a member of; with group_id (owner only) that group's
+// events. The response includes the signing secret, which is not shown
+// again.
+func CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var payload struct {
+		URL     string   `json:"url"`
+		Events  []string `json:"events"`
+		GroupID int64    `json:"group_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !validWebhookURL(payload.URL) {
+		utils.Error(w, http.StatusBadRequest, "url must be an absolute http(s) URL of a public host")
+		return
+	}
+	events, ok := normalizeWebhookEvents(payload.Events)
+	if !ok {
+		utils.Error(w, http.StatusBadRequest, "events must be a non-empty list of: "+strings.Join(WebhookEvents, ", "))
+		return
+	}
+	var groupID interface{}
+	if payload.GroupID > 0 {
+		var ownerID int64
+		if err := db.DB.QueryRow("SELECT owner_id FROM groups WHERE id = ?", payload.GroupID).Scan(&ownerID); err != nil || ownerID != userID {
+			utils.Error(w, http.StatusForbidden, "Only the group owner can add group webhooks")
+			return
+		}
+		groupID = payload.GroupID
+	}
+	var count int
+	db.DB.QueryRow("SELECT COUNT(*) FROM webhooks WHERE user_id = ?", userID).Scan(&count)
+	if count >= maxWebhooksPerUser {
+		utils.Error(w, http.StatusBadRequest, "Too many webhooks")
+		return
+	}
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	h := webhook{URL: payload.URL, Events: events, Active: true, Secret: "whsec_" + hex.EncodeToString(secret)}
+	res, err := db.DB.Exec("INSERT INTO webhooks (user_id, group_id, url, secret, events) VALUES (?, ?, ?, ?, ?)",
+		userID, groupID, h.URL, h.Secret, strings.Join(events, ","))
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to create webhook")
+		return
+	}
+	h.ID, _ = res.LastInsertId()
+	if payload.GroupID > 0 {
+		h.GroupID = &payload.GroupID
+	}
+	h.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	utils.JSON(w, http.StatusCreated, h)
+}
+
+// UpdateWebhookHandler - POST /api/webhooks/update { id, url?, events?, active? }
+func UpdateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var payload struct {
+		ID     int64     `json:"id"`
+		URL    *string   `json:"url"`
+		Events *[]string `json:"events"`
+		Active *bool     `json:"active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.ID <= 0 {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	h, err := loadWebhook(userID, payload.ID)
+	if err == sql.ErrNoRows {
+		utils.Error(w, http.StatusNotFound, "Webhook not found")
+		return
+	} else if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if payload.URL != nil {
+		if !validWebhookURL(*payload.URL) {
+			utils.Error(w, http.StatusBadRequest, "url must be an absolute http(s) URL of a public host")
+			return
+		}
+		h.URL = *payload.URL
+	}
+	if payload.Events != nil {
+		events, ok := normalizeWebhookEvents(*payload.Events)
+		if !ok {
+			utils.Error(w, http.StatusBadRequest, "events must be a non-empty list of: "+strings.Join(WebhookEvents, ", "))
+			return
+		}
+		h.Events = events
+	}
+	if payload.Active != nil {
+		h.Active = *payload.Active
+	}
+	_, err = db.DB.Exec("UPDATE webhooks SET url = ?, events = ?, active = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ?",
+		h.URL, strings.Join(h.Events, ","), h.Active, h.ID, userID)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to update webhook")
+		return
+	}
+	utils.JSON(w, http.StatusOK, h)
+}
+
+// DeleteWebhookHandler - POST /api/webhooks/delete { id }
+// Deletes the hook and its delivery log.
+func DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var payload struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.ID <= 0 {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	tx, err := db.DB.Begin()
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("DELETE FROM webhooks WHERE id = ? AND user_id = ?", payload.ID, userID)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to delete webhook")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		utils.Error(w, http.StatusNotFound, "Webhook not found")
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", payload.ID); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to delete webhook")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to delete webhook")
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+type webhookDelivery struct {
+	ID             int64           `json:"id"`
+	DeliveryID     string          `json:"delivery_id"`
+	Event          string          `json:"event"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	MaxAttempts    int             `json:"max_attempts"`
+	NextAttemptAt  *string         `json:"next_attempt_at"`
+	LastAttemptAt  *string         `json:"last_attempt_at"`
+	ResponseStatus *int            `json:"response_status"`
+	Error          *string         `json:"error"`
+	Payload        json.RawMessage `json:"payload"`
+	CreatedAt      string          `json:"created_at"`
+}
+
+func loadWebhookDelivery(id int64) (webhookDelivery, error) {
+	var d webhookDelivery
+	var next, last, errMsg sql.NullString
+	var status sql.NullInt64
+	var payload string
+	err := db.DB.QueryRow(`SELECT id, delivery_id, event, status, attempts, max_attempts, next_attempt_at, last_attempt_at,
+			response_status, error, payload, created_at
+		FROM webhook_deliveries WHERE id = ?`, id).
+		Scan(&d.ID, &d.DeliveryID, &d.Event, &d.Status, &d.Attempts, &d.MaxAttempts, &next, &last, &status, &errMsg, &payload, &d.CreatedAt)
+	if err != nil {
+		return d, err
+	}
+	if next.Valid && d.Status == "pending" {
+		d.NextAttemptAt = &next.String
+	}
+	if last.Valid {
+		d.LastAttemptAt = &last.String
+	}
+	if status.Valid {
+		s := int(status.Int64)
+		d.ResponseStatus = &s
+	}
+	if errMsg.Valid {
+		d.Error = &errMsg.String
+	}
+	d.Payload = json.RawMessage(payload)
+	return d, nil
+}
+
+// WebhookDeliveriesHandler - GET /api/webhooks/deliveries?webhook_id=<id>&limit=<n>
+// The hook's delivery log, newest first (limit defaults to 20, max 100).
+func WebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	hookID, _ := strconv.ParseInt(r.URL.Query().Get("webhook_id"), 10, 64)
+	if _, err := loadWebhook(userID, hookID); err != nil {
+		utils.Error(w, http.StatusNotFound, "Webhook not found")
+		return
+	}
+	limit := 20
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+		limit = min(v, 100)
+	}
+	rows, err := db.DB.Query("SELECT id FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?", hookID, limit)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	out := []webhookDelivery{}
+	for _, id := range ids {
+		if d, err := loadWebhookDelivery(id); err == nil {
+			out = append(out, d)
+		}
+	}
+	utils.JSON(w, http.StatusOK, out)
+}
+
+// TestWebhookHandler - POST /api/webhooks/test { id }
+// Sends a "ping" event right away, without retries, and returns the logged
+// delivery.
+func TestWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var payload struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.ID <= 0 {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	h, err := loadWebhook(userID, payload.ID)
+	if err != nil {
+		utils.Error(w, http.StatusNotFound, "Webhook not found")
+		return
+	}
+	var groupID int64
+	if h.GroupID != nil {
+		groupID = *h.GroupID
+	}
+	body, _ := json.Marshal(webhookEnvelope{
+		ID:        uuid.New().String(),
+		Event:     "ping",
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		ActorID:   userID,
+		GroupID:   groupID,
+		Data:      map[string]interface{}{"webhook_id": h.ID, "events": h.Events},
+	})
+	id, err := queueWebhookDelivery(h.ID, "ping", body, 1, false)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to queue delivery")
+		return
+	}
+	attemptWebhookDelivery(id)
+	d, err := loadWebhookDelivery(id)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	utils.JSON(w, http.StatusOK, d)
+}
@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"social-network/backend/db"
+	"social-network/backend/jobs"
+	"social-network/backend/models"
+	"social-network/backend/utils"
+	"social-network/backend/webpush"
+)
+
+const (
+	// maxPushFailures is how many deliveries in a row may fail before a
+	// subscription is dropped.
+	maxPushFailures = 5
+	// pushTTL is how long push services keep a notification for a device
+	// that is offline.
+	pushTTL = 24 * time.Hour
+	// pushConcurrency bounds the send_web_push jobs running at once on one
+	// instance.
+	pushConcurrency = 4
+)
+
+// pushSender delivers Web Push messages; nil until InitWebPush succeeds.
+var pushSender *webpush.Sender
+
+// InitWebPush sets up Web Push with the VAPID key privateKey (base64url), or
+// when empty the key stored in vapid_keys, generated on first use. subject
+// is the operator contact sent to push services (mailto: or https:).
+func InitWebPush(privateKey, subject string) error {
+	var key *webpush.VAPIDKey
+	var err error
+	if privateKey != "" {
+		key, err = webpush.ParseVAPIDKey(privateKey)
+	} else {
+		key, err = storedVAPIDKey()
+	}
+	if err != nil {
+		return err
+	}
+	pushSender = &webpush.Sender{Key: key, Subject: subject, Client: &http.Client{Timeout: 10 * time.Second}}
+	return nil
+}
+
+func storedVAPIDKey() (*webpush.VAPIDKey, error) {
+	generated, err := webpush.GenerateVAPIDKey()
+	if err != nil {
+		return nil, err
+	}
+	// another instance may have stored one first; everyone uses that one
+	if _, err := db.DB.Exec("INSERT INTO vapid_keys (id, private_key) VALUES (1, ?) ON CONFLICT(id) DO NOTHING", generated.PrivateKey()); err != nil {
+		return nil, err
+	}
+	var stored string
+	if err := db.DB.QueryRow("SELECT private_key FROM vapid_keys WHERE id = 1").Scan(&stored); err != nil {
+		return nil, err
+	}
+	return webpush.ParseVAPIDKey(stored)
+}
+
+// VAPIDPublicKeyHandler - GET /api/push/vapid-public-key
+// The applicationServerKey to pass to pushManager.subscribe().
+func VAPIDPublicKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if pushSender == nil {
+		utils.Error(w, http.StatusServiceUnavailable, "Web Push is not configured")
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]string{"public_key": pushSender.Key.PublicKey()})
+}
+
+// pushSubscriptionJSON is PushSubscription.toJSON(); expirationTime is in
+// milliseconds since the epoch.
+type pushSubscriptionJSON struct {
+	Endpoint       string `json:"endpoint"`
+	ExpirationTime *int64 `json:"expirationTime"`
+	Keys           struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// SubscribePushHandler - POST /api/push/subscribe
+// Body is the browser's PushSubscription.toJSON(). Subscribing an endpoint
+// again (e.g. after the keys rotated, or as another user on a shared
+// browser) replaces it.
+func SubscribePushHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var payload pushSubscriptionJSON
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	sub := webpush.Subscription{Endpoint: payload.Endpoint, P256dh: payload.Keys.P256dh, Auth: payload.Keys.Auth}
+	if err := sub.Validate(); err != nil {
+		utils.Error(w, http.StatusBadRequest, "Invalid subscription: "+err.Error())
+		return
+	}
+	var expires interface{}
+	if payload.ExpirationTime != nil {
+		t := time.UnixMilli(*payload.ExpirationTime).UTC()
+		if t.Before(time.Now()) {
+			utils.Error(w, http.StatusBadRequest, "Subscription already expired")
+			return
+		}
+		expires = t.Format(sqliteTimeFormat)
+	}
+	var id int64
+	err = db.DB.QueryRow(`INSERT INTO push_subscriptions (user_id, endpoint, p256dh, auth, expiration_time, user_agent)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(endpoint) DO UPDATE SET user_id=excluded.user_id, p256dh=excluded.p256dh, auth=excluded.auth,
+			expiration_time=excluded.expiration_time, user_agent=excluded.user_agent, failure_count=0
+		RETURNING id`, userID, sub.Endpoint, sub.P256dh, sub.Auth, expires, r.UserAgent()).Scan(&id)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to save subscription")
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]interface{}{"id": id, "endpoint": sub.Endpoint})
+}
+
+// UnsubscribePushHandler - POST /api/push/unsubscribe { endpoint }
+func UnsubscribePushHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var payload struct {
+		Endpoint string `json:"endpoint"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.Endpoint == "" {
+		utils.Error(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	res, err := db.DB.Exec("DELETE FROM push_subscriptions WHERE user_id = ? AND endpoint = ?", userID, payload.Endpoint)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Failed to delete subscription")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		utils.Error(w, http.StatusNotFound, "Subscription not found")
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+type pushSubscription struct {
+	ID             int64   `json:"id"`
+	Endpoint       string  `json:"endpoint"`
+	ExpirationTime *string `json:"expiration_time"`
+	UserAgent      string  `json:"user_agent"`
+	CreatedAt      string  `json:"created_at"`
+	LastUsedAt     *string `json:"last_used_at"`
+}
+
+// ListPushSubscriptionsHandler - GET /api/push/subscriptions
+// The caller's registered browsers.
+func ListPushSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	rows, err := db.DB.Query(`SELECT id, endpoint, expiration_time, IFNULL(user_agent, ''), created_at, last_used_at
+		FROM push_subscriptions WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer rows.Close()
+	out := []pushSubscription{}
+	for rows.Next() {
+		var s pushSubscription
+		var expires, lastUsed sql.NullString
+		if err := rows.Scan(&s.ID, &s.Endpoint, &expires, &s.UserAgent, &s.CreatedAt, &lastUsed); err != nil {
+			continue
+		}
+		if expires.Valid {
+			s.ExpirationTime = &expires.String
+		}
+		if lastUsed.Valid {
+			s.LastUsedAt = &lastUsed.String
+		}
+		out = append(out, s)
+	}
+	utils.JSON(w, http.StatusOK, out)
+}
+
+type webPushJob struct {
+	UserID   int64           `json:"user_id"`
+	Realtime json.RawMessage `json:"realtime"`
+}
+
+// QueueWebPush queues a send_web_push job for SendWebPush, so at most
+// pushConcurrency pushes are in flight per instance. The forwarder calls it
+// for recipients without a websocket connection on any instance.
+func QueueWebPush(userID int64, realtime []byte) {
+	if pushSender == nil || !json.Valid(realtime) {
+		return
+	}
+	if _, err := jobs.Enqueue("send_web_push", webPushJob{UserID: userID, Realtime: realtime}); err != nil {
+		log.Println("web push enqueue error:", err)
+	}
+}
+
+func runWebPush(_ context.Context, payload json.RawMessage) error {
+	var j webPushJob
+	if err := json.Unmarshal(payload, &j); err != nil {
+		return jobs.Permanent(err)
+	}
+	SendWebPush(j.UserID, j.Realtime)
+	return nil
+}
+
+// SendWebPush delivers a realtime notification, as published by Notify, to
+// every push subscription of userID.
+func SendWebPush(userID int64, realtime []byte) {
+	if pushSender == nil {
+		return
+	}
+	var notif struct {
+		Type           string          `json:"type"`
+		Data           json.RawMessage `json:"data"`
+		Count          int             `json:"count"`
+		NotificationID int64           `json:"notification_id"`
+		ActorID        int64           `json:"actor_id"`
+	}
+	if err := json.Unmarshal(realtime, &notif); err != nil || notif.Type == "" {
+		return
+	}
+
+	type target struct {
+		id  int64
+		sub webpush.Subscription
+	}
+	rows, err := db.DB.Query(`SELECT id, endpoint, p256dh, auth FROM push_subscriptions
+		WHERE user_id = ? AND (expiration_time IS NULL OR expiration_time > CURRENT_TIMESTAMP)`, userID)
+	if err != nil {
+		log.Println("push subscriptions error:", err)
+		return
+	}
+	var targets []target
+	for rows.Next() {
+		var t target
+		if err := rows.Scan(&t.id, &t.sub.Endpoint, &t.sub.P256dh, &t.sub.Auth); err == nil {
+			targets = append(targets, t)
+		}
+	}
+	rows.Close()
+	if len(targets) == 0 {
+		return
+	}
+
+	n := models.Notification{ID: notif.NotificationID, ActorID: notif.ActorID, Type: notif.Type, Data: notif.Data, Count: notif.Count}
+	list := []models.Notification{n}
+	if n.ID > 0 {
+		attachNotificationActors(list)
+	}
+	if len(list[0].Actors) == 0 && n.ActorID > 0 {
+		actor := models.NotificationActor{ID: n.ActorID}
+		if db.DB.QueryRow("SELECT nickname FROM users WHERE id = ?", n.ActorID).Scan(&actor.Nickname) == nil {
+			list[0].Actors, list[0].ActorCount = []models.NotificationActor{actor}, 1
+		}
+	}
+	summarizeNotifications(list)
+	n = list[0]
+
+	opts := webpush.Options{TTL: pushTTL}
+	tag := n.Type
+	if n.ID > 0 {
+		// an aggregated notification replaces its previous push
+		tag = fmt.Sprintf("notification-%d", n.ID)
+		opts.Topic = tag
+	}
+	if n.Type == "new_message" {
+		opts.Urgency = "high"
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"title":           "Social Network",
+		"body":            n.Summary,
+		"url":             dataString(&n, "url", "/"),
+		"tag":             tag,
+		"type":            n.Type,
+		"notification_id": n.ID,
+		"count":           n.Count,
+	})
+
+	for _, t := range targets {
+		err := pushSender.Send(t.sub, payload, opts)
+		switch {
+		case err == nil:
+			db.DB.Exec("UPDATE push_subscriptions SET failure_count = 0, last_used_at = CURRENT_TIMESTAMP WHERE id = ?", t.id)
+		case errors.Is(err, webpush.ErrGone):
+			log.Printf("push subscription %d expired, removing", t.id)
+			db.DB.Exec("DELETE FROM push_subscriptions WHERE id = ?", t.id)
+		default:
+			log.Printf("push to subscription %d failed: %v", t.id, err)
+			db.DB.Exec("UPDATE push_subscriptions SET failure_count = failure_count + 1 WHERE id = ?", t.id)
+			db.DB.Exec("DELETE FROM push_subscriptions WHERE id = ? AND failure_count >= ?", t.id, maxPushFailures)
+		}
+	}
+}
+
+// CleanupPushSubscriptions deletes subscriptions past their expiration time.
+func CleanupPushSubscriptions() error {
+	_, err := db.DB.Exec("DELETE FROM push_subscriptions WHERE expiration_time IS NOT NULL AND expiration_time <= CURRENT_TIMESTAMP")
+	return err
+}
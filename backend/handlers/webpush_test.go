@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"social-network/backend/db"
+	"social-network/backend/webpush"
+)
+
+// stubSubscription asks the stub push service for a new browser
+// subscription.
+func stubSubscription(t *testing.T, srv *httptest.Server) pushSubscriptionJSON {
+	t.Helper()
+	resp, err := http.Post(srv.URL+"/push-stub/subscriptions", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var sub pushSubscriptionJSON
+	if err := json.NewDecoder(resp.Body).Decode(&sub); err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+func TestWebPushDelivery(t *testing.T) {
+	openTestDB(t)
+	srv := httptest.NewServer(webpush.NewStub("/push-stub/"))
+	defer srv.Close()
+	if err := InitWebPush("", "mailto:ops@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pushSender = nil })
+	alice, bob := createTestUser(t, "alice"), createTestUser(t, "bob")
+
+	var key struct {
+		PublicKey string `json:"public_key"`
+	}
+	if code := call(t, VAPIDPublicKeyHandler, 0, "/api/push/vapid-public-key", nil, &key); code != http.StatusOK || key.PublicKey != pushSender.Key.PublicKey() {
+		t.Errorf("public key = %d %q", code, key.PublicKey)
+	}
+	// the generated key is stored and reused
+	stored := pushSender.Key.PublicKey()
+	if err := InitWebPush("", "mailto:ops@example.com"); err != nil || pushSender.Key.PublicKey() != stored {
+		t.Errorf("second start uses another VAPID key (%v)", err)
+	}
+
+	sub := stubSubscription(t, srv)
+	if code := call(t, SubscribePushHandler, alice, "/api/push/subscribe", sub, nil); code != http.StatusOK {
+		t.Fatalf("subscribe = %d", code)
+	}
+	bad := sub
+	bad.Keys.Auth = "short"
+	if code := call(t, SubscribePushHandler, alice, "/api/push/subscribe", bad, nil); code != http.StatusBadRequest {
+		t.Errorf("invalid keys = %d, want 400", code)
+	}
+
+	SendWebPush(alice, []byte(`{"type":"new_message","data":{"url":"/chat"},"count":1,"notification_id":7,"actor_id":`+strconv.FormatInt(bob, 10)+`}`))
+	resp, err := http.Get(sub.Endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received []webpush.StubMessage
+	json.NewDecoder(resp.Body).Decode(&received)
+	resp.Body.Close()
+	if len(received) != 1 {
+		t.Fatalf("stub received %d pushes, want 1", len(received))
+	}
+	var push map[string]interface{}
+	json.Unmarshal(received[0].Payload, &push)
+	if push["body"] != "New message from bob" || push["url"] != "/chat" || push["tag"] != "notification-7" ||
+		received[0].Topic != "notification-7" || received[0].Urgency != "high" || received[0].TTL != int(pushTTL.Seconds()) {
+		t.Errorf("push = %+v %v", received[0], push)
+	}
+	var lastUsed bool
+	db.DB.QueryRow("SELECT last_used_at IS NOT NULL FROM push_subscriptions WHERE endpoint = ?", sub.Endpoint).Scan(&lastUsed)
+	if !lastUsed {
+		t.Error("last_used_at not set after a delivery")
+	}
+
+	// a subscription the push service reports gone is removed
+	req, _ := http.NewRequest(http.MethodDelete, sub.Endpoint, nil)
+	http.DefaultClient.Do(req)
+	SendWebPush(alice, []byte(`{"type":"new_follower","data":{}}`))
+	var list []pushSubscription
+	if code := call(t, ListPushSubscriptionsHandler, alice, "/api/push/subscriptions", nil, &list); code != http.StatusOK || len(list) != 0 {
+		t.Errorf("subscriptions after 410 = %d %+v, want none", code, list)
+	}
+}
+
+func TestPushSubscriptionsPerUser(t *testing.T) {
+	openTestDB(t)
+	srv := httptest.NewServer(webpush.NewStub("/push-stub/"))
+	defer srv.Close()
+	alice, bob := createTestUser(t, "alice"), createTestUser(t, "bob")
+	sub := stubSubscription(t, srv)
+
+	// the same browser subscribed by another user moves to them
+	call(t, SubscribePushHandler, alice, "/api/push/subscribe", sub, nil)
+	call(t, SubscribePushHandler, bob, "/api/push/subscribe", sub, nil)
+	var list []pushSubscription
+	call(t, ListPushSubscriptionsHandler, alice, "/api/push/subscriptions", nil, &list)
+	if len(list) != 0 {
+		t.Errorf("alice still has %+v", list)
+	}
+	body := map[string]string{"endpoint": sub.Endpoint}
+	if code := call(t, UnsubscribePushHandler, alice, "/api/push/unsubscribe", body, nil); code != http.StatusNotFound {
+		t.Errorf("alice unsubscribing bob's browser = %d, want 404", code)
+	}
+	if code := call(t, UnsubscribePushHandler, bob, "/api/push/unsubscribe", body, nil); code != http.StatusOK {
+		t.Errorf("unsubscribe = %d", code)
+	}
+
+	expired := stubSubscription(t, srv)
+	past := int64(1000)
+	expired.ExpirationTime = &past
+	if code := call(t, SubscribePushHandler, bob, "/api/push/subscribe", expired, nil); code != http.StatusBadRequest {
+		t.Errorf("expired subscription = %d, want 400", code)
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"social-network/backend/bus"
+	"social-network/backend/db"
+)
+
+// presenceSyncInterval is how often an instance announces its connected
+// users on the bus; an instance not heard from for presenceTTL is considered
+// gone and its users offline.
+const (
+	presenceSyncInterval = 15 * time.Second
+	presenceTTL          = 3 * presenceSyncInterval
+)
+
+// Hub tracks every live websocket connection grouped by user, so the same user
+// can be connected from several tabs or devices at once. Presence is derived
+// from the number of open connections.
+//
+// Events sent with SendToUser are sequenced per user and kept in the
+// retention log (see eventlog.go) so a reconnecting client can resume;
+// transient ones (typing) are delivered without a sequence number.
+//
+// When several instances run behind a load balancer, every fan-out is also
+// published on the bus so the instances holding the recipient's other
+// connections deliver it too, and presence is shared the same way. Idleness
+// is only tracked per instance: a user idle here but active elsewhere shows
+// as away in the snapshots built here until their next presence event.
+type Hub struct {
+	mu    sync.RWMutex
+	conns map[string]map[*Client]struct{}
+	// seqLocks serialize sequencing with local delivery per user, so a
+	// user's events reach their connections here in sequence order. Lock
+	// order: a user's seq lock, then mu.
+	seqLocks userLocks
+
+	bus        bus.Bus
+	instanceID string
+	// remote holds the users connected to other instances, keyed by
+	// instance id; guarded by mu.
+	remote map[string]*remoteInstance
+	// onRemoteExpired is called (without locks held) when an instance stops
+	// announcing itself and its users are dropped from presence.
+	onRemoteExpired func()
+	// idle holds the users whose local connections are all idle, as of
+	// the last UpdateIdle; guarded by mu.
+	idle map[string]bool
+}
+
+// userLocks hands out a mutex per user, kept only while someone holds or
+// waits for it.
+type userLocks struct {
+	mu    sync.Mutex
+	locks map[string]*userLock
+}
+
+type userLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks userID's mutex and returns the function unlocking it.
+func (l *userLocks) lock(userID string) (unlock func()) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*userLock)
+	}
+	ul := l.locks[userID]
+	if ul == nil {
+		ul = &userLock{}
+		l.locks[userID] = ul
+	}
+	ul.refs++
+	l.mu.Unlock()
+
+	ul.Lock()
+	return func() {
+		ul.Unlock()
+		l.mu.Lock()
+		if ul.refs--; ul.refs == 0 {
+			delete(l.locks, userID)
+		}
+		l.mu.Unlock()
+	}
+}
+
+type remoteInstance struct {
+	users map[string]bool
+	seen  time.Time
+}
+
+// hub is built by main once the bus is known.
+var hub *Hub
+
+func newHub(b bus.Bus) *Hub {
+	h := &Hub{
+		conns:      make(map[string]map[*Client]struct{}),
+		bus:        b,
+		instanceID: newInstanceID(),
+		remote:     make(map[string]*remoteInstance),
+		idle:       make(map[string]bool),
+	}
+	b.Subscribe(h.receive)
+	return h
+}
+
+// newInstanceID returns INSTANCE_ID or a random id for this process.
+func newInstanceID() string {
+	if id := os.Getenv("INSTANCE_ID"); id != "" {
+		return id
+	}
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Register adds a connection and reports whether the user just came online,
+// i.e. this is their first connection on any instance. It records the user's
+// current sequence on the client: later events are delivered live, earlier
+// ones can only be replayed.
+func (h *Hub) Register(c *Client) bool {
+	unlock := h.seqLocks.lock(c.ID)
+	c.baseSeq = lastEventSeq(c.ID)
+	h.mu.Lock()
+	set, ok := h.conns[c.ID]
+	if !ok {
+		set = make(map[*Client]struct{})
+		h.conns[c.ID] = set
+	}
+	set[c] = struct{}{}
+	wsConnectionsOpen.Add(1)
+	wsConnectionsTotal.Add(1)
+	first := len(set) == 1
+	cameOnline := first && !h.remoteOnline(c.ID)
+	h.mu.Unlock()
+	unlock()
+
+	if first {
+		h.publish(bus.Envelope{Kind: bus.KindPresence, UserID: c.ID, Online: true})
+	}
+	return cameOnline
+}
+
+// Unregister removes a connection, closes its send queue (which stops its
+// writePump) and reports whether the user just went offline, i.e. it was
+// their last connection on any instance. Unregistering twice is a no-op.
+func (h *Hub) Unregister(c *Client) bool {
+	h.mu.Lock()
+	if _, ok := h.conns[c.ID][c]; !ok {
+		h.mu.Unlock()
+		return false
+	}
+	set := h.conns[c.ID]
+	delete(set, c)
+	close(c.Send)
+	wsConnectionsOpen.Add(-1)
+	last := len(set) == 0
+	if last {
+		delete(h.conns, c.ID)
+	}
+	wentOffline := last && !h.remoteOnline(c.ID)
+	h.mu.Unlock()
+
+	if last {
+		h.publish(bus.Envelope{Kind: bus.KindPresence, UserID: c.ID, Online: false})
+	}
+	return wentOffline
+}
+
+// SendToUser assigns payload the next sequence number of userID, logs it for
+// replay and queues it on every connection of userID, on every instance. It
+// reports whether the user is connected anywhere; offline users get the
+// event on resume. Transient events are only delivered. Other instances
+// get the event after this one, so two events sent at once may reach
+// them swapped; clients keep the highest seq they saw.
+func (h *Hub) SendToUser(userID string, payload []byte) bool {
+	var delivered bool
+	if transientEvents[eventType(payload)] {
+		delivered = h.deliver(userID, payload)
+	} else {
+		unlock := h.seqLocks.lock(userID)
+		if _, stamped, err := appendEvent(userID, payload); err == nil {
+			payload = stamped
+		} else {
+			log.Println("Error logging realtime event:", err)
+		}
+		delivered = h.deliver(userID, payload)
+		unlock()
+	}
+	h.publish(bus.Envelope{Kind: bus.KindUser, UserID: userID, Payload: payload})
+	return delivered || h.IsOnline(userID)
+}
+
+// PushSnapshot queues a state snapshot (such as the user list) on every
+// connection of userID without sequencing it: clients re-request snapshots
+// after connecting, so replaying stale ones would be wrong.
+func (h *Hub) PushSnapshot(userID string, payload []byte) {
+	h.deliver(userID, payload)
+	h.publish(bus.Envelope{Kind: bus.KindUser, UserID: userID, Payload: payload})
+}
+
+// Broadcast queues an unsequenced snapshot on every open connection of every
+// instance.
+func (h *Hub) Broadcast(payload []byte) {
+	h.broadcastLocal(payload)
+	h.publish(bus.Envelope{Kind: bus.KindBroadcast, Payload: payload})
+}
+
+func (h *Hub) deliver(userID string, payload []byte) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	delivered := false
+	for c := range h.conns[userID] {
+		if h.enqueue(c, payload) {
+			delivered = true
+		}
+	}
+	return delivered
+}
+
+// broadcastLocal queues payload on every connection of this instance.
+func (h *Hub) broadcastLocal(payload []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, set := range h.conns {
+		for c := range set {
+			h.enqueue(c, payload)
+		}
+	}
+}
+
+// SendToClient queues payload on a single connection.
+func (h *Hub) SendToClient(c *Client, payload []byte) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if _, ok := h.conns[c.ID][c]; !ok {
+		return false
+	}
+	return h.enqueue(c, payload)
+}
+
+// enqueue performs a non-blocking send; callers must hold h.mu so the channel
+// cannot be closed concurrently. The queue is bounded (sendQueueSize): a
+// client that lets it fill up is too slow to keep, so it is evicted rather
+// than stalling the sender or silently missing events.
+func (h *Hub) enqueue(c *Client, payload []byte) bool {
+	select {
+	case c.Send <- payload:
+		wsFramesQueued.Add(1)
+		return true
+	default:
+		wsFramesDropped.Add(1)
+		c.evict("send queue full")
+		return false
+	}
+}
+
+// Replay queues the events of c's user with after < seq <= c.baseSeq on c,
+// at most replayBatchSize at a time. Events after baseSeq were delivered
+// live. complete is false when the events cannot be replayed because they
+// were pruned; the client must then reload state over REST.
+func (h *Hub) Replay(c *Client, after int64) (replayed int, hasMore, complete bool, err error) {
+	events, hasMore, complete, err := eventsSince(c.ID, after, c.baseSeq, replayBatchSize)
+	if err != nil || !complete {
+		return 0, false, complete, err
+	}
+	for _, e := range events {
+		if h.SendToClient(c, e) {
+			replayed++
+		}
+	}
+	return replayed, hasMore, true, nil
+}
+
+// ConnectionCount returns how many connections userID has open on this
+// instance.
+func (h *Hub) ConnectionCount(userID string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.conns[userID])
+}
+
+// IsOnline reports whether userID has at least one open connection on any
+// instance.
+func (h *Hub) IsOnline(userID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.conns[userID]) > 0 || h.remoteOnline(userID)
+}
+
+// OnlineUserIDs returns a snapshot of the ids of all connected users, on
+// every instance.
+func (h *Hub) OnlineUserIDs() map[string]bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	out := make(map[string]bool, len(h.conns))
+	for id := range h.conns {
+		out[id] = true
+	}
+	for _, r := range h.remote {
+		if time.Since(r.seen) > presenceTTL {
+			continue
+		}
+		for id := range r.users {
+			out[id] = true
+		}
+	}
+	return out
+}
+
+// LocalUserIDs returns the ids of users connected to this instance.
+func (h *Hub) LocalUserIDs() map[string]bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	out := make(map[string]bool, len(h.conns))
+	for id := range h.conns {
+		out[id] = true
+	}
+	return out
+}
+
+// SetIdle records whether the user is idle on connection c.
+func (h *Hub) SetIdle(c *Client, idle bool) {
+	h.mu.Lock()
+	c.idle = idle
+	h.mu.Unlock()
+}
+
+// UpdateIdle recomputes whether userID is idle, i.e. every connection they
+// have on this instance is idle, and reports whether that changed since the
+// previous call. Call it after anything that can change the answer: SetIdle,
+// Register and Unregister.
+func (h *Hub) UpdateIdle(userID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	idle := len(h.conns[userID]) > 0
+	for c := range h.conns[userID] {
+		if !c.idle {
+			idle = false
+			break
+		}
+	}
+	if idle == h.idle[userID] {
+		return false
+	}
+	if idle {
+		h.idle[userID] = true
+	} else {
+		delete(h.idle, userID)
+	}
+	return true
+}
+
+// IsIdle reports whether userID was idle as of the last UpdateIdle.
+func (h *Hub) IsIdle(userID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.idle[userID]
+}
+
+// remoteOnline reports whether another live instance holds a connection of
+// userID; callers must hold h.mu.
+func (h *Hub) remoteOnline(userID string) bool {
+	for _, r := range h.remote {
+		if r.users[userID] && time.Since(r.seen) <= presenceTTL {
+			return true
+		}
+	}
+	return false
+}
+
+// publish stamps env with this instance and sends it on the bus. Failures
+// only affect connections on other instances, so they are logged.
+func (h *Hub) publish(env bus.Envelope) {
+	env.Origin = h.instanceID
+	if err := h.bus.Publish(env); err != nil {
+		log.Println("bus publish error:", err)
+	}
+}
+
+// receive handles envelopes published by other instances.
+func (h *Hub) receive(env bus.Envelope) {
+	if env.Origin == h.instanceID {
+		return
+	}
+	switch env.Kind {
+	case bus.KindUser:
+		h.deliver(env.UserID, env.Payload)
+	case bus.KindBroadcast:
+		h.broadcastLocal(env.Payload)
+	case bus.KindPresence, bus.KindPresenceSync:
+		h.mu.Lock()
+		r := h.remote[env.Origin]
+		if r == nil || env.Kind == bus.KindPresenceSync {
+			// a sync replaces whatever we knew about the instance
+			r = &remoteInstance{users: make(map[string]bool)}
+			h.remote[env.Origin] = r
+		}
+		for _, id := range env.UserIDs {
+			r.users[id] = true
+		}
+		if env.Kind == bus.KindPresence {
+			if env.Online {
+				r.users[env.UserID] = true
+			} else {
+				delete(r.users, env.UserID)
+			}
+		}
+		r.seen = time.Now()
+		h.mu.Unlock()
+	}
+}
+
+// RunPresenceSync announces this instance's connected users every
+// presenceSyncInterval and forgets instances that went silent.
+func (h *Hub) RunPresenceSync() {
+	ticker := time.NewTicker(presenceSyncInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.mu.Lock()
+		ids := make([]string, 0, len(h.conns))
+		for id := range h.conns {
+			ids = append(ids, id)
+		}
+		expired := false
+		for origin, r := range h.remote {
+			if time.Since(r.seen) > presenceTTL {
+				expired = expired || len(r.users) > 0
+				delete(h.remote, origin)
+			}
+		}
+		h.mu.Unlock()
+
+		h.publish(bus.Envelope{Kind: bus.KindPresenceSync, UserIDs: ids})
+		if expired && h.onRemoteExpired != nil {
+			h.onRemoteExpired()
+		}
+	}
+}
+
+// persistPresence mirrors a presence transition into users.online_status for
+// SQL consumers and stamps last_seen_at when the user goes offline. It is
+// only called when a user's first connection opens or last connection
+// closes, not on every connect/disconnect. Invisible users are mirrored as
+// offline and keep the last_seen_at from when they went invisible.
+func persistPresence(userID string, online bool) {
+	query := `UPDATE users SET online_status = CASE WHEN presence_status = 'invisible' THEN 0 ELSE 1 END WHERE id = ?`
+	if !online {
+		query = `UPDATE users SET online_status = 0,
+			last_seen_at = CASE WHEN presence_status = 'invisible' THEN last_seen_at ELSE CURRENT_TIMESTAMP END
+			WHERE id = ?`
+	}
+	if _, err := db.DB.Exec(query, userID); err != nil {
+		log.Println("Error updating user status:", err)
+	}
+}
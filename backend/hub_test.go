@@ -0,0 +1,66 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestHubTracksEveryConnection(t *testing.T) {
+	openTestHub(t)
+	tab := newTestClient(t, "alice")
+	phone := &Client{ID: tab.ID, Nickname: "alice", Send: make(chan []byte, sendQueueSize)}
+	bob := newTestClient(t, "bob")
+
+	if !hub.Register(tab) {
+		t.Error("alice's first connection did not bring her online")
+	}
+	if hub.Register(phone) {
+		t.Error("alice's second connection brought her online again")
+	}
+	hub.Register(bob)
+	if n := hub.ConnectionCount(tab.ID); n != 2 {
+		t.Errorf("alice has %d connections, want 2", n)
+	}
+
+	// every connection of the user gets the payload, others do not
+	if !hub.SendToUser(tab.ID, []byte("one")) {
+		t.Error("SendToUser reported no delivery")
+	}
+	for _, c := range []*Client{tab, phone} {
+		if got := drain(c); !slices.Equal(got, []string{"one"}) {
+			t.Errorf("connection of %s got %q", c.Nickname, got)
+		}
+	}
+	if got := drain(bob); len(got) != 0 {
+		t.Errorf("bob got alice's frames %q", got)
+	}
+	hub.Broadcast([]byte("all"))
+	for _, c := range []*Client{tab, phone, bob} {
+		if got := drain(c); !slices.Equal(got, []string{"all"}) {
+			t.Errorf("connection of %s got %q from a broadcast", c.Nickname, got)
+		}
+	}
+
+	// alice stays online until her last connection closes
+	if hub.Unregister(tab) {
+		t.Error("closing one of two connections took alice offline")
+	}
+	if _, ok := <-tab.Send; ok {
+		t.Error("unregistering left the send queue open")
+	}
+	if !hub.IsOnline(phone.ID) || hub.ConnectionCount(phone.ID) != 1 {
+		t.Error("alice went offline with a connection left")
+	}
+	if hub.Unregister(tab) {
+		t.Error("unregistering twice took alice offline")
+	}
+	if !hub.Unregister(phone) {
+		t.Error("closing alice's last connection did not take her offline")
+	}
+	if hub.IsOnline(phone.ID) || hub.SendToUser(phone.ID, []byte("gone")) {
+		t.Error("alice is still reachable after disconnecting")
+	}
+	if online := hub.OnlineUserIDs(); len(online) != 1 || !online[bob.ID] {
+		t.Errorf("online users = %v, want only bob", online)
+	}
+}
@@ -0,0 +1,159 @@
+package jobs
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"social-network/backend/db"
+)
+
+// ErrNotFound is returned by Retry and Cancel for a job that does not exist
+// or is not in a state the operation applies to.
+var ErrNotFound = errors.New("jobs: no such job in that state")
+
+// Job is a row of the queue.
+type Job struct {
+	ID          int64   `json:"id"`
+	Type        string  `json:"type"`
+	Payload     string  `json:"payload"`
+	Status      string  `json:"status"`
+	Attempts    int     `json:"attempts"`
+	MaxAttempts int     `json:"max_attempts"`
+	RunAt       string  `json:"run_at"`
+	LockedBy    *string `json:"locked_by"`
+	LastError   *string `json:"last_error"`
+	Schedule    *string `json:"schedule"`
+	CreatedAt   string  `json:"created_at"`
+	StartedAt   *string `json:"started_at"`
+	FinishedAt  *string `json:"finished_at"`
+}
+
+// Filter selects jobs for List; empty fields match all.
+type Filter struct {
+	Status string
+	Type   string
+	Limit  int
+}
+
+// List returns the most recent jobs matching f.
+func List(f Filter) ([]Job, error) {
+	var where []string
+	var args []interface{}
+	if f.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, f.Status)
+	}
+	if f.Type != "" {
+		where = append(where, "type = ?")
+		args = append(args, f.Type)
+	}
+	if f.Limit <= 0 || f.Limit > 200 {
+		f.Limit = 50
+	}
+	query := `SELECT id, type, payload, status, attempts, max_attempts, run_at, locked_by, last_error, schedule,
+		created_at, started_at, finished_at FROM jobs`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, f.Limit)
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Job{}
+	for rows.Next() {
+		var j Job
+		var lockedBy, lastError, schedule, startedAt, finishedAt sql.NullString
+		if err := rows.Scan(&j.ID, &j.Type, &j.Payload, &j.Status, &j.Attempts, &j.MaxAttempts, &j.RunAt,
+			&lockedBy, &lastError, &schedule, &j.CreatedAt, &startedAt, &finishedAt); err != nil {
+			return nil, err
+		}
+		j.LockedBy, j.LastError, j.Schedule = nullString(lockedBy), nullString(lastError), nullString(schedule)
+		j.StartedAt, j.FinishedAt = nullString(startedAt), nullString(finishedAt)
+		list = append(list, j)
+	}
+	return list, rows.Err()
+}
+
+// Stats is an overview of the queue.
+type Stats struct {
+	Workers   int                       `json:"workers"`
+	Types     []string                  `json:"types"`
+	Counts    map[string]map[string]int `json:"counts"` // type -> status -> jobs
+	Schedules []ScheduleInfo            `json:"schedules"`
+}
+
+// GetStats counts the jobs by type and status and lists the schedules.
+func GetStats() (Stats, error) {
+	s := Stats{Types: []string{}, Counts: map[string]map[string]int{}}
+	mu.Lock()
+	s.Workers = workerCount
+	for name := range types {
+		s.Types = append(s.Types, name)
+	}
+	mu.Unlock()
+	sort.Strings(s.Types)
+
+	rows, err := db.DB.Query("SELECT type, status, COUNT(*) FROM jobs GROUP BY type, status")
+	if err != nil {
+		return s, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var jobType, status string
+		var n int
+		if err := rows.Scan(&jobType, &status, &n); err != nil {
+			return s, err
+		}
+		if s.Counts[jobType] == nil {
+			s.Counts[jobType] = map[string]int{}
+		}
+		s.Counts[jobType][status] = n
+	}
+	if err := rows.Err(); err != nil {
+		return s, err
+	}
+	s.Schedules, err = listSchedules()
+	return s, err
+}
+
+// Retry queues a failed or cancelled job again with fresh attempts.
+func Retry(id int64) error {
+	err := update(`UPDATE jobs SET status = 'pending', attempts = 0, run_at = ?, finished_at = NULL
+		WHERE id = ? AND status IN ('failed', 'cancelled')`, format(time.Now()), id)
+	if err == nil {
+		wakeWorkers()
+	}
+	return err
+}
+
+// Cancel stops a pending job from running. Running jobs cannot be
+// cancelled.
+func Cancel(id int64) error {
+	return update("UPDATE jobs SET status = 'cancelled', finished_at = ? WHERE id = ? AND status = 'pending'", format(time.Now()), id)
+}
+
+func update(query string, args ...interface{}) error {
+	res, err := db.DB.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// nullString maps a NULL column to nil.
+func nullString(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
@@ -0,0 +1,171 @@
+// Package jobs is a durable background job queue stored in SQLite, with
+// retries, cron-like schedules and a worker pool. Every instance sharing the
+// database runs workers; a job is claimed by exactly one of them.
+//
+// Job types are registered with Register before Start. Enqueue stores a job
+// for a worker to run; Schedule enqueues one on a Spec.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"social-network/backend/db"
+)
+
+// Handler runs one job with its payload. A returned error retries the job
+// with backoff until MaxAttempts; wrap it with RetryAfter to pick the delay
+// or Permanent to fail the job right away.
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+// Options configure a job type.
+type Options struct {
+	// MaxAttempts is how often a job is tried before it fails; default 5.
+	MaxAttempts int
+	// Concurrency bounds how many jobs of the type run at once on one
+	// instance; default 1.
+	Concurrency int
+	// Timeout cancels the handler's context; default 1 minute. A job whose
+	// worker died is picked up again after Timeout plus a grace period.
+	Timeout time.Duration
+	// Backoff returns the delay before retry attempt+1; default
+	// exponential from 10s, capped at an hour, with jitter.
+	Backoff func(attempt int) time.Duration
+}
+
+type jobType struct {
+	handler Handler
+	opts    Options
+}
+
+var (
+	mu    sync.Mutex
+	types = map[string]*jobType{}
+	// wake makes the local workers look for due jobs right away.
+	wake = make(chan struct{}, 1)
+)
+
+// Job counters, published through expvar at /debug/vars.
+var (
+	jobsSucceeded = expvar.NewInt("jobs_succeeded")
+	jobsRetried   = expvar.NewInt("jobs_retried")
+	jobsFailed    = expvar.NewInt("jobs_failed")
+)
+
+// Register adds a job type. It panics on duplicates, like http.Handle.
+func Register(name string, h Handler, opts Options) {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Minute
+	}
+	if opts.Backoff == nil {
+		opts.Backoff = defaultBackoff
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := types[name]; dup {
+		panic("jobs: duplicate job type " + name)
+	}
+	types[name] = &jobType{handler: h, opts: opts}
+}
+
+func lookup(name string) (*jobType, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	t, ok := types[name]
+	return t, ok
+}
+
+func defaultBackoff(attempt int) time.Duration {
+	d := 10 * time.Second << min(attempt-1, 9)
+	d = min(d, time.Hour)
+	// up to 20% jitter spreads retries of jobs that failed together
+	return d + time.Duration(rand.Int64N(int64(d/5)+1))
+}
+
+const timeFormat = "2006-01-02 15:04:05"
+
+func format(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+// Enqueue stores a job of a registered type to run as soon as a worker is
+// free. payload is marshalled to JSON.
+func Enqueue(jobType string, payload interface{}) (int64, error) {
+	return EnqueueAt(jobType, payload, time.Now())
+}
+
+// EnqueueAt stores a job to run at runAt or later.
+func EnqueueAt(jobType string, payload interface{}, runAt time.Time) (int64, error) {
+	return enqueue(jobType, payload, runAt, "")
+}
+
+func enqueue(jobType string, payload interface{}, runAt time.Time, schedule string) (int64, error) {
+	t, ok := lookup(jobType)
+	if !ok {
+		return 0, fmt.Errorf("jobs: unknown job type %q", jobType)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	var scheduleName interface{}
+	if schedule != "" {
+		scheduleName = schedule
+	}
+	res, err := db.DB.Exec("INSERT INTO jobs (type, payload, max_attempts, run_at, schedule) VALUES (?, ?, ?, ?, ?)",
+		jobType, string(data), t.opts.MaxAttempts, format(runAt), scheduleName)
+	if err != nil {
+		return 0, err
+	}
+	if !runAt.After(time.Now()) {
+		wakeWorkers()
+	}
+	return res.LastInsertId()
+}
+
+func wakeWorkers() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+type retryError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *retryError) Error() string { return e.err.Error() }
+func (e *retryError) Unwrap() error { return e.err }
+
+// RetryAfter retries the job after d instead of the type's backoff.
+func RetryAfter(d time.Duration, err error) error {
+	return &retryError{err: err, after: d}
+}
+
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent fails the job without further attempts.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+func isPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
@@ -0,0 +1,269 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"social-network/backend/db"
+)
+
+// openTestDB points db.DB at a fresh, migrated database for the test. It
+// runs from the repository root, where InitDB finds the migrations.
+func openTestDB(t *testing.T) {
+	t.Helper()
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+	t.Chdir("../..")
+	db.InitDB()
+	t.Cleanup(func() { db.DB.Close() })
+}
+
+func testPool() *pool {
+	return &pool{instanceID: "test", slots: make(chan struct{}, 4), running: map[string]int{}}
+}
+
+// runOne claims one due job and runs it to completion, reporting whether
+// there was one.
+func (p *pool) runOne() bool {
+	id, t, name, ok := p.claim()
+	if !ok {
+		return false
+	}
+	p.execute(id, name, t)
+	p.done(name)
+	return true
+}
+
+type jobRow struct {
+	status, runAt, lastError string
+	attempts                 int
+}
+
+func loadJob(t *testing.T, id int64) jobRow {
+	t.Helper()
+	var j jobRow
+	var lastError *string
+	if err := db.DB.QueryRow("SELECT status, run_at, last_error, attempts FROM jobs WHERE id = ?", id).
+		Scan(&j.status, &j.runAt, &lastError, &j.attempts); err != nil {
+		t.Fatal(err)
+	}
+	if lastError != nil {
+		j.lastError = *lastError
+	}
+	return j
+}
+
+// makeDue moves a job's run_at to the past.
+func makeDue(t *testing.T, id int64) {
+	t.Helper()
+	db.DB.Exec("UPDATE jobs SET run_at = ? WHERE id = ?", format(time.Now().Add(-time.Second)), id)
+}
+
+func TestJobRetries(t *testing.T) {
+	openTestDB(t)
+	var results []error
+	var payloads []string
+	Register("test_retries", func(ctx context.Context, payload json.RawMessage) error {
+		payloads = append(payloads, string(payload))
+		err := results[0]
+		results = results[1:]
+		return err
+	}, Options{MaxAttempts: 3, Backoff: func(int) time.Duration { return time.Hour }})
+	p := testPool()
+
+	// an error retries after the type's backoff, RetryAfter after its delay
+	results = []error{errors.New("boom"), RetryAfter(5*time.Minute, errors.New("busy")), nil}
+	id, err := Enqueue("test_retries", map[string]int{"n": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	retried, succeeded := jobsRetried.Value(), jobsSucceeded.Value()
+	p.runOne()
+	j := loadJob(t, id)
+	runAt, _ := time.Parse(time.RFC3339, j.runAt)
+	if j.status != "pending" || j.attempts != 1 || j.lastError != "boom" || time.Until(runAt) < 59*time.Minute {
+		t.Fatalf("after an error the job is %+v, want pending for an hour", j)
+	}
+	if p.runOne() {
+		t.Fatal("a job ran before its retry was due")
+	}
+	makeDue(t, id)
+	p.runOne()
+	j = loadJob(t, id)
+	runAt, _ = time.Parse(time.RFC3339, j.runAt)
+	if j.status != "pending" || j.attempts != 2 || time.Until(runAt) > 5*time.Minute || time.Until(runAt) < 4*time.Minute {
+		t.Fatalf("after RetryAfter the job is %+v, want pending for 5 minutes", j)
+	}
+	makeDue(t, id)
+	p.runOne()
+	if j = loadJob(t, id); j.status != "succeeded" || j.attempts != 3 {
+		t.Errorf("after a success the job is %+v", j)
+	}
+	if payloads[0] != `{"n":1}` || len(payloads) != 3 {
+		t.Errorf("handler got %v", payloads)
+	}
+	if jobsRetried.Value()-retried != 2 || jobsSucceeded.Value()-succeeded != 1 {
+		t.Errorf("counters moved by %d retried and %d succeeded, want 2 and 1", jobsRetried.Value()-retried, jobsSucceeded.Value()-succeeded)
+	}
+
+	// out of attempts, the job fails
+	results = []error{errors.New("1"), errors.New("2"), errors.New("3")}
+	id, _ = Enqueue("test_retries", nil)
+	for range 3 {
+		makeDue(t, id)
+		p.runOne()
+	}
+	if j = loadJob(t, id); j.status != "failed" || j.attempts != 3 || j.lastError != "3" {
+		t.Errorf("after MaxAttempts the job is %+v, want failed", j)
+	}
+}
+
+func TestJobPermanentAndPanic(t *testing.T) {
+	openTestDB(t)
+	Register("test_permanent", func(context.Context, json.RawMessage) error {
+		return Permanent(errors.New("bad payload"))
+	}, Options{})
+	Register("test_panic", func(context.Context, json.RawMessage) error { panic("oops") }, Options{})
+	p := testPool()
+
+	id, _ := Enqueue("test_permanent", nil)
+	p.runOne()
+	if j := loadJob(t, id); j.status != "failed" || j.attempts != 1 || j.lastError != "bad payload" {
+		t.Errorf("a permanent error left the job %+v, want failed", j)
+	}
+	id, _ = Enqueue("test_panic", nil)
+	p.runOne()
+	if j := loadJob(t, id); j.status != "pending" || j.lastError != "panic: oops" {
+		t.Errorf("a panic left the job %+v, want pending for a retry", j)
+	}
+	if _, err := Enqueue("test_unknown", nil); err == nil {
+		t.Error("enqueued a job of an unknown type")
+	}
+}
+
+func TestJobConcurrencyAndLease(t *testing.T) {
+	openTestDB(t)
+	Register("test_single", func(context.Context, json.RawMessage) error { return nil }, Options{MaxAttempts: 2})
+	p := testPool()
+	first, _ := Enqueue("test_single", nil)
+	second, _ := Enqueue("test_single", nil)
+
+	// one job of a Concurrency 1 type at a time
+	id, jt, name, ok := p.claim()
+	if !ok || id != first {
+		t.Fatalf("claimed %d, want %d", id, first)
+	}
+	if _, _, _, ok := p.claim(); ok {
+		t.Fatal("claimed a second job of a type with concurrency 1")
+	}
+	p.execute(id, name, jt)
+	p.done(name)
+	if !p.runOne() || loadJob(t, second).status != "succeeded" {
+		t.Error("the second job did not run after the first finished")
+	}
+
+	// a job whose lease ran out is released, or failed without attempts left
+	for _, attempts := range []int{1, 2} {
+		id, _ := Enqueue("test_single", nil)
+		db.DB.Exec("UPDATE jobs SET status = 'running', attempts = ?, locked_by = 'gone', locked_until = ? WHERE id = ?",
+			attempts, format(time.Now().Add(-time.Second)), id)
+		releaseExpired()
+		want := map[int]string{1: "pending", 2: "failed"}[attempts]
+		if j := loadJob(t, id); j.status != want || j.lastError != "worker lease expired" {
+			t.Errorf("expired lease after %d attempts left the job %+v, want %s", attempts, j, want)
+		}
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	openTestDB(t)
+	Register("test_scheduled", func(context.Context, json.RawMessage) error { return nil }, Options{})
+	if err := Schedule("test_hourly", "@hourly", "test_scheduled", map[string]string{"k": "v"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Schedule("test_bad", "@monthly", "test_scheduled", nil); err == nil {
+		t.Error("scheduled a bad spec")
+	}
+	if err := Schedule("test_orphan", "@hourly", "test_unknown", nil); err == nil {
+		t.Error("scheduled an unknown job type")
+	}
+	if err := syncSchedules(); err != nil {
+		t.Fatal(err)
+	}
+	countJobs := func() int {
+		var n int
+		db.DB.QueryRow("SELECT COUNT(*) FROM jobs WHERE schedule = 'test_hourly'").Scan(&n)
+		return n
+	}
+	comeDue := func() {
+		db.DB.Exec("UPDATE job_schedules SET next_run_at = ? WHERE name = 'test_hourly'", format(time.Now().Add(-time.Second)))
+	}
+
+	runDueSchedules()
+	if n := countJobs(); n != 0 {
+		t.Fatalf("%d jobs enqueued before the schedule came due", n)
+	}
+	comeDue()
+	runDueSchedules()
+	runDueSchedules()
+	if n := countJobs(); n != 1 {
+		t.Fatalf("%d jobs enqueued for one due run, want 1", n)
+	}
+	var next string
+	db.DB.QueryRow("SELECT next_run_at FROM job_schedules WHERE name = 'test_hourly'").Scan(&next)
+	if at, _ := time.Parse(time.RFC3339, next); !at.After(time.Now()) {
+		t.Errorf("next_run_at = %s, want the next hour", next)
+	}
+
+	// a run is skipped while the previous one is still queued
+	comeDue()
+	runDueSchedules()
+	if n := countJobs(); n != 1 {
+		t.Errorf("%d jobs with the previous run pending, want 1", n)
+	}
+	// but can be run by hand
+	if _, err := RunScheduleNow("test_hourly"); err != nil || countJobs() != 2 {
+		t.Errorf("RunScheduleNow = %v with %d jobs", err, countJobs())
+	}
+	if _, err := RunScheduleNow("test_none"); !errors.Is(err, ErrNoSchedule) {
+		t.Errorf("RunScheduleNow of an unknown schedule = %v", err)
+	}
+}
+
+func TestRetryAndCancel(t *testing.T) {
+	openTestDB(t)
+	Register("test_admin", func(context.Context, json.RawMessage) error { return Permanent(errors.New("no")) }, Options{})
+	p := testPool()
+	id, _ := Enqueue("test_admin", nil)
+
+	if err := Retry(id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("retrying a pending job = %v, want ErrNotFound", err)
+	}
+	p.runOne()
+	if err := Retry(id); err != nil {
+		t.Fatal(err)
+	}
+	if j := loadJob(t, id); j.status != "pending" || j.attempts != 0 {
+		t.Errorf("a retried job is %+v, want pending with fresh attempts", j)
+	}
+	if err := Cancel(id); err != nil {
+		t.Fatal(err)
+	}
+	if p.runOne() {
+		t.Error("a cancelled job ran")
+	}
+	if err := Cancel(id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("cancelling twice = %v, want ErrNotFound", err)
+	}
+
+	stats, err := GetStats()
+	if err != nil || stats.Counts["test_admin"]["cancelled"] != 1 {
+		t.Errorf("stats = %+v %v", stats.Counts, err)
+	}
+	if list, err := List(Filter{Status: "cancelled", Type: "test_admin"}); err != nil || len(list) != 1 || list[0].ID != id {
+		t.Errorf("List = %+v %v", list, err)
+	}
+}
@@ -0,0 +1,79 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSpecNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t, err := time.Parse("2006-01-02 15:04:05", s)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	for _, tc := range []struct {
+		spec, from, want string
+	}{
+		{"@every 10m", "2026-03-01 12:00:30", "2026-03-01 12:10:30"},
+		{"@hourly", "2026-03-01 12:00:00", "2026-03-01 13:00:00"},
+		{"@daily", "2026-03-01 12:00:00", "2026-03-02 00:00:00"},
+		// 2026-03-01 is a Sunday
+		{"@weekly", "2026-03-01 00:00:00", "2026-03-08 00:00:00"},
+		{"30 3 * * *", "2026-03-01 03:29:59", "2026-03-01 03:30:00"},
+		{"30 3 * * *", "2026-03-01 03:30:00", "2026-03-02 03:30:00"},
+		{"*/15 * * * *", "2026-03-01 12:16:00", "2026-03-01 12:30:00"},
+		{"0 9-17/4 * * *", "2026-03-01 13:00:00", "2026-03-01 17:00:00"},
+		{"0 0 1,15 * *", "2026-03-02 00:00:00", "2026-03-15 00:00:00"},
+		{"0 0 31 * *", "2026-04-01 00:00:00", "2026-05-31 00:00:00"},
+		{"0 0 29 2 *", "2026-03-01 00:00:00", "2028-02-29 00:00:00"},
+		{"59 23 31 12 *", "2026-12-31 23:59:00", "2027-12-31 23:59:00"},
+		// both day fields restricted: the 13th or any Friday
+		{"0 12 13 * 5", "2026-03-01 00:00:00", "2026-03-06 12:00:00"},
+		{"0 12 13 * 5", "2026-03-07 00:00:00", "2026-03-13 12:00:00"},
+	} {
+		spec, err := ParseSpec(tc.spec)
+		if err != nil {
+			t.Fatalf("ParseSpec(%q): %v", tc.spec, err)
+		}
+		if got := spec.Next(at(tc.from)); !got.Equal(at(tc.want)) {
+			t.Errorf("%q.Next(%s) = %s, want %s", tc.spec, tc.from, got.Format(time.DateTime), tc.want)
+		}
+	}
+}
+
+func TestSpecNextNever(t *testing.T) {
+	spec, err := ParseSpec("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := spec.Next(time.Now()); !got.IsZero() {
+		t.Errorf("Feb 31 runs at %s", got)
+	}
+}
+
+func TestParseSpecRejects(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"@monthly",
+		"@every 0s",
+		"@every 500ms",
+		"@every soon",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 7",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"1-x * * * *",
+	} {
+		if _, err := ParseSpec(spec); err == nil {
+			t.Errorf("ParseSpec(%q) succeeded", spec)
+		}
+	}
+}
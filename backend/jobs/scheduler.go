@@ -0,0 +1,190 @@
+package jobs
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"social-network/backend/db"
+)
+
+// ErrNoSchedule is returned for an unknown schedule name.
+var ErrNoSchedule = errors.New("jobs: no such schedule")
+
+type schedule struct {
+	spec    string
+	parsed  Spec
+	jobType string
+	payload interface{}
+}
+
+var schedules = map[string]*schedule{}
+
+// Schedule enqueues a jobType job with payload whenever spec comes due (see
+// Spec). The next run time is kept in job_schedules, so across restarts and
+// instances each run is enqueued once, and a run is skipped while the
+// previous one is still queued or running.
+func Schedule(name, spec, jobType string, payload interface{}) error {
+	parsed, err := ParseSpec(spec)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := types[jobType]; !ok {
+		return fmt.Errorf("jobs: schedule %s: unknown job type %q", name, jobType)
+	}
+	schedules[name] = &schedule{spec: spec, parsed: parsed, jobType: jobType, payload: payload}
+	return nil
+}
+
+func lookupSchedule(name string) (*schedule, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	s, ok := schedules[name]
+	return s, ok
+}
+
+// syncSchedules adds the registered schedules to job_schedules and
+// reschedules those whose spec changed.
+func syncSchedules() error {
+	mu.Lock()
+	names := make(map[string]*schedule, len(schedules))
+	for name, s := range schedules {
+		names[name] = s
+	}
+	mu.Unlock()
+
+	now := time.Now()
+	for name, s := range names {
+		var next interface{}
+		if t := s.parsed.Next(now); !t.IsZero() {
+			next = format(t)
+		}
+		_, err := db.DB.Exec(`INSERT INTO job_schedules (name, spec, job_type, next_run_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT(name) DO UPDATE SET
+				next_run_at = CASE WHEN spec != excluded.spec THEN excluded.next_run_at ELSE next_run_at END,
+				spec = excluded.spec, job_type = excluded.job_type, updated_at = CURRENT_TIMESTAMP`,
+			name, s.spec, s.jobType, next)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runDueSchedules enqueues the jobs of schedules that came due.
+func runDueSchedules() {
+	now := time.Now()
+	rows, err := db.DB.Query("SELECT name, last_job_id FROM job_schedules WHERE next_run_at <= ?", format(now))
+	if err != nil {
+		log.Println("jobs: schedules:", err)
+		return
+	}
+	type due struct {
+		name    string
+		lastJob sql.NullInt64
+	}
+	var list []due
+	for rows.Next() {
+		var d due
+		if rows.Scan(&d.name, &d.lastJob) == nil {
+			list = append(list, d)
+		}
+	}
+	rows.Close()
+
+	for _, d := range list {
+		s, ok := lookupSchedule(d.name)
+		if !ok {
+			// removed from this build, or added by a newer one
+			continue
+		}
+		var nextRun interface{}
+		if t := s.parsed.Next(now); !t.IsZero() {
+			nextRun = format(t)
+		}
+		// move next_run_at on first, so only one instance enqueues this run
+		res, err := db.DB.Exec("UPDATE job_schedules SET next_run_at = ?, last_run_at = ? WHERE name = ? AND next_run_at <= ?",
+			nextRun, format(now), d.name, format(now))
+		if err != nil {
+			log.Println("jobs: schedules:", err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		if d.lastJob.Valid && jobActive(d.lastJob.Int64) {
+			log.Printf("jobs: skipping %s, previous run still queued", d.name)
+			continue
+		}
+		if err := enqueueScheduled(d.name, s); err != nil {
+			log.Printf("jobs: schedule %s: %v", d.name, err)
+		}
+	}
+}
+
+func jobActive(id int64) bool {
+	var status string
+	err := db.DB.QueryRow("SELECT status FROM jobs WHERE id = ?", id).Scan(&status)
+	return err == nil && (status == "pending" || status == "running")
+}
+
+func enqueueScheduled(name string, s *schedule) error {
+	id, err := enqueue(s.jobType, s.payload, time.Now(), name)
+	if err != nil {
+		return err
+	}
+	_, err = db.DB.Exec("UPDATE job_schedules SET last_job_id = ? WHERE name = ?", id, name)
+	return err
+}
+
+// RunScheduleNow enqueues a run of a schedule outside its spec, e.g. from
+// the admin API. Its next regular run is unchanged.
+func RunScheduleNow(name string) (int64, error) {
+	s, ok := lookupSchedule(name)
+	if !ok {
+		return 0, ErrNoSchedule
+	}
+	id, err := enqueue(s.jobType, s.payload, time.Now(), name)
+	if err != nil {
+		return 0, err
+	}
+	_, err = db.DB.Exec("UPDATE job_schedules SET last_job_id = ?, last_run_at = ? WHERE name = ?", id, format(time.Now()), name)
+	return id, err
+}
+
+// ScheduleInfo is a schedule and its last and next run.
+type ScheduleInfo struct {
+	Name      string  `json:"name"`
+	Spec      string  `json:"spec"`
+	JobType   string  `json:"job_type"`
+	NextRunAt *string `json:"next_run_at"`
+	LastRunAt *string `json:"last_run_at"`
+	LastJobID *int64  `json:"last_job_id"`
+}
+
+func listSchedules() ([]ScheduleInfo, error) {
+	rows, err := db.DB.Query("SELECT name, spec, job_type, next_run_at, last_run_at, last_job_id FROM job_schedules ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []ScheduleInfo{}
+	for rows.Next() {
+		var s ScheduleInfo
+		var next, last sql.NullString
+		var lastJob sql.NullInt64
+		if err := rows.Scan(&s.Name, &s.Spec, &s.JobType, &next, &last, &lastJob); err != nil {
+			return nil, err
+		}
+		s.NextRunAt, s.LastRunAt = nullString(next), nullString(last)
+		if lastJob.Valid {
+			s.LastJobID = &lastJob.Int64
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
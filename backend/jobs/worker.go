@@ -0,0 +1,227 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"social-network/backend/db"
+)
+
+const (
+	// pollInterval is how often workers look for due jobs and schedules
+	// when nothing woke them.
+	pollInterval = 2 * time.Second
+	// leaseGrace is added to a type's Timeout before a running job whose
+	// worker went away is released to others.
+	leaseGrace = 30 * time.Second
+	// Finished jobs are kept this long for the admin view.
+	succeededRetention = 7 * 24 * time.Hour
+	failedRetention    = 30 * 24 * time.Hour
+)
+
+// pool runs the jobs of this instance.
+type pool struct {
+	instanceID string
+	slots      chan struct{}
+	mu         sync.Mutex
+	running    map[string]int // per type
+}
+
+var (
+	started     bool
+	workerCount int
+	local       *pool
+)
+
+// Start runs workers goroutines taking jobs from the queue, and the
+// scheduler, until the process exits. instanceID marks the jobs this
+// instance claimed. Register job types and schedules first.
+func Start(instanceID string, workers int) {
+	if workers <= 0 {
+		workers = 4
+	}
+	mu.Lock()
+	if started {
+		mu.Unlock()
+		return
+	}
+	started, workerCount = true, workers
+	mu.Unlock()
+
+	Register("prune_jobs", func(ctx context.Context, _ json.RawMessage) error { return prune() }, Options{})
+	if err := Schedule("prune_jobs", "@hourly", "prune_jobs", nil); err != nil {
+		log.Println("jobs:", err)
+	}
+	if err := syncSchedules(); err != nil {
+		log.Println("jobs: schedules:", err)
+	}
+
+	local = &pool{instanceID: instanceID, slots: make(chan struct{}, workers), running: map[string]int{}}
+	go local.run()
+}
+
+func (p *pool) run() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		runDueSchedules()
+		releaseExpired()
+		for p.claimAndRun() {
+			// claimed a job: look for more while slots are free
+		}
+		select {
+		case <-ticker.C:
+		case <-wake:
+		}
+	}
+}
+
+// claimAndRun starts one due job if a worker slot is free and reports
+// whether it did.
+func (p *pool) claimAndRun() bool {
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		return false
+	}
+	id, t, name, ok := p.claim()
+	if !ok {
+		<-p.slots
+		return false
+	}
+	go func() {
+		defer func() { <-p.slots }()
+		defer p.done(name)
+		p.execute(id, name, t)
+	}()
+	return true
+}
+
+// claim marks the oldest due job of a type with free concurrency as running
+// on this instance.
+func (p *pool) claim() (int64, *jobType, string, bool) {
+	now := time.Now()
+	rows, err := db.DB.Query(`SELECT id, type FROM jobs WHERE status = 'pending' AND run_at <= ?
+		ORDER BY run_at, id LIMIT 50`, format(now))
+	if err != nil {
+		log.Println("jobs: poll:", err)
+		return 0, nil, "", false
+	}
+	type candidate struct {
+		id   int64
+		name string
+	}
+	var due []candidate
+	for rows.Next() {
+		var c candidate
+		if rows.Scan(&c.id, &c.name) == nil {
+			due = append(due, c)
+		}
+	}
+	rows.Close()
+
+	for _, c := range due {
+		t, ok := lookup(c.name)
+		if !ok {
+			// registered by a newer build on another instance
+			continue
+		}
+		p.mu.Lock()
+		if p.running[c.name] >= t.opts.Concurrency {
+			p.mu.Unlock()
+			continue
+		}
+		p.running[c.name]++
+		p.mu.Unlock()
+
+		res, err := db.DB.Exec(`UPDATE jobs SET status = 'running', attempts = attempts + 1, locked_by = ?, locked_until = ?, started_at = ?
+			WHERE id = ? AND status = 'pending'`, p.instanceID, format(now.Add(t.opts.Timeout+leaseGrace)), format(now), c.id)
+		if err == nil {
+			if n, _ := res.RowsAffected(); n == 1 {
+				return c.id, t, c.name, true
+			}
+		}
+		// another instance won it
+		p.done(c.name)
+	}
+	return 0, nil, "", false
+}
+
+func (p *pool) done(name string) {
+	p.mu.Lock()
+	p.running[name]--
+	p.mu.Unlock()
+}
+
+// execute runs a claimed job and records the outcome.
+func (p *pool) execute(id int64, name string, t *jobType) {
+	var payload string
+	var attempts, maxAttempts int
+	err := db.DB.QueryRow("SELECT payload, attempts, max_attempts FROM jobs WHERE id = ?", id).Scan(&payload, &attempts, &maxAttempts)
+	if err != nil {
+		log.Printf("jobs: load %d: %v", id, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.opts.Timeout)
+	err = func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return t.handler(ctx, json.RawMessage(payload))
+	}()
+	cancel()
+
+	now := time.Now()
+	var retry *retryError
+	switch {
+	case err == nil:
+		jobsSucceeded.Add(1)
+		_, err = db.DB.Exec("UPDATE jobs SET status = 'succeeded', locked_by = NULL, locked_until = NULL, finished_at = ? WHERE id = ?", format(now), id)
+	case attempts >= maxAttempts || isPermanent(err):
+		jobsFailed.Add(1)
+		log.Printf("jobs: %s %d failed after %d attempts: %v", name, id, attempts, err)
+		_, err = db.DB.Exec("UPDATE jobs SET status = 'failed', locked_by = NULL, locked_until = NULL, finished_at = ?, last_error = ? WHERE id = ?",
+			format(now), err.Error(), id)
+	default:
+		jobsRetried.Add(1)
+		delay := t.opts.Backoff(attempts)
+		if errors.As(err, &retry) {
+			delay = retry.after
+		}
+		_, err = db.DB.Exec("UPDATE jobs SET status = 'pending', locked_by = NULL, locked_until = NULL, run_at = ?, last_error = ? WHERE id = ?",
+			format(now.Add(delay)), err.Error(), id)
+	}
+	if err != nil {
+		log.Printf("jobs: record %s %d: %v", name, id, err)
+	}
+}
+
+// releaseExpired returns running jobs whose lease ran out (their instance
+// stopped or hung) to the queue, or fails them when out of attempts.
+func releaseExpired() {
+	now := format(time.Now())
+	_, err := db.DB.Exec(`UPDATE jobs SET
+			status = CASE WHEN attempts >= max_attempts THEN 'failed' ELSE 'pending' END,
+			finished_at = CASE WHEN attempts >= max_attempts THEN ? ELSE NULL END,
+			run_at = ?, locked_by = NULL, locked_until = NULL, last_error = 'worker lease expired'
+		WHERE status = 'running' AND locked_until < ?`, now, now, now)
+	if err != nil {
+		log.Println("jobs: release expired:", err)
+	}
+}
+
+func prune() error {
+	now := time.Now()
+	_, err := db.DB.Exec(`DELETE FROM jobs WHERE (status = 'succeeded' AND finished_at < ?)
+		OR (status IN ('failed', 'cancelled') AND finished_at < ?)`,
+		format(now.Add(-succeededRetention)), format(now.Add(-failedRetention)))
+	return err
+}
@@ -0,0 +1,103 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Message is an email with a plain text and an HTML body.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	// Headers are extra headers such as List-Unsubscribe.
+	Headers map[string]string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// New returns the mailer selected by rawURL:
+//   - "" or "file://<dir>": write each message as an .eml file to dir
+//     (backend/outbox by default), for development and tests
+//   - "smtp://[user:pass@]host:port": send through an SMTP server
+//
+// from is the sender address of every message.
+func New(rawURL, from string) (Mailer, error) {
+	if rawURL == "" {
+		return &FileMailer{Dir: filepath.Join("backend", "outbox"), From: from}, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		return &FileMailer{Dir: u.Host + u.Path, From: from}, nil
+	case "smtp":
+		m := &SMTPMailer{Addr: u.Host, From: from}
+		if u.User != nil {
+			m.Username = u.User.Username()
+			m.Password, _ = u.User.Password()
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("unsupported mail url %q", rawURL)
+}
+
+// encode renders msg as a multipart/alternative RFC 5322 message.
+func encode(from string, msg Message) []byte {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		w, _ := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		qp := quotedprintable.NewWriter(w)
+		qp.Write([]byte(part.content))
+		qp.Close()
+	}
+	mw.Close()
+
+	var out bytes.Buffer
+	headers := [][2]string{
+		{"From", from},
+		{"To", msg.To},
+		{"Subject", mime.QEncoding.Encode("utf-8", msg.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + mw.Boundary()},
+	}
+	extra := make([]string, 0, len(msg.Headers))
+	for k := range msg.Headers {
+		extra = append(extra, k)
+	}
+	sort.Strings(extra)
+	for _, k := range extra {
+		headers = append(headers, [2]string{k, msg.Headers[k]})
+	}
+	for _, h := range headers {
+		// header values must not smuggle in extra headers
+		v := strings.NewReplacer("\r", "", "\n", "").Replace(h[1])
+		fmt.Fprintf(&out, "%s: %s\r\n", h[0], v)
+	}
+	out.WriteString("\r\n")
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"social-network/backend/bus"
 	"social-network/backend/db"
 	"social-network/backend/handlers"
+	"social-network/backend/jobs"
+	"social-network/backend/mailer"
 	"social-network/backend/utils"
+	"social-network/backend/webpush"
 	"strconv"
 
 	"github.com/rs/cors"
@@ -19,8 +25,42 @@ func main() {
 	// inject DB into utils package for session helpers
 	utils.SetDB(db.DB)
 
+	// realtime fan-out between instances: in-process unless BUS_URL points
+	// at a shared broker (e.g. redis://localhost:6379/0)
+	b, err := bus.New(os.Getenv("BUS_URL"))
+	if err != nil {
+		log.Fatal("bus: ", err)
+	}
+	defer b.Close()
+	hub = newHub(b)
+	hub.onRemoteExpired = refreshLocalUserLists
+	go hub.RunPresenceSync()
+
+	// digest emails: written to backend/outbox unless MAIL_URL points at an
+	// SMTP server (e.g. smtp://localhost:1025)
+	m, err := mailer.New(os.Getenv("MAIL_URL"), envOr("MAIL_FROM", "no-reply@social-network.local"))
+	if err != nil {
+		log.Fatal("mailer: ", err)
+	}
+	digests := &handlers.DigestSender{
+		Mailer: m,
+		AppURL: envOr("APP_URL", "http://localhost:5173"),
+		APIURL: envOr("PUBLIC_URL", "http://localhost:8080"),
+	}
+
+	if err := handlers.InitWebPush(os.Getenv("VAPID_PRIVATE_KEY"), envOr("VAPID_SUBJECT", "mailto:admin@social-network.local")); err != nil {
+		log.Fatal("web push: ", err)
+	}
+
+	// development only: lets hooks post to localhost and the LAN
+	handlers.WebhookAllowPrivate = os.Getenv("WEBHOOK_ALLOW_PRIVATE") != ""
+
 	mux := http.NewServeMux()
 	registerRoutes(mux)
+	if os.Getenv("PUSH_STUB") != "" {
+		// stand-in push service for development and tests, see webpush.Stub
+		mux.Handle("/push-stub/", webpush.NewStub("/push-stub/"))
+	}
 
 	// CORS handler
 	c := cors.New(cors.Options{
@@ -31,28 +71,58 @@ func main() {
 	})
 	handler := c.Handler(mux)
 
-	// Start periodic session cleanup
+	// Background jobs: the handlers' cleanups, digests, reminders and
+	// notification fan-out, on a queue shared by all instances
+	if err := handlers.RegisterJobs(digests); err != nil {
+		log.Fatal("jobs: ", err)
+	}
+	jobs.Register("prune_realtime_events", func(context.Context, json.RawMessage) error { return pruneEvents() }, jobs.Options{})
+	if err := jobs.Schedule("prune_realtime_events", "@every 10m", "prune_realtime_events", nil); err != nil {
+		log.Fatal("jobs: ", err)
+	}
+	workers, _ := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	jobs.Start(hub.instanceID, workers)
+
+	// The rate limiters and duplicate filter are per process
 	go func() {
 		ticker := time.NewTicker(10 * time.Minute)
 		defer ticker.Stop()
 		for range ticker.C {
-			handlers.CleanupSessions()
+			userFrameLimiter.prune()
+			recentMessages.prune()
 		}
 	}()
 
+	// Post queued webhook deliveries, retrying failures with backoff
+	go handlers.RunWebhookDeliveries()
+
 	// Start bus forwarder: listen for notification messages and send to WS clients
+	bus.StartPump()
 	go func() {
 		for nm := range bus.NotificationChan {
-			// if connected, push payload
-			sid := strconv.FormatInt(nm.RecipientID, 10)
-			if client, ok := clients[sid]; ok {
-				client.Send <- nm.Payload
+			// if connected, push payload to every connection of the recipient
+			if nm.Snapshot {
+				hub.PushSnapshot(strconv.FormatInt(nm.RecipientID, 10), nm.Payload)
+				continue
+			}
+			if !hub.SendToUser(strconv.FormatInt(nm.RecipientID, 10), nm.Payload) && nm.Alert {
+				// not connected anywhere: reach their browsers through Web Push
+				handlers.QueueWebPush(nm.RecipientID, nm.Payload)
 			}
 		}
 	}()
 
-	log.Println("Server running on http://localhost:8080")
-	if err := http.ListenAndServe(":8080", handler); err != nil {
+	addr := envOr("LISTEN_ADDR", ":8080")
+	log.Printf("Server running on http://localhost%s (instance %s)", addr, hub.instanceID)
+	if err := http.ListenAndServe(addr, handler); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// envOr returns the environment variable key, or fallback if it is unset.
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
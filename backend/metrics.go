@@ -0,0 +1,15 @@
+package main
+
+import "expvar"
+
+// Websocket counters, published through expvar at /debug/vars.
+var (
+	wsConnectionsOpen   = expvar.NewInt("ws_connections_open")
+	wsConnectionsTotal  = expvar.NewInt("ws_connections_total")
+	wsFramesQueued      = expvar.NewInt("ws_frames_queued")
+	wsFramesDropped     = expvar.NewInt("ws_frames_dropped")
+	wsSlowClientEvicted = expvar.NewInt("ws_slow_clients_evicted")
+	wsPongTimeouts      = expvar.NewInt("ws_pong_timeouts")
+	wsOversizedFrames   = expvar.NewInt("ws_oversized_frames")
+	wsFramesRateLimited = expvar.NewInt("ws_frames_rate_limited")
+)
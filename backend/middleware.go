@@ -35,3 +35,17 @@ func AuthMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// AdminMiddleware lets only admins (users.is_admin) through. It runs inside
+// AuthMiddleware.
+func AdminMiddleware(next http.Handler) http.Handler {
+	return AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var isAdmin bool
+		err := db.DB.QueryRow("SELECT is_admin FROM users WHERE id = ?", utils.GetUserIDFromContext(r)).Scan(&isAdmin)
+		if err != nil || !isAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"social-network/backend/db"
+)
+
+func TestAdminMiddleware(t *testing.T) {
+	openTestHub(t)
+	alice, bob := newTestClient(t, "alice"), newTestClient(t, "bob")
+	db.DB.Exec("UPDATE users SET is_admin = 1 WHERE id = ?", alice.ID)
+	expiry := time.Now().Add(time.Hour)
+	db.DB.Exec("INSERT INTO sessions (user_id, cookie_token, expiry) VALUES (?, 'alice-token', ?), (?, 'bob-token', ?)",
+		alice.ID, expiry, bob.ID, expiry)
+
+	handler := AdminMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	for token, want := range map[string]int{"alice-token": http.StatusNoContent, "bob-token": http.StatusForbidden, "": http.StatusUnauthorized} {
+		r := httptest.NewRequest(http.MethodGet, "/api/admin/jobs", nil)
+		if token != "" {
+			r.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("session %q = %d, want %d", token, w.Code, want)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // Register and responses
 type RegisterRequest struct {
@@ -87,12 +90,71 @@ type CommentResponse struct {
 }
 
 type Message struct {
-	ID         int       `json:"id"`
-	Type       string    `json:"type"`
-	Content    string    `json:"content"`
-	SenderID   string    `json:"sender_id"`
+	ID          int          `json:"id"`
+	Type        string       `json:"type"`
+	Content     string       `json:"content"`
+	SenderID    string       `json:"sender_id"`
+	SenderName  string       `json:"sender_name"`
+	ReceiverID  string       `json:"receiver_id"`
+	CreatedAt   time.Time    `json:"created_at"`
+	EditedAt    *time.Time   `json:"edited_at,omitempty"`
+	Deleted     bool         `json:"deleted,omitempty"`
+	Reactions   []Reaction   `json:"reactions,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// MessagePage is the cursor-paginated envelope returned by the DM history
+// endpoint. Messages are oldest-first; HasMore refers to the requested paging
+// direction (older for before_id / no cursor, newer for after_id).
+type MessagePage struct {
+	Messages      []Message `json:"messages"`
+	HasMore       bool      `json:"has_more"`
+	HasMoreBefore bool      `json:"has_more_before"`
+	HasMoreAfter  bool      `json:"has_more_after"`
+}
+
+// Attachment describes a file attached to a chat message. URL points at the
+// access-controlled download endpoint.
+type Attachment struct {
+	ID       int64  `json:"id"`
+	URL      string `json:"url"`
+	Name     string `json:"name"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+}
+
+// Reaction aggregates the users who reacted to a message with one emoji.
+type Reaction struct {
+	Emoji       string   `json:"emoji"`
+	Count       int      `json:"count"`
+	UserIDs     []int64  `json:"user_ids"`
+	Nicknames   []string `json:"nicknames"`
+	ReactedByMe bool     `json:"reacted_by_me,omitempty"`
+}
+
+// Receipt is a participant's delivery/read cursor within a DM conversation.
+type Receipt struct {
+	Type            string `json:"type,omitempty"`
+	UserID          int64  `json:"user_id"` // reader
+	PeerID          int64  `json:"peer_id"` // sender of the acknowledged messages
+	LastDeliveredID int64  `json:"last_delivered_id"`
+	LastReadID      int64  `json:"last_read_id"`
+	UpdatedAt       string `json:"updated_at,omitempty"`
+}
+
+// SearchResult is one message hit from the chat search endpoint. Snippet is
+// HTML-escaped with matches wrapped in <mark>.
+type SearchResult struct {
+	Kind       string    `json:"kind"` // direct or group
+	MessageID  int64     `json:"message_id"`
+	PeerID     int64     `json:"peer_id,omitempty"`  // direct: the other participant
+	GroupID    int64     `json:"group_id,omitempty"` // group: the group chat
+	GroupName  string    `json:"group_name,omitempty"`
+	SenderID   int64     `json:"sender_id"`
 	SenderName string    `json:"sender_name"`
-	ReceiverID string    `json:"receiver_id"`
+	Snippet    string    `json:"snippet"`
 	CreatedAt  time.Time `json:"created_at"`
 }
 
@@ -117,11 +179,33 @@ type FollowRequest struct {
 }
 
 type Notification struct {
-	ID          int64  `json:"id"`
-	RecipientID int64  `json:"recipient_id"`
-	ActorID     int64  `json:"actor_id,omitempty"`
-	Type        string `json:"type"`
-	Data        string `json:"data,omitempty"`
-	IsRead      bool   `json:"is_read"`
-	CreatedAt   string `json:"created_at"`
+	ID          int64              `json:"id"`
+	RecipientID int64              `json:"recipient_id"`
+	ActorID     int64              `json:"actor_id,omitempty"`
+	Actor       *NotificationActor `json:"actor,omitempty"`
+	Type        string             `json:"type"`
+	Data        json.RawMessage    `json:"data,omitempty"`
+	IsRead      bool               `json:"is_read"`
+	CreatedAt   string             `json:"created_at"`
+	// Aggregated notifications: Count events from ActorCount distinct
+	// actors, the most recent of which are listed in Actors.
+	Count      int                 `json:"count"`
+	ActorCount int                 `json:"actor_count"`
+	Actors     []NotificationActor `json:"actors,omitempty"`
+	Summary    string              `json:"summary,omitempty"`
+	UpdatedAt  string              `json:"updated_at"`
+}
+
+// NotificationPage is one page of GET /api/notifications, newest first.
+type NotificationPage struct {
+	Notifications []Notification `json:"notifications"`
+	NextCursor    string         `json:"next_cursor,omitempty"`
+	HasMore       bool           `json:"has_more"`
+	UnreadCount   int            `json:"unread_count"`
+}
+
+type NotificationActor struct {
+	ID       int64  `json:"id"`
+	Nickname string `json:"nickname"`
+	Avatar   string `json:"avatar,omitempty"`
 }
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"social-network/backend/db"
+	"social-network/backend/handlers"
+	"social-network/backend/models"
+	"social-network/backend/utils"
+)
+
+// relatedUsersQuery selects everyone a user has a relationship with: people
+// they follow or who follow them, members of their groups and DM partners.
+// Presence is only shared along these edges. Arguments: the user id 5 times.
+const relatedUsersQuery = `
+	SELECT followed_id AS id FROM followers WHERE follower_id = ?
+	UNION SELECT follower_id FROM followers WHERE followed_id = ?
+	UNION SELECT gm2.user_id FROM group_members gm1
+		JOIN group_members gm2 ON gm2.group_id = gm1.group_id
+		WHERE gm1.user_id = ?
+	UNION SELECT receiver_id FROM messages WHERE sender_id = ?
+	UNION SELECT sender_id FROM messages WHERE receiver_id = ?`
+
+func relatedUsersArgs(userID string) []interface{} {
+	return []interface{}{userID, userID, userID, userID, userID}
+}
+
+// relatedUserIDs returns the ids of users related to userID.
+func relatedUserIDs(userID string) ([]string, error) {
+	args := append(relatedUsersArgs(userID), userID)
+	rows, err := db.DB.Query(`SELECT id FROM (`+relatedUsersQuery+`) WHERE id != ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, strconv.FormatInt(id, 10))
+		}
+	}
+	return ids, nil
+}
+
+// Presence statuses. The first four are what a user can choose
+// (users.presence_status); offline is what others see while the user has no
+// connection or is invisible. A user who chose online shows as away while
+// all of their connections are idle.
+const (
+	statusOnline    = "online"
+	statusAway      = "away"
+	statusDND       = "dnd"
+	statusInvisible = "invisible"
+	statusOffline   = "offline"
+)
+
+// Who may see a user's last_seen_at (users.last_seen_visibility).
+const (
+	lastSeenEveryone  = "everyone"
+	lastSeenFollowers = "followers"
+	lastSeenNobody    = "nobody"
+)
+
+func validStatus(status string) bool {
+	switch status {
+	case statusOnline, statusAway, statusDND, statusInvisible:
+		return true
+	}
+	return false
+}
+
+func validLastSeenVisibility(v string) bool {
+	return v == lastSeenEveryone || v == lastSeenFollowers || v == lastSeenNobody
+}
+
+// presenceRow is what the users table holds about a user's presence.
+type presenceRow struct {
+	status     string
+	lastSeen   sql.NullString
+	visibility string
+}
+
+// presenceView is a user's presence as seen by someone else. It is the body
+// of the presence event and of GET /api/presence.
+type presenceView struct {
+	Type       string  `json:"type,omitempty"`
+	UserID     string  `json:"user_id"`
+	Status     string  `json:"status"`
+	IsOnline   bool    `json:"is_online"`
+	LastSeenAt *string `json:"last_seen_at,omitempty"`
+}
+
+// view resolves p for a viewer: online is whether userID is connected and
+// followed whether the viewer follows them. last_seen_at is only shown while
+// the user appears offline and the visibility setting allows it.
+func (p presenceRow) view(userID string, online, followed bool) presenceView {
+	v := presenceView{UserID: userID, Status: statusOffline}
+	if online && p.status != statusInvisible {
+		v.Status = p.status
+		if v.Status == statusOnline && hub.IsIdle(userID) {
+			v.Status = statusAway
+		}
+		v.IsOnline = true
+	}
+	if !v.IsOnline && p.lastSeen.Valid &&
+		(p.visibility == lastSeenEveryone || p.visibility == lastSeenFollowers && followed) {
+		v.LastSeenAt = &p.lastSeen.String
+	}
+	return v
+}
+
+func loadPresence(userID string) (presenceRow, error) {
+	var p presenceRow
+	err := db.DB.QueryRow("SELECT presence_status, last_seen_at, last_seen_visibility FROM users WHERE id = ?", userID).
+		Scan(&p.status, &p.lastSeen, &p.visibility)
+	return p, err
+}
+
+// follows reports whether followerID follows userID.
+func follows(followerID, userID string) bool {
+	var exists bool
+	db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM followers WHERE follower_id = ? AND followed_id = ?)", followerID, userID).Scan(&exists)
+	return exists
+}
+
+// isRelated reports whether viewerID is related to userID (see
+// relatedUsersQuery), i.e. may see their presence.
+func isRelated(userID, viewerID string) bool {
+	var exists bool
+	args := append(relatedUsersArgs(userID), viewerID)
+	db.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM (`+relatedUsersQuery+`) WHERE id = ?)`, args...).Scan(&exists)
+	return exists
+}
+
+// publishPresence sends userID's current presence to the online users
+// related to them. Each viewer gets their own view because last_seen_at
+// depends on who is looking. Presence is state, so the frame is a snapshot
+// and not sequenced.
+func publishPresence(userID string) {
+	p, err := loadPresence(userID)
+	if err != nil {
+		log.Println("presence load error:", err)
+		return
+	}
+	ids, err := relatedUserIDs(userID)
+	if err != nil {
+		log.Println("presence fan-out error:", err)
+		return
+	}
+	online := hub.IsOnline(userID)
+	for _, id := range ids {
+		if !hub.IsOnline(id) {
+			continue
+		}
+		v := p.view(userID, online, p.visibility == lastSeenFollowers && follows(id, userID))
+		v.Type = "presence"
+		payload, _ := json.Marshal(v)
+		hub.PushSnapshot(id, payload)
+	}
+}
+
+// sendPresenceSettings tells c what its user's own presence settings are,
+// so every tab shows the same status picker.
+func sendPresenceSettings(c *Client) {
+	p, err := loadPresence(c.ID)
+	if err != nil {
+		log.Println("presence load error:", err)
+		return
+	}
+	c.send(p.settingsPayload())
+}
+
+// settingsPayload encodes the presence_settings frame.
+func (p presenceRow) settingsPayload() []byte {
+	payload, _ := json.Marshal(map[string]interface{}{
+		"type": "presence_settings", "status": p.status, "last_seen_visibility": p.visibility,
+	})
+	return payload
+}
+
+// updatePresenceSettings stores userID's chosen status and/or last seen
+// visibility (empty means unchanged), then republishes their presence and
+// pushes the new settings to all of their connections. Going invisible
+// stamps last_seen_at, so it looks like an ordinary sign-off.
+func updatePresenceSettings(userID, status, visibility string) (presenceRow, error) {
+	_, err := db.DB.Exec(`UPDATE users SET
+		last_seen_at = CASE WHEN ? = 'invisible' AND presence_status != 'invisible' AND online_status = 1 THEN CURRENT_TIMESTAMP ELSE last_seen_at END,
+		presence_status = COALESCE(NULLIF(?, ''), presence_status),
+		last_seen_visibility = COALESCE(NULLIF(?, ''), last_seen_visibility)
+		WHERE id = ?`, status, status, visibility, userID)
+	if err != nil {
+		return presenceRow{}, err
+	}
+	if hub.IsOnline(userID) {
+		persistPresence(userID, true)
+	}
+	p, err := loadPresence(userID)
+	if err != nil {
+		return p, err
+	}
+	hub.PushSnapshot(userID, p.settingsPayload())
+	publishPresence(userID)
+	return p, nil
+}
+
+// sendUserList pushes target's user list (the users they are related to,
+// with live presence) to every connection of target.
+func sendUserList(target string) {
+	payload, err := userListPayload(target)
+	if err != nil {
+		log.Println("User fetch error:", err)
+		return
+	}
+	hub.PushSnapshot(target, payload)
+}
+
+// refreshLocalUserLists resends the user list to this instance's
+// connections only; every instance does the same when another instance
+// disappears, so fanning out over the bus is unnecessary.
+func refreshLocalUserLists() {
+	for id := range hub.LocalUserIDs() {
+		payload, err := userListPayload(id)
+		if err != nil {
+			log.Println("User fetch error:", err)
+			return
+		}
+		hub.deliver(id, payload)
+	}
+}
+
+// userListPayload encodes the user_list frame for target.
+func userListPayload(target string) ([]byte, error) {
+	// Query once for the full list (avoids running many parallel DB queries
+	// which can cause 'database is locked' errors under SQLite).
+	args := append([]interface{}{target}, relatedUsersArgs(target)...)
+	args = append(args, target)
+	rows, err := db.DB.Query(`
+SELECT u.id,
+	u.nickname,
+	IFNULL(u.avatar, ''),
+	u.presence_status,
+	u.last_seen_at,
+	u.last_seen_visibility,
+	EXISTS(SELECT 1 FROM followers f WHERE f.follower_id = ? AND f.followed_id = u.id)
+FROM users u
+WHERE u.id IN (`+relatedUsersQuery+`) AND u.id != ?
+ORDER BY u.nickname COLLATE NOCASE ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// presence comes from open connections, not from the users table
+	online := hub.OnlineUserIDs()
+
+	users := []map[string]interface{}{}
+	for rows.Next() {
+		var id, nickname, avatar string
+		var p presenceRow
+		var followed bool
+		if err := rows.Scan(&id, &nickname, &avatar, &p.status, &p.lastSeen, &p.visibility, &followed); err != nil {
+			continue
+		}
+		v := p.view(id, online[id], followed)
+		user := map[string]interface{}{
+			"id":        id,
+			"nickname":  nickname,
+			"avatar":    avatar,
+			"status":    v.Status,
+			"is_online": v.IsOnline,
+		}
+		if v.LastSeenAt != nil {
+			user["last_seen_at"] = *v.LastSeenAt
+		}
+		users = append(users, user)
+	}
+	// online users first, alphabetical within each block
+	sort.SliceStable(users, func(i, j int) bool {
+		return users[i]["is_online"].(bool) && !users[j]["is_online"].(bool)
+	})
+
+	jsonUsers, _ := json.Marshal(users)
+	update := models.Message{Type: "user_list", Content: string(jsonUsers)}
+	return json.Marshal(update)
+}
+
+// onlineGroupMembers returns the ids of groupID's members that others see
+// online: connected and not invisible (see presenceRow.view).
+func onlineGroupMembers(groupID int64) ([]int64, error) {
+	return connectedMembers(`SELECT gm.user_id FROM group_members gm
+		JOIN users u ON u.id = gm.user_id
+		WHERE gm.group_id = ? AND u.presence_status != ?`, groupID, statusInvisible)
+}
+
+// connectedGroupMembers returns the ids of groupID's members with an open
+// connection, whatever their status: invisible members still receive.
+func connectedGroupMembers(groupID int64) ([]int64, error) {
+	return connectedMembers("SELECT user_id FROM group_members WHERE group_id = ?", groupID)
+}
+
+// connectedMembers returns the user ids selected by query that are connected.
+func connectedMembers(query string, args ...interface{}) ([]int64, error) {
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	online := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		if hub.IsOnline(strconv.FormatInt(id, 10)) {
+			online = append(online, id)
+		}
+	}
+	return online, rows.Err()
+}
+
+// isInvisible reports whether userID chose to appear offline, in which case
+// their typing must not reach anyone.
+func isInvisible(userID string) (bool, error) {
+	p, err := loadPresence(userID)
+	if err != nil {
+		return false, err
+	}
+	return p.status == statusInvisible, nil
+}
+
+// HandleGroupOnline - GET /api/group/online?group_id=<id>
+// Lists the members of a group that are currently online. Members only.
+func HandleGroupOnline(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(utils.GetUserIDFromContext(r), 10, 64)
+	if err != nil {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	groupID, err := strconv.ParseInt(r.URL.Query().Get("group_id"), 10, 64)
+	if err != nil || groupID <= 0 {
+		utils.Error(w, http.StatusBadRequest, "Invalid group_id")
+		return
+	}
+	if !handlers.IsGroupMember(groupID, userID) {
+		utils.Error(w, http.StatusForbidden, "Not a member")
+		return
+	}
+	online, err := onlineGroupMembers(groupID)
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]interface{}{"group_id": groupID, "user_ids": online})
+}
+
+// HandlePresence - GET /api/presence?user_id=<id>
+// Returns a user's presence as seen by the caller. Presence is only shared
+// with related users (see relatedUsersQuery).
+func HandlePresence(w http.ResponseWriter, r *http.Request) {
+	viewerID := utils.GetUserIDFromContext(r)
+	if viewerID == "" {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	userID := r.URL.Query().Get("user_id")
+	if id, err := strconv.ParseInt(userID, 10, 64); err != nil || id <= 0 {
+		utils.Error(w, http.StatusBadRequest, "Invalid user_id")
+		return
+	}
+	p, err := loadPresence(userID)
+	if err == sql.ErrNoRows {
+		utils.Error(w, http.StatusNotFound, "User not found")
+		return
+	} else if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if userID != viewerID && !isRelated(userID, viewerID) {
+		utils.Error(w, http.StatusForbidden, "Presence is only shared with related users")
+		return
+	}
+	utils.JSON(w, http.StatusOK, p.view(userID, hub.IsOnline(userID), p.visibility == lastSeenFollowers && follows(viewerID, userID)))
+}
+
+// HandlePresenceSettings - GET/POST /api/presence/settings
+// Reads or updates the caller's chosen status (online, away, dnd, invisible)
+// and who may see their last seen time (everyone, followers, nobody). POST
+// accepts either field; omitted fields are left unchanged.
+func HandlePresenceSettings(w http.ResponseWriter, r *http.Request) {
+	userID := utils.GetUserIDFromContext(r)
+	if userID == "" {
+		utils.Error(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var p presenceRow
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		p, err = loadPresence(userID)
+	case http.MethodPost:
+		var payload struct {
+			Status             string `json:"status"`
+			LastSeenVisibility string `json:"last_seen_visibility"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			utils.Error(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if payload.Status != "" && !validStatus(payload.Status) {
+			utils.Error(w, http.StatusBadRequest, "Invalid status")
+			return
+		}
+		if payload.LastSeenVisibility != "" && !validLastSeenVisibility(payload.LastSeenVisibility) {
+			utils.Error(w, http.StatusBadRequest, "Invalid last_seen_visibility")
+			return
+		}
+		p, err = updatePresenceSettings(userID, payload.Status, payload.LastSeenVisibility)
+	default:
+		utils.Error(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	utils.JSON(w, http.StatusOK, map[string]string{"status": p.status, "last_seen_visibility": p.visibility})
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"social-network/backend/db"
+	"social-network/backend/utils"
+)
+
+// createTestGroup adds a group owned by the first member with all of them
+// in it.
+func createTestGroup(t *testing.T, members ...*Client) int64 {
+	t.Helper()
+	res, err := db.DB.Exec("INSERT INTO groups (owner_id, name) VALUES (?, 'g')", members[0].userID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupID, _ := res.LastInsertId()
+	for _, c := range members {
+		if _, err := db.DB.Exec("INSERT INTO group_members (group_id, user_id) VALUES (?, ?)", groupID, c.userID()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return groupID
+}
+
+// typesOf returns the type of every frame.
+func typesOf(frames []string) []string {
+	types := make([]string, len(frames))
+	for i, f := range frames {
+		types[i] = eventType([]byte(f))
+	}
+	return types
+}
+
+func TestGroupOnline(t *testing.T) {
+	openTestHub(t)
+	alice, bob, carol := newTestClient(t, "alice"), newTestClient(t, "bob"), newTestClient(t, "carol")
+	dave := newTestClient(t, "dave") // never connects
+	for _, c := range []*Client{alice, bob, carol} {
+		hub.Register(c)
+	}
+	groupID := createTestGroup(t, alice, bob, dave)
+
+	get := func(c *Client, groupID string) (int, []int64) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/api/group/online?group_id="+groupID, nil)
+		w := httptest.NewRecorder()
+		HandleGroupOnline(w, r.WithContext(context.WithValue(r.Context(), utils.UserIDKey, c.ID)))
+		var got struct {
+			UserIDs []int64 `json:"user_ids"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &got)
+		return w.Code, got.UserIDs
+	}
+	if code, ids := get(bob, strconv.FormatInt(groupID, 10)); code != http.StatusOK || !slices.Equal(ids, []int64{alice.userID(), bob.userID()}) {
+		t.Errorf("online members = %d %v, want [%d %d]", code, ids, alice.userID(), bob.userID())
+	}
+	if code, _ := get(carol, strconv.FormatInt(groupID, 10)); code != http.StatusForbidden {
+		t.Errorf("non-member = %d, want 403", code)
+	}
+	if code, _ := get(bob, "x"); code != http.StatusBadRequest {
+		t.Errorf("bad group_id = %d, want 400", code)
+	}
+}
+
+func TestGroupTyping(t *testing.T) {
+	openTestHub(t)
+	alice, bob, carol := newTestClient(t, "alice"), newTestClient(t, "bob"), newTestClient(t, "carol")
+	for _, c := range []*Client{alice, bob, carol} {
+		hub.Register(c)
+	}
+	groupID := createTestGroup(t, alice, bob)
+
+	if err := sendGroupTyping(alice, "typing", groupID); err != nil {
+		t.Fatal(err)
+	}
+	frames := drain(bob)
+	if len(frames) != 1 {
+		t.Fatalf("member got %v, want one typing frame", frames)
+	}
+	var got map[string]interface{}
+	json.Unmarshal([]byte(frames[0]), &got)
+	if got["type"] != "typing" || got["group_id"] != float64(groupID) || got["sender_id"] != alice.ID || got["sender_name"] != "alice" {
+		t.Errorf("typing frame = %v", got)
+	}
+	if frames := drain(alice); len(frames) != 0 {
+		t.Errorf("sender got their own typing: %v", frames)
+	}
+	if frames := drain(carol); len(frames) != 0 {
+		t.Errorf("non-member got %v", frames)
+	}
+
+	if err := sendGroupTyping(carol, "typing", groupID); err == nil {
+		t.Error("non-member typing in the group was accepted")
+	}
+	if err := (&typingFrame{ReceiverID: bob.ID, GroupID: groupID}).validate(); err == nil {
+		t.Error("a typing frame with both receiver_id and group_id was accepted")
+	}
+}
+
+func TestPresenceScopedToRelationships(t *testing.T) {
+	openTestHub(t)
+	alice, bob, carol := newTestClient(t, "alice"), newTestClient(t, "bob"), newTestClient(t, "carol")
+	hub.Register(bob)
+	hub.Register(carol)
+	db.DB.Exec("INSERT INTO followers (follower_id, followed_id) VALUES (?, ?)", bob.userID(), alice.userID())
+
+	hub.Register(alice)
+	publishPresence(alice.ID)
+	if types := typesOf(drain(bob)); !slices.Equal(types, []string{"presence"}) {
+		t.Errorf("follower got %v, want [presence]", types)
+	}
+	if types := typesOf(drain(carol)); len(types) != 0 {
+		t.Errorf("stranger got %v", types)
+	}
+
+	// the user list only has related users, online ones first
+	payload, err := userListPayload(bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var frame struct {
+		Content string `json:"content"`
+	}
+	json.Unmarshal(payload, &frame)
+	var users []struct {
+		ID       string `json:"id"`
+		IsOnline bool   `json:"is_online"`
+	}
+	json.Unmarshal([]byte(frame.Content), &users)
+	if len(users) != 1 || users[0].ID != alice.ID || !users[0].IsOnline {
+		t.Errorf("bob's user list = %+v, want only alice online", users)
+	}
+}
+
+// getPresence returns userID's presence as viewer sees it.
+func getPresence(t *testing.T, viewer *Client, userID string) (int, presenceView) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/api/presence?user_id="+userID, nil)
+	w := httptest.NewRecorder()
+	HandlePresence(w, r.WithContext(context.WithValue(r.Context(), utils.UserIDKey, viewer.ID)))
+	var v presenceView
+	json.Unmarshal(w.Body.Bytes(), &v)
+	return w.Code, v
+}
+
+func TestPresenceStatus(t *testing.T) {
+	openTestHub(t)
+	alice, bob, carol := newTestClient(t, "alice"), newTestClient(t, "bob"), newTestClient(t, "carol")
+	db.DB.Exec("INSERT INTO followers (follower_id, followed_id) VALUES (?, ?)", bob.userID(), alice.userID())
+	hub.Register(bob)
+
+	status := func() string {
+		t.Helper()
+		code, v := getPresence(t, bob, alice.ID)
+		if code != http.StatusOK {
+			t.Fatalf("GET /api/presence = %d", code)
+		}
+		return v.Status
+	}
+	if got := status(); got != statusOffline {
+		t.Errorf("before connecting alice is %q, want offline", got)
+	}
+	hub.Register(alice)
+	if got := status(); got != statusOnline {
+		t.Errorf("connected alice is %q, want online", got)
+	}
+
+	// away while every connection is idle
+	second := &Client{ID: alice.ID, Nickname: "alice", Send: make(chan []byte, sendQueueSize)}
+	hub.Register(second)
+	hub.SetIdle(alice, true)
+	if hub.UpdateIdle(alice.ID) || status() != statusOnline {
+		t.Error("alice is away although one connection is active")
+	}
+	hub.SetIdle(second, true)
+	if !hub.UpdateIdle(alice.ID) || status() != statusAway {
+		t.Error("alice is not away with every connection idle")
+	}
+
+	for _, s := range []string{statusDND, statusAway, statusOnline} {
+		if _, err := updatePresenceSettings(alice.ID, s, ""); err != nil {
+			t.Fatal(err)
+		}
+		want := s
+		if s == statusOnline {
+			want = statusAway // still idle
+		}
+		if got := status(); got != want {
+			t.Errorf("alice chose %q and shows as %q, want %q", s, got, want)
+		}
+	}
+	drain(bob)
+	if _, err := updatePresenceSettings(alice.ID, statusInvisible, ""); err != nil {
+		t.Fatal(err)
+	}
+	if code, v := getPresence(t, bob, alice.ID); code != http.StatusOK || v.Status != statusOffline || v.IsOnline || v.LastSeenAt == nil {
+		t.Errorf("invisible alice = %+v, want offline with a last seen time", v)
+	}
+	if types := typesOf(drain(bob)); !slices.Equal(types, []string{"presence"}) {
+		t.Errorf("follower got %v when alice went invisible, want [presence]", types)
+	}
+	if types := typesOf(drain(alice)); !slices.Contains(types, "presence_settings") {
+		t.Errorf("alice's tabs got %v, want presence_settings", types)
+	}
+
+	if code, _ := getPresence(t, carol, alice.ID); code != http.StatusForbidden {
+		t.Errorf("unrelated viewer = %d, want 403", code)
+	}
+	if code, _ := getPresence(t, bob, "x"); code != http.StatusBadRequest {
+		t.Errorf("bad user_id = %d, want 400", code)
+	}
+}
+
+func TestLastSeenVisibility(t *testing.T) {
+	openTestHub(t)
+	alice, bob, carol := newTestClient(t, "alice"), newTestClient(t, "bob"), newTestClient(t, "carol")
+	db.DB.Exec("INSERT INTO followers (follower_id, followed_id) VALUES (?, ?), (?, ?)",
+		bob.userID(), alice.userID(), alice.userID(), carol.userID())
+	hub.Register(alice)
+	disconnect(alice)
+
+	for _, tc := range []struct {
+		visibility string
+		bob, carol bool // whether each sees alice's last seen time
+	}{
+		{lastSeenEveryone, true, true},
+		{lastSeenFollowers, true, false},
+		{lastSeenNobody, false, false},
+	} {
+		if _, err := updatePresenceSettings(alice.ID, "", tc.visibility); err != nil {
+			t.Fatal(err)
+		}
+		for viewer, want := range map[*Client]bool{bob: tc.bob, carol: tc.carol} {
+			if _, v := getPresence(t, viewer, alice.ID); (v.LastSeenAt != nil) != want {
+				t.Errorf("%s: %s sees last_seen_at %v, want %v", tc.visibility, viewer.Nickname, v.LastSeenAt, want)
+			}
+		}
+	}
+}
+
+func TestPresenceSettingsHandler(t *testing.T) {
+	openTestHub(t)
+	alice := newTestClient(t, "alice")
+	post := func(body string) (int, map[string]string) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, "/api/presence/settings", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		HandlePresenceSettings(w, r.WithContext(context.WithValue(r.Context(), utils.UserIDKey, alice.ID)))
+		var got map[string]string
+		json.Unmarshal(w.Body.Bytes(), &got)
+		return w.Code, got
+	}
+	if code, got := post(`{"status":"dnd"}`); code != http.StatusOK || got["status"] != statusDND || got["last_seen_visibility"] != lastSeenEveryone {
+		t.Errorf("setting dnd = %d %v", code, got)
+	}
+	if code, got := post(`{"last_seen_visibility":"nobody"}`); code != http.StatusOK || got["status"] != statusDND || got["last_seen_visibility"] != lastSeenNobody {
+		t.Errorf("setting visibility = %d %v, want the status kept", code, got)
+	}
+	for _, body := range []string{`{"status":"busy"}`, `{"last_seen_visibility":"friends"}`, `{`} {
+		if code, _ := post(body); code != http.StatusBadRequest {
+			t.Errorf("POST %s = %d, want 400", body, code)
+		}
+	}
+}
+
+func TestInvisibleMembers(t *testing.T) {
+	openTestHub(t)
+	alice, bob, carol := newTestClient(t, "alice"), newTestClient(t, "bob"), newTestClient(t, "carol")
+	for _, c := range []*Client{alice, bob, carol} {
+		hub.Register(c)
+	}
+	if _, err := updatePresenceSettings(bob.ID, statusInvisible, ""); err != nil {
+		t.Fatal(err)
+	}
+	groupID := createTestGroup(t, alice, bob, carol)
+
+	online, err := onlineGroupMembers(groupID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(online, []int64{alice.userID(), carol.userID()}) {
+		t.Errorf("online members = %v, want [%d %d]", online, alice.userID(), carol.userID())
+	}
+
+	// an invisible member still receives typing
+	drain(bob)
+	drain(carol)
+	if err := sendGroupTyping(alice, "typing", groupID); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*Client{bob, carol} {
+		if types := typesOf(drain(c)); !slices.Equal(types, []string{"typing"}) {
+			t.Errorf("%s got %v, want [typing]", c.Nickname, types)
+		}
+	}
+
+	// but their own typing reaches nobody, in the group or a DM
+	if err := sendGroupTyping(bob, "typing", groupID); err != nil {
+		t.Fatal(err)
+	}
+	typing := handleTypingFrame("typing")
+	if _, err := typing(bob, json.RawMessage(`{"receiver_id":"`+alice.ID+`"}`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*Client{alice, carol} {
+		if types := typesOf(drain(c)); len(types) != 0 {
+			t.Errorf("%s got %v from an invisible sender", c.Nickname, types)
+		}
+	}
+	if _, err := updatePresenceSettings(bob.ID, statusOnline, ""); err != nil {
+		t.Fatal(err)
+	}
+	drain(alice)
+	if _, err := typing(bob, json.RawMessage(`{"receiver_id":"`+alice.ID+`"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if types := typesOf(drain(alice)); !slices.Equal(types, []string{"typing"}) {
+		t.Errorf("alice got %v once bob was visible, want [typing]", types)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"social-network/backend/handlers"
+)
+
+// Websocket protocol
+//
+// Clients pick the protocol version with the Sec-WebSocket-Protocol header.
+// The only version today is "sn.v1"; a connection that offers no subprotocol
+// is served in legacy mode (flat frames, see below), and one that offers only
+// unknown versions is rejected before the upgrade.
+//
+// In sn.v1 every client frame is an envelope:
+//
+//	{"type": "message", "request_id": "r42", "data": {...}}
+//
+// type selects the handler (see frameHandlers), request_id is an optional
+// client-chosen correlation id and data holds the typed payload. Legacy
+// frames carry the payload fields next to type instead of inside data.
+//
+// The outcome of a frame is reported back to the sending connection only:
+//
+//	{"type": "ack", "request_id": "r42", "for": "message", "data": {...}}
+//	{"type": "error", "request_id": "r42", "for": "message", "code": "bad_request", "content": "..."}
+//
+// Acks are only sent when the frame had a request_id; errors are always sent.
+// Frames over the rate limits (see ratelimit.go) are rejected with code
+// "rate_limited" and "retry_after" in seconds; an identical message resent
+// to the same conversation within a few seconds is rejected as "duplicate".
+// Server-pushed events (message, group_message, typing, receipt,
+// notification, ...) keep their flat shape and carry no request_id.
+//
+// Every server-pushed event carries "seq", a per-user sequence number that
+// increases by one per event across all of the user's connections. On
+// connect the server sends {"type": "welcome", "seq": N}; events after N are
+// delivered live. A reconnecting client sends {"type": "resume", "data":
+// {"last_seq": M}} and receives the retained events M < seq <= N followed by
+// {"type": "resumed", ...}, or {"type": "resync_required", "seq": N} when
+// they are no longer retained (see eventlog.go). Typing events are not
+// replayed. user_list, presence, presence_settings and unread_count
+// snapshots and ack/error frames are not sequenced.
+const protocolV1 = "sn.v1"
+
+// supportedProtocols lists the subprotocols offered to the upgrader, newest
+// first.
+var supportedProtocols = []string{protocolV1}
+
+// Error codes carried in error frames.
+const (
+	codeBadRequest  = "bad_request"
+	codeUnknownType = "unknown_type"
+	codeForbidden   = "forbidden"
+	codeNotFound    = "not_found"
+	codeConflict    = "conflict"
+	codeInternal    = "internal"
+	// codeRateLimited errors carry retry_after (see ratelimit.go).
+	codeRateLimited = "rate_limited"
+	codeDuplicate   = "duplicate"
+)
+
+// inboundFrame is the sn.v1 envelope of a client frame.
+type inboundFrame struct {
+	Type      string          `json:"type"`
+	RequestID string          `json:"request_id,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// ackFrame confirms that a frame was processed. Not to be confused with the
+// inbound "ack" frame, which acknowledges delivery of a DM.
+type ackFrame struct {
+	Type      string      `json:"type"`
+	RequestID string      `json:"request_id,omitempty"`
+	For       string      `json:"for"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// errorFrame reports why a frame was rejected. content is kept as the human
+// readable message so legacy clients can display it unchanged. retry_after
+// (seconds) is set on rate_limited errors.
+type errorFrame struct {
+	Type       string  `json:"type"`
+	RequestID  string  `json:"request_id,omitempty"`
+	For        string  `json:"for,omitempty"`
+	Code       string  `json:"code"`
+	Content    string  `json:"content"`
+	RetryAfter float64 `json:"retry_after,omitempty"`
+}
+
+// frameError is an error that is safe to show to the client.
+type frameError struct {
+	Code       string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *frameError) Error() string { return e.Message }
+
+func badRequest(msg string) error {
+	return &frameError{Code: codeBadRequest, Message: msg}
+}
+
+// frameValidator is implemented by every inbound payload type.
+type frameValidator interface {
+	validate() error
+}
+
+// frameHandler processes the payload of one frame type and returns the data
+// to put in the ack frame, if any.
+type frameHandler func(c *Client, data json.RawMessage) (interface{}, error)
+
+// frameHandlers maps each inbound frame type to its handler.
+var frameHandlers = map[string]frameHandler{
+	"message":              handleDirectMessageFrame,
+	"group_message":        handleGroupMessageFrame,
+	"ack":                  handleReceiptFrame("ack"),
+	"read":                 handleReceiptFrame("read"),
+	"edit_message":         handleEditFrame("direct"),
+	"edit_group_message":   handleEditFrame("group"),
+	"delete_message":       handleDeleteFrame("direct"),
+	"delete_group_message": handleDeleteFrame("group"),
+	"add_reaction":         handleReactionFrame(false),
+	"remove_reaction":      handleReactionFrame(true),
+	"typing":               handleTypingFrame("typing"),
+	"stop_typing":          handleTypingFrame("stop_typing"),
+	"set_status":           handleSetStatusFrame,
+	"idle":                 handleIdleFrame,
+	"user_list_request":    handleUserListFrame,
+	"resume":               handleResumeFrame,
+}
+
+// checkSubprotocol rejects upgrade requests that only offer protocol versions
+// this server does not speak. Offering none selects legacy mode.
+func checkSubprotocol(offered []string) bool {
+	if len(offered) == 0 {
+		return true
+	}
+	for _, p := range offered {
+		for _, s := range supportedProtocols {
+			if strings.TrimSpace(p) == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodeFrame unmarshals a payload and validates it. Unknown fields are
+// ignored so legacy frames (which include type) decode too.
+func decodeFrame(data json.RawMessage, v frameValidator) error {
+	if len(bytes.TrimSpace(data)) == 0 {
+		data = json.RawMessage("{}")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return badRequest("Malformed frame data.")
+	}
+	return v.validate()
+}
+
+// decodeEnvelope reads the envelope of a raw frame according to the
+// connection's protocol version.
+func (c *Client) decodeEnvelope(msg []byte) (inboundFrame, error) {
+	var frame inboundFrame
+	if err := json.Unmarshal(msg, &frame); err != nil {
+		return frame, badRequest("Frame is not valid JSON.")
+	}
+	if c.Protocol != protocolV1 {
+		// legacy: the payload fields sit next to type
+		frame.Data = msg
+	}
+	if frame.Type == "" {
+		return frame, badRequest("Frame type is required.")
+	}
+	return frame, nil
+}
+
+// dispatch decodes, routes and answers one inbound frame.
+func (c *Client) dispatch(msg []byte) {
+	frame, err := c.decodeEnvelope(msg)
+	if err != nil {
+		c.sendError(frame, err)
+		return
+	}
+	handle, ok := frameHandlers[frame.Type]
+	if !ok {
+		c.sendError(frame, &frameError{Code: codeUnknownType, Message: "Unknown frame type: " + frame.Type})
+		return
+	}
+	if err := c.checkRate(frame.Type); err != nil {
+		c.sendError(frame, err)
+		return
+	}
+	data, err := handle(c, frame.Data)
+	if err != nil {
+		c.sendError(frame, err)
+		return
+	}
+	if frame.RequestID != "" {
+		payload, _ := json.Marshal(ackFrame{Type: "ack", RequestID: frame.RequestID, For: frame.Type, Data: data})
+		c.send(payload)
+	}
+}
+
+// sendError reports a rejected frame to this connection. Errors that are not
+// frameErrors are mapped from the handlers package's message errors; anything
+// unexpected is logged and reported as a generic internal error.
+func (c *Client) sendError(frame inboundFrame, err error) {
+	out := errorFrame{Type: "error", RequestID: frame.RequestID, For: frame.Type}
+	if fe, ok := err.(*frameError); ok {
+		out.Code, out.Content = fe.Code, fe.Message
+		// round up so a client waiting retry_after never comes back early
+		out.RetryAfter = math.Ceil(fe.RetryAfter.Seconds()*10) / 10
+	} else {
+		switch handlers.MessageErrorStatus(err) {
+		case http.StatusBadRequest:
+			out.Code = codeBadRequest
+		case http.StatusForbidden:
+			out.Code = codeForbidden
+		case http.StatusNotFound:
+			out.Code = codeNotFound
+		case http.StatusConflict:
+			out.Code = codeConflict
+		default:
+			log.Printf("websocket %s frame from user %s failed: %v", frame.Type, c.ID, err)
+			out.Code = codeInternal
+			out.Content = "Something went wrong."
+		}
+		if out.Content == "" {
+			out.Content = err.Error()
+		}
+	}
+	payload, _ := json.Marshal(out)
+	c.send(payload)
+}
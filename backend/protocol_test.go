@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"social-network/backend/db"
+	"social-network/backend/utils"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCheckSubprotocol(t *testing.T) {
+	for _, tc := range []struct {
+		offered []string
+		want    bool
+	}{
+		{nil, true},
+		{[]string{"sn.v1"}, true},
+		{[]string{"sn.v9", " sn.v1"}, true},
+		{[]string{"sn.v9"}, false},
+	} {
+		if got := checkSubprotocol(tc.offered); got != tc.want {
+			t.Errorf("checkSubprotocol(%q) = %v, want %v", tc.offered, got, tc.want)
+		}
+	}
+}
+
+func TestUpgradeNegotiatesProtocol(t *testing.T) {
+	openTestHub(t)
+	alice := newTestClient(t, "alice")
+	conn := dialTestWebSocket(t, alice.ID, protocolV1)
+	if got := conn.Subprotocol(); got != protocolV1 {
+		t.Errorf("negotiated %q, want %q", got, protocolV1)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebSocket(w, r.WithContext(context.WithValue(r.Context(), utils.UserIDKey, alice.ID)))
+	}))
+	defer srv.Close()
+	dialer := websocket.Dialer{Subprotocols: []string{"sn.v9"}}
+	_, resp, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("offering only sn.v9: %v, want 400", err)
+	}
+}
+
+// dispatchFrames runs one inbound frame through c and returns the frames it
+// queued for c, decoded.
+func dispatchFrames(t *testing.T, c *Client, frame string) []map[string]interface{} {
+	t.Helper()
+	c.dispatch([]byte(frame))
+	var out []map[string]interface{}
+	for _, payload := range drain(c) {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(payload), &m); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// replyOf returns the ack or error frame among frames.
+func replyOf(frames []map[string]interface{}) map[string]interface{} {
+	for _, f := range frames {
+		if f["type"] == "ack" || f["type"] == "error" {
+			return f
+		}
+	}
+	return nil
+}
+
+func TestDispatchFrames(t *testing.T) {
+	openTestHub(t)
+	alice, bob, carol := newTestClient(t, "alice"), newTestClient(t, "bob"), newTestClient(t, "carol")
+	alice.Protocol = protocolV1
+	for _, c := range []*Client{alice, bob} {
+		hub.Register(c)
+	}
+	db.DB.Exec("INSERT INTO followers (follower_id, followed_id) VALUES (?, ?)", bob.userID(), alice.userID())
+
+	dm := fmt.Sprintf(`{"receiver_id":%q,"content":"hi"}`, bob.ID)
+	for _, tc := range []struct {
+		name, frame          string
+		typ, requestID, code string
+	}{
+		{"ack", `{"type":"message","request_id":"r1","data":` + dm + `}`, "ack", "r1", ""},
+		{"no request id", fmt.Sprintf(`{"type":"message","data":{"receiver_id":%q,"content":"hi again"}}`, bob.ID), "", "", ""},
+		{"not json", `{"type":`, "error", "", codeBadRequest},
+		{"no type", `{"request_id":"r2"}`, "error", "r2", codeBadRequest},
+		{"unknown type", `{"type":"shout","request_id":"r3"}`, "error", "r3", codeUnknownType},
+		{"invalid data", `{"type":"message","request_id":"r4","data":{"receiver_id":"bob","content":"hi"}}`, "error", "r4", codeBadRequest},
+		{"malformed data", `{"type":"message","request_id":"r5","data":[1]}`, "error", "r5", codeBadRequest},
+		{"forbidden", fmt.Sprintf(`{"type":"message","request_id":"r6","data":{"receiver_id":%q,"content":"hi"}}`, carol.ID), "error", "r6", codeForbidden},
+		{"handler error", `{"type":"edit_message","request_id":"r7","data":{"message_id":999,"content":"x"}}`, "error", "r7", codeNotFound},
+	} {
+		reply := replyOf(dispatchFrames(t, alice, tc.frame))
+		if tc.typ == "" {
+			if reply != nil {
+				t.Errorf("%s: got %v, want no reply", tc.name, reply)
+			}
+			continue
+		}
+		if reply == nil || reply["type"] != tc.typ || (tc.requestID != "" && reply["request_id"] != tc.requestID) ||
+			(tc.code != "" && reply["code"] != tc.code) {
+			t.Errorf("%s: got %v, want %s %s %s", tc.name, reply, tc.typ, tc.requestID, tc.code)
+		}
+	}
+	if got := drain(bob); len(got) < 2 {
+		t.Errorf("bob got %d frames, want both DMs", len(got))
+	}
+
+	// legacy clients send the payload flat, next to type
+	reply := replyOf(dispatchFrames(t, bob, fmt.Sprintf(`{"type":"message","request_id":"l1","receiver_id":%q,"content":"hey"}`, alice.ID)))
+	if reply == nil || reply["type"] != "ack" || reply["for"] != "message" {
+		t.Errorf("legacy frame: got %v, want an ack", reply)
+	}
+	var count int
+	db.DB.QueryRow("SELECT COUNT(*) FROM messages").Scan(&count)
+	if count != 3 {
+		t.Errorf("%d messages stored, want 3", count)
+	}
+}
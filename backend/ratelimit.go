@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+// Inbound frames are rate limited with token buckets at two levels: every
+// connection has an overall budget (connectionLimit) and every user has a
+// budget per frame type (frameLimits) shared by all of their connections on
+// this instance. A rejected frame gets an error frame with code
+// "rate_limited" and retry_after, the seconds until a token is available.
+
+// frameLimit refills rate tokens per second up to burst; each frame costs one.
+type frameLimit struct {
+	rate  float64
+	burst float64
+}
+
+var (
+	frameLimits = map[string]frameLimit{
+		"message":              {rate: 2, burst: 10},
+		"group_message":        {rate: 2, burst: 10},
+		"edit_message":         {rate: 1, burst: 5},
+		"edit_group_message":   {rate: 1, burst: 5},
+		"delete_message":       {rate: 1, burst: 10},
+		"delete_group_message": {rate: 1, burst: 10},
+		"add_reaction":         {rate: 3, burst: 10},
+		"remove_reaction":      {rate: 3, burst: 10},
+		"typing":               {rate: 2, burst: 6},
+		"stop_typing":          {rate: 2, burst: 6},
+		"set_status":           {rate: 0.5, burst: 5},
+		"idle":                 {rate: 1, burst: 5},
+		"user_list_request":    {rate: 0.5, burst: 3},
+		// receipts are sent per message and resume per replay batch
+		"ack":    {rate: 20, burst: 100},
+		"read":   {rate: 5, burst: 20},
+		"resume": {rate: 5, burst: 20},
+	}
+	// defaultFrameLimit applies to frame types missing from frameLimits.
+	defaultFrameLimit = frameLimit{rate: 5, burst: 20}
+	// connectionLimit bounds all frames of one connection together.
+	connectionLimit = frameLimit{rate: 30, burst: 120}
+)
+
+const (
+	// duplicateWindow is how long an identical message to the same
+	// conversation is treated as an accidental resend and dropped.
+	duplicateWindow = 5 * time.Second
+	// limiterIdle is how long a bucket or duplicate entry is kept unused;
+	// a bucket unused that long is full again anyway.
+	limiterIdle = 10 * time.Minute
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take spends a token and returns 0, or returns how long until one is
+// available without spending anything.
+func (b *tokenBucket) take(l frameLimit, now time.Time) time.Duration {
+	if b.last.IsZero() {
+		b.tokens = l.burst
+	} else {
+		b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// rateLimiter holds the per-user buckets, keyed by user id and frame type.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+var userFrameLimiter = &rateLimiter{buckets: make(map[string]*tokenBucket)}
+
+func (r *rateLimiter) take(key string, l frameLimit) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &tokenBucket{}
+		r.buckets[key] = b
+	}
+	return b.take(l, time.Now())
+}
+
+// prune forgets buckets unused for limiterIdle.
+func (r *rateLimiter) prune() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, b := range r.buckets {
+		if time.Since(b.last) > limiterIdle {
+			delete(r.buckets, key)
+		}
+	}
+}
+
+// checkRate spends a token of c's connection budget and of its user's budget
+// for frame type typ, or returns a rate_limited frameError.
+func (c *Client) checkRate(typ string) error {
+	wait := c.limit.take(connectionLimit, time.Now())
+	if wait == 0 {
+		l, ok := frameLimits[typ]
+		if !ok {
+			l = defaultFrameLimit
+		}
+		wait = userFrameLimiter.take(c.ID+"|"+typ, l)
+	}
+	if wait == 0 {
+		return nil
+	}
+	wsFramesRateLimited.Add(1)
+	return &frameError{
+		Code:       codeRateLimited,
+		Message:    fmt.Sprintf("Too many requests, retry in %.1fs.", wait.Seconds()),
+		RetryAfter: wait,
+	}
+}
+
+type recentMessage struct {
+	hash uint64
+	at   time.Time
+}
+
+// duplicateFilter remembers the last message each user sent to each
+// conversation.
+type duplicateFilter struct {
+	mu   sync.Mutex
+	last map[string]recentMessage
+}
+
+var recentMessages = &duplicateFilter{last: make(map[string]recentMessage)}
+
+// messageHash identifies a message by its content and attachments.
+func messageHash(content string, attachmentIDs []int64) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\x00%v", content, attachmentIDs)
+	return h.Sum64()
+}
+
+// seen reports whether userID sent the same content and attachments to
+// conversation (e.g. "user:2", "group:5") within duplicateWindow.
+func (d *duplicateFilter) seen(userID, conversation, content string, attachmentIDs []int64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	prev, ok := d.last[userID+"|"+conversation]
+	return ok && prev.hash == messageHash(content, attachmentIDs) && time.Since(prev.at) < duplicateWindow
+}
+
+// record remembers a message once it was stored, so a failed send can be
+// retried without being taken for a duplicate.
+func (d *duplicateFilter) record(userID, conversation, content string, attachmentIDs []int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.last[userID+"|"+conversation] = recentMessage{hash: messageHash(content, attachmentIDs), at: time.Now()}
+}
+
+// prune forgets entries older than limiterIdle.
+func (d *duplicateFilter) prune() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, m := range d.last {
+		if time.Since(m.at) > limiterIdle {
+			delete(d.last, key)
+		}
+	}
+}
+
+// errDuplicateMessage rejects an accidental resend; the first copy was
+// already delivered.
+var errDuplicateMessage = &frameError{Code: codeDuplicate, Message: "Duplicate message ignored."}
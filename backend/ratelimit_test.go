@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"social-network/backend/db"
+)
+
+func TestTokenBucket(t *testing.T) {
+	l := frameLimit{rate: 2, burst: 3}
+	var b tokenBucket
+	now := time.Now()
+
+	// a fresh bucket allows a full burst, then asks to wait for one token
+	for i := range 3 {
+		if wait := b.take(l, now); wait != 0 {
+			t.Fatalf("frame %d of the burst waits %s", i+1, wait)
+		}
+	}
+	if wait := b.take(l, now); wait != 500*time.Millisecond {
+		t.Errorf("after the burst wait = %s, want 500ms", wait)
+	}
+	// a rejected frame spends nothing
+	if wait := b.take(l, now.Add(250*time.Millisecond)); wait != 250*time.Millisecond {
+		t.Errorf("a quarter second later wait = %s, want 250ms", wait)
+	}
+	if wait := b.take(l, now.Add(500*time.Millisecond)); wait != 0 {
+		t.Errorf("after refilling one token wait = %s, want 0", wait)
+	}
+	// refills never exceed the burst
+	now = now.Add(time.Hour)
+	for i := range 3 {
+		if wait := b.take(l, now); wait != 0 {
+			t.Fatalf("frame %d after an hour waits %s", i+1, wait)
+		}
+	}
+	if wait := b.take(l, now); wait == 0 {
+		t.Error("an idle bucket refilled past its burst")
+	}
+}
+
+func TestRateLimitedFrames(t *testing.T) {
+	openTestHub(t)
+	alice := newTestClient(t, "alice")
+	alice.Protocol = protocolV1
+	hub.Register(alice)
+	limited := wsFramesRateLimited.Value()
+
+	// user_list_request allows a burst of 3, then 0.5 per second
+	for i := range 3 {
+		if reply := replyOf(dispatchFrames(t, alice, fmt.Sprintf(`{"type":"user_list_request","request_id":"r%d"}`, i))); reply["type"] != "ack" {
+			t.Fatalf("request %d = %v, want an ack", i+1, reply)
+		}
+	}
+	reply := replyOf(dispatchFrames(t, alice, `{"type":"user_list_request","request_id":"r3"}`))
+	if reply["code"] != codeRateLimited || reply["request_id"] != "r3" {
+		t.Fatalf("request over the burst = %v, want rate_limited", reply)
+	}
+	if after, _ := reply["retry_after"].(float64); after <= 1.9 || after > 2 {
+		t.Errorf("retry_after = %v, want about 2 seconds", reply["retry_after"])
+	}
+	if got := wsFramesRateLimited.Value() - limited; got != 1 {
+		t.Errorf("%d rate limited frames counted, want 1", got)
+	}
+
+	// the budget is per user and type: another connection of alice's shares
+	// it, other frame types do not
+	second := &Client{ID: alice.ID, Nickname: "alice", Send: make(chan []byte, sendQueueSize)}
+	hub.Register(second)
+	if reply := replyOf(dispatchFrames(t, second, `{"type":"user_list_request","request_id":"r4"}`)); reply["code"] != codeRateLimited {
+		t.Errorf("alice's second connection = %v, want rate_limited", reply)
+	}
+	if reply := replyOf(dispatchFrames(t, alice, `{"type":"idle","request_id":"r5","data":{"idle":true}}`)); reply["type"] != "ack" {
+		t.Errorf("another frame type = %v, want an ack", reply)
+	}
+
+	// the connection budget bounds all types together
+	alice.limit = tokenBucket{tokens: 0, last: time.Now()}
+	if reply := replyOf(dispatchFrames(t, alice, `{"type":"idle","request_id":"r6","data":{"idle":false}}`)); reply["code"] != codeRateLimited {
+		t.Errorf("over the connection budget = %v, want rate_limited", reply)
+	}
+}
+
+func TestDuplicateFilter(t *testing.T) {
+	d := &duplicateFilter{last: make(map[string]recentMessage)}
+	if d.seen("1", "user:2", "hi", nil) {
+		t.Fatal("the first message was a duplicate")
+	}
+	if d.seen("1", "user:2", "hi", nil) {
+		t.Fatal("an unrecorded message was a duplicate")
+	}
+	d.record("1", "user:2", "hi", nil)
+	if !d.seen("1", "user:2", "hi", nil) {
+		t.Error("an immediate resend was not a duplicate")
+	}
+	for _, tc := range []struct {
+		user, conversation, content string
+		attachments                 []int64
+	}{
+		{"1", "user:2", "hi", []int64{7}},
+		{"1", "user:3", "hi", nil},
+		{"4", "user:2", "hi", nil},
+		{"1", "user:2", "hello", nil},
+	} {
+		if d.seen(tc.user, tc.conversation, tc.content, tc.attachments) {
+			t.Errorf("%+v was a duplicate", tc)
+		}
+	}
+
+	// after duplicateWindow the same message is new again
+	d.last["1|user:2"] = recentMessage{hash: d.last["1|user:2"].hash, at: time.Now().Add(-duplicateWindow)}
+	if d.seen("1", "user:2", "hi", nil) {
+		t.Error("a resend after the window was a duplicate")
+	}
+}
+
+func TestDuplicateMessageFrame(t *testing.T) {
+	openTestHub(t)
+	alice, bob := newTestClient(t, "alice"), newTestClient(t, "bob")
+	alice.Protocol = protocolV1
+	hub.Register(alice)
+	db.DB.Exec("INSERT INTO followers (follower_id, followed_id) VALUES (?, ?)", bob.userID(), alice.userID())
+	frame := fmt.Sprintf(`{"type":"message","request_id":"r1","data":{"receiver_id":%q,"content":"hi"}}`, bob.ID)
+
+	// a send that failed to store can be retried
+	db.DB.Exec("CREATE TRIGGER fail_messages BEFORE INSERT ON messages BEGIN SELECT RAISE(ABORT, 'unavailable'); END")
+	if reply := replyOf(dispatchFrames(t, alice, frame)); reply["type"] != "error" || reply["code"] == codeDuplicate {
+		t.Fatalf("send while messages fail = %v, want an error", reply)
+	}
+	db.DB.Exec("DROP TRIGGER fail_messages")
+
+	if reply := replyOf(dispatchFrames(t, alice, frame)); reply["type"] != "ack" {
+		t.Fatalf("first send = %v, want an ack", reply)
+	}
+	if reply := replyOf(dispatchFrames(t, alice, frame)); reply["code"] != codeDuplicate {
+		t.Errorf("resend = %v, want duplicate", reply)
+	}
+	var stored int
+	db.DB.QueryRow("SELECT COUNT(*) FROM messages WHERE sender_id = ?", alice.ID).Scan(&stored)
+	if stored != 1 {
+		t.Errorf("%d messages stored, want 1", stored)
+	}
+}
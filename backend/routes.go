@@ -1,6 +1,7 @@
 package main
 
 import (
+	"expvar"
 	"net/http"
 	"os"
 	"social-network/backend/handlers"
@@ -16,9 +17,23 @@ func registerRoutes(mux *http.ServeMux) {
 
 	// Websocket endpoint (protected by auth middleware so context contains user ID)
 	mux.Handle("/ws", AuthMiddleware(http.HandlerFunc(HandleWebSocket)))
+	mux.Handle("/api/events", AuthMiddleware(http.HandlerFunc(HandleEvents)))
+	// rich presence: status, last seen and its visibility
+	mux.Handle("/api/presence", AuthMiddleware(http.HandlerFunc(HandlePresence)))
+	mux.Handle("/api/presence/settings", AuthMiddleware(http.HandlerFunc(HandlePresenceSettings)))
+	// websocket/runtime counters (expvar), admins only
+	mux.Handle("/debug/vars", AdminMiddleware(expvar.Handler()))
 
 	// chat message history
 	mux.Handle("/api/messages/history", AuthMiddleware(http.HandlerFunc(handlers.GetMessageHistory)))
+	// read receipts
+	mux.Handle("/api/messages/read", AuthMiddleware(http.HandlerFunc(handlers.MarkConversationReadHandler)))
+	mux.Handle("/api/messages/receipts", AuthMiddleware(http.HandlerFunc(handlers.GetReceiptsHandler)))
+	mux.Handle("/api/messages/edit", AuthMiddleware(http.HandlerFunc(handlers.EditMessageHandler)))
+	mux.Handle("/api/messages/delete", AuthMiddleware(http.HandlerFunc(handlers.DeleteMessageHandler)))
+	mux.Handle("/api/messages/react", AuthMiddleware(http.HandlerFunc(handlers.ReactMessageHandler)))
+	// full-text search over DMs and group chats
+	mux.Handle("/api/search/messages", AuthMiddleware(http.HandlerFunc(handlers.SearchMessagesHandler)))
 
 	// API endpoints
 	mux.HandleFunc("/register", handlers.RegisterHandler)
@@ -44,12 +59,42 @@ func registerRoutes(mux *http.ServeMux) {
 	// posts
 	mux.Handle("/api/posts/create", AuthMiddleware(http.HandlerFunc(handlers.CreatePostHandler)))
 	mux.HandleFunc("/api/posts", handlers.ListFeedHandler)
+	mux.Handle("/api/posts/update", AuthMiddleware(http.HandlerFunc(handlers.UpdatePostHandler)))
+	mux.Handle("/api/posts/delete", AuthMiddleware(http.HandlerFunc(handlers.DeletePostHandler)))
+	mux.Handle("/api/posts/history", AuthMiddleware(http.HandlerFunc(handlers.PostHistoryHandler)))
+	mux.HandleFunc("/api/posts/comments", handlers.ListCommentsHandler)
+	mux.Handle("/api/posts/audience", AuthMiddleware(http.HandlerFunc(handlers.PostAudienceHandler)))
 
 	// notifications
 	// sanitized user list endpoint
 	mux.HandleFunc("/api/users", handlers.PublicUsersHandler)
 	mux.Handle("/api/notifications", AuthMiddleware(http.HandlerFunc(handlers.ListNotificationsHandler)))
 	mux.Handle("/api/notifications/mark-read", AuthMiddleware(http.HandlerFunc(handlers.MarkNotificationsReadHandler)))
+	mux.Handle("/api/notifications/unread-count", AuthMiddleware(http.HandlerFunc(handlers.UnreadCountHandler)))
+	mux.Handle("/api/notifications/delete", AuthMiddleware(http.HandlerFunc(handlers.DeleteNotificationHandler)))
+	mux.Handle("/api/notifications/clear", AuthMiddleware(http.HandlerFunc(handlers.ClearNotificationsHandler)))
+	mux.Handle("/api/notifications/preferences", AuthMiddleware(http.HandlerFunc(handlers.NotificationPreferencesHandler)))
+	mux.Handle("/api/notifications/digest", AuthMiddleware(http.HandlerFunc(handlers.DigestSettingsHandler)))
+	// token authenticated: the link in digest emails
+	mux.HandleFunc("/api/notifications/unsubscribe", handlers.UnsubscribeDigestHandler)
+	// web push
+	mux.HandleFunc("/api/push/vapid-public-key", handlers.VAPIDPublicKeyHandler)
+	mux.Handle("/api/push/subscribe", AuthMiddleware(http.HandlerFunc(handlers.SubscribePushHandler)))
+	mux.Handle("/api/push/unsubscribe", AuthMiddleware(http.HandlerFunc(handlers.UnsubscribePushHandler)))
+	mux.Handle("/api/push/subscriptions", AuthMiddleware(http.HandlerFunc(handlers.ListPushSubscriptionsHandler)))
+	// webhooks
+	mux.Handle("/api/webhooks", AuthMiddleware(http.HandlerFunc(handlers.ListWebhooksHandler)))
+	mux.Handle("/api/webhooks/create", AuthMiddleware(http.HandlerFunc(handlers.CreateWebhookHandler)))
+	mux.Handle("/api/webhooks/update", AuthMiddleware(http.HandlerFunc(handlers.UpdateWebhookHandler)))
+	mux.Handle("/api/webhooks/delete", AuthMiddleware(http.HandlerFunc(handlers.DeleteWebhookHandler)))
+	mux.Handle("/api/webhooks/deliveries", AuthMiddleware(http.HandlerFunc(handlers.WebhookDeliveriesHandler)))
+	mux.Handle("/api/webhooks/test", AuthMiddleware(http.HandlerFunc(handlers.TestWebhookHandler)))
+	// background jobs (admins only)
+	mux.Handle("/api/admin/jobs", AdminMiddleware(http.HandlerFunc(handlers.AdminJobsHandler)))
+	mux.Handle("/api/admin/jobs/stats", AdminMiddleware(http.HandlerFunc(handlers.AdminJobStatsHandler)))
+	mux.Handle("/api/admin/jobs/retry", AdminMiddleware(http.HandlerFunc(handlers.AdminRetryJobHandler)))
+	mux.Handle("/api/admin/jobs/cancel", AdminMiddleware(http.HandlerFunc(handlers.AdminCancelJobHandler)))
+	mux.Handle("/api/admin/jobs/run-schedule", AdminMiddleware(http.HandlerFunc(handlers.AdminRunScheduleHandler)))
 	mux.Handle("/api/group/create", AuthMiddleware(http.HandlerFunc(handlers.CreateGroupHandler)))
 	mux.HandleFunc("/api/groups", handlers.ListGroupsHandler)
 	mux.HandleFunc("/api/group", handlers.GetGroupHandler)
@@ -61,18 +106,32 @@ func registerRoutes(mux *http.ServeMux) {
 	mux.Handle("/api/group/requests", AuthMiddleware(http.HandlerFunc(handlers.ListRequestsHandler)))
 	mux.Handle("/api/group/request/status", AuthMiddleware(http.HandlerFunc(handlers.GetRequestStatusHandler)))
 	mux.Handle("/api/group/post/create", AuthMiddleware(http.HandlerFunc(handlers.CreateGroupPostHandler)))
+	mux.Handle("/api/group/post/update", AuthMiddleware(http.HandlerFunc(handlers.UpdateGroupPostHandler)))
+	mux.Handle("/api/group/post/delete", AuthMiddleware(http.HandlerFunc(handlers.DeleteGroupPostHandler)))
 	mux.HandleFunc("/api/group/posts", handlers.ListGroupPostsHandler)
 	// group messages history
 	mux.Handle("/api/group/messages", AuthMiddleware(http.HandlerFunc(handlers.ListGroupMessagesHandler)))
+	mux.Handle("/api/group/messages/edit", AuthMiddleware(http.HandlerFunc(handlers.EditGroupMessageHandler)))
+	mux.Handle("/api/group/messages/delete", AuthMiddleware(http.HandlerFunc(handlers.DeleteGroupMessageHandler)))
+	mux.Handle("/api/group/messages/react", AuthMiddleware(http.HandlerFunc(handlers.ReactGroupMessageHandler)))
+	mux.Handle("/api/group/online", AuthMiddleware(http.HandlerFunc(HandleGroupOnline)))
+	mux.Handle("/api/group/mute", AuthMiddleware(http.HandlerFunc(handlers.MuteGroupHandler)))
+	mux.Handle("/api/group/unmute", AuthMiddleware(http.HandlerFunc(handlers.UnmuteGroupHandler)))
 	mux.Handle("/api/group/comment", AuthMiddleware(http.HandlerFunc(handlers.AddGroupCommentHandler)))
+	mux.Handle("/api/group/comment/update", AuthMiddleware(http.HandlerFunc(handlers.UpdateGroupCommentHandler)))
+	mux.Handle("/api/group/comment/delete", AuthMiddleware(http.HandlerFunc(handlers.DeleteGroupCommentHandler)))
 	mux.Handle("/api/group/event/create", AuthMiddleware(http.HandlerFunc(handlers.CreateEventHandler)))
 	mux.Handle("/api/group/event/vote", AuthMiddleware(http.HandlerFunc(handlers.VoteEventHandler)))
 	mux.Handle("/api/group/events", AuthMiddleware(http.HandlerFunc(handlers.ListEventsHandler)))
 	mux.Handle("/api/posts/comment", AuthMiddleware(http.HandlerFunc(handlers.AddCommentHandler)))
+	mux.Handle("/api/posts/comment/update", AuthMiddleware(http.HandlerFunc(handlers.UpdateCommentHandler)))
+	mux.Handle("/api/posts/comment/delete", AuthMiddleware(http.HandlerFunc(handlers.DeleteCommentHandler)))
 
 	// serve uploaded images
 	mux.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir("backend/uploads"))))
 
 	// upload endpoint
 	mux.Handle("/api/upload", AuthMiddleware(http.HandlerFunc(handlers.UploadHandler)))
+	// chat attachments (participants only)
+	mux.Handle("/api/attachments/", AuthMiddleware(http.HandlerFunc(handlers.GetAttachmentHandler)))
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"social-network/backend/utils"
+)
+
+// HandleEvents - GET /api/events
+// Server-Sent Events fallback for clients whose proxies break websockets. It
+// streams the same events a websocket connection receives (messages,
+// notifications, receipts, presence, ...) from the same hub, one JSON frame
+// per "data:" line. Sequenced events carry their seq as the SSE id, so the
+// browser's automatic reconnect (Last-Event-ID header, or ?last_event_id= for
+// the first connect) replays what was missed. The stream is receive-only:
+// sending goes through the REST endpoints.
+func HandleEvents(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(utils.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var lastSeq int64 = -1
+	if lastID != "" {
+		parsed, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil || parsed < 0 {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastSeq = parsed
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// ask reverse proxies (nginx) not to buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	client := &Client{
+		ID:       userID,
+		Nickname: userNickname(userID),
+		Protocol: "sse",
+		Send:     make(chan []byte, sendQueueSize),
+		done:     make(chan struct{}),
+	}
+	cameOnline := hub.Register(client)
+	defer disconnect(client)
+	rc := http.NewResponseController(w)
+
+	// write sends one frame; id is the event's seq, 0 for unsequenced frames
+	// (welcome, user_list, ...) which must not move Last-Event-ID.
+	write := func(payload []byte, id int64) error {
+		rc.SetWriteDeadline(time.Now().Add(writeWait))
+		if id > 0 {
+			if _, err := fmt.Fprintf(w, "id: %d\n", id); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", payload); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	// retry hint for EventSource, then the same welcome a websocket gets
+	fmt.Fprintf(w, "retry: %d\n\n", 3000)
+	welcome, _ := json.Marshal(map[string]interface{}{"type": "welcome", "protocol": client.Protocol, "seq": client.baseSeq})
+	if write(welcome, 0) != nil {
+		return
+	}
+
+	// Replay missed events straight to the stream; anything newer than
+	// baseSeq is already waiting in client.Send.
+	if lastSeq >= 0 {
+		for after := lastSeq; ; {
+			events, hasMore, complete, err := eventsSince(userID, after, client.baseSeq, replayBatchSize)
+			if err != nil {
+				log.Println("SSE replay error:", err)
+				break
+			}
+			if !complete {
+				resync, _ := json.Marshal(map[string]interface{}{"type": "resync_required", "seq": client.baseSeq})
+				write(resync, 0)
+				break
+			}
+			for _, e := range events {
+				if write(e, eventSeq(e)) != nil {
+					return
+				}
+			}
+			if !hasMore || len(events) == 0 {
+				break
+			}
+			after = eventSeq(events[len(events)-1])
+		}
+	}
+
+	announceConnect(client, cameOnline)
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case payload, ok := <-client.Send:
+			if !ok {
+				return
+			}
+			if write(payload, eventSeq(payload)) != nil {
+				return
+			}
+		case <-ticker.C:
+			// comment line keeps idle proxies from closing the stream
+			rc.SetWriteDeadline(time.Now().Add(writeWait))
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-client.done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
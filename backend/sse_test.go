@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"social-network/backend/utils"
+)
+
+// sseEvent is one event read off an event stream.
+type sseEvent struct {
+	id   string
+	data map[string]interface{}
+}
+
+// openTestStream connects to HandleEvents as userID with the given
+// Last-Event-ID and returns the response and the stream's events. The stream
+// is closed when the test ends, which waits for the user to go offline.
+func openTestStream(t *testing.T, userID, lastEventID string) (*http.Response, <-chan sseEvent) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleEvents(w, r.WithContext(context.WithValue(r.Context(), utils.UserIDKey, userID)))
+	}))
+	t.Cleanup(srv.Close)
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		resp.Body.Close()
+		waitFor(t, "the stream to close", func() bool { return !hub.IsOnline(userID) })
+	})
+
+	events := make(chan sseEvent, 64)
+	go func() {
+		defer close(events)
+		var ev sseEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				ev.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev.data)
+			case line == "" && ev.data != nil:
+				events <- ev
+				ev = sseEvent{}
+			}
+		}
+	}()
+	return resp, events
+}
+
+// nextEvent returns the next event of the given type, skipping others.
+func nextEvent(t *testing.T, events <-chan sseEvent, eventType string) sseEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				t.Fatalf("stream ended waiting for %s", eventType)
+			}
+			if ev.data["type"] == eventType {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", eventType)
+		}
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	openTestHub(t)
+	alice := newTestClient(t, "alice")
+	for _, typ := range []string{"message", "notification", "message"} {
+		hub.SendToUser(alice.ID, []byte(`{"type":"`+typ+`"}`)) // seqs 1-3, missed
+	}
+
+	resp, events := openTestStream(t, alice.ID, "1")
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if welcome := nextEvent(t, events, "welcome"); welcome.id != "" || welcome.data["protocol"] != "sse" || welcome.data["seq"] != 3.0 {
+		t.Errorf("welcome = %+v, want protocol sse at seq 3 without an id", welcome)
+	}
+	// the missed events are replayed with their seq as the event id
+	if ev := nextEvent(t, events, "notification"); ev.id != "2" {
+		t.Errorf("replayed notification id = %q, want 2", ev.id)
+	}
+	if ev := nextEvent(t, events, "message"); ev.id != "3" {
+		t.Errorf("replayed message id = %q, want 3", ev.id)
+	}
+	waitFor(t, "alice to come online", func() bool { return hub.IsOnline(alice.ID) })
+
+	// live events arrive too; unsequenced ones must not move Last-Event-ID
+	hub.SendToUser(alice.ID, []byte(`{"type":"typing"}`))
+	hub.SendToUser(alice.ID, []byte(`{"type":"message"}`))
+	if ev := nextEvent(t, events, "typing"); ev.id != "" {
+		t.Errorf("typing carried id %q", ev.id)
+	}
+	if ev := nextEvent(t, events, "message"); ev.id != "4" {
+		t.Errorf("live message id = %q, want 4", ev.id)
+	}
+}
+
+func TestEventStreamResync(t *testing.T) {
+	openTestHub(t)
+	alice := newTestClient(t, "alice")
+	hub.SendToUser(alice.ID, []byte(`{"type":"message"}`))
+
+	_, events := openTestStream(t, alice.ID, "5")
+	if ev := nextEvent(t, events, "resync_required"); ev.data["seq"] != 1.0 {
+		t.Errorf("resync_required = %+v, want seq 1", ev)
+	}
+}
+
+func TestEventStreamRejectsBadLastEventID(t *testing.T) {
+	openTestHub(t)
+	alice := newTestClient(t, "alice")
+	for _, id := range []string{"x", "-1"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/events", nil)
+		req.Header.Set("Last-Event-ID", id)
+		rec := httptest.NewRecorder()
+		HandleEvents(rec, req.WithContext(context.WithValue(req.Context(), utils.UserIDKey, alice.ID)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Last-Event-ID %q = %d, want 400", id, rec.Code)
+		}
+	}
+}
+
+func TestEventStreamEvicted(t *testing.T) {
+	openTestHub(t)
+	alice := newTestClient(t, "alice")
+	_, events := openTestStream(t, alice.ID, "")
+	nextEvent(t, events, "welcome")
+	waitFor(t, "alice to come online", func() bool { return hub.IsOnline(alice.ID) })
+
+	// a stream that cannot keep up is ended like a websocket would be
+	hub.mu.RLock()
+	for c := range hub.conns[alice.ID] {
+		c.evict("test")
+	}
+	hub.mu.RUnlock()
+	for range events {
+	}
+	waitFor(t, "alice to go offline", func() bool { return !hub.IsOnline(alice.ID) })
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"social-network/backend/bus"
+	"social-network/backend/db"
+)
+
+// openTestHub points db.DB at a fresh, migrated database and hub at a hub
+// on an in-memory bus, and forgets the rate limits and recent messages of
+// earlier tests. It runs from the repository root, where InitDB finds the
+// migrations.
+func openTestHub(t *testing.T) {
+	t.Helper()
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+	t.Chdir("..")
+	db.InitDB()
+	t.Cleanup(func() { db.DB.Close() })
+	hub = newHub(bus.NewMemory())
+	userFrameLimiter = &rateLimiter{buckets: make(map[string]*tokenBucket)}
+	recentMessages = &duplicateFilter{last: make(map[string]recentMessage)}
+}
+
+// newTestClient adds a user with the given nickname and returns a connection
+// for them, not yet registered.
+func newTestClient(t *testing.T, nickname string) *Client {
+	t.Helper()
+	res, err := db.DB.Exec("INSERT INTO users (email, password, first_name, last_name, nickname) VALUES (?, 'x', ?, 'L', ?)",
+		nickname+"@example.com", nickname, nickname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return &Client{ID: strconv.FormatInt(id, 10), Nickname: nickname, Send: make(chan []byte, 256)}
+}
+
+// drain empties c's send queue and returns the frames in it.
+func drain(c *Client) []string {
+	var frames []string
+	for {
+		select {
+		case payload, ok := <-c.Send:
+			if !ok {
+				return frames
+			}
+			frames = append(frames, string(payload))
+		default:
+			return frames
+		}
+	}
+}
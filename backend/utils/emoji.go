@@ -0,0 +1,113 @@
+package utils
+
+import "strings"
+
+// emojiShortcodes is the small set of shortcodes expanded in chat input.
+var emojiShortcodes = map[string]string{
+	":smile:":     "😄",
+	":heart:":     "❤️",
+	":thumbs_up:": "👍",
+	":laugh:":     "😂",
+	":cry:":       "😢",
+}
+
+// ExpandEmoji replaces known shortcodes (e.g. ":smile:") with their emoji
+func ExpandEmoji(s string) string {
+	for k, v := range emojiShortcodes {
+		if strings.Contains(s, k) {
+			s = strings.ReplaceAll(s, k, v)
+		}
+	}
+	return s
+}
+
+const (
+	zeroWidthJoiner = '\u200d'
+	keycapMark      = '\u20e3'
+	textPresenter   = '\ufe0e'
+	emojiPresenter  = '\ufe0f'
+	tagCancel       = '\U000e007f'
+)
+
+// emojiRanges are the code points that start an emoji: pictographs, the
+// symbols and dingbats blocks, and the older symbols with an emoji
+// presentation (©, ™, ↔, ⌚, ▶, ⬛, 〰, ...).
+var emojiRanges = [][2]rune{
+	{0x00a9, 0x00a9}, {0x00ae, 0x00ae}, {0x203c, 0x203c}, {0x2049, 0x2049},
+	{0x2122, 0x2122}, {0x2139, 0x2139}, {0x2194, 0x21aa}, {0x231a, 0x23ff},
+	{0x24c2, 0x24c2}, {0x25aa, 0x25fe}, {0x2600, 0x27bf}, {0x2934, 0x2935},
+	{0x2b05, 0x2b55}, {0x3030, 0x3030}, {0x303d, 0x303d}, {0x3297, 0x3299},
+	{0x1f000, 0x1faff},
+}
+
+func isEmojiBase(r rune) bool {
+	if isSkinTone(r) || isRegionalIndicator(r) {
+		return false
+	}
+	for _, rg := range emojiRanges {
+		if r >= rg[0] && r <= rg[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func isSkinTone(r rune) bool          { return r >= 0x1f3fb && r <= 0x1f3ff }
+func isRegionalIndicator(r rune) bool { return r >= 0x1f1e6 && r <= 0x1f1ff }
+func isTag(r rune) bool               { return r >= 0xe0020 && r <= 0xe007e }
+
+// IsEmojiSequence reports whether s is exactly one emoji: a keycap (1️⃣), a
+// flag made of two regional indicators, or pictographs joined by zero width
+// joiners, each optionally followed by a variation selector, a skin tone
+// modifier and a tag sequence (as in subdivision flags).
+func IsEmojiSequence(s string) bool {
+	runes := []rune(s)
+	i := 0
+	for {
+		if i >= len(runes) {
+			return false
+		}
+		r := runes[i]
+		i++
+		switch {
+		case r == '#' || r == '*' || (r >= '0' && r <= '9'):
+			if i < len(runes) && runes[i] == emojiPresenter {
+				i++
+			}
+			if i >= len(runes) || runes[i] != keycapMark {
+				return false
+			}
+			i++
+		case isRegionalIndicator(r):
+			if i >= len(runes) || !isRegionalIndicator(runes[i]) {
+				return false
+			}
+			i++
+		case isEmojiBase(r):
+			if i < len(runes) && (runes[i] == emojiPresenter || runes[i] == textPresenter) {
+				i++
+			}
+			if i < len(runes) && isSkinTone(runes[i]) {
+				i++
+			}
+			if i < len(runes) && isTag(runes[i]) {
+				for i < len(runes) && isTag(runes[i]) {
+					i++
+				}
+				if i >= len(runes) || runes[i] != tagCancel {
+					return false
+				}
+				i++
+			}
+		default:
+			return false
+		}
+		if i == len(runes) {
+			return true
+		}
+		if runes[i] != zeroWidthJoiner {
+			return false
+		}
+		i++
+	}
+}
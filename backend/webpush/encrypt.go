@@ -0,0 +1,134 @@
+package webpush
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// Payloads are encrypted per RFC 8291 with the aes128gcm content coding of
+// RFC 8188, as a single record.
+
+const (
+	// recordSize is the record size announced in the header; the payload,
+	// its delimiter and the GCM tag must fit in one record.
+	recordSize = 4096
+	// MaxPayload is the largest payload Encrypt accepts.
+	MaxPayload = recordSize - 16 - 1
+)
+
+var ErrPayloadTooLarge = errors.New("webpush: payload too large")
+
+// Encrypt encrypts plaintext for a subscription with the user agent public
+// key p256dh and authentication secret auth.
+func Encrypt(p256dh, auth, plaintext []byte) ([]byte, error) {
+	if len(plaintext) > MaxPayload {
+		return nil, ErrPayloadTooLarge
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(p256dh)
+	if err != nil {
+		return nil, errors.New("webpush: invalid p256dh key")
+	}
+	if len(auth) != 16 {
+		return nil, errors.New("webpush: auth secret must be 16 bytes")
+	}
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	secret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+	gcm, nonce, err := contentKeys(secret, auth, salt, p256dh, asPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.Write(salt)
+	binary.Write(&out, binary.BigEndian, uint32(recordSize))
+	out.WriteByte(byte(len(asPublic)))
+	out.Write(asPublic)
+	// 0x02 delimits the last (and only) record; no padding
+	record := append(append([]byte{}, plaintext...), 0x02)
+	out.Write(gcm.Seal(nil, nonce, record, nil))
+	return out.Bytes(), nil
+}
+
+// decrypt reverses Encrypt for the user agent holding uaPrivate; the stub
+// push service uses it to check what was sent.
+func decrypt(uaPrivate *ecdh.PrivateKey, auth, body []byte) ([]byte, error) {
+	if len(body) < 21 {
+		return nil, errors.New("webpush: truncated header")
+	}
+	salt, rs, idLen := body[:16], binary.BigEndian.Uint32(body[16:20]), int(body[20])
+	if len(body) < 21+idLen || rs < 18 {
+		return nil, errors.New("webpush: truncated header")
+	}
+	asPublicBytes, ciphertext := body[21:21+idLen], body[21+idLen:]
+	if len(ciphertext) > int(rs) {
+		return nil, errors.New("webpush: only single record payloads are supported")
+	}
+	asPublic, err := ecdh.P256().NewPublicKey(asPublicBytes)
+	if err != nil {
+		return nil, errors.New("webpush: invalid sender key")
+	}
+	secret, err := uaPrivate.ECDH(asPublic)
+	if err != nil {
+		return nil, err
+	}
+	gcm, nonce, err := contentKeys(secret, auth, salt, uaPrivate.PublicKey().Bytes(), asPublicBytes)
+	if err != nil {
+		return nil, err
+	}
+	record, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+	// strip padding back to the delimiter
+	end := bytes.LastIndexByte(record, 0x02)
+	if end < 0 || len(bytes.Trim(record[end+1:], "\x00")) != 0 {
+		return nil, errors.New("webpush: invalid record delimiter")
+	}
+	return record[:end], nil
+}
+
+// contentKeys derives the AES-GCM key and nonce from the ECDH secret
+// (RFC 8291 section 3.4, RFC 8188 section 2.2).
+func contentKeys(secret, auth, salt, uaPublic, asPublic []byte) (cipher.AEAD, []byte, error) {
+	keyInfo := "WebPush: info\x00" + string(uaPublic) + string(asPublic)
+	ikm, err := hkdf.Key(sha256.New, secret, auth, keyInfo, 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	return gcm, nonce, err
+}
@@ -0,0 +1,78 @@
+package webpush
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+)
+
+func mustB64(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatalf("decoding %q: %v", s, err)
+	}
+	return b
+}
+
+// TestDecryptRFC8291Example checks the key derivation and record format
+// against the example in RFC 8291 appendix A.
+func TestDecryptRFC8291Example(t *testing.T) {
+	uaPrivate, err := ecdh.P256().NewPrivateKey(mustB64(t, "q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := uaPrivate.PublicKey().Bytes(), mustB64(t, "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"); !bytes.Equal(got, want) {
+		t.Fatalf("user agent public key = %x, want %x", got, want)
+	}
+	auth := mustB64(t, "BTBZMqHH6r4Tts7J_aSIgg")
+	body := mustB64(t, "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN")
+
+	plaintext, err := decrypt(uaPrivate, auth, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "When I grow up, I want to be a watermelon"; string(plaintext) != want {
+		t.Fatalf("decrypted %q, want %q", plaintext, want)
+	}
+
+	asPrivate, err := ecdh.P256().NewPrivateKey(mustB64(t, "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := asPrivate.ECDH(uaPrivate.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, nonce, err := contentKeys(secret, auth, mustB64(t, "DGv6ra1nlYgDCS1FRnbzlw"), uaPrivate.PublicKey().Bytes(), asPrivate.PublicKey().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := mustB64(t, "4h_95klXJ5E_qnoN"); !bytes.Equal(nonce, want) {
+		t.Fatalf("nonce = %x, want %x", nonce, want)
+	}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	uaPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	for _, plaintext := range [][]byte{{}, []byte(`{"title":"Social Network"}`), bytes.Repeat([]byte{0x02}, MaxPayload)} {
+		body, err := Encrypt(uaPrivate.PublicKey().Bytes(), auth, plaintext)
+		if err != nil {
+			t.Fatalf("Encrypt %d bytes: %v", len(plaintext), err)
+		}
+		got, err := decrypt(uaPrivate, auth, body)
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Fatalf("round trip of %d bytes = %d bytes, %v", len(plaintext), len(got), err)
+		}
+	}
+	if _, err := Encrypt(uaPrivate.PublicKey().Bytes(), auth, make([]byte, MaxPayload+1)); err != ErrPayloadTooLarge {
+		t.Fatalf("Encrypt over MaxPayload = %v, want ErrPayloadTooLarge", err)
+	}
+}
@@ -0,0 +1,135 @@
+package webpush
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Subscription is a browser's PushSubscription: where to post and the keys
+// to encrypt for, both base64url encoded as in PushSubscription.toJSON().
+type Subscription struct {
+	Endpoint string
+	P256dh   string
+	Auth     string
+}
+
+// Options are the push message headers of RFC 8030.
+type Options struct {
+	// TTL is how long the push service keeps the message for an offline
+	// device; 0 means deliver now or never.
+	TTL time.Duration
+	// Urgency is "very-low", "low", "normal" or "high"; empty means normal.
+	Urgency string
+	// Topic replaces a pending message with the same topic; at most 32
+	// base64url characters.
+	Topic string
+}
+
+// ErrGone means the subscription expired or was revoked (404 or 410); it
+// must be deleted.
+var ErrGone = errors.New("webpush: subscription gone")
+
+// StatusError is any other rejection by the push service.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("webpush: push service returned %d: %s", e.StatusCode, e.Body)
+}
+
+// Sender posts encrypted messages to push services.
+type Sender struct {
+	Key *VAPIDKey
+	// Subject is the contact push services can reach the operator at, a
+	// mailto: or https: URL.
+	Subject string
+	Client  *http.Client
+}
+
+// vapidTTL is how long the VAPID token of a request is valid; at most 24h.
+const vapidTTL = 12 * time.Hour
+
+// Send encrypts payload for sub and posts it to sub.Endpoint.
+func (s *Sender) Send(sub Subscription, payload []byte, opts Options) error {
+	p256dh, err := decodeB64(sub.P256dh)
+	if err != nil {
+		return fmt.Errorf("webpush: invalid p256dh: %w", err)
+	}
+	auth, err := decodeB64(sub.Auth)
+	if err != nil {
+		return fmt.Errorf("webpush: invalid auth: %w", err)
+	}
+	body, err := Encrypt(p256dh, auth, payload)
+	if err != nil {
+		return err
+	}
+	authorization, err := s.Key.authorization(sub.Endpoint, s.Subject, vapidTTL)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(opts.TTL.Seconds())))
+	if opts.Urgency != "" {
+		req.Header.Set("Urgency", opts.Urgency)
+	}
+	if opts.Topic != "" {
+		req.Header.Set("Topic", opts.Topic)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrGone
+	}
+	return &StatusError{StatusCode: resp.StatusCode, Body: string(msg)}
+}
+
+// Validate checks that sub can be sent to: an https endpoint (http is
+// allowed for localhost, e.g. the Stub) and well-formed keys.
+func (sub Subscription) Validate() error {
+	u, err := url.Parse(sub.Endpoint)
+	if err != nil || u.Host == "" {
+		return errors.New("invalid endpoint")
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && (u.Hostname() == "localhost" || u.Hostname() == "127.0.0.1")) {
+		return errors.New("endpoint must be https")
+	}
+	p256dh, err := decodeB64(sub.P256dh)
+	if err != nil {
+		return errors.New("invalid p256dh key")
+	}
+	if _, err := ecdh.P256().NewPublicKey(p256dh); err != nil {
+		return errors.New("invalid p256dh key")
+	}
+	if auth, err := decodeB64(sub.Auth); err != nil || len(auth) != 16 {
+		return errors.New("auth secret must be 16 bytes")
+	}
+	return nil
+}
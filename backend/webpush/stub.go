@@ -0,0 +1,183 @@
+package webpush
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stub is a stand-in push service for development and tests. It hands out
+// subscriptions like a browser would and accepts pushes to them like a push
+// service, checking the VAPID authorization and decrypting the payload so it
+// can be inspected. Mounted under prefix (e.g. "/push-stub/"):
+//
+//	POST   {prefix}subscriptions  new subscription, as PushSubscription.toJSON()
+//	POST   {prefix}push/{id}      the push endpoint
+//	GET    {prefix}push/{id}      messages received, oldest first
+//	DELETE {prefix}push/{id}      expire the subscription: pushes get 410 Gone
+type Stub struct {
+	prefix string
+	mu     sync.Mutex
+	nextID int
+	subs   map[string]*stubSubscription
+}
+
+type stubSubscription struct {
+	key      *ecdh.PrivateKey
+	auth     []byte
+	gone     bool
+	messages []StubMessage
+}
+
+// StubMessage is a push received by the stub.
+type StubMessage struct {
+	Payload    json.RawMessage `json:"payload"`
+	TTL        int             `json:"ttl"`
+	Urgency    string          `json:"urgency,omitempty"`
+	Topic      string          `json:"topic,omitempty"`
+	ReceivedAt time.Time       `json:"received_at"`
+}
+
+// NewStub returns a stub serving the paths under prefix.
+func NewStub(prefix string) *Stub {
+	return &Stub{prefix: prefix, subs: make(map[string]*stubSubscription)}
+}
+
+func (s *Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, s.prefix)
+	switch {
+	case path == "subscriptions" && r.Method == http.MethodPost:
+		s.subscribe(w, r)
+	case strings.HasPrefix(path, "push/"):
+		s.mu.Lock()
+		sub, ok := s.subs[strings.TrimPrefix(path, "push/")]
+		s.mu.Unlock()
+		if !ok {
+			http.Error(w, "unknown subscription", http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodPost:
+			s.receive(w, r, sub)
+		case http.MethodGet:
+			s.mu.Lock()
+			messages := append([]StubMessage{}, sub.messages...)
+			s.mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(messages)
+		case http.MethodDelete:
+			s.mu.Lock()
+			sub.gone = true
+			s.mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Stub) subscribe(w http.ResponseWriter, r *http.Request) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+
+	s.mu.Lock()
+	s.nextID++
+	id := strconv.Itoa(s.nextID)
+	s.subs[id] = &stubSubscription{key: key, auth: auth}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"endpoint":       origin(r) + s.prefix + "push/" + id,
+		"expirationTime": nil,
+		"keys": map[string]string{
+			"p256dh": b64.EncodeToString(key.PublicKey().Bytes()),
+			"auth":   b64.EncodeToString(auth),
+		},
+	})
+}
+
+// receive validates a push like a push service and stores its payload.
+func (s *Stub) receive(w http.ResponseWriter, r *http.Request, sub *stubSubscription) {
+	s.mu.Lock()
+	gone := sub.gone
+	s.mu.Unlock()
+	if gone {
+		http.Error(w, "subscription expired", http.StatusGone)
+		return
+	}
+	if err := verifyAuthorization(r.Header.Get("Authorization"), origin(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	ttl, err := strconv.Atoi(r.Header.Get("TTL"))
+	if err != nil || ttl < 0 {
+		http.Error(w, "missing or invalid TTL", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Content-Encoding") != "aes128gcm" {
+		http.Error(w, "unsupported content encoding", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, recordSize+1024))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	plaintext, err := decrypt(sub.key, sub.auth, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	payload := json.RawMessage(plaintext)
+	if !json.Valid(plaintext) {
+		payload, _ = json.Marshal(string(plaintext))
+	}
+	msg := StubMessage{
+		Payload:    payload,
+		TTL:        ttl,
+		Urgency:    r.Header.Get("Urgency"),
+		Topic:      r.Header.Get("Topic"),
+		ReceivedAt: time.Now().UTC(),
+	}
+
+	s.mu.Lock()
+	replaced := false
+	if msg.Topic != "" {
+		// a new message with the same topic replaces the pending one
+		for i := range sub.messages {
+			if sub.messages[i].Topic == msg.Topic {
+				sub.messages[i] = msg
+				replaced = true
+			}
+		}
+	}
+	if !replaced {
+		sub.messages = append(sub.messages, msg)
+	}
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusCreated)
+}
+
+// origin is the scheme and host the request was addressed to: the VAPID
+// audience of the stub's endpoints.
+func origin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,153 @@
+package webpush
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// b64 is the encoding of keys and tokens in Web Push: base64url without
+// padding.
+var b64 = base64.RawURLEncoding
+
+// decodeB64 also accepts padded input, which some browsers send.
+func decodeB64(s string) ([]byte, error) {
+	return b64.DecodeString(strings.TrimRight(s, "="))
+}
+
+// VAPIDKey is the application server's P-256 key pair (RFC 8292). Browsers
+// bind subscriptions to its public key, so it must stay the same across
+// restarts.
+type VAPIDKey struct {
+	priv *ecdsa.PrivateKey
+}
+
+// GenerateVAPIDKey creates a new key pair.
+func GenerateVAPIDKey() (*VAPIDKey, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &VAPIDKey{priv: priv}, nil
+}
+
+// ParseVAPIDKey decodes a private key as returned by PrivateKey.
+func ParseVAPIDKey(s string) (*VAPIDKey, error) {
+	d, err := decodeB64(s)
+	if err != nil || len(d) != 32 {
+		return nil, errors.New("vapid: private key must be 32 base64url bytes")
+	}
+	// derive the public point through crypto/ecdh, which validates d
+	ecdhKey, err := ecdh.P256().NewPrivateKey(d)
+	if err != nil {
+		return nil, fmt.Errorf("vapid: %w", err)
+	}
+	point := ecdhKey.PublicKey().Bytes()
+	return &VAPIDKey{priv: &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(point[1:33]),
+			Y:     new(big.Int).SetBytes(point[33:]),
+		},
+		D: new(big.Int).SetBytes(d),
+	}}, nil
+}
+
+// PrivateKey returns the private scalar, base64url encoded.
+func (k *VAPIDKey) PrivateKey() string {
+	return b64.EncodeToString(k.priv.D.FillBytes(make([]byte, 32)))
+}
+
+// PublicKey returns the uncompressed public point, base64url encoded: the
+// applicationServerKey browsers subscribe with.
+func (k *VAPIDKey) PublicKey() string {
+	pub, _ := k.priv.PublicKey.ECDH()
+	return b64.EncodeToString(pub.Bytes())
+}
+
+// authorization returns the Authorization header for a push to endpoint:
+// a JWT for the endpoint's origin signed with ES256, and the public key.
+func (k *VAPIDKey) authorization(endpoint, subject string, ttl time.Duration) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header := b64.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, _ := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(ttl).Unix(),
+		"sub": subject,
+	})
+	signed := header + "." + b64.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, k.priv, digest[:])
+	if err != nil {
+		return "", err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return fmt.Sprintf("vapid t=%s.%s, k=%s", signed, b64.EncodeToString(sig), k.PublicKey()), nil
+}
+
+// verifyAuthorization checks a VAPID Authorization header as a push service
+// does: a valid ES256 signature by k, the audience and expiry.
+func verifyAuthorization(header, audience string) error {
+	params := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(header, "vapid "), ",") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(part), "="); ok {
+			params[key] = value
+		}
+	}
+	token, key := params["t"], params["k"]
+	parts := strings.Split(token, ".")
+	if !strings.HasPrefix(header, "vapid ") || len(parts) != 3 || key == "" {
+		return errors.New("malformed vapid authorization")
+	}
+	point, err := decodeB64(key)
+	if err != nil || len(point) != 65 || point[0] != 4 {
+		return errors.New("malformed vapid public key")
+	}
+	pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(point[1:33]), Y: new(big.Int).SetBytes(point[33:])}
+	sig, err := decodeB64(parts[2])
+	if err != nil || len(sig) != 64 {
+		return errors.New("malformed vapid signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return errors.New("invalid vapid signature")
+	}
+	raw, err := decodeB64(parts[1])
+	if err != nil {
+		return errors.New("malformed vapid claims")
+	}
+	var claims struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+		Sub string `json:"sub"`
+	}
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return errors.New("malformed vapid claims")
+	}
+	switch {
+	case claims.Aud != audience:
+		return fmt.Errorf("vapid audience %q, want %q", claims.Aud, audience)
+	case time.Unix(claims.Exp, 0).Before(time.Now()):
+		return errors.New("vapid token expired")
+	case time.Unix(claims.Exp, 0).After(time.Now().Add(24 * time.Hour)):
+		return errors.New("vapid token expires more than 24h ahead")
+	case claims.Sub == "":
+		return errors.New("vapid subject missing")
+	}
+	return nil
+}
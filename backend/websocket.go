@@ -1,25 +1,39 @@
 package main
 
 import (
-	"database/sql"
 	"encoding/json"
-	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
 	"social-network/backend/db"
-	"social-network/backend/handlers"
-	"social-network/backend/models"
 	"social-network/backend/utils"
 
 	"github.com/gorilla/websocket"
 )
 
-const throttleRate = 500 * time.Millisecond
+const (
+	throttleRate = 500 * time.Millisecond
+
+	// writeWait is the time allowed to write a frame to the peer.
+	writeWait = 10 * time.Second
+	// maxMessageSize bounds a single inbound frame.
+	maxMessageSize = 64 * 1024
+	// sendQueueSize bounds the per-connection outbound queue; overflowing it
+	// evicts the connection.
+	sendQueueSize = 256
+)
+
+// pongWait is how long we wait for any frame (including a pong) before
+// treating the connection as dead; pingPeriod must be shorter so pongs arrive
+// in time. They are variables so tests can shorten them.
+var (
+	pongWait   = 60 * time.Second
+	pingPeriod = (pongWait * 9) / 10
+)
 
 var (
 	upgrader = websocket.Upgrader{
@@ -28,17 +42,93 @@ var (
 		CheckOrigin: func(r *http.Request) bool {
 			return true
 		},
+		Subprotocols: supportedProtocols,
 	}
-	clients      = make(map[string]*Client)
-	clientsMutex sync.RWMutex
 )
 
 type Client struct {
-	ID       string
-	Nickname string
-	Conn     *websocket.Conn
-	Send     chan []byte
-	lastSent time.Time
+	ID        string
+	Nickname  string
+	Protocol  string // negotiated subprotocol, "" for legacy clients
+	Conn      *websocket.Conn
+	Send      chan []byte
+	lastSent  time.Time
+	evictOnce sync.Once
+	// baseSeq is the user's last event sequence when this connection was
+	// registered; see Hub.Replay.
+	baseSeq int64
+	// done is closed to evict a connection without a websocket (SSE).
+	done chan struct{}
+	// idle is set by the client's idle frames; guarded by hub.mu.
+	idle bool
+	// limit is the connection's inbound frame budget, only touched by
+	// readPump (see checkRate).
+	limit tokenBucket
+}
+
+// userID returns the numeric id of the connected user.
+func (c *Client) userID() int64 {
+	id, _ := strconv.ParseInt(c.ID, 10, 64)
+	return id
+}
+
+// send queues a frame for this connection only (errors, acks, ...).
+func (c *Client) send(payload []byte) {
+	hub.SendToClient(c, payload)
+}
+
+// evict drops a connection that cannot keep up. Closing the socket makes
+// readPump fail, which unregisters the client and stops writePump; an SSE
+// stream ends when done is closed.
+func (c *Client) evict(reason string) {
+	c.evictOnce.Do(func() {
+		log.Printf("Evicting realtime client for user %s: %s", c.ID, reason)
+		wsSlowClientEvicted.Add(1)
+		if c.Conn != nil {
+			c.Conn.Close()
+		} else {
+			close(c.done)
+		}
+	})
+}
+
+// userNickname returns the nickname shown on realtime events from userID.
+func userNickname(userID string) string {
+	var nickname string
+	if err := db.DB.QueryRow("SELECT nickname FROM users WHERE id = ?", userID).Scan(&nickname); err != nil {
+		log.Println("Error fetching user nickname:", err)
+		return userID // fallback
+	}
+	return nickname
+}
+
+// announceConnect updates presence after Register: a user's first
+// connection anywhere flips them online for the users they are related to,
+// and a new (active) connection ends an idle spell. Every new connection gets
+// the user's presence settings and the current user list.
+func announceConnect(c *Client, cameOnline bool) {
+	idleChanged := hub.UpdateIdle(c.ID)
+	if cameOnline {
+		persistPresence(c.ID, true)
+	}
+	if cameOnline || idleChanged {
+		publishPresence(c.ID)
+	}
+	sendPresenceSettings(c)
+	sendUserList(c.ID)
+}
+
+// disconnect unregisters a connection and flips the user offline if it was
+// their last one, or away if only idle connections remain.
+func disconnect(c *Client) {
+	wentOffline := hub.Unregister(c)
+	idleChanged := hub.UpdateIdle(c.ID)
+	if wentOffline {
+		persistPresence(c.ID, false)
+	}
+	if wentOffline || idleChanged {
+		publishPresence(c.ID)
+	}
 }
 
 func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
@@ -48,42 +138,37 @@ func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Println("WebSocket upgrade error:", err)
+	if !checkSubprotocol(websocket.Subprotocols(r)) {
+		http.Error(w, "Unsupported websocket protocol version", http.StatusBadRequest)
 		return
 	}
 
-	// Get user nickname for the client
-	var nickname string
-	err = db.DB.QueryRow("SELECT nickname FROM users WHERE id = ?", userID).Scan(&nickname)
+	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("Error fetching user nickname:", err)
-		nickname = userID // fallback
+		log.Println("WebSocket upgrade error:", err)
+		return
 	}
 
+	nickname := userNickname(userID)
 	client := &Client{
 		ID:       userID,
 		Nickname: nickname,
+		Protocol: conn.Subprotocol(),
 		Conn:     conn,
-		Send:     make(chan []byte, 256),
-	}
-
-	clientsMutex.Lock()
-	if oldClient, exists := clients[userID]; exists {
-		oldClient.Conn.Close() // Triggers cleanup in readPump()
+		Send:     make(chan []byte, sendQueueSize),
 	}
-	clients[userID] = client
-	clientsMutex.Unlock()
 
-	fmt.Println("User connected:", userID, "Nickname:", nickname)
+	// several connections per user are allowed (tabs/devices); presence only
+	// changes when the first one opens
+	cameOnline := hub.Register(client)
+	log.Printf("User %s connected (%d connections)", userID, hub.ConnectionCount(userID))
 
-	_, err = db.DB.Exec("UPDATE users SET online_status = 1 WHERE id = ?", userID)
-	if err != nil {
-		log.Println("Error updating user status:", err)
-	}
+	// tell the client where its event stream starts; a reconnecting client
+	// answers with a resume frame to fetch what it missed
+	welcome, _ := json.Marshal(map[string]interface{}{"type": "welcome", "protocol": client.Protocol, "seq": client.baseSeq})
+	client.send(welcome)
 
-	sendOnlineUsers("")
+	announceConnect(client, cameOnline)
 	go client.readPump()
 	go client.writePump()
 }
@@ -91,264 +176,78 @@ func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 func (c *Client) readPump() {
 	defer func() {
 		c.Conn.Close()
-		clientsMutex.Lock()
-		delete(clients, c.ID)
-		clientsMutex.Unlock()
-		db.DB.Exec("UPDATE users SET online_status = 0 WHERE id = ?", c.ID)
-		db.DB.Exec("UPDATE users SET online_status = 0 WHERE id = ?", c.ID)
-		sendOnlineUsers("")
+		disconnect(c)
 	}()
 
+	// Any inbound frame (pongs included) extends the read deadline, so a
+	// half-open TCP connection times out instead of staying "online".
+	c.Conn.SetReadLimit(maxMessageSize)
+	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.Conn.SetPongHandler(func(string) error {
+		return c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
 	for {
 		_, msgBytes, err := c.Conn.ReadMessage()
 		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				wsPongTimeouts.Add(1)
+			} else if err == websocket.ErrReadLimit {
+				wsOversizedFrames.Add(1)
+			}
 			log.Println("WebSocket read error:", err)
 			break
 		}
+		c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+		c.dispatch(msgBytes)
+	}
+}
 
-		// decode into a lightweight struct to support group messages
-		var raw struct {
-			Type       string `json:"type"`
-			ReceiverID string `json:"receiver_id"`
-			GroupID    int64  `json:"group_id"`
-			Content    string `json:"content"`
-		}
-		if err := json.Unmarshal(msgBytes, &raw); err != nil {
-			log.Println("Message unmarshal error:", err)
-			continue
-		}
-
-		// basic emoji shortcode expansion (small set)
-		emojiMap := map[string]string{
-			":smile:":     "😄",
-			":heart:":     "❤️",
-			":thumbs_up:": "👍",
-			":laugh:":     "😂",
-			":cry:":       "😢",
-		}
-		for k, v := range emojiMap {
-			if strings.Contains(raw.Content, k) {
-				raw.Content = strings.ReplaceAll(raw.Content, k, v)
-			}
-		}
-
-		// DM (direct message)
-		if raw.Type == "message" {
-			// enforce allowed users: either follows the other
-			senderIDInt, _ := strconv.ParseInt(c.ID, 10, 64)
-			receiverIDInt, errConv := strconv.ParseInt(raw.ReceiverID, 10, 64)
-			if errConv != nil {
-				// invalid receiver id
-				continue
-			}
-
-			var relCount int
-			err := db.DB.QueryRow("SELECT COUNT(1) FROM followers WHERE (follower_id=? AND followed_id=?) OR (follower_id=? AND followed_id=?)", senderIDInt, receiverIDInt, receiverIDInt, senderIDInt).Scan(&relCount)
-			if err != nil {
-				log.Println("relation check error:", err)
-				continue
-			}
-			if relCount == 0 {
-				// not allowed to DM
-				errMsg := models.Message{Type: "error", Content: "You are not allowed to message this user."}
-				payload, _ := json.Marshal(errMsg)
-				c.Send <- payload
-				continue
-			}
-
-			// insert DM
-			result, err := db.DB.Exec("INSERT INTO messages (sender_id, receiver_id, content, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)", senderIDInt, receiverIDInt, raw.Content)
-			if err != nil {
-				log.Println("DB insert error:", err)
-				continue
-			}
-			msgID, _ := result.LastInsertId()
-			var createdAt string
-			db.DB.QueryRow("SELECT created_at FROM messages WHERE id = ?", msgID).Scan(&createdAt)
-
-			// prepare outgoing message
-			out := models.Message{
-				ID:         int(msgID),
-				Type:       "message",
-				Content:    raw.Content,
-				SenderID:   c.ID,
-				SenderName: c.Nickname,
-				ReceiverID: raw.ReceiverID,
-			}
-
-			// parse createdAt (DB returns string) and set CreatedAt on outgoing message
-			if parsed, err := time.Parse("2006-01-02 15:04:05", createdAt); err == nil {
-				out.CreatedAt = parsed
-			} else if parsedRFC, err2 := time.Parse(time.RFC3339, createdAt); err2 == nil {
-				out.CreatedAt = parsedRFC
-			}
-			encoded, _ := json.Marshal(out)
-
-			clientsMutex.RLock()
-			receiverClient, ok := clients[raw.ReceiverID]
-			clientsMutex.RUnlock()
-			if ok {
-				receiverClient.Send <- encoded
-				// lightweight realtime notification
-				notification := models.Message{Type: "new_message_notification", SenderID: out.SenderID, SenderName: out.SenderName, Content: out.Content}
-				notifPayload, _ := json.Marshal(notification)
-				receiverClient.Send <- notifPayload
-			}
-			// persist & publish structured notification (store preview only)
-			preview := raw.Content
-			if len(preview) > 140 {
-				preview = preview[:140]
-			}
-			_ = handlers.Notify(receiverIDInt, senderIDInt, "new_message", map[string]interface{}{"message_id": msgID, "conversation_id": receiverIDInt, "preview": preview, "url": "/chat"})
-
-			// echo back to sender
-			c.Send <- encoded
-			continue
-		}
-
-		// Group message
-		if raw.Type == "group_message" {
-			senderIDInt, _ := strconv.ParseInt(c.ID, 10, 64)
-			// check membership
-			var memberCount int
-			err := db.DB.QueryRow("SELECT COUNT(1) FROM group_members WHERE group_id=? AND user_id=?", raw.GroupID, senderIDInt).Scan(&memberCount)
-			if err != nil {
-				log.Println("group membership check error:", err)
-				continue
-			}
-			if memberCount == 0 {
-				errMsg := models.Message{Type: "error", Content: "You are not a member of this group."}
-				payload, _ := json.Marshal(errMsg)
-				c.Send <- payload
-				continue
-			}
-
-			// persist group message
-			res, err := db.DB.Exec("INSERT INTO group_messages (group_id, sender_id, content, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)", raw.GroupID, senderIDInt, raw.Content)
-			if err != nil {
-				log.Println("group message insert error:", err)
-				continue
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.Conn.Close()
+	}()
+	for {
+		select {
+		case msg, ok := <-c.Send:
+			if !ok {
+				// hub closed the queue: connection is being torn down
+				c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
 			}
-			gmID, _ := res.LastInsertId()
 
-			// build outgoing payload
-			out := map[string]interface{}{
-				"id":          gmID,
-				"type":        "group_message",
-				"group_id":    raw.GroupID,
-				"content":     raw.Content,
-				"sender_id":   c.ID,
-				"sender_name": c.Nickname,
+			// Extract the message type
+			var raw struct {
+				Type string `json:"type"`
 			}
-			encoded, _ := json.Marshal(out)
-
-			// notify group members (both realtime and persistent)
-			rows, err := db.DB.Query("SELECT user_id FROM group_members WHERE group_id=? AND user_id != ?", raw.GroupID, senderIDInt)
-			if err != nil {
-				log.Println("group members query error:", err)
+			if err := json.Unmarshal(msg, &raw); err != nil {
+				log.Println("Failed to parse message type:", err)
 				continue
 			}
-			defer rows.Close()
-			var uid int64
-			var recipients []int64
-			for rows.Next() {
-				if err := rows.Scan(&uid); err == nil {
-					recipients = append(recipients, uid)
-				}
-			}
-			// send to connected members
-			for _, rid := range recipients {
-				ridStr := strconv.FormatInt(rid, 10)
-				clientsMutex.RLock()
-				memberClient, ok := clients[ridStr]
-				clientsMutex.RUnlock()
-				if ok {
-					memberClient.Send <- encoded
-				}
-				// persist & publish structured group_message notification (preview + link)
-				preview := raw.Content
-				if len(preview) > 140 {
-					preview = preview[:140]
-				}
-				_ = handlers.Notify(rid, senderIDInt, "group_message", map[string]interface{}{"message_id": gmID, "group_id": raw.GroupID, "preview": preview, "url": fmt.Sprintf("/groups/%d", raw.GroupID)})
-			}
-			// also echo to sender
-			c.Send <- encoded
-			_ = handlers.Notify(senderIDInt, senderIDInt, "group_message_sent", map[string]interface{}{"message_id": gmID, "group_id": raw.GroupID})
-			continue
-		}
-
-		if raw.Type == "typing" {
-			clientsMutex.RLock()
-			receiver, ok := clients[raw.ReceiverID]
-			clientsMutex.RUnlock()
 
-			if ok {
-				fmt.Println("Forwarding typing notification from", c.ID, "to", receiver.ID)
-				typingNotification := models.Message{
-					Type:       "typing",
-					SenderID:   c.ID,
-					SenderName: c.Nickname,
-					ReceiverID: raw.ReceiverID,
+			// Apply throttling only for typing events
+			if raw.Type == "typing" || raw.Type == "stop_typing" {
+				if !c.canSendMessage() {
+					log.Println("Throttled message:", string(msg))
+					continue
 				}
-				payload, _ := json.Marshal(typingNotification)
-				receiver.Send <- payload
 			}
-			continue
-		}
-
-		if raw.Type == "stop_typing" {
-			clientsMutex.RLock()
-			receiver, ok := clients[raw.ReceiverID]
-			clientsMutex.RUnlock()
 
-			if ok {
-				stopTypingNotification := models.Message{
-					Type:       "stop_typing",
-					SenderID:   c.ID,
-					ReceiverID: raw.ReceiverID,
-				}
-				payload, _ := json.Marshal(stopTypingNotification)
-				receiver.Send <- payload
+			// Send message
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.Conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
 			}
-			continue
-		}
-
-		if raw.Type == "user_list_request" {
-			sendOnlineUsers(c.ID)
-			continue
-		}
-
-		log.Println("Unknown message type:", raw.Type)
-	}
-}
-
-func (c *Client) writePump() {
-	defer c.Conn.Close()
-	for msg := range c.Send {
-		// Extract the message type
-		var raw struct {
-			Type string `json:"type"`
-		}
-		if err := json.Unmarshal(msg, &raw); err != nil {
-			log.Println("Failed to parse message type:", err)
-			continue
-		}
-
-		// Apply throttling only for typing events
-		if raw.Type == "typing" || raw.Type == "stop_typing" {
-			if !c.canSendMessage() {
-				log.Println("Throttled message:", string(msg))
-				continue
+		case <-ticker.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
 			}
 		}
-
-		// Send message
-		if err := c.Conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-			break
-		}
 	}
-	c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
 }
 
 func (c *Client) canSendMessage() bool {
@@ -358,84 +257,3 @@ func (c *Client) canSendMessage() bool {
 	c.lastSent = time.Now()
 	return true
 }
-
-func sendOnlineUsers(_ string) {
-	// Query once for the full user list (avoids running many parallel DB queries
-	// which can cause 'database is locked' errors under SQLite).
-	rows, err := db.DB.Query(`
-SELECT u.id,
-	u.nickname,
-	IFNULL(u.avatar, ''),
-	CASE WHEN u.online_status = 1 THEN 1 ELSE 0 END AS is_online,
-	MAX(m.created_at) as last_msg
-FROM users u
-LEFT JOIN messages m ON (
-	(u.id = m.sender_id AND m.receiver_id = ?) OR
-	(u.id = m.receiver_id AND m.sender_id = ?)
-)
-WHERE u.id != ?
-GROUP BY u.id
-ORDER BY
-	is_online DESC,
-	last_msg DESC NULLS LAST,
-	u.nickname COLLATE NOCASE ASC
-	`, "0", "0", "0") // placeholders; ordering is independent of requester
-
-	if err != nil {
-		log.Println("User fetch error:", err)
-		return
-	}
-	defer rows.Close()
-
-	var users []map[string]interface{}
-	for rows.Next() {
-		var id, nickname, avatar string
-		var isOnline int
-		var lastMsg sql.NullString
-
-		if err := rows.Scan(&id, &nickname, &avatar, &isOnline, &lastMsg); err != nil {
-			continue
-		}
-		users = append(users, map[string]interface{}{
-			"id":        id,
-			"nickname":  nickname,
-			"avatar":    avatar,
-			"is_online": isOnline == 1,
-		})
-	}
-
-	jsonUsers, _ := json.Marshal(users)
-	update := models.Message{Type: "user_list", Content: string(jsonUsers)}
-	payload, _ := json.Marshal(update)
-
-	clientsMutex.RLock()
-	defer clientsMutex.RUnlock()
-	for _, client := range clients {
-		// broadcast same payload to all clients
-		sendToClient(client, payload)
-	}
-}
-
-// sendToClient tries to send a payload to a client's Send channel without blocking
-// the caller. It will attempt a non-blocking send first, then fall back to a
-// goroutine that will time out after a short period. This avoids closing the
-// connection for transient backpressure while still protecting the broadcaster.
-func sendToClient(c *Client, payload []byte) {
-	// fast path: if channel has capacity, send immediately
-	select {
-	case c.Send <- payload:
-		return
-	default:
-	}
-
-	// otherwise attempt a timed send in a goroutine
-	go func() {
-		select {
-		case c.Send <- payload:
-			return
-		case <-time.After(600 * time.Millisecond):
-			// timed out sending to slow client; drop the message silently
-			return
-		}
-	}()
-}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"social-network/backend/utils"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialTestWebSocket opens a websocket to HandleWebSocket as userID, offering
+// the given subprotocols. It is closed when the test ends, which waits for the
+// user to go offline.
+func dialTestWebSocket(t *testing.T, userID string, protocols ...string) *websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebSocket(w, r.WithContext(context.WithValue(r.Context(), utils.UserIDKey, userID)))
+	}))
+	t.Cleanup(srv.Close)
+	dialer := websocket.Dialer{Subprotocols: protocols}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		waitFor(t, "the connection to close", func() bool { return !hub.IsOnline(userID) })
+	})
+	return conn
+}
+
+// waitFor polls cond until it holds or a few seconds have passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// readUntilClosed keeps reading from conn, which answers pings, until the
+// connection fails.
+func readUntilClosed(conn *websocket.Conn) <-chan struct{} {
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	return closed
+}
+
+func TestHeartbeat(t *testing.T) {
+	openTestHub(t)
+	defer func(wait, period time.Duration) { pongWait, pingPeriod = wait, period }(pongWait, pingPeriod)
+	pongWait, pingPeriod = 300*time.Millisecond, 100*time.Millisecond
+	alice, bob := newTestClient(t, "alice"), newTestClient(t, "bob")
+	timeouts := wsPongTimeouts.Value()
+
+	// alice answers pings; bob's client swallows them like a dead peer would
+	readUntilClosed(dialTestWebSocket(t, alice.ID))
+	deaf := dialTestWebSocket(t, bob.ID)
+	deaf.SetPingHandler(func(string) error { return nil })
+	closed := readUntilClosed(deaf)
+
+	waitFor(t, "both users to connect", func() bool { return hub.IsOnline(alice.ID) && hub.IsOnline(bob.ID) })
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a client that never answers pings was not disconnected")
+	}
+	waitFor(t, "bob to go offline", func() bool { return !hub.IsOnline(bob.ID) })
+	if got := wsPongTimeouts.Value() - timeouts; got != 1 {
+		t.Errorf("%d pong timeouts counted, want 1", got)
+	}
+	if !hub.IsOnline(alice.ID) {
+		t.Error("alice was disconnected although she answered every ping")
+	}
+}
+
+func TestOversizedFrameCloses(t *testing.T) {
+	openTestHub(t)
+	alice := newTestClient(t, "alice")
+	oversized := wsOversizedFrames.Value()
+	conn := dialTestWebSocket(t, alice.ID)
+	closed := readUntilClosed(conn)
+	waitFor(t, "alice to connect", func() bool { return hub.IsOnline(alice.ID) })
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"message","content":"`+strings.Repeat("x", maxMessageSize)+`"}`))
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("an oversized frame did not close the connection")
+	}
+	waitFor(t, "alice to go offline", func() bool { return !hub.IsOnline(alice.ID) })
+	if got := wsOversizedFrames.Value() - oversized; got != 1 {
+		t.Errorf("%d oversized frames counted, want 1", got)
+	}
+}
+
+func TestSlowClientEvicted(t *testing.T) {
+	openTestHub(t)
+	alice := newTestClient(t, "alice")
+	evicted, dropped := wsSlowClientEvicted.Value(), wsFramesDropped.Value()
+
+	// a server-side connection nobody writes out, with room for one frame
+	serverConn := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		serverConn <- conn
+	}))
+	defer srv.Close()
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	slow := &Client{ID: alice.ID, Nickname: "alice", Conn: <-serverConn, Send: make(chan []byte, 1)}
+	hub.Register(slow)
+	hub.Register(alice)
+
+	for range 3 {
+		hub.SendToUser(alice.ID, []byte(`{"type":"ping"}`))
+	}
+	if got := drain(alice); len(got) != 3 {
+		t.Errorf("the other connection got %d frames, want 3", len(got))
+	}
+	if got := wsSlowClientEvicted.Value() - evicted; got != 1 {
+		t.Errorf("%d evictions counted, want 1", got)
+	}
+	if got := wsFramesDropped.Value() - dropped; got != 2 {
+		t.Errorf("%d dropped frames counted, want 2", got)
+	}
+	// the socket is closed, so the peer sees the connection end
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := peer.ReadMessage(); err == nil || strings.Contains(err.Error(), "timeout") {
+		t.Errorf("peer of an evicted client read %v, want the connection closed", err)
+	}
+}
@@ -0,0 +1,27 @@
+// Service worker for Web Push: shows notifications sent while the app has no
+// websocket connection, and opens their page when clicked.
+self.addEventListener('push', (event) => {
+  let data = {}
+  try { data = event.data ? event.data.json() : {} } catch (e) { data = { body: event.data.text() } }
+  event.waitUntil(self.registration.showNotification(data.title || 'Social Network', {
+    body: data.body || '',
+    tag: data.tag,
+    renotify: !!data.tag,
+    icon: '/favicon.ico',
+    data: { url: data.url || '/' },
+  }))
+})
+
+self.addEventListener('notificationclick', (event) => {
+  event.notification.close()
+  const url = event.notification.data && event.notification.data.url || '/'
+  event.waitUntil(self.clients.matchAll({ type: 'window', includeUncontrolled: true }).then((windows) => {
+    for (const w of windows) {
+      if ('focus' in w) {
+        w.navigate(url)
+        return w.focus()
+      }
+    }
+    return self.clients.openWindow(url)
+  }))
+})
@@ -0,0 +1,22 @@
+import axios from './index'
+
+// filters: { status?, type?, limit? }
+export function getJobs(filters = {}) {
+  return axios.get('/api/admin/jobs', { params: filters })
+}
+
+export function getJobStats() {
+  return axios.get('/api/admin/jobs/stats')
+}
+
+export function retryJob(id) {
+  return axios.post('/api/admin/jobs/retry', { id })
+}
+
+export function cancelJob(id) {
+  return axios.post('/api/admin/jobs/cancel', { id })
+}
+
+export function runSchedule(name) {
+  return axios.post('/api/admin/jobs/run-schedule', { name })
+}
@@ -22,7 +22,19 @@ export const getMessages = () => {
   return store.messages
 }
 
-// Fetch message history between current user and other user (paginated)
-export const fetchHistory = (userId, offset = 0) => {
-  return api.get('/api/messages/history', { params: { user_id: userId, offset } })
-}
\ No newline at end of file
+// Fetch message history between current user and other user (cursor paginated).
+// cursor: { before_id } | { after_id } | { around_id }; resolves to
+// { messages, has_more, has_more_before, has_more_after }
+export const fetchHistory = (userId, cursor = {}, limit = 10) => {
+  return api.get('/api/messages/history', { params: { user_id: userId, limit, ...cursor } })
+}
+
+// Mark the conversation with userId read (up to messageId, or everything)
+export const markConversationRead = (userId, messageId = null) => {
+  return api.post('/api/messages/read', messageId ? { user_id: userId, message_id: messageId } : { user_id: userId })
+}
+
+// Fetch both participants' delivery/read cursors for a conversation
+export const fetchReceipts = (userId) => {
+  return api.get('/api/messages/receipts', { params: { user_id: userId } })
+}
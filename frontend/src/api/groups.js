@@ -48,10 +48,27 @@ export function createGroupPost(formData) {
   return api.post('/api/group/post/create', formData, { headers: { 'Content-Type': 'multipart/form-data' } })
 }
 
+// formData: id, and content, image or remove_image=1
+export function updateGroupPost(formData) {
+  return api.post('/api/group/post/update', formData, { headers: { 'Content-Type': 'multipart/form-data' } })
+}
+
+export function deleteGroupPost(id) {
+  return api.post('/api/group/post/delete', { id })
+}
+
 export function addGroupComment(payload) {
   return api.post('/api/group/comment', payload)
 }
 
+export function updateGroupComment(id, content) {
+  return api.post('/api/group/comment/update', { id, content })
+}
+
+export function deleteGroupComment(id) {
+  return api.post('/api/group/comment/delete', { id })
+}
+
 export function createEvent(payload) {
   return api.post('/api/group/event/create', payload)
 }
@@ -10,7 +10,7 @@ export default api
 export const uploadFile = async (file, type) => {
     const formData = new FormData();
     formData.append('file', file);
-    formData.append('type', type); // 'avatar' or 'post'
+    formData.append('type', type); // 'avatar', 'post' or 'chat'
   
     const response = await api.post('/api/upload', formData, {
       headers: {
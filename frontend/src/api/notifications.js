@@ -1,9 +1,48 @@
 import axios from './index'
 
-export function getNotifications() {
-  return axios.get('/api/notifications')
+// params: { limit, cursor, type, read }
+export function getNotifications(params = {}) {
+  return axios.get('/api/notifications', { params })
+}
+
+export function getUnreadCount() {
+  return axios.get('/api/notifications/unread-count')
+}
+
+export function deleteNotification(id) {
+  return axios.post('/api/notifications/delete', { id })
+}
+
+export function clearNotifications(readOnly = false) {
+  return axios.post('/api/notifications/clear', { read_only: readOnly })
 }
 
 export function markNotificationsRead(id=null) {
   return axios.post('/api/notifications/mark-read', id ? { id } : {})
 }
+
+export function getNotificationPreferences() {
+  return axios.get('/api/notifications/preferences')
+}
+
+// preferences: [{ type, in_app?, realtime?, email? }]
+export function updateNotificationPreferences(preferences) {
+  return axios.post('/api/notifications/preferences', { preferences })
+}
+
+export function muteGroup(groupId, durationMinutes = 0) {
+  return axios.post('/api/group/mute', { group_id: groupId, duration_minutes: durationMinutes })
+}
+
+export function unmuteGroup(groupId) {
+  return axios.post('/api/group/unmute', { group_id: groupId })
+}
+
+export function getDigestSettings() {
+  return axios.get('/api/notifications/digest')
+}
+
+// frequency: 'off' | 'daily' | 'weekly'
+export function updateDigestFrequency(frequency) {
+  return axios.post('/api/notifications/digest', { frequency })
+}
@@ -5,9 +5,22 @@ export const createPost = async (postData) => {
   return res.data;
 }
 
+// the first page of the feed, or of one user's posts
 export const listPosts = async (user_id) => {
-  const url = user_id ? `/api/posts?user_id=${user_id}` : '/api/posts';
-  const res = await api.get(url);
+  const page = await listPostsPage(user_id ? { user_id } : {});
+  return page.posts;
+}
+
+// params: { user_id?, limit?, before?, after? } (before/after are post ids)
+// returns { posts, has_more }
+export const listPostsPage = async (params = {}) => {
+  const res = await api.get('/api/posts', { params });
+  return res.data;
+}
+
+// a post's comments older than comment id before (all if omitted); returns { comments, has_more }
+export const listComments = async (post_id, before) => {
+  const res = await api.get('/api/posts/comments', { params: { post_id, before } });
   return res.data;
 }
 
@@ -15,3 +28,42 @@ export const addComment = async (post_id, content, image_url) => {
   const res = await api.post('/api/posts/comment', { post_id, content, image_url });
   return res.data;
 }
+
+// changes: { content?, image_url?, privacy?, audience? }; image_url '' removes the image
+export const updatePost = async (id, changes) => {
+  const res = await api.post('/api/posts/update', { id, ...changes });
+  return res.data;
+}
+
+// { id, privacy, audience: [{ id, nickname, avatar }] } of one of your posts
+export const getPostAudience = async (id) => {
+  const res = await api.get('/api/posts/audience', { params: { id } });
+  return res.data;
+}
+
+// audience: ids of followers to share a private post with
+export const setPostAudience = async (id, audience) => {
+  const res = await api.post('/api/posts/audience', { id, audience });
+  return res.data;
+}
+
+export const deletePost = async (id) => {
+  const res = await api.post('/api/posts/delete', { id });
+  return res.data;
+}
+
+export const updateComment = async (id, changes) => {
+  const res = await api.post('/api/posts/comment/update', { id, ...changes });
+  return res.data;
+}
+
+export const deleteComment = async (id) => {
+  const res = await api.post('/api/posts/comment/delete', { id });
+  return res.data;
+}
+
+// kind: 'post', 'comment', 'group_post' or 'group_comment'
+export const getEditHistory = async (kind, id) => {
+  const res = await api.get('/api/posts/history', { params: { kind, id } });
+  return res.data;
+}
@@ -0,0 +1,45 @@
+import axios from './index'
+
+export function pushSupported() {
+  return 'serviceWorker' in navigator && 'PushManager' in window
+}
+
+function urlBase64ToUint8Array(base64) {
+  const padded = (base64 + '='.repeat((4 - base64.length % 4) % 4)).replace(/-/g, '+').replace(/_/g, '/')
+  return Uint8Array.from(atob(padded), (c) => c.charCodeAt(0))
+}
+
+// Asks for permission, subscribes this browser and registers it, so
+// notifications arrive as Web Push while the app is closed.
+export async function enablePush() {
+  if (!pushSupported()) throw new Error('Web Push is not supported by this browser')
+  if (await Notification.requestPermission() !== 'granted') throw new Error('Notification permission denied')
+  const registration = await navigator.serviceWorker.register('/sw.js')
+  const { data } = await axios.get('/api/push/vapid-public-key')
+  const subscription = await registration.pushManager.subscribe({
+    userVisibleOnly: true,
+    applicationServerKey: urlBase64ToUint8Array(data.public_key),
+  })
+  await axios.post('/api/push/subscribe', subscription.toJSON())
+  return subscription
+}
+
+// Unregisters and unsubscribes this browser, e.g. on logout.
+export async function disablePush() {
+  if (!pushSupported()) return
+  const registration = await navigator.serviceWorker.getRegistration('/sw.js')
+  const subscription = registration && await registration.pushManager.getSubscription()
+  if (!subscription) return
+  await axios.post('/api/push/unsubscribe', { endpoint: subscription.endpoint }).catch(() => {})
+  await subscription.unsubscribe()
+}
+
+export async function pushEnabled() {
+  if (!pushSupported()) return false
+  const registration = await navigator.serviceWorker.getRegistration('/sw.js')
+  return !!(registration && await registration.pushManager.getSubscription())
+}
+
+export function getPushSubscriptions() {
+  return axios.get('/api/push/subscriptions')
+}
@@ -0,0 +1,27 @@
+import axios from './index'
+
+export function getWebhooks() {
+  return axios.get('/api/webhooks')
+}
+
+// groupId: only for hooks on a group the user owns
+export function createWebhook(url, events, groupId = null) {
+  return axios.post('/api/webhooks/create', groupId ? { url, events, group_id: groupId } : { url, events })
+}
+
+// changes: { url?, events?, active? }
+export function updateWebhook(id, changes) {
+  return axios.post('/api/webhooks/update', { id, ...changes })
+}
+
+export function deleteWebhook(id) {
+  return axios.post('/api/webhooks/delete', { id })
+}
+
+export function getWebhookDeliveries(webhookId, limit = 20) {
+  return axios.get('/api/webhooks/deliveries', { params: { webhook_id: webhookId, limit } })
+}
+
+export function testWebhook(id) {
+  return axios.post('/api/webhooks/test', { id })
+}
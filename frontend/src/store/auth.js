@@ -2,6 +2,7 @@
 import { defineStore } from 'pinia';
 import * as api from '@/api/auth';
 import { useChatStore } from '@/store/chat'
+import { disablePush } from '@/api/push'
 
 export const useAuthStore = defineStore('auth', {
   state: () => ({
@@ -28,6 +29,8 @@ export const useAuthStore = defineStore('auth', {
       return res
     },
     async logout() {
+      // this browser should not get the next user's push notifications
+      await disablePush().catch(() => {})
       await api.logout();
       const chat = useChatStore()
       chat.disconnect()
@@ -14,13 +14,21 @@ const normalizeAvatar = (path) => {
 export const useChatStore = defineStore('chat', {
 	state: () => ({
 		socket: null,
+		events: null, // EventSource fallback when websockets are blocked
 		connected: false,
 		messageQueue: [], // Queue messages before socket opens
+		_requestSeq: 0, // request_id counter for outgoing frames
+		lastSeq: 0, // highest realtime event seq seen, used to resume after a reconnect
 		contacts: [],
 		conversations: {},
 		activeContactId: null,
 		errors: [],
 		typingUsers: {},
+		groupTyping: {}, // group id -> { user id: nickname }
+		presence: { status: 'online', lastSeenVisibility: 'everyone' }, // own presence settings
+		_idle: false,
+		_idleTimer: null,
+		_idleWatching: false,
 		currentUserId: null,
 		// batching for incoming realtime messages
 		_incomingBuffer: [],
@@ -46,7 +54,8 @@ export const useChatStore = defineStore('chat', {
 			// connect to backend websocket (backend runs on :8080)
 			const backendHost = window.location.hostname || 'localhost'
 			const url = `ws://${backendHost}:8080/ws`
-			this.socket = new WebSocket(url)
+			// negotiate the versioned envelope protocol (see backend/protocol.go)
+			this.socket = new WebSocket(url, ['sn.v1'])
 
 			console.log('chat: connecting to', url)
 
@@ -54,6 +63,11 @@ export const useChatStore = defineStore('chat', {
 				this.connected = true
 				this.currentUserId = this.getCurrentUserId()
 
+				// replay what was missed while disconnected before sending anything new
+				if (this.lastSeq > 0) {
+					this.socket.send(JSON.stringify({ type: 'resume', request_id: 'resume', data: { last_seq: this.lastSeq } }))
+				}
+
 				// Flush queued messages
 				while (this.messageQueue.length) {
 					const msg = this.messageQueue.shift()
@@ -62,44 +76,21 @@ export const useChatStore = defineStore('chat', {
 
 				// Request user list after connection established
 				this.requestUserList()
+				this.watchIdle()
 
 				console.log('chat: connected as user', this.currentUserId)
 			}
 
-			this.socket.onmessage = (event) => {
-				let payload
-				try {
-					payload = JSON.parse(event.data)
-				} catch (err) {
-					console.error('chat: invalid payload', err)
-					return
-				}
-				// forward certain payloads to notification store for realtime UX
-				const notifTypes = new Set(['group_invite','group_invite_response','group_join_request','group_join_response','group_event','new_message','group_message','new_follower','follow_request','follow_request_accepted','follow_request_declined'])
-				if (payload && payload.type && notifTypes.has(payload.type)) {
-					try {
-						const notifStore = useNotificationStore()
-						const tempId = -Date.now()
-						notifStore.list.unshift({
-							id: tempId,
-							recipient_id: Number(this.getCurrentUserId()) || 0,
-							actor_id: 0,
-							type: payload.type,
-							data: JSON.stringify(payload.data || {}),
-							is_read: false,
-							created_at: new Date().toISOString(),
-						})
-					} catch (e) {
-						console.error('Failed to push realtime notification', e)
-					}
-				}
-				this.handleMessage(payload)
-			}
+			this.socket.onmessage = (event) => this.receive(event)
 
 			this.socket.onclose = () => {
 				console.log('chat: disconnected')
+				const neverOpened = !this.connected
 				this.connected = false
 				this.socket = null
+				// a proxy that breaks websockets fails the handshake: fall back to
+				// the receive-only SSE stream so realtime updates keep arriving
+				if (neverOpened) this.connectEvents()
 			}
 
 			this.socket.onerror = (err) => {
@@ -108,12 +99,63 @@ export const useChatStore = defineStore('chat', {
 			}
 		},
 
+		connectEvents() {
+			if (this.events) return
+			const backendHost = window.location.hostname || 'localhost'
+			const params = this.lastSeq > 0 ? `?last_event_id=${this.lastSeq}` : ''
+			this.events = new EventSource(`http://${backendHost}:8080/api/events${params}`, { withCredentials: true })
+			this.events.onmessage = (event) => this.receive(event)
+		},
+
+		receive(event) {
+			let payload
+			try {
+				payload = JSON.parse(event.data)
+			} catch (err) {
+				console.error('chat: invalid payload', err)
+				return
+			}
+			if (payload && typeof payload.seq === 'number' && payload.seq > this.lastSeq && !['welcome', 'resumed', 'resync_required'].includes(payload.type)) {
+				this.lastSeq = payload.seq
+			}
+			// forward certain payloads to notification store for realtime UX
+			const notifTypes = new Set(['group_invite','group_invite_response','group_join_request','group_join_response','group_event','new_message','group_message','new_follower','follow_request','follow_request_accepted','follow_request_declined'])
+			if (payload && payload.type && notifTypes.has(payload.type)) {
+				try {
+					const notifStore = useNotificationStore()
+					// aggregated notifications replace their existing entry
+					const id = payload.notification_id || -Date.now()
+					const existing = notifStore.list.find((n) => n.id === id)
+					notifStore.list = notifStore.list.filter((n) => n.id !== id)
+					notifStore.list.unshift({
+						...(existing || {}),
+						id,
+						recipient_id: Number(this.getCurrentUserId()) || 0,
+						actor_id: 0,
+						type: payload.type,
+						data: payload.data || {},
+						is_read: false,
+						count: payload.count || 1,
+						created_at: existing ? existing.created_at : new Date().toISOString(),
+						updated_at: new Date().toISOString(),
+					})
+				} catch (e) {
+					console.error('Failed to push realtime notification', e)
+				}
+			}
+			this.handleMessage(payload)
+		},
+
 		disconnect() {
 			console.log('chat: disconnecting')
 			if (this.socket) this.socket.close()
 			this.socket = null
+			if (this.events) this.events.close()
+			this.events = null
 			this.connected = false
 			this.messageQueue = []
+			this.lastSeq = 0
+			this._idle = false
 		},
 
 		getCurrentUserId() {
@@ -122,8 +164,46 @@ export const useChatStore = defineStore('chat', {
 		},
 
 		requestUserList() {
-			const payload = { type: 'user_list_request' }
-			this.sendMessage(payload)
+			this.sendFrame('user_list_request', {})
+		},
+
+		// sendFrame wraps a payload in the sn.v1 envelope and sends it, or
+		// queues it until the socket opens. Returns the request_id, which the
+		// server echoes in the matching ack/error frame.
+		sendFrame(type, data) {
+			this._requestSeq += 1
+			const frame = { type, request_id: `r${this._requestSeq}`, data }
+			if (this.socket && this.socket.readyState === WebSocket.OPEN) {
+				this.socket.send(JSON.stringify(frame))
+			} else {
+				this.messageQueue.push(frame)
+			}
+			return frame.request_id
+		},
+
+		// setStatus picks the presence status others see: online, away, dnd
+		// (no realtime notifications) or invisible (appear offline).
+		setStatus(status) {
+			this.sendFrame('set_status', { status })
+		},
+
+		// watchIdle reports this tab as idle after idleAfterMs without input or
+		// while hidden; the server shows the user as away once every tab is idle.
+		watchIdle(idleAfterMs = 5 * 60 * 1000) {
+			if (this._idleWatching || typeof window === 'undefined') return
+			this._idleWatching = true
+			const setIdle = (idle) => {
+				if (this._idle === idle) return
+				this._idle = idle
+				if (this.socket && this.socket.readyState === WebSocket.OPEN) this.sendFrame('idle', { idle })
+			}
+			const activity = () => {
+				setIdle(document.hidden)
+				clearTimeout(this._idleTimer)
+				this._idleTimer = setTimeout(() => setIdle(true), idleAfterMs)
+			}
+			;['mousemove', 'keydown', 'touchstart', 'visibilitychange'].forEach((e) => window.addEventListener(e, activity, { passive: true }))
+			activity()
 		},
 
 		sendMessage(payload) {
@@ -139,12 +219,8 @@ export const useChatStore = defineStore('chat', {
 			body.receiver_id = String(body.receiver_id)
 			body.content = body.content.trim()
 
-			if (this.socket && this.socket.readyState === WebSocket.OPEN) {
-				this.socket.send(JSON.stringify(body))
-			} else {
-				// Queue the message if socket not open
-				this.messageQueue.push(body)
-			}
+			const { type, ...data } = body
+			this.sendFrame(type, data)
 			return true
 		},
 
@@ -157,10 +233,35 @@ export const useChatStore = defineStore('chat', {
 					this.bufferIncomingMessage(msg)
 					break
 				case 'typing':
-					this.typingUsers[String(msg.sender_id)] = true
+					if (msg.group_id) {
+						const gid = String(msg.group_id)
+						this.groupTyping[gid] = { ...(this.groupTyping[gid] || {}), [String(msg.sender_id)]: msg.sender_name || '' }
+					} else {
+						this.typingUsers[String(msg.sender_id)] = true
+					}
 					break
 				case 'stop_typing':
-					delete this.typingUsers[String(msg.sender_id)]
+					if (msg.group_id) {
+						const gid = String(msg.group_id)
+						if (this.groupTyping[gid]) delete this.groupTyping[gid][String(msg.sender_id)]
+					} else {
+						delete this.typingUsers[String(msg.sender_id)]
+					}
+					break
+				case 'presence': {
+					const contact = this.contacts.find((c) => c.id === String(msg.user_id))
+					if (contact) {
+						contact.isOnline = !!msg.is_online
+						contact.status = msg.status || (msg.is_online ? 'online' : 'offline')
+						contact.lastSeenAt = msg.last_seen_at || null
+					}
+					break
+				}
+				case 'unread_count':
+					useNotificationStore().setUnreadCount(msg.count || 0)
+					break
+				case 'presence_settings':
+					this.presence = { status: msg.status, lastSeenVisibility: msg.last_seen_visibility }
 					break
 				case 'group_message':
 					this.handleGroupMessage(msg)
@@ -168,6 +269,26 @@ export const useChatStore = defineStore('chat', {
 				case 'error':
 					this.pushError(msg.content || 'Unable to deliver message.')
 					break
+				case 'ack':
+					break
+				case 'welcome':
+					// first connection: start the event stream here
+					if (!this.lastSeq) this.lastSeq = msg.seq || 0
+					break
+				case 'resumed':
+					if (msg.has_more) {
+						this.socket.send(JSON.stringify({ type: 'resume', request_id: 'resume', data: { last_seq: this.lastSeq } }))
+					} else if (msg.seq > this.lastSeq) {
+						this.lastSeq = msg.seq
+					}
+					break
+				case 'resync_required':
+					// missed events are gone: reload over REST and continue from here
+					this.lastSeq = msg.seq || 0
+					this.conversations = {}
+					this.groupConversations = {}
+					this.requestUserList()
+					break
 				case 'new_message_notification':
 					break
 				default:
@@ -252,11 +373,8 @@ export const useChatStore = defineStore('chat', {
 			}
 			if (!body.content || !body.content.trim()) return false
 			body.content = body.content.trim()
-			if (this.socket && this.socket.readyState === WebSocket.OPEN) {
-				this.socket.send(JSON.stringify(body))
-			} else {
-				this.messageQueue.push(body)
-			}
+			const { type, ...data } = body
+			this.sendFrame(type, data)
 			return true
 		},
 
@@ -302,6 +420,7 @@ export const useChatStore = defineStore('chat', {
 						displayName: msg.sender_name || `User ${otherId}`,
 						avatar: '',
 						isOnline: true,
+						status: 'online',
 						unread: sender === me ? 0 : 1,
 					})
 				}
@@ -329,6 +448,8 @@ export const useChatStore = defineStore('chat', {
 					displayName: user.display_name || user.nickname || `User ${id}`,
 					avatar: normalizeAvatar(user.avatar),
 					isOnline: !!user.is_online,
+					status: user.status || (user.is_online ? 'online' : 'offline'),
+					lastSeenAt: user.last_seen_at || null,
 					unread: existing ? existing.unread : 0,
 				}
 			})
@@ -375,6 +496,7 @@ export const useChatStore = defineStore('chat', {
 					displayName: msg.sender_name || `User ${otherId}`,
 					avatar: '',
 					isOnline: true,
+					status: 'online',
 					unread: sender === me ? 0 : 1,
 				})
 			}
@@ -386,13 +508,13 @@ export const useChatStore = defineStore('chat', {
 				this.ensureConversation(this.activeContactId)
 				// load recent history from server (first page)
 				try {
-					const { data } = await fetchHistory(this.activeContactId, 0)
-					// data is an array of messages (oldest-first after server reversal)
+					const { data } = await fetchHistory(this.activeContactId)
+					// data.messages is oldest-first
 					const conv = this.ensureConversation(this.activeContactId)
 					conv.splice(0, conv.length) // clear existing
 					const me = this.getCurrentUserId()
-					if (Array.isArray(data)) {
-						data.forEach((m) => {
+					if (data && Array.isArray(data.messages)) {
+						data.messages.forEach((m) => {
 							conv.push({
 								id: m.id,
 								content: m.content,
@@ -415,12 +537,12 @@ export const useChatStore = defineStore('chat', {
 			if (!contactId) return 0
 			const key = String(contactId)
 			const conv = this.ensureConversation(key)
-			const offset = conv.length
+			const oldest = conv.find((m) => m.id)
 			try {
-				const { data } = await fetchHistory(key, offset)
-				if (!Array.isArray(data) || data.length === 0) return 0
+				const { data } = await fetchHistory(key, oldest ? { before_id: oldest.id } : {})
+				if (!data || !Array.isArray(data.messages) || data.messages.length === 0) return 0
 				const me = this.getCurrentUserId()
-				const items = data.map((m) => ({
+				const items = data.messages.map((m) => ({
 					id: m.id,
 					content: m.content,
 					outgoing: String(m.sender_id) === me,
@@ -1,12 +1,31 @@
 import { defineStore } from 'pinia'
-import { getNotifications, markNotificationsRead } from '../api/notifications'
+import { getNotifications, getUnreadCount, markNotificationsRead, deleteNotification, clearNotifications } from '../api/notifications'
 
 export const useNotificationStore = defineStore('notifications', {
-  state: () => ({ list: [] }),
+  state: () => ({ list: [], unreadCount: 0, nextCursor: null, hasMore: false }),
   actions: {
     async fetch() {
       const res = await getNotifications()
-      this.list = res.data
+      this.list = res.data.notifications
+      this.nextCursor = res.data.next_cursor || null
+      this.hasMore = res.data.has_more
+      this.unreadCount = res.data.unread_count
+    },
+    async fetchMore() {
+      if (!this.hasMore) return
+      const res = await getNotifications({ cursor: this.nextCursor })
+      const seen = new Set(this.list.map(n => n.id))
+      this.list = this.list.concat(res.data.notifications.filter(n => !seen.has(n.id)))
+      this.nextCursor = res.data.next_cursor || null
+      this.hasMore = res.data.has_more
+    },
+    async fetchUnreadCount() {
+      const res = await getUnreadCount()
+      this.unreadCount = res.data.unread_count
+    },
+    // the server pushes {type: 'unread_count'} whenever the count changes
+    setUnreadCount(count) {
+      this.unreadCount = count
     },
     async markAllRead() {
       await markNotificationsRead()
@@ -15,6 +34,14 @@ export const useNotificationStore = defineStore('notifications', {
     async markRead(id) {
       await markNotificationsRead(id)
       this.list = this.list.map(n => (n.id === id ? { ...n, is_read: true } : n))
+    },
+    async remove(id) {
+      await deleteNotification(id)
+      this.list = this.list.filter(n => n.id !== id)
+    },
+    async clear(readOnly = false) {
+      await clearNotifications(readOnly)
+      this.list = readOnly ? this.list.filter(n => !n.is_read) : []
     }
   }
 })